@@ -1,9 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"bitcoinecho.org/node/pkg/bitcoin"
 )
@@ -14,12 +16,30 @@ const (
 )
 
 func main() {
+	network := flag.String("network", "mainnet", "network to join: mainnet, testnet3, testnet4 or regtest")
+	dataDir := flag.String("datadir", "", "directory for block data; blocks are kept in memory if empty")
+	dbCache := flag.Int64("dbcache", bitcoin.DefaultDBCache>>20, "coins cache size in MiB before it is flushed to disk")
+	flag.Usage = printHelp
+	flag.Parse()
+
 	fmt.Printf("%s v%s\n", Name, Version)
 	fmt.Println("A Pure Bitcoin Node Implementation")
 	fmt.Println("")
 
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
+	params, err := bitcoin.ParamsForNetwork(*network)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		printHelp()
+		os.Exit(1)
+	}
+	// Without the block solution check anyone could extend the signet chain
+	if params == &bitcoin.SigNetParams {
+		fmt.Println("Error: signet is not supported yet: BIP325 block solutions are not validated")
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
 		case "version":
 			printVersion()
 		case "help":
@@ -27,13 +47,13 @@ func main() {
 		case "test":
 			runTests()
 		default:
-			fmt.Printf("Unknown command: %s\n", os.Args[1])
+			fmt.Printf("Unknown command: %s\n", flag.Arg(0))
 			printHelp()
 			os.Exit(1)
 		}
 	} else {
 		// Default: start the node
-		startNode()
+		startNode(params, *dataDir, *dbCache<<20)
 	}
 }
 
@@ -45,7 +65,12 @@ func printVersion() {
 }
 
 func printHelp() {
-	fmt.Printf("Usage: %s [command]\n", Name)
+	fmt.Printf("Usage: %s [options] [command]\n", Name)
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  -network    Network to join: mainnet (default), testnet3, testnet4 or regtest")
+	fmt.Println("  -datadir    Directory for block data (default: keep blocks in memory)")
+	fmt.Printf("  -dbcache    Coins cache size in MiB (default: %d)\n", bitcoin.DefaultDBCache>>20)
 	fmt.Println("")
 	fmt.Println("Commands:")
 	fmt.Println("  help        Show this help message")
@@ -56,10 +81,21 @@ func printHelp() {
 	fmt.Println("For more information, visit: https://bitcoinecho.org")
 }
 
-func startNode() {
+func startNode(params *bitcoin.ChainParams, dataDir string, dbCache int64) {
 	fmt.Println("🚀 Starting Bitcoin Echo node...")
 	fmt.Println("")
 
+	fmt.Printf("🌐 Network: %s (magic 0x%08x, port %d)\n", params.Name, params.Magic, params.DefaultPort)
+	chain, closeChain, err := openChain(params, dataDir, dbCache)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeChain()
+	fmt.Printf("   Genesis: %s\n", params.GenesisHash.String())
+	fmt.Printf("   Tip: %s (height %d)\n", chain.GetTip().Hash().String(), chain.Height())
+	fmt.Println("")
+
 	// TODO: Implement full node startup
 	fmt.Println("⚠️  Node implementation in progress")
 	fmt.Println("📋 Current status: Core types defined")
@@ -72,6 +108,47 @@ func startNode() {
 	fmt.Println("Use Ctrl+C to stop")
 }
 
+// openChain loads the blockchain from dataDir, or starts an in-memory one if
+// dataDir is empty. The returned function flushes and closes the storage.
+func openChain(params *bitcoin.ChainParams, dataDir string, dbCache int64) (*bitcoin.BlockChain, func(), error) {
+	if dataDir == "" {
+		return bitcoin.NewBlockChainWithParams(params, params.GenesisBlock), func() {}, nil
+	}
+
+	blocksDir := filepath.Join(dataDir, params.Name, "blocks")
+	store, err := bitcoin.OpenBlockStore(blocksDir, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	coinsDir := filepath.Join(dataDir, params.Name, "chainstate")
+	coinsDB, err := bitcoin.OpenCoinsDB(coinsDir)
+	if err != nil {
+		_ = store.Close()
+		return nil, nil, err
+	}
+	coins := bitcoin.NewUTXOSetWithDB(coinsDB, dbCache)
+	chain, err := bitcoin.NewBlockChainWithCoins(params, store, coins, bitcoin.ValidationOptions{})
+	if err != nil {
+		_ = coinsDB.Close()
+		_ = store.Close()
+		return nil, nil, err
+	}
+	fmt.Printf("   Blocks: %s\n", blocksDir)
+	fmt.Printf("   Chainstate: %s (%d coins)\n", coinsDir, coins.Size())
+
+	return chain, func() {
+		if err := chain.Flush(); err != nil {
+			log.Printf("Error flushing chain state: %v", err)
+		}
+		if err := coinsDB.Close(); err != nil {
+			log.Printf("Error closing coins database: %v", err)
+		}
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing block store: %v", err)
+		}
+	}, nil
+}
+
 func runTests() {
 	fmt.Println("🧪 Running basic functionality tests...")
 	fmt.Println("")
@@ -6,7 +6,9 @@ package bitcoin
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"time"
 )
 
@@ -76,7 +78,7 @@ func (b *Block) SetHeight(height int32) {
 func (bh *BlockHeader) Hash() Hash256 {
 	if bh.hash == nil {
 		// Serialize block header and hash with double SHA-256
-		serialized, err := bh.serialize()
+		serialized, err := bh.Serialize()
 		if err != nil {
 			// In case of serialization error, return zero hash
 			return ZeroHash
@@ -95,8 +97,8 @@ func (bh *BlockHeader) Hash() Hash256 {
 	return *bh.hash
 }
 
-// serialize serializes the block header for hashing
-func (bh *BlockHeader) serialize() ([]byte, error) {
+// Serialize serializes the block header to its 80-byte wire format
+func (bh *BlockHeader) Serialize() ([]byte, error) {
 	buf := new(bytes.Buffer)
 
 	// Version (4 bytes, little-endian)
@@ -134,6 +136,78 @@ func (bh *BlockHeader) serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DeserializeBlockHeader reads an 80-byte wire format block header from r
+func DeserializeBlockHeader(r io.Reader) (BlockHeader, error) {
+	var buf [BlockHeaderSize]byte
+	if err := readFull(r, buf[:], "block header"); err != nil {
+		return BlockHeader{}, err
+	}
+
+	var prevHash, merkleRoot Hash256
+	copy(prevHash[:], buf[4:36])
+	copy(merkleRoot[:], buf[36:68])
+
+	// Hashes are stored in reverse order on the wire
+	return NewBlockHeader(
+		binary.LittleEndian.Uint32(buf[0:4]),
+		reverseHash(prevHash),
+		reverseHash(merkleRoot),
+		binary.LittleEndian.Uint32(buf[68:72]),
+		binary.LittleEndian.Uint32(buf[72:76]),
+		binary.LittleEndian.Uint32(buf[76:80]),
+	), nil
+}
+
+// Serialize serializes the block to wire format: the header, the
+// transaction count and every transaction including witness data
+func (b *Block) Serialize() ([]byte, error) {
+	header, err := b.Header.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, b.Size()))
+	buf.Write(header)
+	buf.Write(EncodeVarInt(uint64(len(b.Transactions))))
+	for i := range b.Transactions {
+		serialized, err := b.Transactions[i].Serialize()
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize transaction %d: %w", i, err)
+		}
+		buf.Write(serialized)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DeserializeBlock reads a wire format block from r. Only the block is
+// consumed, so several blocks can be read from the same stream.
+func DeserializeBlock(r io.Reader) (*Block, error) {
+	header, err := DeserializeBlockHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	txCount, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction count: %v", err)
+	}
+	if txCount > MaxPayload {
+		return nil, fmt.Errorf("transaction count too large: %d", txCount)
+	}
+
+	transactions := make([]Transaction, 0, preallocCount(txCount))
+	for i := uint64(0); i < txCount; i++ {
+		tx, err := ReadTransaction(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode transaction %d: %w", i, err)
+		}
+		transactions = append(transactions, *tx)
+	}
+
+	return NewBlock(header, transactions), nil
+}
+
 // Time returns the block timestamp as a time.Time
 func (bh *BlockHeader) Time() time.Time {
 	return time.Unix(int64(bh.Timestamp), 0)
@@ -167,50 +241,37 @@ func (b *Block) CoinbaseTransaction() *Transaction {
 	return nil
 }
 
-// Size returns the serialized size of the block in bytes
+// Size returns the serialized size of the block in bytes, including witness data
 func (b *Block) Size() int {
-	// TODO: Implement actual block serialization to get accurate size
-	// This is a placeholder estimation
-	size := 80 // Block header size
-	size += 9  // Approximate varint for transaction count
-
+	size := BlockHeaderSize + VarIntSize(uint64(len(b.Transactions)))
 	for i := range b.Transactions {
-		size += b.estimateTransactionSize(&b.Transactions[i])
+		size += b.Transactions[i].Size()
 	}
+	return size
+}
 
+// StrippedSize returns the serialized size of the block in bytes without witness data
+func (b *Block) StrippedSize() int {
+	size := BlockHeaderSize + VarIntSize(uint64(len(b.Transactions)))
+	for i := range b.Transactions {
+		size += b.Transactions[i].StrippedSize()
+	}
 	return size
 }
 
-// Weight returns the block weight as defined by BIP141
+// Weight returns the block weight as defined by BIP141:
+// stripped size * (WitnessScaleFactor - 1) + total size
 func (b *Block) Weight() int {
-	// TODO: Implement BIP141 weight calculation
-	// Weight = (base_size * 3) + total_size
-	// where base_size excludes witness data and total_size includes it
-	return b.Size() * 4 // Placeholder: assume no witness data
+	return b.StrippedSize()*(WitnessScaleFactor-1) + b.Size()
 }
 
-// estimateTransactionSize estimates the serialized size of a transaction
-func (b *Block) estimateTransactionSize(tx *Transaction) int {
-	// Rough estimation - in practice this should serialize the transaction
-	size := 4                   // Version
-	size += 1                   // Input count varint (simplified)
-	size += len(tx.Inputs) * 36 // Each input: 32-byte hash + 4-byte index + script + sequence
-	size += 1                   // Output count varint (simplified)
-	size += len(tx.Outputs) * 9 // Each output: 8-byte value + script (simplified)
-	size += 4                   // Lock time
-
-	// Add script sizes
-	for _, input := range tx.Inputs {
-		size += len(input.ScriptSig) + 1 // Script + length varint
-	}
-	for _, output := range tx.Outputs {
-		size += len(output.ScriptPubKey) + 1 // Script + length varint
-	}
-
-	return size
+// VirtualSize returns the block weight divided by four, rounded up
+func (b *Block) VirtualSize() int {
+	return (b.Weight() + WitnessScaleFactor - 1) / WitnessScaleFactor
 }
 
-// Validate performs basic block validation
+// Validate performs basic block validation. Witness data is not checked: the
+// rules depend on whether segwit is active at the block's height.
 func (b *Block) Validate() error {
 	// Check if block has transactions
 	if len(b.Transactions) == 0 {
@@ -236,17 +297,21 @@ func (b *Block) Validate() error {
 		}
 	}
 
-	// Check block size/weight limits
-	if b.Size() > MaxBlockSize {
-		return fmt.Errorf("block size %d exceeds maximum %d", b.Size(), MaxBlockSize)
+	// Check block size/weight limits. Witness data does not count towards
+	// the legacy size limit, only towards the weight.
+	if size := b.StrippedSize(); size > MaxBlockSize {
+		return fmt.Errorf("block size %d exceeds maximum %d", size, MaxBlockSize)
 	}
 
-	if b.Weight() > MaxBlockWeight {
-		return fmt.Errorf("block weight %d exceeds maximum %d", b.Weight(), MaxBlockWeight)
+	if weight := b.Weight(); weight > MaxBlockWeight {
+		return fmt.Errorf("block weight %d exceeds maximum %d", weight, MaxBlockWeight)
+	}
+
+	if err := b.CheckMerkleRoot(); err != nil {
+		return err
 	}
 
 	// TODO: Additional validations:
-	// - Merkle root validation
 	// - Proof of work validation
 	// - Timestamp validation
 	// - Difficulty target validation
@@ -254,12 +319,99 @@ func (b *Block) Validate() error {
 	return nil
 }
 
+// CalculateMerkleRoot returns the merkle root of the block's transactions,
+// i.e. the value the header must commit to
+func (b *Block) CalculateMerkleRoot() Hash256 {
+	return CalculateMerkleRoot(b.transactionHashes())
+}
+
+// transactionHashes returns the txids of the block's transactions in order
+func (b *Block) transactionHashes() []Hash256 {
+	txHashes := make([]Hash256, len(b.Transactions))
+	for i := range b.Transactions {
+		txHashes[i] = b.Transactions[i].Hash()
+	}
+	return txHashes
+}
+
+// CheckMerkleRoot verifies the header merkle root against the block's
+// transactions and rejects CVE-2012-2459 mutations, where trailing
+// transactions are duplicated without changing the root. Both failures wrap
+// ErrBlockMutated: the transactions do not belong to the header, but the
+// header itself may be part of a valid block.
+func (b *Block) CheckMerkleRoot() error {
+	root, mutated := CalculateMerkleRootMutated(b.transactionHashes())
+	if root != b.Header.MerkleRoot {
+		return fmt.Errorf("%w: merkle root mismatch: header %s, computed %s",
+			ErrBlockMutated, b.Header.MerkleRoot, root)
+	}
+	if mutated {
+		return fmt.Errorf("%w: duplicate transactions in merkle tree", ErrBlockMutated)
+	}
+
+	return nil
+}
+
+// WitnessCommitmentIndex returns the index of the coinbase output holding the
+// BIP141 witness commitment, or -1 if there is none. When several outputs
+// match, the last one is the commitment.
+func (b *Block) WitnessCommitmentIndex() int {
+	coinbase := b.CoinbaseTransaction()
+	if coinbase == nil {
+		return -1
+	}
+
+	for i := len(coinbase.Outputs) - 1; i >= 0; i-- {
+		script := coinbase.Outputs[i].ScriptPubKey
+		if len(script) >= MinWitnessCommitmentSize && script[0] == byte(OP_RETURN) && script[1] == 0x24 &&
+			bytes.Equal(script[2:6], WitnessCommitmentHeader) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateWitnessCommitment checks the BIP141 witness commitment. If the
+// coinbase commits to witness data, its witness must be a single 32-byte
+// reserved value and the commitment must equal
+// Hash256(witness merkle root || reserved value). Without a commitment no
+// transaction in the block may carry witness data.
+func (b *Block) ValidateWitnessCommitment() error {
+	commitmentIndex := b.WitnessCommitmentIndex()
+	if commitmentIndex < 0 {
+		for i := range b.Transactions {
+			if b.Transactions[i].HasWitness() {
+				return fmt.Errorf("%w: transaction %d has witness data but block has no witness commitment",
+					ErrBlockMutated, i)
+			}
+		}
+		return nil
+	}
+
+	coinbase := b.CoinbaseTransaction()
+	witness := coinbase.Inputs[0].Witness
+	if len(witness) != 1 || len(witness[0]) != 32 {
+		return fmt.Errorf("%w: coinbase witness must be a single 32-byte reserved value", ErrBlockMutated)
+	}
+
+	witnessRoot := reverseHash(CalculateWitnessMerkleRoot(b.Transactions))
+	commitment := DoubleHashSHA256(append(witnessRoot[:], witness[0]...))
+
+	script := coinbase.Outputs[commitmentIndex].ScriptPubKey
+	if !bytes.Equal(script[6:MinWitnessCommitmentSize], commitment[:]) {
+		return fmt.Errorf("%w: witness commitment mismatch", ErrBlockMutated)
+	}
+
+	return nil
+}
+
 // Validate performs block header validation
 func (bh *BlockHeader) Validate() error {
-	// Check timestamp is not too far in future (2 hours)
-	maxTime := time.Now().Add(2 * time.Hour)
+	// Check timestamp is not too far in future (2 hours). The chain checks
+	// again against network-adjusted time and the median time past.
+	maxTime := time.Now().Add(MaxFutureBlockTime)
 	if bh.Time().After(maxTime) {
-		return fmt.Errorf("block timestamp too far in future")
+		return ErrBlockTimeTooNew
 	}
 
 	// TODO: Additional header validations:
@@ -272,6 +424,22 @@ func (bh *BlockHeader) Validate() error {
 
 // Constants
 const (
-	MaxBlockSize   = 1000000 // 1MB (legacy limit)
-	MaxBlockWeight = 4000000 // 4M weight units (BIP141)
+	MaxBlockSize       = 1000000 // 1MB (legacy limit)
+	MaxBlockWeight     = 4000000 // 4M weight units (BIP141)
+	WitnessScaleFactor = 4       // Weight units per non-witness byte (BIP141)
+	BlockHeaderSize    = 80      // Serialized block header size in bytes
+
+	// MinWitnessCommitmentSize is OP_RETURN, a 36-byte push, the 4-byte header and the 32-byte commitment
+	MinWitnessCommitmentSize = 38
 )
+
+// ErrBlockMutated marks a block whose transactions or witness data do not match
+// what its header commits to
+var ErrBlockMutated = errors.New("block mutated")
+
+// ErrBlockTimeTooNew marks a block timestamped more than MaxFutureBlockTime
+// past network-adjusted time
+var ErrBlockTimeTooNew = errors.New("block timestamp too far in future")
+
+// WitnessCommitmentHeader prefixes the witness commitment in the coinbase output
+var WitnessCommitmentHeader = []byte{0xaa, 0x21, 0xa9, 0xed}
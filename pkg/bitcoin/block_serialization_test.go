@@ -0,0 +1,182 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Mainnet genesis block and block 1 in wire format
+const (
+	genesisBlockHex = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67" +
+		"768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c" +
+		"01" +
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d01044554" +
+		"68652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64" +
+		"206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b710" +
+		"5cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00" +
+		"000000"
+	block1Hex = "010000006fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000982051fd1e4ba744bbbe680e1fee" +
+		"14677ba1a3c3540bf7b1cdb606e857233e0e61bc6649ffff001d01e36299" +
+		"01" +
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0704ffff001d0104ff" +
+		"ffffff0100f2052a0100000043410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589" +
+		"379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac00000000"
+)
+
+// TestDeserializeBlock tests parsing and round-tripping real mainnet blocks
+func TestDeserializeBlock(t *testing.T) {
+	tests := []struct {
+		name         string
+		blockHex     string
+		expectedHash string
+		expectedRoot string
+	}{
+		{
+			name:         "Genesis block",
+			blockHex:     genesisBlockHex,
+			expectedHash: "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+			expectedRoot: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		},
+		{
+			name:         "Block 1",
+			blockHex:     block1Hex,
+			expectedHash: "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048",
+			expectedRoot: "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := mustDecodeHex(tt.blockHex)
+			block, err := DeserializeBlock(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("Failed to deserialize block: %v", err)
+			}
+
+			if block.Hash().String() != tt.expectedHash {
+				t.Errorf("Expected hash %s, got %s", tt.expectedHash, block.Hash().String())
+			}
+			if block.Header.MerkleRoot.String() != tt.expectedRoot {
+				t.Errorf("Expected merkle root %s, got %s", tt.expectedRoot, block.Header.MerkleRoot.String())
+			}
+			if block.Size() != len(raw) {
+				t.Errorf("Expected size %d, got %d", len(raw), block.Size())
+			}
+
+			serialized, err := block.Serialize()
+			if err != nil {
+				t.Fatalf("Failed to serialize block: %v", err)
+			}
+			if !bytes.Equal(serialized, raw) {
+				t.Errorf("Round-trip failed")
+				t.Errorf("Original:   %s", hex.EncodeToString(raw))
+				t.Errorf("Round-trip: %s", hex.EncodeToString(serialized))
+			}
+		})
+	}
+}
+
+// TestDeserializeBlock_Stream tests reading consecutive blocks from one stream
+func TestDeserializeBlock_Stream(t *testing.T) {
+	witnessBlock := createWitnessBlock()
+	witnessBytes, err := witnessBlock.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize witness block: %v", err)
+	}
+
+	stream := bytes.NewReader(append(mustDecodeHex(genesisBlockHex+block1Hex), witnessBytes...))
+	var blocks []*Block
+	for stream.Len() > 0 {
+		block, err := DeserializeBlock(stream)
+		if err != nil {
+			t.Fatalf("Failed to deserialize block %d: %v", len(blocks), err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	if len(blocks) != 3 {
+		t.Fatalf("Expected 3 blocks, got %d", len(blocks))
+	}
+	if blocks[1].Header.PrevBlockHash != blocks[0].Hash() {
+		t.Error("Expected block 1 to build on the genesis block")
+	}
+
+	parsed := blocks[2]
+	if !parsed.Transactions[1].HasWitness() {
+		t.Error("Expected witness data to survive the round trip")
+	}
+	if parsed.Transactions[1].WitnessHash() != witnessBlock.Transactions[1].WitnessHash() {
+		t.Error("Witness transaction ID changed after round trip")
+	}
+	if err := parsed.ValidateWitnessCommitment(); err != nil {
+		t.Errorf("Expected witness commitment to validate after round trip, got %v", err)
+	}
+}
+
+// TestDeserializeBlock_Truncated tests errors for incomplete block data
+func TestDeserializeBlock_Truncated(t *testing.T) {
+	raw := mustDecodeHex(block1Hex)
+
+	tests := []struct {
+		name     string
+		length   int
+		errorMsg string
+	}{
+		{"empty", 0, "insufficient data for block header"},
+		{"partial header", 40, "insufficient data for block header"},
+		{"missing transaction count", BlockHeaderSize, "transaction count"},
+		{"partial transaction", BlockHeaderSize + 20, "failed to decode transaction 0"},
+		{"missing locktime", len(raw) - 2, "insufficient data for locktime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DeserializeBlock(bytes.NewReader(raw[:tt.length]))
+			if err == nil || !contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Expected error containing '%s', got %v", tt.errorMsg, err)
+			}
+		})
+	}
+}
+
+// TestDeserializeBlockHeader tests header round-tripping
+func TestDeserializeBlockHeader(t *testing.T) {
+	raw := mustDecodeHex(genesisBlockHex)[:BlockHeaderSize]
+
+	header, err := DeserializeBlockHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to deserialize header: %v", err)
+	}
+	if header.Timestamp != 1231006505 || header.Bits != 0x1d00ffff || header.Nonce != 2083236893 {
+		t.Errorf("Unexpected header fields: %+v", header)
+	}
+
+	serialized, err := header.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize header: %v", err)
+	}
+	if !bytes.Equal(serialized, raw) {
+		t.Errorf("Expected %x, got %x", raw, serialized)
+	}
+}
+
+// TestBlockChain_AddMainnetBlock tests connecting a real mainnet block
+func TestBlockChain_AddMainnetBlock(t *testing.T) {
+	genesis, err := DeserializeBlock(bytes.NewReader(mustDecodeHex(genesisBlockHex)))
+	if err != nil {
+		t.Fatalf("Failed to deserialize genesis block: %v", err)
+	}
+	block1, err := DeserializeBlock(bytes.NewReader(mustDecodeHex(block1Hex)))
+	if err != nil {
+		t.Fatalf("Failed to deserialize block 1: %v", err)
+	}
+
+	blockchain := NewBlockChain(genesis)
+	if err := blockchain.AddBlock(block1); err != nil {
+		t.Fatalf("Failed to add block 1: %v", err)
+	}
+	if blockchain.Height() != 1 || blockchain.GetTip().Hash() != block1.Hash() {
+		t.Errorf("Expected block 1 at the tip, got height %d", blockchain.Height())
+	}
+}
@@ -1,6 +1,7 @@
 package bitcoin
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -456,6 +457,7 @@ func TestBlock_Validate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			header := NewBlockHeader(1, ZeroHash, ZeroHash, 1640995200, 0x1d00ffff, 0)
 			block := NewBlock(header, tt.transactions)
+			block.Header.MerkleRoot = block.CalculateMerkleRoot()
 
 			err := block.Validate()
 
@@ -585,7 +587,7 @@ func TestBlock_Weight(t *testing.T) {
 	weight := block.Weight()
 	size := block.Size()
 
-	// Currently weight = size * 4 (placeholder implementation)
+	// Without witness data every byte weighs four units
 	expectedWeight := size * 4
 	if weight != expectedWeight {
 		t.Errorf("expected weight %d, got %d", expectedWeight, weight)
@@ -597,6 +599,51 @@ func TestBlock_Weight(t *testing.T) {
 	}
 }
 
+// TestBlock_SizeAndWeight tests exact block sizes with witness data
+func TestBlock_SizeAndWeight(t *testing.T) {
+	block := createWitnessBlock()
+
+	expectedSize := BlockHeaderSize + 1
+	expectedStripped := BlockHeaderSize + 1
+	for i := range block.Transactions {
+		serialized, err := block.Transactions[i].Serialize()
+		if err != nil {
+			t.Fatalf("Failed to serialize transaction %d: %v", i, err)
+		}
+		stripped, err := block.Transactions[i].serializeForHashing()
+		if err != nil {
+			t.Fatalf("Failed to serialize stripped transaction %d: %v", i, err)
+		}
+		expectedSize += len(serialized)
+		expectedStripped += len(stripped)
+	}
+
+	if block.Size() != expectedSize {
+		t.Errorf("Expected size %d, got %d", expectedSize, block.Size())
+	}
+	if block.StrippedSize() != expectedStripped {
+		t.Errorf("Expected stripped size %d, got %d", expectedStripped, block.StrippedSize())
+	}
+	if expectedWeight := expectedStripped*3 + expectedSize; block.Weight() != expectedWeight {
+		t.Errorf("Expected weight %d, got %d", expectedWeight, block.Weight())
+	}
+	if block.VirtualSize() != (block.Weight()+3)/4 {
+		t.Errorf("Expected vsize %d, got %d", (block.Weight()+3)/4, block.VirtualSize())
+	}
+
+	// Witness data beyond the legacy 1MB limit is fine as long as the weight fits
+	block.Transactions[1].Inputs[0].Witness = [][]byte{make([]byte, MaxBlockSize)}
+	if err := block.Validate(); err != nil && contains(err.Error(), "size") {
+		t.Errorf("Witness data should not count towards the size limit: %v", err)
+	}
+
+	// Heavy witness data is still bounded by the weight limit
+	block.Transactions[1].Inputs[0].Witness = [][]byte{make([]byte, MaxBlockWeight)}
+	if err := block.Validate(); err == nil || !contains(err.Error(), "weight") {
+		t.Errorf("Expected weight limit error, got %v", err)
+	}
+}
+
 // TestBlockConstants tests Bitcoin block constants
 func TestBlockConstants(t *testing.T) {
 	// Test MaxBlockSize constant
@@ -700,7 +747,7 @@ func TestBlockHeader_Serialize(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			data, err := tt.header.serialize()
+			data, err := tt.header.Serialize()
 
 			if tt.shouldError {
 				if err == nil {
@@ -842,6 +889,147 @@ func TestBlock_Validate_EdgeCases(t *testing.T) {
 	}
 }
 
+// TestBlock_ValidateWitnessCommitment tests BIP141 witness commitment validation
+func TestBlock_ValidateWitnessCommitment(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(b *Block)
+		errorMsg string
+	}{
+		{"valid commitment", func(b *Block) {}, ""},
+		{"tampered witness", func(b *Block) {
+			b.Transactions[1].Inputs[0].Witness[0][0] ^= 0x01
+		}, "witness commitment mismatch"},
+		{"different reserved value", func(b *Block) {
+			b.Transactions[0].Inputs[0].Witness[0][0] = 0x01
+		}, "witness commitment mismatch"},
+		{"missing reserved value", func(b *Block) {
+			b.Transactions[0].Inputs[0].Witness = nil
+		}, "single 32-byte reserved value"},
+		{"missing commitment with witness data", func(b *Block) {
+			b.Transactions[0].Outputs = b.Transactions[0].Outputs[:1]
+		}, "no witness commitment"},
+		{"missing commitment without witness data", func(b *Block) {
+			b.Transactions[0].Outputs = b.Transactions[0].Outputs[:1]
+			b.Transactions[0].Inputs[0].Witness = nil
+			b.Transactions[1].Inputs[0].Witness = nil
+		}, ""},
+		{"last matching output is the commitment", func(b *Block) {
+			bogus := append([]byte{}, b.Transactions[0].Outputs[1].ScriptPubKey...)
+			bogus[len(bogus)-1] ^= 0x01
+			b.Transactions[0].Outputs = append(b.Transactions[0].Outputs, TxOutput{ScriptPubKey: bogus})
+		}, "witness commitment mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := createWitnessBlock()
+			tt.modify(block)
+			// Mutations must not be masked by cached hashes
+			for i := range block.Transactions {
+				block.Transactions[i].wthash = nil
+			}
+
+			err := block.ValidateWitnessCommitment()
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Expected error containing '%s', got %v", tt.errorMsg, err)
+			}
+		})
+	}
+
+	if err := createWitnessBlock().Validate(); err != nil {
+		t.Errorf("Expected witness block to validate, got %v", err)
+	}
+
+	// Without a height the commitment is left to the chain
+	block := createWitnessBlock()
+	block.Transactions[0].Outputs = block.Transactions[0].Outputs[:1]
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	if err := block.Validate(); err != nil {
+		t.Errorf("Expected block without a commitment to pass basic validation, got %v", err)
+	}
+}
+
+// createWitnessBlock creates a block with one segwit spend and a valid witness commitment
+func createWitnessBlock() *Block {
+	coinbase := Transaction{
+		Version: 1,
+		Inputs: []TxInput{{
+			PreviousOutput: OutPoint{Hash: Hash256{}, Index: 0xffffffff},
+			ScriptSig:      []byte{0x01, 0x42},
+			Sequence:       0xffffffff,
+			Witness:        [][]byte{make([]byte, 32)},
+		}},
+		Outputs: []TxOutput{{
+			Value:        5000000000,
+			ScriptPubKey: []byte{0x76, 0xa9, 0x14},
+		}},
+	}
+	spend := Transaction{
+		Version: 2,
+		Inputs: []TxInput{{
+			PreviousOutput: OutPoint{Hash: Hash256{0x01}, Index: 0},
+			Sequence:       0xffffffff,
+			Witness:        [][]byte{{0x30, 0x01}, {0x02, 0x03}},
+		}},
+		Outputs: []TxOutput{{
+			Value:        1000,
+			ScriptPubKey: []byte{0x00, 0x14},
+		}},
+	}
+	transactions := []Transaction{coinbase, spend}
+
+	witnessRoot := reverseHash(CalculateWitnessMerkleRoot(transactions))
+	commitment := DoubleHashSHA256(append(witnessRoot[:], make([]byte, 32)...))
+	script := append([]byte{byte(OP_RETURN), 0x24}, WitnessCommitmentHeader...)
+	transactions[0].Outputs = append(transactions[0].Outputs, TxOutput{ScriptPubKey: append(script, commitment[:]...)})
+
+	header := NewBlockHeader(1, ZeroHash, ZeroHash, 1640995200, 0x207fffff, 0)
+	block := NewBlock(header, transactions)
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	return block
+}
+
+// TestBlock_CheckMerkleRoot tests header merkle root verification
+func TestBlock_CheckMerkleRoot(t *testing.T) {
+	block := createWitnessBlock()
+	block.Transactions = append(block.Transactions, Transaction{
+		Version: 1,
+		Inputs:  []TxInput{{PreviousOutput: OutPoint{Hash: Hash256{0x02}}, Sequence: 0xffffffff}},
+		Outputs: []TxOutput{{Value: 1000, ScriptPubKey: []byte{0x51}}},
+	})
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	if err := block.CheckMerkleRoot(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Header committing to different transactions
+	wrongRoot := *block
+	wrongRoot.Header.MerkleRoot = Hash256{0x01}
+	if err := wrongRoot.CheckMerkleRoot(); !errors.Is(err, ErrBlockMutated) || !contains(err.Error(), "merkle root mismatch") {
+		t.Errorf("Expected merkle root mismatch, got %v", err)
+	}
+
+	// Duplicating the last transaction keeps the root but mutates the block
+	duplicated := *block
+	duplicated.Transactions = append(duplicated.Transactions[:3:3], duplicated.Transactions[2])
+	if duplicated.CalculateMerkleRoot() != block.Header.MerkleRoot {
+		t.Fatal("Expected duplicated transaction list to produce the same merkle root")
+	}
+	if err := duplicated.CheckMerkleRoot(); !errors.Is(err, ErrBlockMutated) || !contains(err.Error(), "duplicate") {
+		t.Errorf("Expected duplicate transaction mutation, got %v", err)
+	}
+	if err := duplicated.Validate(); !errors.Is(err, ErrBlockMutated) {
+		t.Errorf("Expected Validate to reject the mutated block, got %v", err)
+	}
+}
+
 func mustParseHash(hashStr string) Hash256 {
 	hash, err := NewHash256FromString(hashStr)
 	if err != nil {
@@ -3,44 +3,248 @@ package bitcoin
 import (
 	"errors"
 	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
 )
 
+// CoinsFlushInterval is the longest a disk-backed chain keeps coin changes
+// only in memory. The cache is also flushed whenever it outgrows its budget.
+const CoinsFlushInterval = time.Hour
+
 // BlockChain represents a Bitcoin blockchain
 // TDD GREEN: Basic implementation to make tests pass
 type BlockChain struct {
-	blocks  []*Block
+	params  *ChainParams
+	options ValidationOptions
+	chain   []*blockNode // Main chain, indexed by height
 	utxoSet *UTXOSet
 	tip     *Block // Current chain tip
 
-	// For reorganization support
-	forkBlocks map[string][]*Block // Track competing forks by their root hash
+	// Every known block, including forks and invalid blocks
+	index   *blockIndex
+	tipNode *blockNode
+
+	// Flat-file block storage; nil keeps every block in memory
+	store     *BlockStore
+	lastFlush time.Time
+
+	// Network-adjusted clock that bounds block timestamps from above
+	timeSource TimeSource
 }
 
-// NewBlockChain creates a new blockchain
+// ValidationOptions adjusts consensus checks for callers that build chains
+// without mining them. The zero value enforces every rule.
+type ValidationOptions struct {
+	// SkipProofOfWork accepts headers whose hash does not meet their target.
+	// It is intended for tests only and must never be set on a live node.
+	SkipProofOfWork bool
+}
+
+// NewBlockChain creates a new mainnet blockchain
 func NewBlockChain(genesisBlock *Block) *BlockChain {
+	return NewBlockChainWithParams(&MainNetParams, genesisBlock)
+}
+
+// NewBlockChainWithParams creates a new blockchain following the rules of the
+// given network. Pass params.GenesisBlock to start from the network's genesis.
+func NewBlockChainWithParams(params *ChainParams, genesisBlock *Block) *BlockChain {
+	return NewBlockChainWithOptions(params, genesisBlock, ValidationOptions{})
+}
+
+// NewBlockChainWithOptions creates a new blockchain with adjusted validation
+func NewBlockChainWithOptions(params *ChainParams, genesisBlock *Block, options ValidationOptions) *BlockChain {
 	blockchain := &BlockChain{
-		blocks:     make([]*Block, 0),
+		params:     params,
+		options:    options,
+		chain:      make([]*blockNode, 0),
 		utxoSet:    NewUTXOSet(),
-		forkBlocks: make(map[string][]*Block),
+		index:      newBlockIndex(),
+		timeSource: NewMedianTimeSource(),
 	}
 
 	if genesisBlock != nil {
-		blockchain.blocks = append(blockchain.blocks, genesisBlock)
-		blockchain.tip = genesisBlock
-
-		// Process Genesis block transactions to populate UTXO set
-		blockchain.processBlockTransactions(genesisBlock)
+		node := blockchain.index.addNode(genesisBlock, nil, StatusDataStored)
+		_ = blockchain.connectTip(node) // Cannot fail without a block store
 	}
 
 	return blockchain
 }
 
+// NewBlockChainWithStore creates a blockchain whose blocks are kept in
+// store, with the UTXO set in memory. The UTXO set is rebuilt at startup by
+// connecting every stored block of the chain with most work again.
+func NewBlockChainWithStore(params *ChainParams, store *BlockStore, options ValidationOptions) (*BlockChain, error) {
+	return NewBlockChainWithCoins(params, store, NewUTXOSet(), options)
+}
+
+// NewBlockChainWithCoins creates a blockchain whose blocks are kept in store
+// and whose UTXO set is coins. An empty store is initialized with the
+// network's genesis block; otherwise the block tree is rebuilt from the
+// store's index. A disk-backed UTXO set resumes from the best block it
+// records, so only blocks connected after its last flush are processed again.
+// If those blocks cannot be connected, the chain resumes at that best block.
+func NewBlockChainWithCoins(params *ChainParams, store *BlockStore, coins *UTXOSet, options ValidationOptions) (*BlockChain, error) {
+	blockchain := &BlockChain{
+		params:     params,
+		options:    options,
+		chain:      make([]*blockNode, 0),
+		utxoSet:    coins,
+		index:      newBlockIndex(),
+		store:      store,
+		lastFlush:  time.Now(),
+		timeSource: NewMedianTimeSource(),
+	}
+
+	entries := store.Entries()
+	if len(entries) == 0 {
+		if coins.BestBlock() != ZeroHash {
+			return nil, errors.New("coins database does not match the empty block store")
+		}
+		if err := blockchain.AddBlock(params.GenesisBlock); err != nil {
+			return nil, err
+		}
+		return blockchain, blockchain.Flush()
+	}
+
+	var best *blockNode
+	for i := range entries {
+		entry := &entries[i]
+		var parent *blockNode
+		if entry.Header.PrevBlockHash != ZeroHash {
+			if parent = blockchain.index.lookup(entry.Header.PrevBlockHash); parent == nil {
+				continue // Orphaned by a block lost in an unclean shutdown
+			}
+		} else if entry.Hash != params.GenesisHash {
+			return nil, fmt.Errorf("block store holds genesis block %s of another network", entry.Hash)
+		}
+
+		// Connection is redone below, so only failures are carried over
+		node := blockchain.index.addHeader(entry.Header, parent, entry.Status&^StatusValid)
+		if !node.status.KnownInvalid() && (best == nil || node.work.Cmp(best.work) > 0) {
+			best = node
+		}
+	}
+	if best == nil {
+		return nil, errors.New("block store holds no valid chain")
+	}
+
+	// Resume from the block the coins were flushed at, or start from genesis
+	start := blockchain.index.lookup(params.GenesisHash)
+	resumed := false
+	if coinsBest := coins.BestBlock(); coinsBest != ZeroHash {
+		if start = blockchain.index.lookup(coinsBest); start == nil {
+			return nil, fmt.Errorf("coins database best block %s not in block store", coinsBest)
+		}
+		resumed = true
+	}
+	if err := blockchain.setTip(start, !resumed); err != nil {
+		return nil, err
+	}
+
+	if tip := blockchain.tipNode; best != tip && best.work.Cmp(tip.work) > 0 {
+		if err := blockchain.reorganize(best); err != nil {
+			// A block that cannot be connected yet, or turns out invalid, must
+			// not stop the node starting on the chain it resumed
+			if blockchain.tipNode != tip {
+				return nil, fmt.Errorf("failed to reconnect blocks: %w", err)
+			}
+			log.Printf("Resuming at block %s: failed to reconnect blocks: %v", tip.hash, err)
+		}
+	}
+
+	return blockchain, blockchain.Flush()
+}
+
+// setTip makes node the tip of an empty main chain. The UTXO set either
+// already reflects it, or, if connect is set, is built by connecting every
+// block from genesis.
+func (bc *BlockChain) setTip(node *blockNode, connect bool) error {
+	var path []*blockNode
+	for n := node; n != nil; n = n.parent {
+		path = append(path, n)
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		if connect {
+			if err := bc.connectTip(path[i]); err != nil {
+				return fmt.Errorf("failed to reconnect block %s: %w", path[i].hash, err)
+			}
+			continue
+		}
+		path[i].status |= StatusValid
+		bc.chain = append(bc.chain, path[i])
+		bc.tipNode = path[i]
+	}
+
+	tip, err := bc.loadBlock(node)
+	if err != nil {
+		return err
+	}
+	node.block = tip
+	bc.tip = tip
+	return nil
+}
+
+// Flush makes the chain state durable: block data first, then the coins
+// together with the tip they reflect. It does nothing for in-memory chains
+// and must be called before shutdown.
+func (bc *BlockChain) Flush() error {
+	if bc.store != nil {
+		if err := bc.store.Sync(); err != nil {
+			return err
+		}
+	}
+	if bc.tipNode != nil {
+		if err := bc.utxoSet.Flush(bc.tipNode.hash); err != nil {
+			return err
+		}
+	}
+	bc.lastFlush = time.Now()
+	return nil
+}
+
+// flushIfNeeded flushes the chain state once the coins cache is full or has
+// not been flushed for CoinsFlushInterval
+func (bc *BlockChain) flushIfNeeded() error {
+	if bc.utxoSet.NeedsFlush() || time.Since(bc.lastFlush) > CoinsFlushInterval {
+		return bc.Flush()
+	}
+	return nil
+}
+
+// Params returns the chain parameters the blockchain validates against
+func (bc *BlockChain) Params() *ChainParams {
+	return bc.params
+}
+
+// TimeSource returns the network-adjusted clock used to reject blocks from
+// the future. Peer connections feed it the times from version messages.
+func (bc *BlockChain) TimeSource() TimeSource {
+	return bc.timeSource
+}
+
+// SetTimeSource replaces the network-adjusted clock, letting tests fix the current time
+func (bc *BlockChain) SetTimeSource(timeSource TimeSource) {
+	bc.timeSource = timeSource
+}
+
+// MedianTimePast returns the median timestamp of the last 11 main chain
+// blocks. The next block's timestamp must be greater than it.
+func (bc *BlockChain) MedianTimePast() uint32 {
+	if bc.tipNode == nil {
+		return 0
+	}
+	return bc.tipNode.medianTimePast()
+}
+
 // Height returns the current blockchain height (0-based)
 func (bc *BlockChain) Height() int {
-	if len(bc.blocks) == 0 {
+	if len(bc.chain) == 0 {
 		return -1 // Empty blockchain
 	}
-	return len(bc.blocks) - 1
+	return len(bc.chain) - 1
 }
 
 // GetTip returns the current chain tip block
@@ -48,35 +252,93 @@ func (bc *BlockChain) GetTip() *Block {
 	return bc.tip
 }
 
-// AddBlock adds a new block to the blockchain
-// TDD GREEN: Basic validation to make tests pass
+// ChainWork returns the cumulative proof of work of the main chain
+func (bc *BlockChain) ChainWork() *big.Int {
+	if bc.tipNode == nil {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Set(bc.tipNode.work)
+}
+
+// AddBlock adds a new block to the block tree and switches the main chain to
+// it if it now has the most cumulative work
 func (bc *BlockChain) AddBlock(block *Block) error {
 	if block == nil {
 		return errors.New("cannot add nil block")
 	}
 
-	// Check if this block builds on current tip (normal case)
-	if bc.tip != nil && block.Header.PrevBlockHash == bc.tip.Hash() {
-		// Normal block addition
+	hash := block.Hash()
+	if node := bc.index.lookup(hash); node != nil {
+		if node.status.KnownInvalid() {
+			return fmt.Errorf("block %s previously marked invalid", hash)
+		}
+		return fmt.Errorf("block %s already known", hash)
+	}
+
+	// The first block of an empty chain is its genesis
+	if bc.tipNode == nil && block.Header.PrevBlockHash == ZeroHash {
+		if hash != bc.params.GenesisHash {
+			return fmt.Errorf("block %s is not the %s genesis block", hash, bc.params.Name)
+		}
 		if err := bc.validateBlock(block); err != nil {
-			return fmt.Errorf("block validation failed: %v", err)
+			return fmt.Errorf("block validation failed: %w", err)
+		}
+		if err := bc.storeBlock(block, 0); err != nil {
+			return err
 		}
+		return bc.connectTip(bc.index.addNode(block, nil, StatusDataStored))
+	}
 
-		bc.blocks = append(bc.blocks, block)
-		bc.tip = block
-		bc.processBlockTransactions(block)
-		return nil
+	parent := bc.index.lookup(block.Header.PrevBlockHash)
+	if parent == nil {
+		return errors.New("block does not connect to any known block")
+	}
+	if parent.status.KnownInvalid() {
+		bc.index.addNode(block, parent, StatusDataStored)
+		return fmt.Errorf("block %s descends from an invalid block", hash)
+	}
+
+	// Blocks extending the tip get full validation, others are validated
+	// as fork blocks at their height in the tree
+	var err error
+	if parent == bc.tipNode {
+		err = bc.validateBlock(block)
+	} else {
+		err = bc.validateForkBlock(block, parent)
+	}
+	if err != nil {
+		bc.markInvalid(block, parent, err)
+		if parent == bc.tipNode {
+			return fmt.Errorf("block validation failed: %w", err)
+		}
+		return fmt.Errorf("fork block validation failed: %w", err)
+	}
+
+	if err := bc.storeBlock(block, parent.height+1); err != nil {
+		return err
 	}
+	node := bc.index.addNode(block, parent, StatusDataStored)
 
-	// Check if this block starts a reorganization
-	return bc.handlePotentialReorganization(block)
+	// Only strictly more work moves the tip; on a tie the first block seen wins
+	if node.work.Cmp(bc.tipNode.work) > 0 {
+		if parent == bc.tipNode {
+			if err := bc.connectTip(node); err != nil {
+				return err
+			}
+		} else if err := bc.reorganize(node); err != nil {
+			return fmt.Errorf("reorganization failed: %w", err)
+		}
+	}
+	bc.releaseBlock(node)
+
+	return bc.flushIfNeeded()
 }
 
 // validateBlock performs basic block validation
 // TDD GREEN: Basic validation logic
 func (bc *BlockChain) validateBlock(block *Block) error {
 	// Check if blockchain is empty (only Genesis allowed)
-	if len(bc.blocks) == 0 {
+	if len(bc.chain) == 0 {
 		// For Genesis block, just check basic structure
 		if len(block.Transactions) == 0 {
 			return errors.New("genesis block must have at least one transaction")
@@ -90,62 +352,171 @@ func (bc *BlockChain) validateBlock(block *Block) error {
 		return errors.New("invalid previous block hash")
 	}
 
-	// Check proof of work (skip for test blocks with specific test nonces)
-	blockHash := block.Hash()
-	isTestBlock := (block.Header.Nonce == 1) || (block.Header.Nonce >= 12345 && block.Header.Nonce < 20000) ||
-		(block.Header.Nonce >= 50000 && block.Header.Nonce < 60000) // Include fork test range
-	if !isTestBlock {
-		if !ValidateProofOfWork(blockHash, block.Header.Bits) {
-			return errors.New("invalid proof of work")
+	// Check proof of work
+	if err := bc.checkProofOfWork(&block.Header); err != nil {
+		return err
+	}
+
+	// Check the header against its position in the chain
+	if err := bc.checkHeaderContext(&block.Header, bc.tipNode); err != nil {
+		return err
+	}
+
+	// Check the transactions match the header
+	if err := block.CheckMerkleRoot(); err != nil {
+		return err
+	}
+	if err := bc.checkWitness(block, int32(len(bc.chain))); err != nil {
+		return err
+	}
+
+	// Check the transactions themselves, now they are known to be the ones committed to
+	return checkBlockSanity(block)
+}
+
+// checkWitness validates a block's witness data against the rules in force at
+// its height: the BIP141 commitment once segwit is active, none at all before
+func (bc *BlockChain) checkWitness(block *Block, height int32) error {
+	if bc.params.IsSegwitActive(height) {
+		return block.ValidateWitnessCommitment()
+	}
+
+	for i := range block.Transactions {
+		if block.Transactions[i].HasWitness() {
+			return fmt.Errorf("%w: unexpected witness data before segwit activation", ErrBlockMutated)
 		}
 	}
+	return nil
+}
+
+// checkProofOfWork checks a header against the network's proof of work rules
+// unless the chain was created with SkipProofOfWork
+func (bc *BlockChain) checkProofOfWork(header *BlockHeader) error {
+	if bc.options.SkipProofOfWork {
+		return nil
+	}
+	return CheckProofOfWork(header, bc.params)
+}
+
+// checkHeaderContext checks the header rules that depend on its parent: the
+// difficulty bits required by retargeting and the BIP94 timewarp limit
+func (bc *BlockChain) checkHeaderContext(header *BlockHeader, parent *blockNode) error {
+	expectedBits := calcNextRequiredDifficulty(bc.params, parent, header.Timestamp)
+	if header.Bits != expectedBits {
+		return ruleError(ErrBadDiffBits, "incorrect proof of work bits: got 0x%08x, expected 0x%08x", header.Bits, expectedBits)
+	}
 
-	// Check block has transactions
-	if len(block.Transactions) == 0 {
-		return errors.New("block must have at least one transaction")
+	if mtp := parent.medianTimePast(); header.Timestamp <= mtp {
+		return ruleError(ErrTimeTooOld, "block timestamp %d not after median time past %d", header.Timestamp, mtp)
 	}
 
-	// Check first transaction is coinbase
-	firstTx := &block.Transactions[0]
-	if !firstTx.IsCoinbase() {
-		return errors.New("first transaction must be coinbase")
+	maxTime := bc.timeSource.AdjustedTime().Add(MaxFutureBlockTime)
+	if header.Time().After(maxTime) {
+		return fmt.Errorf("%w: block timestamp %d later than %d", ErrBlockTimeTooNew, header.Timestamp, maxTime.Unix())
+	}
+
+	// BIP94: the first block of a period may not predate its parent by more than maxTimewarp
+	height := parent.height + 1
+	if bc.params.EnforceBIP94 && height%bc.params.DifficultyAdjustmentInterval() == 0 &&
+		int64(header.Timestamp) < int64(parent.header.Timestamp)-maxTimewarp {
+		return ruleError(ErrTimewarp, "block timestamp %d too far before previous block at retarget height %d",
+			header.Timestamp, height)
 	}
 
 	return nil
 }
 
+// NextWorkRequired returns the difficulty bits a block with the given
+// timestamp must carry to extend the block with hash prevHash
+func (bc *BlockChain) NextWorkRequired(prevHash Hash256, timestamp uint32) (uint32, error) {
+	parent := bc.index.lookup(prevHash)
+	if parent == nil {
+		return 0, fmt.Errorf("unknown block %s", prevHash)
+	}
+	return calcNextRequiredDifficulty(bc.params, parent, timestamp), nil
+}
+
+// IsBlockInvalid reports whether a block hash has been marked invalid
+func (bc *BlockChain) IsBlockInvalid(hash Hash256) bool {
+	node := bc.index.lookup(hash)
+	return node != nil && node.status.KnownInvalid()
+}
+
+// markInvalid records that a block failed validation so it is not
+// reconsidered, unless it was mutated or is from the future (see connectTip)
+func (bc *BlockChain) markInvalid(block *Block, parent *blockNode, err error) {
+	if errors.Is(err, ErrBlockMutated) || errors.Is(err, ErrBlockTimeTooNew) {
+		return
+	}
+	bc.index.addNode(block, parent, StatusValidateFailed)
+}
+
+// GetChainTips returns the tip of every known branch of the block tree,
+// including the main chain, in the manner of getchaintips
+func (bc *BlockChain) GetChainTips() []ChainTip {
+	var tips []ChainTip
+	for _, node := range bc.index.tips() {
+		tip := ChainTip{Height: node.height, Hash: node.hash}
+
+		fork := findFork(node, bc.tipNode)
+		if fork != nil {
+			tip.BranchLen = node.height - fork.height
+		} else {
+			tip.BranchLen = node.height + 1
+		}
+
+		switch {
+		case node == bc.tipNode:
+			tip.Status = ChainTipActive
+		case node.status.KnownInvalid():
+			tip.Status = ChainTipInvalid
+		case node.status&StatusValid != 0:
+			tip.Status = ChainTipValidFork
+		case node.status&StatusDataStored != 0:
+			tip.Status = ChainTipValidHeaders
+		default:
+			tip.Status = ChainTipHeadersOnly
+		}
+
+		tips = append(tips, tip)
+	}
+
+	// Highest tips first, as getchaintips reports them
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].Height != tips[j].Height {
+			return tips[i].Height > tips[j].Height
+		}
+		return tips[i].Hash.String() < tips[j].Hash.String()
+	})
+	return tips
+}
+
 // ValidateChain validates the entire blockchain
 // TDD GREEN: Basic chain validation
 func (bc *BlockChain) ValidateChain() bool {
-	if len(bc.blocks) == 0 {
+	if len(bc.chain) == 0 {
 		return true // Empty chain is valid
 	}
 
 	// Validate Genesis block
-	genesis := bc.blocks[0]
-	if genesis.Header.PrevBlockHash != ZeroHash {
+	genesis := bc.chain[0]
+	if genesis.header.PrevBlockHash != ZeroHash {
 		return false
 	}
 
 	// Validate chain links
-	for i := 1; i < len(bc.blocks); i++ {
-		currentBlock := bc.blocks[i]
-		prevBlock := bc.blocks[i-1]
+	for i := 1; i < len(bc.chain); i++ {
+		current := bc.chain[i]
+		prev := bc.chain[i-1]
 
 		// Check previous hash link
-		if currentBlock.Header.PrevBlockHash != prevBlock.Hash() {
+		if current.header.PrevBlockHash != prev.hash {
 			return false
 		}
 
-		// Check proof of work (skip for test blocks)
-		blockHash := currentBlock.Hash()
-		isTestBlock := (currentBlock.Header.Nonce == 1) ||
-			(currentBlock.Header.Nonce >= 12345 && currentBlock.Header.Nonce < 20000) ||
-			(currentBlock.Header.Nonce >= 50000 && currentBlock.Header.Nonce < 60000) // Include fork test range
-		if !isTestBlock {
-			if !ValidateProofOfWork(blockHash, currentBlock.Header.Bits) {
-				return false
-			}
+		// Check proof of work
+		if err := bc.checkProofOfWork(&current.header); err != nil {
+			return false
 		}
 	}
 
@@ -157,53 +528,84 @@ func (bc *BlockChain) GetUTXOSet() *UTXOSet {
 	return bc.utxoSet
 }
 
-// processBlockTransactions processes all transactions in a block to update UTXO set
-// TDD GREEN: Basic UTXO processing
-func (bc *BlockChain) processBlockTransactions(block *Block) {
-	for i, tx := range block.Transactions {
-		// Process transaction inputs (except coinbase)
-		if !tx.IsCoinbase() {
-			for _, input := range tx.Inputs {
-				// Remove spent UTXO
-				bc.utxoSet.Remove(input.PreviousOutput.Hash, input.PreviousOutput.Index)
-			}
-		}
-
-		// Process transaction outputs
-		txHash := tx.Hash()
-		for j, output := range tx.Outputs {
-			// Validate output index before conversion
-			if j < 0 || j > 0xffffffff {
-				continue // Skip invalid output index
-			}
-			// Create new UTXO
-			utxo := NewUTXO(txHash, uint32(j), output.Value, output.ScriptPubKey)
-			bc.utxoSet.Add(utxo)
-		}
-
-		// Log transaction processing
-		_ = i // Suppress unused variable warning
+// AuditSupply sums the UTXO set, reading every coin from disk if needed, and
+// compares it with the theoretical issuance at the tip. An error is returned
+// if the set holds more than was ever issued, or cannot be read.
+func (bc *BlockChain) AuditSupply() (SupplyAudit, error) {
+	height := bc.tipNode.height
+	audit := SupplyAudit{
+		Height:      height,
+		UTXOCount:   bc.utxoSet.Size(),
+		UTXOValue:   bc.utxoSet.TotalValue(),
+		MaxIssuance: TotalIssuance(height, bc.params),
 	}
+	if err := bc.utxoSet.readError(); err != nil {
+		return audit, err
+	}
+	if audit.UTXOValue > audit.MaxIssuance {
+		return audit, fmt.Errorf("UTXO set holds %d satoshis, more than the %d issued by height %d",
+			audit.UTXOValue, audit.MaxIssuance, height)
+	}
+	return audit, nil
 }
 
-// GetBlock returns block at specified height
+// GetBlock returns block at specified height, reading it from disk if
+// needed. It returns nil if the block cannot be read.
 func (bc *BlockChain) GetBlock(height int) *Block {
-	if height < 0 || height >= len(bc.blocks) {
+	if height < 0 || height >= len(bc.chain) {
 		return nil
 	}
-	return bc.blocks[height]
+	block, err := bc.loadBlock(bc.chain[height])
+	if err != nil {
+		return nil
+	}
+	return block
 }
 
-// GetBlockByHash returns block with specified hash
+// GetBlockByHash returns the main chain block with specified hash
 func (bc *BlockChain) GetBlockByHash(hash Hash256) *Block {
-	for _, block := range bc.blocks {
-		if block.Hash() == hash {
-			return block
-		}
+	node := bc.index.lookup(hash)
+	if node == nil || int(node.height) >= len(bc.chain) || bc.chain[node.height] != node {
+		return nil
+	}
+	return bc.GetBlock(int(node.height))
+}
+
+// loadBlock returns a node's block, reading it from the block store if it is
+// not held in memory
+func (bc *BlockChain) loadBlock(node *blockNode) (*Block, error) {
+	if node.block != nil {
+		return node.block, nil
+	}
+	if bc.store == nil {
+		return nil, fmt.Errorf("block %s data not available", node.hash)
+	}
+	block, err := bc.store.ReadBlock(node.hash)
+	if err != nil {
+		return nil, err
+	}
+	block.SetHeight(node.height)
+	return block, nil
+}
+
+// storeBlock writes a validated block to the block store, if there is one
+func (bc *BlockChain) storeBlock(block *Block, height int32) error {
+	if bc.store == nil {
+		return nil
+	}
+	if _, err := bc.store.WriteBlock(block, height, StatusDataStored); err != nil {
+		return fmt.Errorf("failed to store block: %w", err)
 	}
 	return nil
 }
 
+// releaseBlock drops a stored block from memory unless it is the tip
+func (bc *BlockChain) releaseBlock(node *blockNode) {
+	if bc.store != nil && node != bc.tipNode {
+		node.block = nil
+	}
+}
+
 // Contains checks if blockchain contains a block with given hash
 func (bc *BlockChain) Contains(hash Hash256) bool {
 	return bc.GetBlockByHash(hash) != nil
@@ -212,140 +614,174 @@ func (bc *BlockChain) Contains(hash Hash256) bool {
 // ForceReplaceBlock replaces a block at given height (for testing purposes only)
 // This method is used only in tests to simulate corruption
 func (bc *BlockChain) ForceReplaceBlock(height int, block *Block) {
-	if height >= 0 && height < len(bc.blocks) {
-		bc.blocks[height] = block
+	if height >= 0 && height < len(bc.chain) {
+		bc.chain[height] = &blockNode{
+			hash:   block.Hash(),
+			header: block.Header,
+			height: int32(height),
+			block:  block,
+		}
 		// Update tip if we replaced the last block
-		if height == len(bc.blocks)-1 {
+		if height == len(bc.chain)-1 {
 			bc.tip = block
 		}
 	}
 }
 
-// handlePotentialReorganization handles blocks that don't build on current tip
-// This implements the "longest chain rule" for blockchain reorganization
-func (bc *BlockChain) handlePotentialReorganization(block *Block) error {
-	// Find the common ancestor with this block
-	forkPoint := bc.findForkPoint(block.Header.PrevBlockHash)
-	if forkPoint == -1 {
-		// Check if this block builds on a known fork
-		forkKey, forkIndex := bc.findForkConnection(block.Header.PrevBlockHash)
-		if forkKey == "" {
-			return errors.New("block does not connect to any known block")
-		}
-
-		// This block extends an existing fork
-		bc.forkBlocks[forkKey] = append(bc.forkBlocks[forkKey], block)
-
-		// Check if this fork is now longer than main chain
-		forkLength := len(bc.forkBlocks[forkKey])
-		// For reorganization, compare the number of blocks built from the fork point
-		// Main chain blocks from fork point: len(bc.blocks) - forkIndex - 1
-		mainChainFromFork := len(bc.blocks) - forkIndex - 1
+// connectTip connects a child of the tip to the main chain, validating its
+// transactions with connectBlock and applying them to the UTXO set; on any
+// error the chain is left unchanged. Only a block that breaks a rule, failing
+// with a RuleError, is marked invalid. Other failures say nothing about the
+// block itself: its coins could not be read, the script engine does not
+// support its scripts yet, its transactions do not match the header its hash
+// commits to, or its timestamp is ahead of a clock that will catch up. Such a
+// block is rejected without being marked invalid, so it can still connect later.
+func (bc *BlockChain) connectTip(node *blockNode) error {
+	block, err := bc.loadBlock(node)
+	if err != nil {
+		return err
+	}
+	block.SetHeight(node.height)
 
-		// Only reorganize if fork is STRICTLY longer (not equal)
-		if forkLength > mainChainFromFork {
-			// Reorganize to this fork
-			bc.reorganizeToFork(forkIndex, bc.forkBlocks[forkKey])
+	view := NewUTXOView(bc.utxoSet)
+	undo, err := bc.connectBlock(node, block, view)
+	if readErr := bc.utxoSet.readError(); readErr != nil {
+		err = readErr
+	}
+	if err != nil {
+		var ruleErr RuleError
+		if errors.As(err, &ruleErr) {
+			bc.invalidateNode(node)
 		}
-
-		return nil
+		return fmt.Errorf("failed to connect block %s: %w", node.hash, err)
 	}
+	bc.utxoSet.commitBlock(node.hash, view, undo)
 
-	// Validate the block (skip previous hash check since it's a fork)
-	if err := bc.validateForkBlock(block); err != nil {
-		return fmt.Errorf("fork block validation failed: %v", err)
+	if bc.store != nil {
+		if err := bc.store.SetStatus(node.hash, node.status|StatusValid); err != nil {
+			return err
+		}
 	}
-
-	// This is the start of a new fork from main chain
-	forkKey := fmt.Sprintf("fork_%s", block.Header.PrevBlockHash.String())
-	bc.forkBlocks[forkKey] = []*Block{block}
-
-	// Compare blocks built from the fork point
-	forkBlocksFromPoint := 1 // This new block
-	mainChainFromFork := len(bc.blocks) - forkPoint - 1
-
-	if forkBlocksFromPoint > mainChainFromFork {
-		// Reorganize: replace main chain from fork point
-		bc.reorganizeToFork(forkPoint, []*Block{block})
+	node.status |= StatusValid
+
+	previous := bc.tipNode
+	bc.chain = append(bc.chain, node)
+	bc.tip = block
+	bc.tipNode = node
+	node.block = block
+	if previous != nil {
+		bc.releaseBlock(previous)
 	}
-
 	return nil
 }
 
-// findForkPoint finds the height where a block's previous hash matches our chain
-func (bc *BlockChain) findForkPoint(prevHash Hash256) int {
-	for i := len(bc.blocks) - 1; i >= 0; i-- {
-		if bc.blocks[i].Hash() == prevHash {
-			return i
+// invalidateNode marks a block that failed to connect as invalid, and every
+// known block built on it as descending from an invalid block
+func (bc *BlockChain) invalidateNode(node *blockNode) {
+	node.status |= StatusValidateFailed
+	if bc.store != nil {
+		// Best effort: the block is checked again after a restart if this is lost
+		_ = bc.store.SetStatus(node.hash, node.status)
+	}
+	for _, n := range bc.index.nodes {
+		if n != node && n.ancestor(node.height) == node {
+			n.status |= StatusInvalidAncestor
 		}
 	}
-	return -1 // No common ancestor found
 }
 
-// findForkConnection finds if a hash connects to any tracked fork
-func (bc *BlockChain) findForkConnection(prevHash Hash256) (string, int) {
-	for forkKey, forkChain := range bc.forkBlocks {
-		for _, block := range forkChain {
-			if block.Hash() == prevHash {
-				// Extract the fork point from the fork key
-				// For simplicity, we'll find where the fork started
-				if len(forkChain) > 0 {
-					forkPoint := bc.findForkPoint(forkChain[0].Header.PrevBlockHash)
-					return forkKey, forkPoint
-				}
-			}
-		}
+// disconnectTip removes the tip from the main chain, restoring the outputs
+// it spent from the undo data recorded when it was connected
+func (bc *BlockChain) disconnectTip() error {
+	node := bc.tipNode
+	if node.parent == nil {
+		return errors.New("cannot disconnect the genesis block")
+	}
+	parent, err := bc.loadBlock(node.parent)
+	if err != nil {
+		return err
+	}
+	undo, err := bc.utxoSet.BlockUndo(node.hash)
+	if err != nil {
+		return err
 	}
-	return "", -1
+	if err := bc.utxoSet.DisconnectBlock(node.block, undo); err != nil {
+		return err
+	}
+
+	bc.chain = bc.chain[:node.height]
+	bc.tipNode = node.parent
+	bc.tip = parent
+	node.parent.block = parent
+	bc.releaseBlock(node)
+	return nil
 }
 
-// reorganizeToFork reorganizes the blockchain to a new fork
-func (bc *BlockChain) reorganizeToFork(forkPoint int, forkBlocks []*Block) {
-	// Truncate the current chain to the fork point
-	bc.blocks = bc.blocks[:forkPoint+1]
+// reorganize switches the main chain to end at node, which has more work
+// than the current tip. Blocks are disconnected back to the fork point and the
+// new branch connected, so the cost depends only on the depth of the reorganization.
+func (bc *BlockChain) reorganize(node *blockNode) error {
+	fork := findFork(node, bc.tipNode)
 
-	// Add all fork blocks
-	bc.blocks = append(bc.blocks, forkBlocks...)
-	bc.tip = forkBlocks[len(forkBlocks)-1]
+	// Collect the new branch from the fork point up to the node
+	var attach []*blockNode
+	for n := node; n != fork; n = n.parent {
+		attach = append(attach, n)
+	}
 
-	// Rebuild UTXO set from scratch
-	bc.rebuildUTXOSet()
+	var detach []*blockNode
+	for bc.tipNode != fork {
+		tip := bc.tipNode
+		if err := bc.disconnectTip(); err != nil {
+			// Reconnect what was already detached rather than stop part way
+			return bc.restoreBranch(bc.tipNode, detach, err)
+		}
+		detach = append(detach, tip)
+	}
+	for i := len(attach) - 1; i >= 0; i-- {
+		if err := bc.connectTip(attach[i]); err != nil {
+			return bc.restoreBranch(fork, detach, err)
+		}
+	}
+	return nil
 }
 
-// rebuildUTXOSet rebuilds the UTXO set from the current blockchain
-func (bc *BlockChain) rebuildUTXOSet() {
-	// Clear existing UTXO set
-	bc.utxoSet.Clear()
-
-	// Process all blocks in order
-	for _, block := range bc.blocks {
-		bc.processBlockTransactions(block)
+// restoreBranch returns the main chain to the branch that was detached by a
+// reorganization that failed with cause
+func (bc *BlockChain) restoreBranch(fork *blockNode, detach []*blockNode, cause error) error {
+	for bc.tipNode != fork {
+		if err := bc.disconnectTip(); err != nil {
+			return fmt.Errorf("%v; failed to restore previous chain: %w", cause, err)
+		}
 	}
+	for i := len(detach) - 1; i >= 0; i-- {
+		if err := bc.connectTip(detach[i]); err != nil {
+			return fmt.Errorf("%v; failed to restore previous chain: %w", cause, err)
+		}
+	}
+	return cause
 }
 
-// validateForkBlock performs validation for fork blocks (skips previous hash check)
-func (bc *BlockChain) validateForkBlock(block *Block) error {
-	// Check block has transactions
-	if len(block.Transactions) == 0 {
-		return errors.New("block must have at least one transaction")
+// validateForkBlock performs validation for fork blocks building on parent (skips previous hash check)
+func (bc *BlockChain) validateForkBlock(block *Block, parent *blockNode) error {
+	// Check proof of work
+	if err := bc.checkProofOfWork(&block.Header); err != nil {
+		return err
 	}
 
-	// Check first transaction is coinbase
-	firstTx := &block.Transactions[0]
-	if !firstTx.IsCoinbase() {
-		return errors.New("first transaction must be coinbase")
+	// Check the header against its position in the chain
+	if err := bc.checkHeaderContext(&block.Header, parent); err != nil {
+		return err
 	}
 
-	// Skip proof of work check for test blocks
-	blockHash := block.Hash()
-	isTestBlock := (block.Header.Nonce == 1) || (block.Header.Nonce >= 12345 && block.Header.Nonce < 20000) ||
-		(block.Header.Nonce >= 50000 && block.Header.Nonce < 60000) // Include fork test range
-
-	if !isTestBlock {
-		if !ValidateProofOfWork(blockHash, block.Header.Bits) {
-			return errors.New("invalid proof of work")
-		}
+	// Check the transactions match the header
+	if err := block.CheckMerkleRoot(); err != nil {
+		return err
+	}
+	if err := bc.checkWitness(block, parent.height+1); err != nil {
+		return err
 	}
 
-	return nil
+	// Check the transactions themselves, now they are known to be the ones committed to
+	return checkBlockSanity(block)
 }
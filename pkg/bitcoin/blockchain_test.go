@@ -1,6 +1,7 @@
 package bitcoin
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -203,19 +204,19 @@ func TestBlockChain_UTXO_Integration(t *testing.T) {
 		{
 			name:          "Genesis block UTXO creation",
 			transactions:  []string{"coinbase"},
-			expectedUTXOs: 1,
-			description:   "Genesis coinbase should create initial UTXO",
+			expectedUTXOs: 0,
+			description:   "Genesis coinbase should not create a UTXO",
 		},
 		{
 			name:          "Simple spend and create",
 			transactions:  []string{"coinbase", "spend_and_create"},
-			expectedUTXOs: 2, // Genesis coinbase + new block coinbase
+			expectedUTXOs: 1, // New block coinbase; the genesis coinbase is unspendable
 			description:   "Transaction should update UTXO set correctly",
 		},
 		{
 			name:          "Multiple transactions",
 			transactions:  []string{"coinbase", "spend_create", "spend_create", "spend_only"},
-			expectedUTXOs: 4, // Genesis + 3 new blocks (each adds coinbase)
+			expectedUTXOs: 3, // 3 new blocks (each adds coinbase)
 			description:   "Multiple transactions should maintain correct UTXO count",
 		},
 	}
@@ -225,7 +226,7 @@ func TestBlockChain_UTXO_Integration(t *testing.T) {
 			t.Logf("TDD RED: %s - %s", tt.name, tt.description)
 
 			// This should fail since we haven't implemented UTXO integration yet
-			blockchain := NewBlockChain(createGenesisBlock())
+			blockchain := newTestBlockChain()
 
 			// Process transactions (skip coinbase since Genesis already has it)
 			for i, txType := range tt.transactions {
@@ -341,13 +342,13 @@ func TestBlockChain_Reorganization(t *testing.T) {
 
 // Helper functions for test setup
 func createGenesisBlock() *Block {
-	// Create Genesis block with known parameters
+	// Create Genesis block with known parameters at regtest difficulty
 	genesisHeader := BlockHeader{
-		Version:       1,
+		Version:       4,
 		PrevBlockHash: ZeroHash,
 		MerkleRoot:    mustParseHash("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"),
 		Timestamp:     1231006505, // Genesis timestamp
-		Bits:          0x1d00ffff,
+		Bits:          RegTestParams.PowLimitBits,
 		Nonce:         2083236893,
 	}
 
@@ -360,59 +361,52 @@ func createGenesisBlock() *Block {
 func createValidNextBlock() *Block {
 	// Create a valid block that builds on Genesis
 	header := BlockHeader{
-		Version:       1,
-		PrevBlockHash: mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
+		Version:       4,
+		PrevBlockHash: createGenesisBlock().Hash(),
 		Timestamp:     1231006505 + 600, // 10 minutes later
-		Bits:          0x1d00ffff,
-		Nonce:         12345,
+		Bits:          RegTestParams.PowLimitBits,
 	}
 
-	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return NewBlock(header, []Transaction{*coinbaseTx})
+	coinbaseTx := createUniqueCoinbaseTransaction(5000000000, 1)
+	return mineTestBlock(header, []Transaction{*coinbaseTx})
 }
 
 func createBlockWithInvalidPrevHash() *Block {
 	header := BlockHeader{
-		Version:       1,
+		Version:       4,
 		PrevBlockHash: mustParseHash("1111111111111111111111111111111111111111111111111111111111111111"),
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
 		Timestamp:     1231006505 + 600,
-		Bits:          0x1d00ffff,
-		Nonce:         12345,
+		Bits:          RegTestParams.PowLimitBits,
 	}
 
 	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return NewBlock(header, []Transaction{*coinbaseTx})
+	return mineTestBlock(header, []Transaction{*coinbaseTx})
 }
 
 func createBlockWithInvalidPoW() *Block {
 	header := BlockHeader{
-		Version:       1,
-		PrevBlockHash: mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
+		Version:       4,
+		PrevBlockHash: createGenesisBlock().Hash(),
 		Timestamp:     1231006505 + 600,
 		Bits:          0x1d00ffff,
 		Nonce:         999999, // Invalid nonce that won't meet difficulty
 	}
 
 	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return NewBlock(header, []Transaction{*coinbaseTx})
+	return newTestBlock(header, []Transaction{*coinbaseTx})
 }
 
 func createValidBlock(height int) *Block {
 	// Create a valid block for given height
 	header := BlockHeader{
-		Version:       1,
-		PrevBlockHash: mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"), // Simplified
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
+		Version:       4,
+		PrevBlockHash: createGenesisBlock().Hash(), // Simplified
 		Timestamp:     uint32(1231006505 + height*600),
-		Bits:          0x1d00ffff,
-		Nonce:         uint32(12345 + height),
+		Bits:          RegTestParams.PowLimitBits,
 	}
 
 	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return NewBlock(header, []Transaction{*coinbaseTx})
+	return mineTestBlock(header, []Transaction{*coinbaseTx})
 }
 
 func createCoinbaseTransaction(amount uint64) *Transaction {
@@ -450,16 +444,23 @@ func createCoinbaseTransaction(amount uint64) *Transaction {
 }
 
 func createUniqueCoinbaseTransaction(amount uint64, height int) *Transaction {
-	// Create a unique coinbase transaction by including height in scriptSig
-	heightBytes := []byte{byte(height & 0xff), byte((height >> 8) & 0xff), byte((height >> 16) & 0xff), byte((height >> 24) & 0xff)}
-	scriptSig := append([]byte{0x04, 0xff, 0xff, 0x00, 0x1d}, heightBytes...)
+	return createTaggedCoinbaseTransaction(amount, height, height)
+}
+
+// createTaggedCoinbaseTransaction creates a coinbase for the block at height
+// whose scriptSig starts with the BIP34 height push and ends with the tag, so
+// blocks at the same height on different branches get different coinbases
+func createTaggedCoinbaseTransaction(amount uint64, height, tag int) *Transaction {
+	tagBytes := []byte{byte(tag & 0xff), byte((tag >> 8) & 0xff), byte((tag >> 16) & 0xff), byte((tag >> 24) & 0xff)}
+	scriptSig := append(coinbaseHeightPush(height), 0x04, 0xff, 0xff, 0x00, 0x1d)
+	scriptSig = append(scriptSig, tagBytes...)
 
 	input := TxInput{
 		PreviousOutput: OutPoint{
 			Hash:  ZeroHash,
 			Index: 0xffffffff,
 		},
-		ScriptSig: scriptSig, // Unique script with height
+		ScriptSig: scriptSig, // Unique script with height and tag
 		Sequence:  0xffffffff,
 	}
 
@@ -486,8 +487,30 @@ func createUniqueCoinbaseTransaction(amount uint64, height int) *Transaction {
 	)
 }
 
+// coinbaseHeightPush returns the minimal push of a block height that BIP34
+// requires at the start of a coinbase scriptSig
+func coinbaseHeightPush(height int) []byte {
+	if height >= 1 && height <= 16 {
+		return []byte{byte(0x50 + height)} // OP_1 to OP_16
+	}
+	var num []byte
+	for h := height; h > 0; h >>= 8 {
+		num = append(num, byte(h))
+	}
+	if len(num) > 0 && num[len(num)-1]&0x80 != 0 {
+		num = append(num, 0x00) // Keep the number positive
+	}
+	return append([]byte{byte(len(num))}, num...)
+}
+
+// newTestBlockChain creates a regtest chain on the test genesis block, so
+// helper blocks can be mined at minimum difficulty
+func newTestBlockChain() *BlockChain {
+	return NewBlockChainWithParams(&RegTestParams, createGenesisBlock())
+}
+
 func setupBlockChain(numBlocks int) *BlockChain {
-	blockchain := NewBlockChain(createGenesisBlock())
+	blockchain := newTestBlockChain()
 
 	for i := 1; i < numBlocks; i++ {
 		// Create block that properly links to previous block
@@ -505,17 +528,32 @@ func setupBlockChain(numBlocks int) *BlockChain {
 func createValidBlockAfter(prevBlock *Block, height int) *Block {
 	// Create a valid block that builds on the previous block
 	header := BlockHeader{
-		Version:       1,
+		Version:       4,
 		PrevBlockHash: prevBlock.Hash(), // Correct previous hash
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
 		Timestamp:     uint32(1231006505 + height*600),
-		Bits:          0x1d00ffff,
-		Nonce:         uint32(12345 + height),
+		Bits:          RegTestParams.PowLimitBits,
 	}
 
 	// Create unique coinbase transaction for each block
 	coinbaseTx := createUniqueCoinbaseTransaction(5000000000, height)
-	return NewBlock(header, []Transaction{*coinbaseTx})
+	return mineTestBlock(header, []Transaction{*coinbaseTx})
+}
+
+// newTestBlock creates a block whose header commits to its transactions
+func newTestBlock(header BlockHeader, transactions []Transaction) *Block {
+	block := NewBlock(header, transactions)
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	return block
+}
+
+// mineTestBlock creates a block committing to its transactions and mines it
+// at the header's difficulty, which must be regtest's
+func mineTestBlock(header BlockHeader, transactions []Transaction) *Block {
+	block := newTestBlock(header, transactions)
+	if !solveBlock(block) {
+		panic("failed to mine test block")
+	}
+	return block
 }
 
 func applyChainCorruption(blockchain *BlockChain, corruption string) {
@@ -527,8 +565,8 @@ func applyChainCorruption(blockchain *BlockChain, corruption string) {
 			block := blockchain.GetBlock(1)
 			if block != nil {
 				// Create a corrupted version by changing the nonce
-				corruptedHeader := block.Header
-				corruptedHeader.Nonce = 999999 // Invalid nonce
+				corruptedHeader := NewBlockHeader(block.Header.Version, block.Header.PrevBlockHash,
+					block.Header.MerkleRoot, block.Header.Timestamp, block.Header.Bits, 999999)
 				corruptedBlock := NewBlock(corruptedHeader, block.Transactions)
 				// Force replace the block in the blockchain (for testing purposes)
 				// Note: This is a test hack - real blockchain wouldn't allow this
@@ -565,7 +603,7 @@ func createForkChain(length int) []*Block {
 	}
 	blocks := make([]*Block, forkBlocks)
 
-	genesisHash := mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	genesisHash := createGenesisBlock().Hash()
 
 	for i := 0; i < forkBlocks; i++ {
 		var prevHash Hash256
@@ -578,24 +616,95 @@ func createForkChain(length int) []*Block {
 		}
 
 		header := BlockHeader{
-			Version:       1,
+			Version:       4,
 			PrevBlockHash: prevHash,
-			MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000002"),
 			Timestamp:     uint32(1231006505 + (i+100)*600), // Different timestamps
-			Bits:          0x1d00ffff,
-			Nonce:         uint32(50000 + i), // Different nonces for fork
+			Bits:          RegTestParams.PowLimitBits,
 		}
 
 		// Create unique coinbase for fork blocks
-		coinbaseTx := createUniqueCoinbaseTransaction(5000000000, i+100)
-		blocks[i] = NewBlock(header, []Transaction{*coinbaseTx})
+		coinbaseTx := createTaggedCoinbaseTransaction(5000000000, i+1, i+100)
+		blocks[i] = mineTestBlock(header, []Transaction{*coinbaseTx})
 	}
 	return blocks
 }
 
+// TestBlockChain_MutatedBlock tests that a mutated block does not poison the real block hash
+func TestBlockChain_MutatedBlock(t *testing.T) {
+	blockchain := newTestBlockChain()
+
+	spend := func(index uint32) Transaction {
+		return Transaction{
+			Version: 1,
+			Inputs: []TxInput{{
+				PreviousOutput: OutPoint{Hash: Hash256{0x01}, Index: index},
+				Sequence:       0xffffffff,
+			}},
+			Outputs: []TxOutput{{Value: 1000, ScriptPubKey: []byte{0x51}}},
+		}
+	}
+
+	blockchain.utxoSet.Add(NewUTXO(Hash256{0x01}, 0, 2000, []byte{0x51}))
+	blockchain.utxoSet.Add(NewUTXO(Hash256{0x01}, 1, 2000, []byte{0x51}))
+
+	template := createValidBlockAfter(blockchain.GetTip(), 1)
+	transactions := []Transaction{template.Transactions[0], spend(0), spend(1)}
+	realBlock := newTestBlock(template.Header, transactions)
+
+	// Same header, last transaction duplicated: identical hash and merkle root
+	mutatedBlock := NewBlock(realBlock.Header, append(transactions[:3:3], transactions[2]))
+	if mutatedBlock.Hash() != realBlock.Hash() {
+		t.Fatal("Expected mutated block to share the real block hash")
+	}
+
+	err := blockchain.AddBlock(mutatedBlock)
+	if !errors.Is(err, ErrBlockMutated) {
+		t.Fatalf("Expected mutated block error, got %v", err)
+	}
+	if blockchain.IsBlockInvalid(realBlock.Hash()) {
+		t.Error("Mutated block must not mark the block hash invalid")
+	}
+
+	// The real header with transactions stripped must not poison the hash either
+	for _, stripped := range [][]Transaction{nil, transactions[1:]} {
+		err := blockchain.AddBlock(NewBlock(realBlock.Header, stripped))
+		if !errors.Is(err, ErrBlockMutated) {
+			t.Fatalf("Expected mutated block error for %d stripped transactions, got %v", len(stripped), err)
+		}
+		if blockchain.IsBlockInvalid(realBlock.Hash()) {
+			t.Error("Block with stripped transactions must not mark the block hash invalid")
+		}
+	}
+
+	if err := blockchain.AddBlock(realBlock); err != nil {
+		t.Fatalf("Expected real block to be accepted after the mutated one, got %v", err)
+	}
+	if blockchain.GetTip().Hash() != realBlock.Hash() {
+		t.Error("Expected real block at the tip")
+	}
+}
+
+// TestBlockChain_InvalidBlockCache tests that invalid blocks are remembered
+func TestBlockChain_InvalidBlockCache(t *testing.T) {
+	blockchain := newTestBlockChain()
+
+	block := createBlockWithInvalidPoW()
+	if err := blockchain.AddBlock(block); err == nil {
+		t.Fatal("Expected block with invalid proof of work to be rejected")
+	}
+	if !blockchain.IsBlockInvalid(block.Hash()) {
+		t.Fatal("Expected block to be marked invalid")
+	}
+
+	err := blockchain.AddBlock(block)
+	if err == nil || !contains(err.Error(), "previously marked invalid") {
+		t.Errorf("Expected previously marked invalid error, got %v", err)
+	}
+}
+
 // TestBlockChain_GetBlockByHash tests retrieving blocks by hash
 func TestBlockChain_GetBlockByHash(t *testing.T) {
-	blockchain := NewBlockChain(createGenesisBlock())
+	blockchain := newTestBlockChain()
 
 	// Test getting Genesis block
 	genesisHash := blockchain.GetTip().Hash()
@@ -627,7 +736,7 @@ func TestBlockChain_GetBlockByHash(t *testing.T) {
 
 // TestBlockChain_Contains tests checking if blockchain contains a block
 func TestBlockChain_Contains(t *testing.T) {
-	blockchain := NewBlockChain(createGenesisBlock())
+	blockchain := newTestBlockChain()
 
 	// Test Genesis block exists
 	genesisHash := blockchain.GetTip().Hash()
@@ -653,7 +762,7 @@ func TestBlockChain_Contains(t *testing.T) {
 
 // TestBlockChain_GetBlock_EdgeCases tests GetBlock with invalid heights
 func TestBlockChain_GetBlock_EdgeCases(t *testing.T) {
-	blockchain := NewBlockChain(createGenesisBlock())
+	blockchain := newTestBlockChain()
 
 	// Test negative height
 	block := blockchain.GetBlock(-1)
@@ -682,7 +791,7 @@ func TestBlockChain_ValidateBlock_EdgeCases(t *testing.T) {
 	// Test Genesis block with empty transactions list
 	emptyGenesisBlock := &Block{
 		Header: BlockHeader{
-			Version:       1,
+			Version:       4,
 			PrevBlockHash: ZeroHash,
 			MerkleRoot:    ZeroHash,
 			Timestamp:     1234567890,
@@ -698,12 +807,12 @@ func TestBlockChain_ValidateBlock_EdgeCases(t *testing.T) {
 	}
 
 	// Now test with established blockchain
-	blockchain = NewBlockChain(createGenesisBlock())
+	blockchain = newTestBlockChain()
 
 	// Test block with no transactions
 	emptyBlock := &Block{
 		Header: BlockHeader{
-			Version:       1,
+			Version:       4,
 			PrevBlockHash: blockchain.GetTip().Hash(),
 			MerkleRoot:    ZeroHash,
 			Timestamp:     1234567890,
@@ -721,7 +830,7 @@ func TestBlockChain_ValidateBlock_EdgeCases(t *testing.T) {
 	// Test block with non-coinbase first transaction
 	nonCoinbaseFirst := &Block{
 		Header: BlockHeader{
-			Version:       1,
+			Version:       4,
 			PrevBlockHash: blockchain.GetTip().Hash(),
 			MerkleRoot:    ZeroHash,
 			Timestamp:     1234567890,
@@ -747,6 +856,24 @@ func TestBlockChain_ValidateBlock_EdgeCases(t *testing.T) {
 	if err == nil {
 		t.Error("Block with non-coinbase first transaction should fail validation")
 	}
+
+	// Test block carrying witness data before segwit activation
+	uncommittedWitness := createValidBlockAfter(blockchain.GetTip(), 1)
+	uncommittedWitness.Transactions[0].Inputs[0].Witness = [][]byte{make([]byte, 32)}
+
+	presegwitParams := RegTestParams
+	presegwitParams.SegwitHeight = 100
+	presegwit := NewBlockChainWithParams(&presegwitParams, createGenesisBlock())
+	err = presegwit.AddBlock(uncommittedWitness)
+	if err == nil || !contains(err.Error(), "unexpected witness data") {
+		t.Errorf("Block with witness data before segwit should fail validation, got %v", err)
+	}
+
+	// Test block carrying witness data without a witness commitment
+	err = blockchain.AddBlock(uncommittedWitness)
+	if err == nil || !contains(err.Error(), "no witness commitment") {
+		t.Errorf("Block with uncommitted witness data should fail validation, got %v", err)
+	}
 }
 
 // TestBlockChain_ValidateForkBlock tests fork block validation edge cases
@@ -761,8 +888,8 @@ func TestBlockChain_ValidateForkBlock(t *testing.T) {
 			name: "Valid fork block",
 			block: &Block{
 				Header: BlockHeader{
-					Nonce: 12345, // Test nonce range
-					Bits:  0x1d00ffff,
+					Timestamp: 1231006505 + 600,
+					Bits:      RegTestParams.PowLimitBits,
 				},
 				Transactions: []Transaction{
 					{
@@ -790,8 +917,8 @@ func TestBlockChain_ValidateForkBlock(t *testing.T) {
 			name: "Fork block with no transactions",
 			block: &Block{
 				Header: BlockHeader{
-					Nonce: 12345,
-					Bits:  0x1d00ffff,
+					Timestamp: 1231006505 + 600,
+					Bits:      RegTestParams.PowLimitBits,
 				},
 				Transactions: []Transaction{},
 			},
@@ -802,8 +929,8 @@ func TestBlockChain_ValidateForkBlock(t *testing.T) {
 			name: "Fork block without coinbase",
 			block: &Block{
 				Header: BlockHeader{
-					Nonce: 12345,
-					Bits:  0x1d00ffff,
+					Timestamp: 1231006505 + 600,
+					Bits:      RegTestParams.PowLimitBits,
 				},
 				Transactions: []Transaction{
 					{
@@ -829,10 +956,10 @@ func TestBlockChain_ValidateForkBlock(t *testing.T) {
 			expectedError: "first transaction must be coinbase",
 		},
 		{
-			name: "Fork block with invalid PoW (non-test nonce)",
+			name: "Fork block with invalid PoW",
 			block: &Block{
 				Header: BlockHeader{
-					Nonce: 99999, // Non-test nonce that will require real PoW
+					Nonce: 99999, // Does not meet the mainnet pow limit target
 					Bits:  0x1d00ffff,
 				},
 				Transactions: []Transaction{
@@ -862,9 +989,14 @@ func TestBlockChain_ValidateForkBlock(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			blockchain := NewBlockChain(createGenesisBlock())
+			blockchain := newTestBlockChain()
+			tt.block.Header.MerkleRoot = tt.block.CalculateMerkleRoot()
+			// Transactions are only checked once the header is known to be valid
+			if tt.block.Header.Bits == RegTestParams.PowLimitBits {
+				solveBlock(tt.block)
+			}
 
-			err := blockchain.validateForkBlock(tt.block)
+			err := blockchain.validateForkBlock(tt.block, blockchain.tipNode)
 
 			if tt.shouldError {
 				if err == nil {
@@ -883,7 +1015,7 @@ func TestBlockChain_ValidateForkBlock(t *testing.T) {
 
 // TestBlockChain_ProcessBlockTransactions tests transaction processing edge cases
 func TestBlockChain_ProcessBlockTransactions(t *testing.T) {
-	blockchain := NewBlockChain(createGenesisBlock())
+	blockchain := newTestBlockChain()
 
 	// Test block with multiple transactions
 	block := &Block{
@@ -942,7 +1074,13 @@ func TestBlockChain_ProcessBlockTransactions(t *testing.T) {
 	blockchain.utxoSet.Add(utxo)
 
 	// Process the block transactions
-	blockchain.processBlockTransactions(block)
+	undo, err := blockchain.utxoSet.ConnectBlock(block, 1)
+	if err != nil {
+		t.Fatalf("Failed to connect block: %v", err)
+	}
+	if len(undo.Transactions) != 1 || len(undo.Transactions[0].SpentOutputs) != 1 {
+		t.Fatal("Expected undo data for the spent UTXO")
+	}
 
 	// Verify coinbase UTXO was added
 	coinbaseHash := block.Transactions[0].Hash()
@@ -970,7 +1108,7 @@ func TestBlockChain_ProcessBlockTransactions(t *testing.T) {
 func TestForceReplaceBlock(t *testing.T) {
 	// Create genesis block first
 	genesisBlock := createGenesisBlock()
-	blockchain := NewBlockChain(genesisBlock)
+	blockchain := NewBlockChainWithParams(&RegTestParams, genesisBlock)
 
 	// Add a second block
 	block1 := createValidNextBlock()
@@ -1032,3 +1170,31 @@ func TestForceReplaceBlock(t *testing.T) {
 		t.Error("Should not be able to get block at invalid height")
 	}
 }
+
+// TestBlockChain_SkipProofOfWork tests that proof of work is only skipped when requested
+func TestBlockChain_SkipProofOfWork(t *testing.T) {
+	genesis := MainNetParams.GenesisBlock
+	unmined := newTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: genesis.Hash(),
+		Timestamp:     genesis.Header.Timestamp + 600,
+		Bits:          MainNetParams.PowLimitBits,
+	}, []Transaction{*createUniqueCoinbaseTransaction(5000000000, 1)})
+
+	enforcing := NewBlockChainWithParams(&MainNetParams, genesis)
+	err := enforcing.AddBlock(unmined)
+	if err == nil || !contains(err.Error(), "invalid proof of work") {
+		t.Errorf("Expected invalid proof of work error, got %v", err)
+	}
+
+	skipping := NewBlockChainWithOptions(&MainNetParams, genesis, ValidationOptions{SkipProofOfWork: true})
+	if err := skipping.AddBlock(unmined); err != nil {
+		t.Fatalf("Expected block to be accepted without proof of work, got %v", err)
+	}
+	if !skipping.ValidateChain() {
+		t.Error("Expected chain to validate without proof of work")
+	}
+	if enforcing.Height() != 0 {
+		t.Error("Expected enforcing chain to remain at genesis")
+	}
+}
@@ -0,0 +1,187 @@
+package bitcoin
+
+import (
+	"math/big"
+	"sort"
+)
+
+// BlockStatus is a bit set recording what is known about a block in the index
+type BlockStatus uint32
+
+// Block status flags
+const (
+	StatusDataStored      BlockStatus = 1 << iota // Full block data is available
+	StatusValid                                   // Block has been connected to the main chain
+	StatusValidateFailed                          // Block failed validation
+	StatusInvalidAncestor                         // Block descends from a block that failed validation
+)
+
+// KnownInvalid reports whether the block or one of its ancestors failed validation
+func (s BlockStatus) KnownInvalid() bool {
+	return s&(StatusValidateFailed|StatusInvalidAncestor) != 0
+}
+
+// Chain tip statuses reported by GetChainTips, matching getchaintips
+const (
+	ChainTipActive       = "active"        // Tip of the main chain
+	ChainTipValidFork    = "valid-fork"    // Fully validated branch that is not the main chain
+	ChainTipValidHeaders = "valid-headers" // Block data available but never connected
+	ChainTipHeadersOnly  = "headers-only"  // Block data not available
+	ChainTipInvalid      = "invalid"       // Branch contains an invalid block
+)
+
+// ChainTip describes the tip of a known branch of the block tree
+type ChainTip struct {
+	Height    int32
+	Hash      Hash256
+	BranchLen int32 // Blocks between the tip and the main chain
+	Status    string
+}
+
+// blockNode is an entry in the block index tree
+type blockNode struct {
+	hash     Hash256
+	header   BlockHeader
+	parent   *blockNode
+	skip     *blockNode // Ancestor at skipHeight(height), so ancestor takes O(log n) steps
+	height   int32
+	work     *big.Int // Cumulative work of the chain ending at this block
+	status   BlockStatus
+	block    *Block
+	sequence uint64 // Order in which the block was received
+}
+
+// ancestor returns the node's ancestor at the given height, following skip
+// pointers where they do not overshoot it
+func (n *blockNode) ancestor(height int32) *blockNode {
+	if height < 0 || height > n.height {
+		return nil
+	}
+	node := n
+	for node.height > height {
+		skip := skipHeight(node.height)
+		skipPrev := skipHeight(node.height - 1)
+		// Only take the skip if the parent's skip would not get closer to height
+		if node.skip != nil && (skip == height ||
+			(skip > height && !(skipPrev < skip-2 && skipPrev >= height))) {
+			node = node.skip
+		} else {
+			node = node.parent
+		}
+	}
+	return node
+}
+
+// skipHeight returns the height a node's skip pointer leads to, chosen as in
+// Bitcoin Core so that any ancestor can be reached in O(log n) steps
+func skipHeight(height int32) int32 {
+	if height < 2 {
+		return 0
+	}
+	// Clear the lowest set bit, twice for odd heights, and keep odd heights odd
+	if height&1 != 0 {
+		h := height - 1
+		h &= h - 1
+		return h&(h-1) + 1
+	}
+	return height & (height - 1)
+}
+
+// medianTimePastBlocks is how many blocks the median time past is taken over
+const medianTimePastBlocks = 11
+
+// medianTimePast returns the median timestamp of the node and up to ten of
+// its ancestors. A block's timestamp must be later than its parent's median
+// time past, and BIP113 evaluates time-based locktimes against it.
+func (n *blockNode) medianTimePast() uint32 {
+	timestamps := make([]uint32, 0, medianTimePastBlocks)
+	for node := n; node != nil && len(timestamps) < medianTimePastBlocks; node = node.parent {
+		timestamps = append(timestamps, node.header.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// blockIndex holds every block the chain has seen, valid or not, as a tree
+// rooted at the genesis block
+type blockIndex struct {
+	nodes    map[Hash256]*blockNode
+	sequence uint64
+}
+
+// newBlockIndex creates an empty block index
+func newBlockIndex() *blockIndex {
+	return &blockIndex{nodes: make(map[Hash256]*blockNode)}
+}
+
+// lookup returns the node for a block hash, or nil if it is unknown
+func (bi *blockIndex) lookup(hash Hash256) *blockNode {
+	return bi.nodes[hash]
+}
+
+// addNode inserts a block as a child of parent, which is nil for the genesis block
+func (bi *blockIndex) addNode(block *Block, parent *blockNode, status BlockStatus) *blockNode {
+	node := bi.addHeader(block.Header, parent, status)
+	node.block = block
+	return node
+}
+
+// addHeader inserts a block whose data is not held in memory
+func (bi *blockIndex) addHeader(header BlockHeader, parent *blockNode, status BlockStatus) *blockNode {
+	node := &blockNode{
+		hash:     header.Hash(),
+		header:   header,
+		parent:   parent,
+		work:     CalcWork(header.Bits),
+		status:   status,
+		sequence: bi.sequence,
+	}
+	bi.sequence++
+
+	if parent != nil {
+		node.height = parent.height + 1
+		node.skip = parent.ancestor(skipHeight(node.height))
+		node.work.Add(node.work, parent.work)
+		if parent.status.KnownInvalid() {
+			node.status |= StatusInvalidAncestor
+		}
+	}
+
+	bi.nodes[node.hash] = node
+	return node
+}
+
+// tips returns every node without children
+func (bi *blockIndex) tips() []*blockNode {
+	hasChildren := make(map[*blockNode]bool, len(bi.nodes))
+	for _, node := range bi.nodes {
+		if node.parent != nil {
+			hasChildren[node.parent] = true
+		}
+	}
+
+	var tips []*blockNode
+	for _, node := range bi.nodes {
+		if !hasChildren[node] {
+			tips = append(tips, node)
+		}
+	}
+	return tips
+}
+
+// findFork returns the last common ancestor of two nodes
+func findFork(a, b *blockNode) *blockNode {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a.height > b.height {
+		a = a.ancestor(b.height)
+	} else {
+		b = b.ancestor(a.height)
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+	return a
+}
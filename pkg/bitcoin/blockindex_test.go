@@ -0,0 +1,478 @@
+package bitcoin
+
+import (
+	"testing"
+)
+
+// createBlockOn mines a block extending parent with the given difficulty.
+// The tag makes the coinbase, and so the block, unique.
+func createBlockOn(parent *Block, bits uint32, tag int) *Block {
+	header := BlockHeader{
+		Version:       4,
+		PrevBlockHash: parent.Hash(),
+		Timestamp:     parent.Header.Timestamp + 600,
+		Bits:          bits,
+	}
+
+	coinbaseTx := createTaggedCoinbaseTransaction(5000000000, testBlockHeight(parent)+1, tag)
+	return mineTestBlock(header, []Transaction{*coinbaseTx})
+}
+
+// createNextBlock mines a block extending parent after the given number of
+// seconds, at the difficulty the chain requires
+func createNextBlock(t *testing.T, blockchain *BlockChain, parent *Block, spacing uint32, tag int) *Block {
+	t.Helper()
+	timestamp := parent.Header.Timestamp + spacing
+	bits, err := blockchain.NextWorkRequired(parent.Hash(), timestamp)
+	if err != nil {
+		t.Fatalf("Failed to get required difficulty: %v", err)
+	}
+
+	header := BlockHeader{
+		Version:       4,
+		PrevBlockHash: parent.Hash(),
+		Timestamp:     timestamp,
+		Bits:          bits,
+	}
+	coinbaseTx := createTaggedCoinbaseTransaction(5000000000, testBlockHeight(parent)+1, tag)
+	return mineTestBlock(header, []Transaction{*coinbaseTx})
+}
+
+// testBlockHeight returns the height a test block's coinbase commits to, or
+// zero for a block without a parent
+func testBlockHeight(block *Block) int {
+	if block.Header.PrevBlockHash == ZeroHash {
+		return 0
+	}
+	opcode, data, _, _ := parseScriptOp(block.Transactions[0].Inputs[0].ScriptSig, 0)
+	if opcode >= OP_1 && opcode <= OP_16 {
+		return int(opcode-OP_1) + 1
+	}
+	return int(decodeScriptNum(data))
+}
+
+// newRetargetTestParams returns regtest parameters that retarget every four blocks
+func newRetargetTestParams() *ChainParams {
+	params := RegTestParams
+	params.TargetTimespan = 4 * params.TargetSpacing
+	params.NoRetargeting = false
+	params.ReduceMinDifficulty = false
+	return &params
+}
+
+// TestBlockNode_Ancestor tests ancestor lookups through skip pointers
+func TestBlockNode_Ancestor(t *testing.T) {
+	index := newBlockIndex()
+	nodes := []*blockNode{index.addHeader(BlockHeader{Bits: RegTestParams.PowLimitBits}, nil, StatusDataStored)}
+	for i := 1; i < 5000; i++ {
+		header := BlockHeader{PrevBlockHash: nodes[i-1].hash, Bits: RegTestParams.PowLimitBits}
+		nodes = append(nodes, index.addHeader(header, nodes[i-1], StatusDataStored))
+	}
+
+	tip := nodes[len(nodes)-1]
+	for _, height := range []int32{0, 1, 2, 3, 1023, 1024, 1025, 2500, 4998, 4999} {
+		if ancestor := tip.ancestor(height); ancestor != nodes[height] {
+			t.Errorf("Expected ancestor at height %d, got %v", height, ancestor)
+		}
+		if ancestor := nodes[3000].ancestor(height); height <= 3000 && ancestor != nodes[height] {
+			t.Errorf("Expected ancestor of node 3000 at height %d, got %v", height, ancestor)
+		}
+	}
+	if tip.ancestor(5000) != nil || tip.ancestor(-1) != nil {
+		t.Error("Expected no ancestor outside the node's chain")
+	}
+
+	for _, node := range nodes[2:] {
+		if node.skip == nil || node.skip.height != skipHeight(node.height) || node.skip.height >= node.height {
+			t.Fatalf("Expected node %d to skip to a lower height %d, got %v", node.height, skipHeight(node.height), node.skip)
+		}
+	}
+}
+
+// TestBlockChain_MostWorkChain tests that the chain with most work wins over the longest chain
+func TestBlockChain_MostWorkChain(t *testing.T) {
+	blockchain := NewBlockChainWithParams(newRetargetTestParams(), createGenesisBlock())
+	genesis := blockchain.GetTip()
+
+	// Slow blocks stay at the pow limit, worth 2 each
+	prev := genesis
+	for i := 1; i <= 7; i++ {
+		block := createNextBlock(t, blockchain, prev, 1200, i)
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %d: %v", i, err)
+		}
+		prev = block
+	}
+	mainTip := blockchain.GetTip()
+	mainWork := blockchain.ChainWork()
+
+	// Fast blocks retarget to four times the difficulty at height 4, so five
+	// of them are worth 2+2+2+8+8 and beat the seven slow blocks
+	prev = genesis
+	for i := 1; i <= 5; i++ {
+		block := createNextBlock(t, blockchain, prev, 1, 10+i)
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add fast block %d: %v", i, err)
+		}
+		prev = block
+	}
+	heavy := prev
+	if heavy.Header.Bits == RegTestParams.PowLimitBits {
+		t.Fatal("Expected fast blocks to raise the difficulty")
+	}
+	if blockchain.GetTip() != heavy {
+		t.Fatalf("Expected fast branch at the tip, got %s", blockchain.GetTip().Hash())
+	}
+	if blockchain.Height() != 5 {
+		t.Errorf("Expected height 5 after reorganization, got %d", blockchain.Height())
+	}
+	if blockchain.ChainWork().Cmp(mainWork) <= 0 {
+		t.Error("Expected chain work to increase after reorganization")
+	}
+	if blockchain.GetBlockByHash(mainTip.Hash()) != nil {
+		t.Error("Expected old tip to leave the main chain")
+	}
+
+	// Extending the old branch with another block of low work does not win it back
+	low := createNextBlock(t, blockchain, mainTip, 1200, 8)
+	if err := blockchain.AddBlock(low); err != nil {
+		t.Fatalf("Failed to add block to old branch: %v", err)
+	}
+	if blockchain.GetTip() != heavy {
+		t.Error("Expected fast branch to remain the tip")
+	}
+}
+
+// TestBlockChain_DifficultyBits tests that blocks must carry the required difficulty
+func TestBlockChain_DifficultyBits(t *testing.T) {
+	blockchain := NewBlockChainWithParams(newRetargetTestParams(), createGenesisBlock())
+	genesis := blockchain.GetTip()
+
+	// A harder target than required still has to match exactly
+	harder := createBlockOn(genesis, 0x2000ffff, 1)
+	err := blockchain.AddBlock(harder)
+	if err == nil || !contains(err.Error(), "incorrect proof of work bits") {
+		t.Errorf("Expected incorrect bits error, got %v", err)
+	}
+
+	prev := genesis
+	for i := 1; i <= 3; i++ {
+		block := createNextBlock(t, blockchain, prev, 1, i)
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %d: %v", i, err)
+		}
+		prev = block
+	}
+
+	// The retarget block may not keep the old difficulty
+	stale := createBlockOn(prev, RegTestParams.PowLimitBits, 4)
+	err = blockchain.AddBlock(stale)
+	if err == nil || !contains(err.Error(), "incorrect proof of work bits") {
+		t.Errorf("Expected incorrect bits error at retarget height, got %v", err)
+	}
+	if err := blockchain.AddBlock(createNextBlock(t, blockchain, prev, 1, 5)); err != nil {
+		t.Errorf("Expected retargeted block to be accepted, got %v", err)
+	}
+}
+
+// TestBlockChain_TimewarpRule tests the BIP94 limit on retarget block timestamps
+func TestBlockChain_TimewarpRule(t *testing.T) {
+	params := newRetargetTestParams()
+	params.EnforceBIP94 = true
+	blockchain := NewBlockChainWithParams(params, createGenesisBlock())
+
+	prev := blockchain.GetTip()
+	for i := 1; i <= 3; i++ {
+		block := createNextBlock(t, blockchain, prev, 1200, i)
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %d: %v", i, err)
+		}
+		prev = block
+	}
+
+	tests := []struct {
+		name        string
+		offset      int64
+		shouldError bool
+	}{
+		{"more than ten minutes before parent", -601, true},
+		{"exactly ten minutes before parent", -600, false},
+		{"after parent", 600, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := createNextBlock(t, blockchain, prev, uint32(tt.offset), 10+i)
+			err := blockchain.validateForkBlock(block, blockchain.tipNode)
+			if tt.shouldError && (err == nil || !contains(err.Error(), "too far before previous block")) {
+				t.Errorf("Expected timewarp error, got %v", err)
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("Expected block to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+// TestBlockChain_ForkOfFork tests reorganization onto a branch of a side branch
+func TestBlockChain_ForkOfFork(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+
+	a1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 1)
+	a2 := createBlockOn(a1, RegTestParams.PowLimitBits, 2)
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	c2 := createBlockOn(b1, RegTestParams.PowLimitBits, 22)
+	b2 := createBlockOn(b1, RegTestParams.PowLimitBits, 12)
+	c3 := createBlockOn(c2, RegTestParams.PowLimitBits, 23)
+
+	for _, block := range []*Block{a1, a2, b1, c2, b2} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %s: %v", block.Hash(), err)
+		}
+	}
+	if blockchain.GetTip() != a2 {
+		t.Fatalf("Expected first seen branch to stay active, got %s", blockchain.GetTip().Hash())
+	}
+
+	if err := blockchain.AddBlock(c3); err != nil {
+		t.Fatalf("Failed to add block c3: %v", err)
+	}
+	if blockchain.GetTip() != c3 {
+		t.Fatal("Expected reorganization onto the fork of a fork")
+	}
+	for height, expected := range []*Block{genesis, b1, c2, c3} {
+		if blockchain.GetBlock(height) != expected {
+			t.Errorf("Unexpected block at height %d", height)
+		}
+	}
+	if !blockchain.ValidateChain() {
+		t.Error("Expected reorganized chain to validate")
+	}
+
+	// Outputs of the abandoned branch are gone, those of the new branch exist
+	utxos := blockchain.GetUTXOSet()
+	if _, found := utxos.Find(a1.Transactions[0].Hash(), 0); found {
+		t.Error("Expected coinbase of disconnected block to be removed")
+	}
+	if _, found := utxos.Find(c3.Transactions[0].Hash(), 0); !found {
+		t.Error("Expected coinbase of connected block to be present")
+	}
+}
+
+// TestBlockChain_GetChainTips tests getchaintips style reporting of all branches
+func TestBlockChain_GetChainTips(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+
+	a1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 1)
+	a2 := createBlockOn(a1, RegTestParams.PowLimitBits, 2)
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	b2 := createBlockOn(b1, RegTestParams.PowLimitBits, 12)
+	b3 := createBlockOn(b2, RegTestParams.PowLimitBits, 13)
+	c2 := createBlockOn(a1, RegTestParams.PowLimitBits, 21)
+	invalid := createBlockWithInvalidPoW()
+
+	// a1-a2 is active first, then b1-b3 takes over
+	for _, block := range []*Block{a1, a2, b1, b2, c2, b3} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+	if err := blockchain.AddBlock(invalid); err == nil {
+		t.Fatal("Expected block with invalid proof of work to be rejected")
+	}
+
+	expected := map[Hash256]ChainTip{
+		b3.Hash():      {Height: 3, Hash: b3.Hash(), BranchLen: 0, Status: ChainTipActive},
+		a2.Hash():      {Height: 2, Hash: a2.Hash(), BranchLen: 2, Status: ChainTipValidFork},
+		c2.Hash():      {Height: 2, Hash: c2.Hash(), BranchLen: 2, Status: ChainTipValidHeaders},
+		invalid.Hash(): {Height: 1, Hash: invalid.Hash(), BranchLen: 1, Status: ChainTipInvalid},
+	}
+
+	tips := blockchain.GetChainTips()
+	if len(tips) != len(expected) {
+		t.Fatalf("Expected %d chain tips, got %d: %+v", len(expected), len(tips), tips)
+	}
+	if tips[0].Status != ChainTipActive {
+		t.Errorf("Expected active tip first, got %+v", tips[0])
+	}
+	for _, tip := range tips {
+		if tip != expected[tip.Hash] {
+			t.Errorf("Expected tip %+v, got %+v", expected[tip.Hash], tip)
+		}
+	}
+}
+
+// TestBlockChain_InvalidAncestor tests that descendants of invalid blocks are rejected
+func TestBlockChain_InvalidAncestor(t *testing.T) {
+	blockchain := newTestBlockChain()
+
+	invalid := createBlockWithInvalidPoW()
+	if err := blockchain.AddBlock(invalid); err == nil {
+		t.Fatal("Expected block with invalid proof of work to be rejected")
+	}
+
+	child := createBlockOn(invalid, RegTestParams.PowLimitBits, 1)
+	err := blockchain.AddBlock(child)
+	if err == nil || !contains(err.Error(), "descends from an invalid block") {
+		t.Fatalf("Expected invalid ancestor error, got %v", err)
+	}
+	if !blockchain.IsBlockInvalid(child.Hash()) {
+		t.Error("Expected child of invalid block to be marked invalid")
+	}
+
+	grandchild := createBlockOn(child, RegTestParams.PowLimitBits, 2)
+	if err := blockchain.AddBlock(grandchild); err == nil {
+		t.Error("Expected grandchild of invalid block to be rejected")
+	}
+	if blockchain.Height() != 0 {
+		t.Errorf("Expected height 0, got %d", blockchain.Height())
+	}
+}
+
+// TestBlockChain_DuplicateBlock tests that a known block is not added twice
+func TestBlockChain_DuplicateBlock(t *testing.T) {
+	blockchain := newTestBlockChain()
+
+	block := createBlockOn(blockchain.GetTip(), RegTestParams.PowLimitBits, 1)
+	if err := blockchain.AddBlock(block); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	err := blockchain.AddBlock(block)
+	if err == nil || !contains(err.Error(), "already known") {
+		t.Errorf("Expected already known error, got %v", err)
+	}
+	if blockchain.Height() != 1 {
+		t.Errorf("Expected height 1, got %d", blockchain.Height())
+	}
+}
+
+// TestBlockChain_EmptyChainGenesis tests that an empty chain accepts a genesis block
+func TestBlockChain_EmptyChainGenesis(t *testing.T) {
+	blockchain := NewBlockChainWithParams(&RegTestParams, nil)
+
+	// Any other block without a parent is refused
+	if err := blockchain.AddBlock(createGenesisBlock()); err == nil || !contains(err.Error(), "not the regtest genesis block") {
+		t.Errorf("Expected a foreign genesis block to be refused, got %v", err)
+	}
+	if blockchain.GetTip() != nil {
+		t.Fatal("Expected chain to stay empty")
+	}
+
+	if err := blockchain.AddBlock(RegTestParams.GenesisBlock); err != nil {
+		t.Fatalf("Failed to add genesis block: %v", err)
+	}
+	if blockchain.Height() != 0 || blockchain.GetTip() != RegTestParams.GenesisBlock {
+		t.Error("Expected genesis block at height 0")
+	}
+	if blockchain.ChainWork().Int64() != 2 {
+		t.Errorf("Expected chain work 2, got %s", blockchain.ChainWork())
+	}
+}
+
+// TestBlockChain_ReorgDisconnectFailure tests that a reorganization failing
+// to disconnect a block reconnects the blocks it had already disconnected
+func TestBlockChain_ReorgDisconnectFailure(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+	a1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 1)
+	a2 := createBlockOn(a1, RegTestParams.PowLimitBits, 2)
+	for _, block := range []*Block{a1, a2} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+
+	// a1 can no longer be disconnected once its coinbase output is missing
+	utxos := blockchain.GetUTXOSet()
+	utxos.Remove(a1.Transactions[0].Hash(), 0)
+	utxoCount := utxos.Size()
+
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	b2 := createBlockOn(b1, RegTestParams.PowLimitBits, 12)
+	b3 := createBlockOn(b2, RegTestParams.PowLimitBits, 13)
+	for _, block := range []*Block{b1, b2} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add fork block: %v", err)
+		}
+	}
+	if err := blockchain.AddBlock(b3); err == nil || !contains(err.Error(), "missing from the UTXO set") {
+		t.Fatalf("Expected the disconnect to fail, got %v", err)
+	}
+	if blockchain.GetTip() != a2 || blockchain.Height() != 2 {
+		t.Errorf("Expected original tip restored, got %s at height %d", blockchain.GetTip().Hash(), blockchain.Height())
+	}
+	if _, found := utxos.Find(a2.Transactions[0].Hash(), 0); !found || utxos.Size() != utxoCount {
+		t.Error("Expected disconnected block to be connected again")
+	}
+	if blockchain.IsBlockInvalid(b3.Hash()) {
+		t.Error("Expected the new branch not to be marked invalid")
+	}
+}
+
+// TestBlockChain_ReorgRestoresSpentOutputs tests that a reorganization restores outputs spent by disconnected blocks
+func TestBlockChain_ReorgRestoresSpentOutputs(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+	funding := NewUTXO(Hash256{0x01}, 0, 5000000000, []byte{0x51})
+	blockchain.GetUTXOSet().Add(funding)
+
+	spend := Transaction{
+		Version: 1,
+		Inputs:  []TxInput{{PreviousOutput: OutPoint{Hash: funding.TxHash(), Index: 0}, Sequence: 0xffffffff}},
+		Outputs: []TxOutput{{Value: 4000000000, ScriptPubKey: []byte{0x51}}},
+	}
+	a1 := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: genesis.Hash(),
+		Timestamp:     genesis.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{*createUniqueCoinbaseTransaction(5000000000, 1), spend})
+	if err := blockchain.AddBlock(a1); err != nil {
+		t.Fatalf("Failed to add spending block: %v", err)
+	}
+	utxos := blockchain.GetUTXOSet()
+	if _, found := utxos.Find(funding.TxHash(), 0); found {
+		t.Fatal("Expected funding output to be spent")
+	}
+
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	b2 := createBlockOn(b1, RegTestParams.PowLimitBits, 12)
+	for _, block := range []*Block{b1, b2} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add fork block: %v", err)
+		}
+	}
+	if blockchain.GetTip() != b2 {
+		t.Fatal("Expected reorganization onto the longer branch")
+	}
+	if _, found := utxos.Find(funding.TxHash(), 0); !found {
+		t.Error("Expected funding output to be restored")
+	}
+	if _, found := utxos.Find(spend.Hash(), 0); found {
+		t.Error("Expected output of disconnected transaction to be removed")
+	}
+	if utxos.Size() != 3 {
+		t.Errorf("Expected 3 UTXOs after reorganization, got %d", utxos.Size())
+	}
+
+	// Reorganizing back spends it again
+	a2 := createBlockOn(a1, RegTestParams.PowLimitBits, 2)
+	a3 := createBlockOn(a2, RegTestParams.PowLimitBits, 3)
+	for _, block := range []*Block{a2, a3} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+	if blockchain.GetTip() != a3 {
+		t.Fatal("Expected reorganization back onto the original branch")
+	}
+	if _, found := utxos.Find(funding.TxHash(), 0); found {
+		t.Error("Expected funding output to be spent again")
+	}
+	if _, found := utxos.Find(spend.Hash(), 0); !found {
+		t.Error("Expected output of reconnected transaction to be present")
+	}
+}
@@ -0,0 +1,461 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Block storage layout. Blocks are appended to numbered flat files in the
+// same framing as Bitcoin Core's blk?????.dat: network magic, little-endian
+// length, then the serialized block. An append-only index file records where
+// each block lives; the last record for a hash wins, so status changes are
+// written as new records rather than by rewriting old ones.
+const (
+	DefaultMaxBlockFileSize = 128 << 20 // Start a new block file beyond this size
+	blockIndexFileName      = "index.dat"
+	blockRecordHeaderSize   = 8
+	blockIndexRecordSize    = 32 + BlockHeaderSize + 5*4 + 4 // Hash, header, file, offset, size, height, status, checksum
+)
+
+// BlockIndexEntry records where a stored block lives and what is known about it
+type BlockIndexEntry struct {
+	Hash   Hash256
+	Header BlockHeader
+	File   uint32 // Number of the blk?????.dat file
+	Offset uint32 // Offset of the serialized block within the file
+	Size   uint32 // Length of the serialized block
+	Height int32
+	Status BlockStatus
+}
+
+// BlockStore keeps blocks in append-only flat files with an on-disk index
+type BlockStore struct {
+	mu          sync.Mutex
+	dir         string
+	magic       uint32
+	maxFileSize int64
+
+	index    *os.File
+	current  *os.File // Block file being appended to
+	fileNum  uint32
+	fileSize int64
+
+	entries map[Hash256]*BlockIndexEntry
+	order   []Hash256 // Hashes in the order they were first stored
+}
+
+// OpenBlockStore opens or creates the block store in dir for the given
+// network. Writes interrupted by an unclean shutdown are recovered: torn index
+// records are discarded, complete blocks missing from the index are indexed
+// again and any partial block at the end of the last file is truncated.
+func OpenBlockStore(dir string, params *ChainParams) (*BlockStore, error) {
+	return OpenBlockStoreWithFileSize(dir, params, DefaultMaxBlockFileSize)
+}
+
+// OpenBlockStoreWithFileSize opens a block store that starts a new block file
+// once the current one would grow beyond maxFileSize bytes
+func OpenBlockStoreWithFileSize(dir string, params *ChainParams, maxFileSize int64) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create block directory: %w", err)
+	}
+
+	index, err := os.OpenFile(filepath.Join(dir, blockIndexFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block index: %w", err)
+	}
+
+	store := &BlockStore{
+		dir:         dir,
+		magic:       params.Magic,
+		maxFileSize: maxFileSize,
+		index:       index,
+		entries:     make(map[Hash256]*BlockIndexEntry),
+	}
+	if err := store.load(); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// blockFilePath returns the path of a numbered block file
+func (s *BlockStore) blockFilePath(num uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("blk%05d.dat", num))
+}
+
+// load reads the index, then reconciles it with the block files
+func (s *BlockStore) load() error {
+	data, err := io.ReadAll(s.index)
+	if err != nil {
+		return fmt.Errorf("failed to read block index: %w", err)
+	}
+
+	valid := 0
+	for ; valid+blockIndexRecordSize <= len(data); valid += blockIndexRecordSize {
+		entry, err := decodeBlockIndexRecord(data[valid : valid+blockIndexRecordSize])
+		if err != nil {
+			break
+		}
+		s.remember(entry)
+	}
+
+	// Anything after the last intact record is a torn write
+	if valid != len(data) {
+		if err := s.index.Truncate(int64(valid)); err != nil {
+			return fmt.Errorf("failed to truncate block index: %w", err)
+		}
+	}
+	if _, err := s.index.Seek(int64(valid), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek block index: %w", err)
+	}
+
+	// Drop entries whose block data never reached the disk
+	var indexedEnd int64
+	for _, hash := range s.order {
+		entry := s.entries[hash]
+		info, err := os.Stat(s.blockFilePath(entry.File))
+		end := int64(entry.Offset) + int64(entry.Size)
+		if err != nil || end > info.Size() {
+			delete(s.entries, hash)
+			continue
+		}
+		if entry.File > s.fileNum || (entry.File == s.fileNum && end > indexedEnd) {
+			if entry.File > s.fileNum {
+				s.fileNum = entry.File
+			}
+			indexedEnd = end
+		}
+	}
+	s.compactOrder()
+
+	// A later file exists if the first block written to it was never indexed
+	for {
+		if _, err := os.Stat(s.blockFilePath(s.fileNum + 1)); err != nil {
+			break
+		}
+		s.fileNum++
+		indexedEnd = 0
+	}
+
+	current, err := os.OpenFile(s.blockFilePath(s.fileNum), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open block file: %w", err)
+	}
+	s.current = current
+
+	return s.recoverTail(indexedEnd)
+}
+
+// recoverTail indexes complete blocks written to the current file after the
+// last indexed one, then truncates whatever partial block follows them
+func (s *BlockStore) recoverTail(offset int64) error {
+	info, err := s.current.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat block file: %w", err)
+	}
+
+	for offset+blockRecordHeaderSize <= info.Size() {
+		var prefix [blockRecordHeaderSize]byte
+		if _, err := s.current.ReadAt(prefix[:], offset); err != nil {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(prefix[4:]))
+		if binary.LittleEndian.Uint32(prefix[:4]) != s.magic || offset+blockRecordHeaderSize+size > info.Size() {
+			break
+		}
+
+		data := make([]byte, size)
+		if _, err := s.current.ReadAt(data, offset+blockRecordHeaderSize); err != nil {
+			break
+		}
+		block, err := DeserializeBlock(bytes.NewReader(data))
+		if err != nil {
+			break
+		}
+
+		// Heights follow from the parent, which was always stored first
+		height := int32(0)
+		if block.Header.PrevBlockHash != ZeroHash {
+			parent, ok := s.entries[block.Header.PrevBlockHash]
+			if !ok {
+				break
+			}
+			height = parent.Height + 1
+		}
+
+		entry := &BlockIndexEntry{
+			Hash:   block.Hash(),
+			Header: block.Header,
+			File:   s.fileNum,
+			Offset: uint32(offset + blockRecordHeaderSize),
+			Size:   uint32(size),
+			Height: height,
+			Status: StatusDataStored,
+		}
+		if err := s.appendIndex(entry); err != nil {
+			return err
+		}
+		offset += blockRecordHeaderSize + size
+	}
+
+	if offset != info.Size() {
+		if err := s.current.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate block file: %w", err)
+		}
+	}
+	s.fileSize = offset
+	return nil
+}
+
+// remember records an index entry, keeping the position of its first appearance
+func (s *BlockStore) remember(entry *BlockIndexEntry) {
+	if _, exists := s.entries[entry.Hash]; !exists {
+		s.order = append(s.order, entry.Hash)
+	}
+	s.entries[entry.Hash] = entry
+}
+
+// compactOrder drops hashes whose entries have been removed
+func (s *BlockStore) compactOrder() {
+	order := s.order[:0]
+	for _, hash := range s.order {
+		if _, exists := s.entries[hash]; exists {
+			order = append(order, hash)
+		}
+	}
+	s.order = order
+}
+
+// appendIndex writes an index record and remembers the entry
+func (s *BlockStore) appendIndex(entry *BlockIndexEntry) error {
+	record, err := encodeBlockIndexRecord(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.index.Write(record); err != nil {
+		return fmt.Errorf("failed to write block index: %w", err)
+	}
+	s.remember(entry)
+	return nil
+}
+
+// WriteBlock appends a block to the current block file and indexes it.
+// The block data is written before its index record, so a crash between
+// the two leaves a block that is indexed again by recovery.
+func (s *BlockStore) WriteBlock(block *Block, height int32, status BlockStatus) (*BlockIndexEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := block.Hash()
+	if entry, exists := s.entries[hash]; exists {
+		return entry, nil
+	}
+
+	data, err := block.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize block: %w", err)
+	}
+
+	recordSize := int64(blockRecordHeaderSize + len(data))
+	if s.fileSize > 0 && s.fileSize+recordSize > s.maxFileSize {
+		if err := s.nextFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	record := make([]byte, blockRecordHeaderSize, recordSize)
+	binary.LittleEndian.PutUint32(record[:4], s.magic)
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(data)))
+	record = append(record, data...)
+	if _, err := s.current.WriteAt(record, s.fileSize); err != nil {
+		return nil, fmt.Errorf("failed to write block: %w", err)
+	}
+
+	entry := &BlockIndexEntry{
+		Hash:   hash,
+		Header: block.Header,
+		File:   s.fileNum,
+		Offset: uint32(s.fileSize + blockRecordHeaderSize),
+		Size:   uint32(len(data)),
+		Height: height,
+		Status: status,
+	}
+	s.fileSize += recordSize
+
+	if err := s.appendIndex(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// nextFile syncs the current block file and starts the next one
+func (s *BlockStore) nextFile() error {
+	if err := s.current.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block file: %w", err)
+	}
+	if err := s.current.Close(); err != nil {
+		return fmt.Errorf("failed to close block file: %w", err)
+	}
+
+	current, err := os.OpenFile(s.blockFilePath(s.fileNum+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open block file: %w", err)
+	}
+	s.current = current
+	s.fileNum++
+	s.fileSize = 0
+	return nil
+}
+
+// SetStatus records a new status for a stored block
+func (s *BlockStore) SetStatus(hash Hash256, status BlockStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[hash]
+	if !exists {
+		return fmt.Errorf("block %s not stored", hash)
+	}
+	if entry.Status == status {
+		return nil
+	}
+
+	updated := *entry
+	updated.Status = status
+	return s.appendIndex(&updated)
+}
+
+// ReadBlock reads a stored block from disk
+func (s *BlockStore) ReadBlock(hash Hash256) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[hash]
+	if !exists {
+		return nil, fmt.Errorf("block %s not stored", hash)
+	}
+
+	file := s.current
+	if entry.File != s.fileNum {
+		f, err := os.Open(s.blockFilePath(entry.File))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open block file: %w", err)
+		}
+		defer f.Close()
+		file = f
+	}
+
+	data := make([]byte, entry.Size)
+	if _, err := file.ReadAt(data, int64(entry.Offset)); err != nil {
+		return nil, fmt.Errorf("failed to read block %s: %w", hash, err)
+	}
+	block, err := DeserializeBlock(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode block %s: %w", hash, err)
+	}
+	if block.Hash() != hash {
+		return nil, fmt.Errorf("block %s corrupted on disk", hash)
+	}
+	return block, nil
+}
+
+// Entry returns the index entry for a stored block
+func (s *BlockStore) Entry(hash Hash256) (BlockIndexEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.entries[hash]
+	if !exists {
+		return BlockIndexEntry{}, false
+	}
+	return *entry, true
+}
+
+// Entries returns every stored block in the order it was first written,
+// which places each block after its parent
+func (s *BlockStore) Entries() []BlockIndexEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]BlockIndexEntry, 0, len(s.order))
+	for _, hash := range s.order {
+		entries = append(entries, *s.entries[hash])
+	}
+	return entries
+}
+
+// Sync flushes block data and then the index to stable storage
+func (s *BlockStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.current.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block file: %w", err)
+	}
+	if err := s.index.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block index: %w", err)
+	}
+	return nil
+}
+
+// Close syncs and closes the store
+func (s *BlockStore) Close() error {
+	var errs []error
+	if s.current != nil {
+		errs = append(errs, s.Sync(), s.current.Close())
+	}
+	errs = append(errs, s.index.Close())
+	return errors.Join(errs...)
+}
+
+// encodeBlockIndexRecord serializes an index entry with a trailing checksum
+func encodeBlockIndexRecord(entry *BlockIndexEntry) ([]byte, error) {
+	header, err := entry.Header.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	record := make([]byte, 0, blockIndexRecordSize)
+	record = append(record, entry.Hash[:]...)
+	record = append(record, header...)
+	record = binary.LittleEndian.AppendUint32(record, entry.File)
+	record = binary.LittleEndian.AppendUint32(record, entry.Offset)
+	record = binary.LittleEndian.AppendUint32(record, entry.Size)
+	record = binary.LittleEndian.AppendUint32(record, uint32(entry.Height))
+	record = binary.LittleEndian.AppendUint32(record, uint32(entry.Status))
+	return binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(record)), nil
+}
+
+// decodeBlockIndexRecord parses an index record, rejecting torn or corrupt ones
+func decodeBlockIndexRecord(record []byte) (*BlockIndexEntry, error) {
+	body := record[:blockIndexRecordSize-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(record[blockIndexRecordSize-4:]) {
+		return nil, errors.New("block index record checksum mismatch")
+	}
+
+	header, err := DeserializeBlockHeader(bytes.NewReader(body[32 : 32+BlockHeaderSize]))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &BlockIndexEntry{Header: header}
+	copy(entry.Hash[:], body[:32])
+	if header.Hash() != entry.Hash {
+		return nil, errors.New("block index record hash mismatch")
+	}
+
+	fields := body[32+BlockHeaderSize:]
+	entry.File = binary.LittleEndian.Uint32(fields[0:4])
+	entry.Offset = binary.LittleEndian.Uint32(fields[4:8])
+	entry.Size = binary.LittleEndian.Uint32(fields[8:12])
+	entry.Height = int32(binary.LittleEndian.Uint32(fields[12:16]))
+	entry.Status = BlockStatus(binary.LittleEndian.Uint32(fields[16:20]))
+	return entry, nil
+}
@@ -0,0 +1,265 @@
+package bitcoin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createStoreTestBlocks mines a chain of count blocks on the regtest genesis block
+func createStoreTestBlocks(count int) []*Block {
+	blocks := []*Block{RegTestParams.GenesisBlock}
+	for i := 1; i <= count; i++ {
+		blocks = append(blocks, createBlockOn(blocks[i-1], RegTestParams.PowLimitBits, i))
+	}
+	return blocks
+}
+
+// openTestBlockStore opens a block store in dir, failing the test on error
+func openTestBlockStore(t *testing.T, dir string, maxFileSize int64) *BlockStore {
+	t.Helper()
+	store, err := OpenBlockStoreWithFileSize(dir, &RegTestParams, maxFileSize)
+	if err != nil {
+		t.Fatalf("Failed to open block store: %v", err)
+	}
+	return store
+}
+
+// TestBlockStore_WriteRead tests storing blocks across several files and reading them back after reopening
+func TestBlockStore_WriteRead(t *testing.T) {
+	dir := t.TempDir()
+	blocks := createStoreTestBlocks(5)
+
+	// Small files force a new block file every couple of blocks
+	store := openTestBlockStore(t, dir, 600)
+	for height, block := range blocks {
+		if _, err := store.WriteBlock(block, int32(height), StatusDataStored); err != nil {
+			t.Fatalf("Failed to write block %d: %v", height, err)
+		}
+	}
+	if err := store.SetStatus(blocks[1].Hash(), StatusDataStored|StatusValid); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close block store: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "blk00001.dat")); err != nil {
+		t.Error("Expected blocks to roll over into a second file")
+	}
+
+	store = openTestBlockStore(t, dir, 600)
+	defer store.Close()
+
+	entries := store.Entries()
+	if len(entries) != len(blocks) {
+		t.Fatalf("Expected %d entries, got %d", len(blocks), len(entries))
+	}
+	for height, block := range blocks {
+		if entries[height].Hash != block.Hash() || entries[height].Height != int32(height) {
+			t.Errorf("Unexpected entry at position %d", height)
+		}
+
+		read, err := store.ReadBlock(block.Hash())
+		if err != nil {
+			t.Fatalf("Failed to read block %d: %v", height, err)
+		}
+		if read.Hash() != block.Hash() || len(read.Transactions) != len(block.Transactions) {
+			t.Errorf("Block %d read back differently", height)
+		}
+	}
+
+	entry, found := store.Entry(blocks[1].Hash())
+	if !found || entry.Status != StatusDataStored|StatusValid {
+		t.Errorf("Expected updated status to persist, got %v", entry.Status)
+	}
+	if _, err := store.ReadBlock(Hash256{0x01}); err == nil {
+		t.Error("Expected error reading unknown block")
+	}
+}
+
+// TestBlockStore_Recovery tests reopening a store after writes were interrupted
+func TestBlockStore_Recovery(t *testing.T) {
+	blocks := createStoreTestBlocks(3)
+
+	// writeStore stores the blocks and returns the paths of the index and block file
+	writeStore := func(t *testing.T) (string, string, string) {
+		dir := t.TempDir()
+		store := openTestBlockStore(t, dir, DefaultMaxBlockFileSize)
+		for height, block := range blocks {
+			if _, err := store.WriteBlock(block, int32(height), StatusDataStored); err != nil {
+				t.Fatalf("Failed to write block %d: %v", height, err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("Failed to close block store: %v", err)
+		}
+		return dir, filepath.Join(dir, blockIndexFileName), filepath.Join(dir, "blk00000.dat")
+	}
+
+	fileSize := func(t *testing.T, path string) int64 {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		return info.Size()
+	}
+
+	tests := []struct {
+		name            string
+		damage          func(t *testing.T, indexPath, blockPath string)
+		expectedEntries int
+	}{
+		{
+			name: "torn index record",
+			damage: func(t *testing.T, indexPath, _ string) {
+				if err := os.Truncate(indexPath, fileSize(t, indexPath)-10); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedEntries: 4, // The last block is indexed again from the block file
+		},
+		{
+			name: "missing index record",
+			damage: func(t *testing.T, indexPath, _ string) {
+				if err := os.Truncate(indexPath, 2*blockIndexRecordSize); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedEntries: 4,
+		},
+		{
+			name: "partial block",
+			damage: func(t *testing.T, indexPath, blockPath string) {
+				if err := os.Truncate(blockPath, fileSize(t, blockPath)-1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedEntries: 3,
+		},
+		{
+			name: "garbage after last block",
+			damage: func(t *testing.T, _, blockPath string) {
+				f, err := os.OpenFile(blockPath, os.O_APPEND|os.O_WRONLY, 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if _, err := f.Write([]byte{0xfa, 0xbf, 0xb5, 0xda, 0xff, 0xff}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectedEntries: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, indexPath, blockPath := writeStore(t)
+			intactSize := fileSize(t, blockPath)
+			tt.damage(t, indexPath, blockPath)
+
+			store := openTestBlockStore(t, dir, DefaultMaxBlockFileSize)
+			entries := store.Entries()
+			if len(entries) != tt.expectedEntries {
+				t.Fatalf("Expected %d entries after recovery, got %d", tt.expectedEntries, len(entries))
+			}
+			for _, entry := range entries {
+				if _, err := store.ReadBlock(entry.Hash); err != nil {
+					t.Errorf("Failed to read recovered block: %v", err)
+				}
+			}
+
+			// Writing resumes cleanly after the last recovered block
+			if tt.expectedEntries < len(blocks) {
+				if _, err := store.WriteBlock(blocks[3], 3, StatusDataStored); err != nil {
+					t.Fatalf("Failed to write block after recovery: %v", err)
+				}
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("Failed to close block store: %v", err)
+			}
+			if size := fileSize(t, blockPath); size != intactSize {
+				t.Errorf("Expected block file of %d bytes, got %d", intactSize, size)
+			}
+			if size := fileSize(t, indexPath); size%blockIndexRecordSize != 0 {
+				t.Errorf("Expected whole index records, got %d bytes", size)
+			}
+		})
+	}
+}
+
+// TestBlockChain_WithStore tests that a chain backed by a block store survives a restart
+func TestBlockChain_WithStore(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestBlockStore(t, dir, DefaultMaxBlockFileSize)
+	blockchain, err := NewBlockChainWithStore(&RegTestParams, store, ValidationOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	genesis := blockchain.GetTip()
+	if genesis.Hash() != RegTestParams.GenesisHash {
+		t.Fatal("Expected empty store to start from the genesis block")
+	}
+
+	// A short branch that is later reorganized away, then a longer one
+	a1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 1)
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	b2 := createBlockOn(b1, RegTestParams.PowLimitBits, 12)
+	for _, block := range []*Block{a1, b1, b2} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+	if blockchain.GetTip() != b2 {
+		t.Fatal("Expected reorganization onto the longer branch")
+	}
+
+	// Blocks other than the tip are read back from disk
+	if node := blockchain.index.lookup(b1.Hash()); node.block != nil {
+		t.Error("Expected stored block to be released from memory")
+	}
+	if block := blockchain.GetBlock(1); block == nil || block.Hash() != b1.Hash() {
+		t.Error("Expected block at height 1 to be read from disk")
+	}
+	if block := blockchain.GetBlockByHash(a1.Hash()); block != nil {
+		t.Error("Expected side branch block not to be on the main chain")
+	}
+	utxoCount := blockchain.GetUTXOSet().Size()
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close block store: %v", err)
+	}
+
+	store = openTestBlockStore(t, dir, DefaultMaxBlockFileSize)
+	defer store.Close()
+	reopened, err := NewBlockChainWithStore(&RegTestParams, store, ValidationOptions{})
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	if reopened.GetTip().Hash() != b2.Hash() || reopened.Height() != 2 {
+		t.Errorf("Expected tip %s at height 2, got %s at height %d", b2.Hash(), reopened.GetTip().Hash(), reopened.Height())
+	}
+	if reopened.ChainWork().Cmp(blockchain.ChainWork()) != 0 {
+		t.Error("Expected chain work to be restored")
+	}
+	if reopened.GetUTXOSet().Size() != utxoCount {
+		t.Errorf("Expected %d UTXOs after restart, got %d", utxoCount, reopened.GetUTXOSet().Size())
+	}
+	if len(reopened.GetChainTips()) != 2 {
+		t.Errorf("Expected the side branch to be known after restart, got %d tips", len(reopened.GetChainTips()))
+	}
+
+	// The reopened chain keeps extending
+	b3 := createBlockOn(b2, RegTestParams.PowLimitBits, 13)
+	if err := reopened.AddBlock(b3); err != nil {
+		t.Fatalf("Failed to extend reopened chain: %v", err)
+	}
+	if reopened.Height() != 3 {
+		t.Errorf("Expected height 3, got %d", reopened.Height())
+	}
+
+	// A store belongs to one network
+	if _, err := NewBlockChainWithStore(&MainNetParams, store, ValidationOptions{}); err == nil {
+		t.Error("Expected a store of another network to be rejected")
+	}
+}
@@ -0,0 +1,589 @@
+package bitcoin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Coins database layout. Records are appended to a checksummed log. Changes
+// are written in batches that end with a commit record naming the best block,
+// so after a crash everything up to the last commit is kept and anything after
+// it is discarded: the coins on disk always match exactly the state after
+// connecting the best block. The coins index locates each record in the log.
+const (
+	coinsRecordOverhead  = 1 + 4 + 4 // Kind, payload length, checksum
+	coinsCompactMinBytes = 1 << 20   // Logs smaller than this are never compacted
+)
+
+// Coins database record kinds
+const (
+	coinsRecordCoin       byte = iota + 1 // An unspent output
+	coinsRecordSpend                      // An output that is no longer unspent
+	coinsRecordUndo                       // Undo data for a connected block
+	coinsRecordUndoDelete                 // Undo data that is no longer needed
+	coinsRecordCommit                     // End of a batch, naming the best block
+)
+
+// coinsBatch collects the changes written to the database by one flush
+type coinsBatch struct {
+	coins       []*UTXO
+	spent       []OutPoint
+	undo        map[Hash256]*BlockUndo
+	undoDeleted []Hash256
+}
+
+// coinsIndexOp is a change a committed batch makes to the coins index
+type coinsIndexOp struct {
+	key    coinsIndexKey
+	entry  coinsIndexEntry
+	remove bool
+}
+
+// CoinsDB stores unspent outputs and block undo data on disk. Neither the
+// values nor the index locating them are held in memory.
+type CoinsDB struct {
+	mu        sync.Mutex
+	dir       string
+	file      *os.File    // Log of the index's generation
+	size      int64       // Length of the log up to the last commit
+	index     *coinsIndex // Location of every live record in the log
+	bestBlock Hash256
+}
+
+// OpenCoinsDB opens or creates the coins database in dir, discarding any
+// batch whose commit record did not reach the disk
+func OpenCoinsDB(dir string) (*CoinsDB, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create coins directory: %w", err)
+	}
+
+	db := &CoinsDB{dir: dir}
+	indexPath := filepath.Join(dir, coinsIndexFileName)
+	_ = os.Remove(indexPath + ".tmp")
+
+	index, err := openCoinsIndex(indexPath)
+	if err != nil {
+		// A missing or damaged index is rebuilt from the newest log. Its
+		// header says nothing is indexed yet, so a crash while rebuilding
+		// only means rebuilding again.
+		generation, err := db.newestLog()
+		if err != nil {
+			return nil, err
+		}
+		if index, err = createCoinsIndex(indexPath, generation, coinsIndexMinSlots); err != nil {
+			return nil, err
+		}
+	}
+	db.index = index
+
+	file, err := os.OpenFile(db.logPath(index.generation), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		_ = index.close()
+		return nil, fmt.Errorf("failed to open coins database: %w", err)
+	}
+	db.file = file
+
+	if err := db.load(); err != nil {
+		_ = file.Close()
+		_ = index.close()
+		return nil, err
+	}
+	db.removeStaleLogs()
+	return db, nil
+}
+
+// logPath returns the path of the log of the given generation. Each
+// compaction writes the next generation.
+func (db *CoinsDB) logPath(generation uint64) string {
+	return filepath.Join(db.dir, fmt.Sprintf("coins%05d.dat", generation))
+}
+
+// logGenerations returns the generation of every log file in the directory
+func (db *CoinsDB) logGenerations() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(db.dir, "coins*.dat"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list coins logs: %w", err)
+	}
+	var generations []uint64
+	for _, path := range paths {
+		var generation uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "coins%d.dat", &generation); err == nil {
+			generations = append(generations, generation)
+		}
+	}
+	return generations, nil
+}
+
+// newestLog returns the generation of the newest log, or 1 for a new database
+func (db *CoinsDB) newestLog() (uint64, error) {
+	generations, err := db.logGenerations()
+	if err != nil {
+		return 0, err
+	}
+	newest := uint64(1)
+	for _, generation := range generations {
+		if generation > newest {
+			newest = generation
+		}
+	}
+	return newest, nil
+}
+
+// removeStaleLogs deletes logs left behind by an interrupted compaction
+func (db *CoinsDB) removeStaleLogs() {
+	generations, _ := db.logGenerations()
+	for _, generation := range generations {
+		if generation != db.index.generation {
+			_ = os.Remove(db.logPath(generation))
+		}
+	}
+}
+
+// load brings the index up to date by replaying the batches committed after
+// it was last synced
+func (db *CoinsDB) load() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat coins database: %w", err)
+	}
+	if info.Size() < db.index.indexed {
+		return errors.New("coins log is shorter than its index")
+	}
+
+	db.size, db.bestBlock = db.index.indexed, db.index.bestBlock
+	reader := bufio.NewReader(io.NewSectionReader(db.file, db.size, info.Size()-db.size))
+	offset := db.size
+	var pending []coinsIndexOp
+
+	for {
+		kind, payload, err := readCoinsRecord(reader)
+		if err != nil {
+			break
+		}
+		recordOffset, recordSize := offset, int64(coinsRecordOverhead+len(payload))
+		offset += recordSize
+		entry := coinsIndexEntry{offset: recordOffset, size: recordSize}
+
+		switch kind {
+		case coinsRecordCoin:
+			utxo, err := decodeCoin(payload)
+			if err != nil {
+				return fmt.Errorf("corrupt coin record at offset %d: %w", recordOffset, err)
+			}
+			outpoint := OutPoint{Hash: utxo.txHash, Index: utxo.outputIndex}
+			pending = append(pending, coinsIndexOp{key: coinKey(outpoint), entry: entry})
+		case coinsRecordSpend:
+			outpoint, err := decodeOutPoint(payload)
+			if err != nil {
+				return fmt.Errorf("corrupt spend record at offset %d: %w", recordOffset, err)
+			}
+			pending = append(pending, coinsIndexOp{key: coinKey(outpoint), remove: true})
+		case coinsRecordUndo, coinsRecordUndoDelete:
+			if len(payload) < 32 {
+				return fmt.Errorf("corrupt undo record at offset %d", recordOffset)
+			}
+			var hash Hash256
+			copy(hash[:], payload[:32])
+			pending = append(pending, coinsIndexOp{key: undoKey(hash), entry: entry, remove: kind == coinsRecordUndoDelete})
+		case coinsRecordCommit:
+			if len(payload) != 32 {
+				return fmt.Errorf("corrupt commit record at offset %d", recordOffset)
+			}
+			if err := db.applyIndex(pending); err != nil {
+				return err
+			}
+			pending = pending[:0]
+			copy(db.bestBlock[:], payload)
+			db.size = offset
+		default:
+			return fmt.Errorf("unknown coins record kind %d at offset %d", kind, recordOffset)
+		}
+	}
+
+	// A replayed batch may have been partly indexed before the crash
+	if db.size != db.index.indexed {
+		if err := db.index.recount(); err != nil {
+			return err
+		}
+		if err := db.index.commit(db.size, db.bestBlock); err != nil {
+			return err
+		}
+	}
+
+	// Drop the uncommitted tail of an interrupted flush
+	if err := db.file.Truncate(db.size); err != nil {
+		return fmt.Errorf("failed to truncate coins database: %w", err)
+	}
+	return nil
+}
+
+// applyIndex applies the index changes of a committed batch, growing the
+// index first if needed. Applying a batch again has no further effect.
+func (db *CoinsDB) applyIndex(ops []coinsIndexOp) error {
+	if !db.index.hasRoom(len(ops)) {
+		if err := db.resizeIndex(coinsIndexSlots(db.index.entries + uint64(len(ops)))); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		var err error
+		if op.remove {
+			err = db.index.remove(op.key)
+		} else {
+			err = db.index.put(op.key, op.entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resizeIndex copies the index into a table of the given size, dropping
+// deleted slots. The copy replaces the index by rename.
+func (db *CoinsDB) resizeIndex(slots uint64) error {
+	indexPath := filepath.Join(db.dir, coinsIndexFileName)
+	resized, err := createCoinsIndex(indexPath+".tmp", db.index.generation, slots)
+	if err != nil {
+		return err
+	}
+	err = db.index.forEach(resized.put)
+	if err == nil {
+		err = resized.commit(db.index.indexed, db.index.bestBlock)
+	}
+	if err == nil {
+		err = os.Rename(indexPath+".tmp", indexPath)
+	}
+	if err != nil {
+		_ = resized.close()
+		_ = os.Remove(indexPath + ".tmp")
+		return fmt.Errorf("failed to resize coins index: %w", err)
+	}
+
+	_ = db.index.close()
+	db.index = resized
+	return syncDir(db.dir)
+}
+
+// BestBlock returns the block the stored coins are the result of connecting,
+// or ZeroHash if nothing has been committed yet
+func (db *CoinsDB) BestBlock() Hash256 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.bestBlock
+}
+
+// Count returns the number of stored unspent outputs
+func (db *CoinsDB) Count() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return int(db.index.count)
+}
+
+// GetCoin reads an unspent output from disk
+func (db *CoinsDB) GetCoin(outpoint OutPoint) (*UTXO, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry, exists, err := db.index.get(coinKey(outpoint))
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	payload, err := db.readAt(entry.offset, coinsRecordCoin)
+	if err != nil {
+		return nil, false, err
+	}
+	utxo, err := decodeCoin(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	return utxo, true, nil
+}
+
+// GetUndo reads the undo data of a connected block from disk
+func (db *CoinsDB) GetUndo(hash Hash256) (*BlockUndo, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry, exists, err := db.index.get(undoKey(hash))
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	payload, err := db.readAt(entry.offset, coinsRecordUndo)
+	if err != nil {
+		return nil, false, err
+	}
+	undo, err := DeserializeBlockUndo(bytes.NewReader(payload[32:]))
+	if err != nil {
+		return nil, false, err
+	}
+	return undo, true, nil
+}
+
+// ForEachCoin calls fn with every stored unspent output
+func (db *CoinsDB) ForEachCoin(fn func(*UTXO)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.index.forEach(func(key coinsIndexKey, entry coinsIndexEntry) error {
+		if key.kind != coinsSlotCoin {
+			return nil
+		}
+		payload, err := db.readAt(entry.offset, coinsRecordCoin)
+		if err != nil {
+			return err
+		}
+		utxo, err := decodeCoin(payload)
+		if err != nil {
+			return err
+		}
+		fn(utxo)
+		return nil
+	})
+}
+
+// readAt reads the payload of the record at offset, checking its kind and checksum
+func (db *CoinsDB) readAt(offset int64, kind byte) ([]byte, error) {
+	section := io.NewSectionReader(db.file, offset, db.size-offset)
+	recordKind, payload, err := readCoinsRecord(section)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coins record at offset %d: %w", offset, err)
+	}
+	if recordKind != kind {
+		return nil, fmt.Errorf("unexpected coins record kind %d at offset %d", recordKind, offset)
+	}
+	return payload, nil
+}
+
+// write applies a batch and records bestBlock as the new best block. The
+// batch takes effect once its commit record has been synced to disk; if the
+// index cannot be updated after that, it is brought up to date on the next
+// open.
+func (db *CoinsDB) write(batch *coinsBatch, bestBlock Hash256) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var buf bytes.Buffer
+	var ops []coinsIndexOp
+	offset := db.size
+
+	appendRecord := func(kind byte, payload []byte) coinsIndexEntry {
+		entry := coinsIndexEntry{offset: offset, size: int64(coinsRecordOverhead + len(payload))}
+		writeCoinsRecord(&buf, kind, payload)
+		offset += entry.size
+		return entry
+	}
+
+	for _, utxo := range batch.coins {
+		outpoint := OutPoint{Hash: utxo.txHash, Index: utxo.outputIndex}
+		entry := appendRecord(coinsRecordCoin, encodeCoin(utxo))
+		ops = append(ops, coinsIndexOp{key: coinKey(outpoint), entry: entry})
+	}
+	for _, outpoint := range batch.spent {
+		appendRecord(coinsRecordSpend, encodeOutPoint(outpoint))
+		ops = append(ops, coinsIndexOp{key: coinKey(outpoint), remove: true})
+	}
+	for hash, undo := range batch.undo {
+		payload := append(hash[:len(hash):len(hash)], undo.Serialize()...)
+		entry := appendRecord(coinsRecordUndo, payload)
+		ops = append(ops, coinsIndexOp{key: undoKey(hash), entry: entry})
+	}
+	for _, hash := range batch.undoDeleted {
+		appendRecord(coinsRecordUndoDelete, hash[:])
+		ops = append(ops, coinsIndexOp{key: undoKey(hash), remove: true})
+	}
+	appendRecord(coinsRecordCommit, bestBlock[:])
+
+	if _, err := db.file.WriteAt(buf.Bytes(), db.size); err != nil {
+		return fmt.Errorf("failed to write coins: %w", err)
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync coins: %w", err)
+	}
+
+	// The size must be updated first so records of this batch can be read back
+	db.size = offset
+	db.bestBlock = bestBlock
+	if err := db.applyIndex(ops); err != nil {
+		return fmt.Errorf("failed to update coins index: %w", err)
+	}
+	if err := db.index.commit(db.size, bestBlock); err != nil {
+		return fmt.Errorf("failed to update coins index: %w", err)
+	}
+
+	// The batch is durable whether or not the log can be compacted
+	if db.size > coinsCompactMinBytes && db.size > 2*db.index.liveBytes {
+		if err := db.compact(); err != nil {
+			log.Printf("Failed to compact coins database: %v", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the records still in use into the log of the next
+// generation, along with an index for it. Renaming the new index into place
+// switches logs, so a crash leaves either the old or the new pair intact.
+func (db *CoinsDB) compact() error {
+	generation := db.index.generation + 1
+	logPath := db.logPath(generation)
+	indexPath := filepath.Join(db.dir, coinsIndexFileName)
+
+	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted coins database: %w", err)
+	}
+	index, err := createCoinsIndex(indexPath+".tmp", generation, coinsIndexSlots(db.index.entries))
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(logPath)
+		return err
+	}
+
+	fail := func(err error) error {
+		_ = file.Close()
+		_ = index.close()
+		_ = os.Remove(logPath)
+		_ = os.Remove(indexPath + ".tmp")
+		return fmt.Errorf("failed to compact coins database: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	var offset int64
+	err = db.index.forEach(func(key coinsIndexKey, entry coinsIndexEntry) error {
+		kind := coinsRecordCoin
+		if key.kind == coinsSlotUndo {
+			kind = coinsRecordUndo
+		}
+		payload, err := db.readAt(entry.offset, kind)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		writeCoinsRecord(&buf, kind, payload)
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		copied := coinsIndexEntry{offset: offset, size: int64(buf.Len())}
+		offset += copied.size
+		return index.put(key, copied)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	var commit bytes.Buffer
+	writeCoinsRecord(&commit, coinsRecordCommit, db.bestBlock[:])
+	offset += int64(commit.Len())
+	if _, err := writer.Write(commit.Bytes()); err != nil {
+		return fail(err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	// The new log must be on disk before an index naming it can be
+	if err := syncDir(db.dir); err != nil {
+		return fail(err)
+	}
+	if err := index.commit(offset, db.bestBlock); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(indexPath+".tmp", indexPath); err != nil {
+		return fail(err)
+	}
+
+	oldGeneration := db.index.generation
+	_ = db.file.Close()
+	_ = db.index.close()
+	db.file, db.index, db.size = file, index, offset
+
+	// The old log is only unused once the rename is on disk
+	if err := syncDir(db.dir); err != nil {
+		return err
+	}
+	_ = os.Remove(db.logPath(oldGeneration))
+	return nil
+}
+
+// syncDir syncs a directory so that files created or renamed in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database. Committed batches are already on disk.
+func (db *CoinsDB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return errors.Join(db.file.Close(), db.index.close())
+}
+
+// writeCoinsRecord appends a checksummed record to buf
+func writeCoinsRecord(buf *bytes.Buffer, kind byte, payload []byte) {
+	start := buf.Len()
+	buf.WriteByte(kind)
+	writeUint32LE(buf, uint32(len(payload)))
+	buf.Write(payload)
+	writeUint32LE(buf, crc32.ChecksumIEEE(buf.Bytes()[start:]))
+}
+
+// readCoinsRecord reads one record, rejecting torn or corrupt ones
+func readCoinsRecord(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[1:])
+	if length > MaxPayload {
+		return 0, nil, fmt.Errorf("coins record too large: %d", length)
+	}
+
+	body := make([]byte, int(length)+4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	payload := body[:length]
+
+	checksum := crc32.NewIEEE()
+	checksum.Write(header[:])
+	checksum.Write(payload)
+	if checksum.Sum32() != binary.LittleEndian.Uint32(body[length:]) {
+		return 0, nil, errors.New("coins record checksum mismatch")
+	}
+	return header[0], payload, nil
+}
+
+// encodeOutPoint serializes an outpoint as its hash followed by its index
+func encodeOutPoint(outpoint OutPoint) []byte {
+	buf := make([]byte, 0, 36)
+	buf = append(buf, outpoint.Hash[:]...)
+	return binary.LittleEndian.AppendUint32(buf, outpoint.Index)
+}
+
+// decodeOutPoint parses an outpoint written by encodeOutPoint
+func decodeOutPoint(data []byte) (OutPoint, error) {
+	if len(data) != 36 {
+		return OutPoint{}, fmt.Errorf("invalid outpoint length %d", len(data))
+	}
+	var outpoint OutPoint
+	copy(outpoint.Hash[:], data[:32])
+	outpoint.Index = binary.LittleEndian.Uint32(data[32:])
+	return outpoint, nil
+}
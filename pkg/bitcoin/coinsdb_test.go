@@ -0,0 +1,423 @@
+package bitcoin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestCoinsDB opens a coins database in dir, failing the test on error
+func openTestCoinsDB(t *testing.T, dir string) *CoinsDB {
+	t.Helper()
+	db, err := OpenCoinsDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to open coins database: %v", err)
+	}
+	return db
+}
+
+// TestCoinsDB_WriteReopen tests that flushed coins, undo data and the best block survive a reopen
+func TestCoinsDB_WriteReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestCoinsDB(t, dir)
+
+	coin := NewUTXO(Hash256{0x01}, 1, 5000, []byte{0x51})
+	coin.height = 7
+	coin.coinbase = true
+	spent := NewUTXO(Hash256{0x02}, 0, 1000, []byte{0x52})
+	undo := &BlockUndo{Transactions: []TxUndo{{SpentOutputs: []*UTXO{spent}}}}
+
+	if err := db.write(&coinsBatch{coins: []*UTXO{coin, spent}}, Hash256{0xaa}); err != nil {
+		t.Fatalf("Failed to write first batch: %v", err)
+	}
+	batch := &coinsBatch{
+		spent: []OutPoint{{Hash: spent.txHash, Index: spent.outputIndex}},
+		undo:  map[Hash256]*BlockUndo{{0xbb}: undo},
+	}
+	if err := db.write(batch, Hash256{0xbb}); err != nil {
+		t.Fatalf("Failed to write second batch: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close coins database: %v", err)
+	}
+
+	db = openTestCoinsDB(t, dir)
+	defer db.Close()
+
+	if db.BestBlock() != (Hash256{0xbb}) {
+		t.Errorf("Expected best block %s, got %s", Hash256{0xbb}, db.BestBlock())
+	}
+	if db.Count() != 1 {
+		t.Errorf("Expected 1 coin, got %d", db.Count())
+	}
+
+	read, found, err := db.GetCoin(OutPoint{Hash: coin.txHash, Index: coin.outputIndex})
+	if err != nil || !found {
+		t.Fatalf("Expected coin to be found, got %v", err)
+	}
+	if read.amount != 5000 || read.height != 7 || !read.coinbase || len(read.scriptPubKey) != 1 {
+		t.Errorf("Coin read back differently: %+v", read)
+	}
+	if _, found, _ := db.GetCoin(OutPoint{Hash: spent.txHash, Index: spent.outputIndex}); found {
+		t.Error("Expected spent coin to be deleted")
+	}
+
+	readUndo, found, err := db.GetUndo(Hash256{0xbb})
+	if err != nil || !found {
+		t.Fatalf("Expected undo data to be found, got %v", err)
+	}
+	if len(readUndo.Transactions) != 1 || readUndo.Transactions[0].SpentOutputs[0].amount != 1000 {
+		t.Error("Undo data read back differently")
+	}
+}
+
+// TestCoinsDB_UncommittedBatch tests that a batch cut short before its commit record is discarded
+func TestCoinsDB_UncommittedBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestCoinsDB(t, dir)
+	if err := db.write(&coinsBatch{coins: []*UTXO{NewUTXO(Hash256{0x01}, 0, 100, nil)}}, Hash256{0xaa}); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	committed := db.size
+	indexPath := filepath.Join(dir, coinsIndexFileName)
+	index, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.write(&coinsBatch{coins: []*UTXO{NewUTXO(Hash256{0x02}, 0, 200, nil)}}, Hash256{0xbb}); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	path := db.logPath(db.index.generation)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close coins database: %v", err)
+	}
+
+	// Losing the last byte tears the second commit record, which the index
+	// can only have been updated after
+	if err := os.WriteFile(indexPath, index, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTestCoinsDB(t, dir)
+	defer db.Close()
+	if db.BestBlock() != (Hash256{0xaa}) {
+		t.Errorf("Expected best block of the first batch, got %s", db.BestBlock())
+	}
+	if db.Count() != 1 {
+		t.Errorf("Expected 1 coin, got %d", db.Count())
+	}
+	if _, found, _ := db.GetCoin(OutPoint{Hash: Hash256{0x02}}); found {
+		t.Error("Expected coin of the uncommitted batch to be discarded")
+	}
+	if db.size != committed {
+		t.Errorf("Expected log truncated to %d bytes, got %d", committed, db.size)
+	}
+}
+
+// TestCoinsDB_Compaction tests that a log mostly made of stale records is rewritten
+func TestCoinsDB_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestCoinsDB(t, dir)
+
+	// Repeatedly create and spend large coins so only the last one is live
+	script := make([]byte, 64<<10)
+	for i := 0; i < 40; i++ {
+		coin := NewUTXO(Hash256{byte(i)}, 0, uint64(i), script)
+		batch := &coinsBatch{coins: []*UTXO{coin}}
+		if i > 0 {
+			batch.spent = []OutPoint{{Hash: Hash256{byte(i - 1)}}}
+		}
+		if err := db.write(batch, Hash256{byte(i)}); err != nil {
+			t.Fatalf("Failed to write batch %d: %v", i, err)
+		}
+	}
+	if db.size > coinsCompactMinBytes {
+		t.Errorf("Expected log to be compacted, got %d bytes", db.size)
+	}
+	if logs, _ := db.logGenerations(); len(logs) != 1 || logs[0] != db.index.generation {
+		t.Errorf("Expected only the log of generation %d, got %v", db.index.generation, logs)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close coins database: %v", err)
+	}
+
+	db = openTestCoinsDB(t, dir)
+	defer db.Close()
+	if db.Count() != 1 || db.BestBlock() != (Hash256{39}) {
+		t.Errorf("Expected 1 coin at best block %s, got %d at %s", Hash256{39}, db.Count(), db.BestBlock())
+	}
+	if coin, found, err := db.GetCoin(OutPoint{Hash: Hash256{39}}); err != nil || !found || coin.amount != 39 {
+		t.Errorf("Expected last coin to survive compaction, got %v", err)
+	}
+}
+
+// TestCoinsDB_Index tests that the on-disk index grows with the coins, is
+// used as is on reopen, and is brought up to date or rebuilt from the log
+func TestCoinsDB_Index(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, coinsIndexFileName)
+	db := openTestCoinsDB(t, dir)
+
+	// More coins than the smallest table holds force it to grow
+	var coins []*UTXO
+	for i := 0; i < coinsIndexMinSlots; i++ {
+		coins = append(coins, NewUTXO(Hash256{byte(i), byte(i >> 8)}, uint32(i), uint64(i), []byte{0x51}))
+	}
+	if err := db.write(&coinsBatch{coins: coins}, Hash256{0xaa}); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	if db.index.slots <= coinsIndexMinSlots {
+		t.Errorf("Expected index to grow past %d slots, got %d", coinsIndexMinSlots, db.index.slots)
+	}
+	indexed, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := &coinsBatch{
+		coins: []*UTXO{NewUTXO(Hash256{0xff}, 0, 5000, nil)},
+		spent: []OutPoint{{Hash: coins[0].txHash, Index: coins[0].outputIndex}},
+	}
+	if err := db.write(batch, Hash256{0xbb}); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close coins database: %v", err)
+	}
+
+	check := func(t *testing.T, db *CoinsDB) {
+		t.Helper()
+		if db.Count() != len(coins) || db.BestBlock() != (Hash256{0xbb}) {
+			t.Errorf("Expected %d coins at best block %s, got %d at %s", len(coins), Hash256{0xbb}, db.Count(), db.BestBlock())
+		}
+		if db.index.indexed != db.size {
+			t.Errorf("Expected index to cover the log of %d bytes, got %d", db.size, db.index.indexed)
+		}
+		for _, coin := range append(coins[1:], batch.coins...) {
+			if read, found, err := db.GetCoin(OutPoint{Hash: coin.txHash, Index: coin.outputIndex}); err != nil || !found || read.amount != coin.amount {
+				t.Fatalf("Expected coin %s:%d to be found, got %v", coin.txHash, coin.outputIndex, err)
+			}
+		}
+		if _, found, _ := db.GetCoin(OutPoint{Hash: coins[0].txHash, Index: coins[0].outputIndex}); found {
+			t.Error("Expected spent coin to be deleted")
+		}
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T)
+	}{
+		{"index up to date", func(t *testing.T) {}},
+		{"index behind the log", func(t *testing.T) {
+			if err := os.WriteFile(indexPath, indexed, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"index missing", func(t *testing.T) {
+			if err := os.Remove(indexPath); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.prepare(t)
+			db := openTestCoinsDB(t, dir)
+			defer db.Close()
+			check(t, db)
+		})
+	}
+}
+
+// TestUTXOSet_WriteBackCache tests that changes stay in memory until flushed
+func TestUTXOSet_WriteBackCache(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestCoinsDB(t, dir)
+	defer db.Close()
+	set := NewUTXOSetWithDB(db, DefaultDBCache)
+
+	kept := NewUTXO(Hash256{0x01}, 0, 100, nil)
+	spentLater := NewUTXO(Hash256{0x02}, 0, 200, nil)
+	spentNow := NewUTXO(Hash256{0x03}, 0, 300, nil)
+	for _, utxo := range []*UTXO{kept, spentLater, spentNow} {
+		set.Add(utxo)
+	}
+	set.Remove(spentNow.txHash, 0)
+
+	if db.Count() != 0 {
+		t.Fatal("Expected nothing written before a flush")
+	}
+	if set.Size() != 2 || set.CacheSize() == 0 {
+		t.Errorf("Expected 2 cached coins, got %d using %d bytes", set.Size(), set.CacheSize())
+	}
+
+	// A coin created and spent between flushes never reaches the disk
+	if err := set.Flush(Hash256{0xaa}); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if db.Count() != 2 || set.CacheSize() != 0 || set.BestBlock() != (Hash256{0xaa}) {
+		t.Errorf("Expected 2 coins on disk and an empty cache, got %d and %d bytes", db.Count(), set.CacheSize())
+	}
+	if _, found, _ := db.index.get(coinKey(OutPoint{Hash: spentNow.txHash})); found {
+		t.Error("Expected fresh coin spent before flushing to be skipped")
+	}
+
+	// Coins are read back on demand and deletions are written on the next flush
+	if utxo, found := set.Find(kept.txHash, 0); !found || utxo.amount != 100 {
+		t.Error("Expected flushed coin to be read from disk")
+	}
+	if !set.Remove(spentLater.txHash, 0) {
+		t.Fatal("Expected flushed coin to be spendable")
+	}
+	if set.Size() != 1 || db.Count() != 2 {
+		t.Errorf("Expected the spend to be cached, got %d in set and %d on disk", set.Size(), db.Count())
+	}
+	if err := set.Flush(Hash256{0xbb}); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if db.Count() != 1 || set.TotalValue() != 100 {
+		t.Errorf("Expected 1 coin worth 100 on disk, got %d worth %d", db.Count(), set.TotalValue())
+	}
+
+	// A tiny budget asks for a flush as soon as anything is cached
+	small := NewUTXOSetWithDB(db, 1)
+	if small.NeedsFlush() {
+		t.Error("Expected empty cache not to need a flush")
+	}
+	small.Add(NewUTXO(Hash256{0x04}, 0, 400, nil))
+	if !small.NeedsFlush() {
+		t.Error("Expected cache over budget to need a flush")
+	}
+}
+
+// TestBlockChain_WithCoins tests that a chain with a coins database resumes from its best block
+func TestBlockChain_WithCoins(t *testing.T) {
+	dir := t.TempDir()
+	openChain := func(t *testing.T) (*BlockChain, func()) {
+		t.Helper()
+		store := openTestBlockStore(t, filepath.Join(dir, "blocks"), DefaultMaxBlockFileSize)
+		db := openTestCoinsDB(t, filepath.Join(dir, "chainstate"))
+		blockchain, err := NewBlockChainWithCoins(&RegTestParams, store, NewUTXOSetWithDB(db, DefaultDBCache), ValidationOptions{})
+		if err != nil {
+			t.Fatalf("Failed to open blockchain: %v", err)
+		}
+		return blockchain, func() {
+			if err := blockchain.Flush(); err != nil {
+				t.Errorf("Failed to flush: %v", err)
+			}
+			_ = db.Close()
+			_ = store.Close()
+		}
+	}
+
+	blockchain, closeChain := openChain(t)
+	genesis := blockchain.GetTip()
+	a1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 1)
+	a2 := createBlockOn(a1, RegTestParams.PowLimitBits, 2)
+	for _, block := range []*Block{a1, a2} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+	utxoCount := blockchain.GetUTXOSet().Size()
+	closeChain()
+
+	// Reopening resumes at the flushed tip without reconnecting any block
+	blockchain, closeChain = openChain(t)
+	if blockchain.GetTip().Hash() != a2.Hash() || blockchain.Height() != 2 {
+		t.Fatalf("Expected tip %s at height 2, got %s", a2.Hash(), blockchain.GetTip().Hash())
+	}
+	if blockchain.GetUTXOSet().Size() != utxoCount {
+		t.Errorf("Expected %d UTXOs after restart, got %d", utxoCount, blockchain.GetUTXOSet().Size())
+	}
+	if blockchain.GetUTXOSet().CacheSize() != 0 {
+		t.Error("Expected no coins to be loaded by resuming")
+	}
+
+	// A longer branch disconnects blocks connected before the restart
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	b2 := createBlockOn(b1, RegTestParams.PowLimitBits, 12)
+	b3 := createBlockOn(b2, RegTestParams.PowLimitBits, 13)
+	for _, block := range []*Block{b1, b2, b3} {
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+	if blockchain.GetTip().Hash() != b3.Hash() {
+		t.Fatal("Expected reorganization onto the longer branch")
+	}
+	if _, found := blockchain.GetUTXOSet().Find(a1.Transactions[0].Hash(), 0); found {
+		t.Error("Expected coinbase of the disconnected branch to be removed")
+	}
+	utxoCount = blockchain.GetUTXOSet().Size()
+	closeChain()
+
+	blockchain, closeChain = openChain(t)
+	defer closeChain()
+	if blockchain.GetTip().Hash() != b3.Hash() || blockchain.GetUTXOSet().Size() != utxoCount {
+		t.Errorf("Expected tip %s with %d UTXOs, got %s with %d", b3.Hash(), utxoCount, blockchain.GetTip().Hash(), blockchain.GetUTXOSet().Size())
+	}
+}
+
+// TestBlockChain_RestartWithUnconnectableBlock tests that a stored block that
+// cannot be connected at startup leaves the chain at its flushed tip
+func TestBlockChain_RestartWithUnconnectableBlock(t *testing.T) {
+	dir := t.TempDir()
+	openChain := func(t *testing.T) (*BlockChain, func()) {
+		t.Helper()
+		store := openTestBlockStore(t, filepath.Join(dir, "blocks"), DefaultMaxBlockFileSize)
+		db := openTestCoinsDB(t, filepath.Join(dir, "chainstate"))
+		blockchain, err := NewBlockChainWithCoins(&RegTestParams, store, NewUTXOSetWithDB(db, DefaultDBCache), ValidationOptions{})
+		if err != nil {
+			t.Fatalf("Failed to open blockchain: %v", err)
+		}
+		return blockchain, func() {
+			if err := blockchain.Flush(); err != nil {
+				t.Errorf("Failed to flush: %v", err)
+			}
+			_ = db.Close()
+			_ = store.Close()
+		}
+	}
+
+	blockchain, closeChain := openChain(t)
+	a1 := createBlockOn(blockchain.GetTip(), RegTestParams.PowLimitBits, 1)
+	if err := blockchain.AddBlock(a1); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+	multisig := []byte{byte(OP_0), byte(OP_0), byte(OP_CHECKMULTISIG)}
+	blockchain.GetUTXOSet().Add(NewUTXO(Hash256{0x01}, 0, 1000, multisig))
+
+	// The script engine cannot verify a2, so it is stored but not connected
+	a2 := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: a1.Hash(),
+		Timestamp:     a1.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{*createUniqueCoinbaseTransaction(5000000000, 2), spendingTransaction(900, OutPoint{Hash: Hash256{0x01}})})
+	if err := blockchain.AddBlock(a2); !errors.Is(err, ErrUnsupportedOpcode) {
+		t.Fatalf("Expected unsupported opcode error, got %v", err)
+	}
+	utxoCount := blockchain.GetUTXOSet().Size()
+	closeChain()
+
+	blockchain, closeChain = openChain(t)
+	defer closeChain()
+	if blockchain.GetTip().Hash() != a1.Hash() || blockchain.Height() != 1 {
+		t.Fatalf("Expected tip %s at height 1, got %s", a1.Hash(), blockchain.GetTip().Hash())
+	}
+	if blockchain.IsBlockInvalid(a2.Hash()) {
+		t.Error("Expected unconnected block not marked invalid")
+	}
+	if blockchain.GetUTXOSet().Size() != utxoCount {
+		t.Errorf("Expected %d UTXOs after restart, got %d", utxoCount, blockchain.GetUTXOSet().Size())
+	}
+}
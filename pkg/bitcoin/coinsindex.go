@@ -0,0 +1,350 @@
+package bitcoin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// Coins index layout. The index is an open-addressing hash table on disk
+// mapping each unspent output and each block's undo data to its record in the
+// coins log. Its header records how much of the log the table reflects, so
+// opening the database only replays batches committed after the index was
+// last synced. Slots are aligned so writing one never spans a disk sector.
+const (
+	coinsIndexFileName   = "coins.idx"
+	coinsIndexMagic      = 0x58444943 // "CIDX"
+	coinsIndexHeaderSize = 128
+	coinsIndexSlotSize   = 64
+	coinsIndexMinSlots   = 1024
+)
+
+// Coins index slot states
+const (
+	coinsSlotEmpty   byte = iota // Never used; ends a probe
+	coinsSlotCoin                // An unspent output
+	coinsSlotUndo                // Undo data of a block, keyed by its hash and index 0
+	coinsSlotDeleted             // A removed entry; reused by inserts
+)
+
+// coinsIndexKey identifies an entry of the coins index
+type coinsIndexKey struct {
+	kind     byte
+	outpoint OutPoint
+}
+
+func coinKey(outpoint OutPoint) coinsIndexKey {
+	return coinsIndexKey{kind: coinsSlotCoin, outpoint: outpoint}
+}
+
+func undoKey(hash Hash256) coinsIndexKey {
+	return coinsIndexKey{kind: coinsSlotUndo, outpoint: OutPoint{Hash: hash}}
+}
+
+// coinsIndexEntry locates a record in the coins log
+type coinsIndexEntry struct {
+	offset int64
+	size   int64
+}
+
+// coinsIndex is the on-disk table of a coins database. Only its header is
+// held in memory.
+type coinsIndex struct {
+	file *os.File
+
+	generation uint64  // Number of the log file the index belongs to
+	indexed    int64   // Length of the log reflected by the index
+	bestBlock  Hash256 // Best block committed at indexed
+	slots      uint64  // Size of the table, a power of two
+	used       uint64  // Slots that are not empty, including deleted ones
+	entries    uint64  // Coins and undo data in the table
+	count      int64   // Unspent outputs in the table
+	liveBytes  int64   // Size of the log records the table points to
+}
+
+// createCoinsIndex creates an empty index for the log of the given
+// generation, replacing any file at path
+func createCoinsIndex(path string, generation, slots uint64) (*coinsIndex, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create coins index: %w", err)
+	}
+	idx := &coinsIndex{file: file, generation: generation, slots: slots}
+	if err := file.Truncate(coinsIndexHeaderSize + int64(slots)*coinsIndexSlotSize); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to size coins index: %w", err)
+	}
+	if err := idx.writeHeader(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// openCoinsIndex opens the index at path, rejecting it if its header is damaged
+func openCoinsIndex(path string) (*coinsIndex, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	idx := &coinsIndex{file: file}
+	if err := idx.readHeader(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (idx *coinsIndex) writeHeader() error {
+	header := make([]byte, 0, coinsIndexHeaderSize)
+	header = binary.LittleEndian.AppendUint32(header, coinsIndexMagic)
+	header = binary.LittleEndian.AppendUint64(header, idx.generation)
+	header = binary.LittleEndian.AppendUint64(header, uint64(idx.indexed))
+	header = append(header, idx.bestBlock[:]...)
+	header = binary.LittleEndian.AppendUint64(header, idx.slots)
+	header = binary.LittleEndian.AppendUint64(header, idx.used)
+	header = binary.LittleEndian.AppendUint64(header, idx.entries)
+	header = binary.LittleEndian.AppendUint64(header, uint64(idx.count))
+	header = binary.LittleEndian.AppendUint64(header, uint64(idx.liveBytes))
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(header))
+	if _, err := idx.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("failed to write coins index header: %w", err)
+	}
+	return nil
+}
+
+func (idx *coinsIndex) readHeader() error {
+	var header [96]byte
+	if _, err := idx.file.ReadAt(header[:], 0); err != nil {
+		return fmt.Errorf("failed to read coins index header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != coinsIndexMagic {
+		return errors.New("not a coins index")
+	}
+	if crc32.ChecksumIEEE(header[:92]) != binary.LittleEndian.Uint32(header[92:]) {
+		return errors.New("coins index header checksum mismatch")
+	}
+	idx.generation = binary.LittleEndian.Uint64(header[4:])
+	idx.indexed = int64(binary.LittleEndian.Uint64(header[12:]))
+	copy(idx.bestBlock[:], header[20:52])
+	idx.slots = binary.LittleEndian.Uint64(header[52:])
+	idx.used = binary.LittleEndian.Uint64(header[60:])
+	idx.entries = binary.LittleEndian.Uint64(header[68:])
+	idx.count = int64(binary.LittleEndian.Uint64(header[76:]))
+	idx.liveBytes = int64(binary.LittleEndian.Uint64(header[84:]))
+
+	info, err := idx.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat coins index: %w", err)
+	}
+	if idx.slots == 0 || idx.slots&(idx.slots-1) != 0 || info.Size() != coinsIndexHeaderSize+int64(idx.slots)*coinsIndexSlotSize {
+		return errors.New("coins index size does not match its header")
+	}
+	return nil
+}
+
+// commit syncs the table, then records that it reflects the log up to
+// indexed with bestBlock as the best block
+func (idx *coinsIndex) commit(indexed int64, bestBlock Hash256) error {
+	if err := idx.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync coins index: %w", err)
+	}
+	idx.indexed = indexed
+	idx.bestBlock = bestBlock
+	if err := idx.writeHeader(); err != nil {
+		return err
+	}
+	if err := idx.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync coins index: %w", err)
+	}
+	return nil
+}
+
+// slotOffset returns the position of slot i in the file
+func slotOffset(i uint64) int64 {
+	return coinsIndexHeaderSize + int64(i)*coinsIndexSlotSize
+}
+
+// encodeCoinsSlot serializes an entry as its state, key and log location
+func encodeCoinsSlot(state byte, key coinsIndexKey, entry coinsIndexEntry) []byte {
+	slot := make([]byte, 0, coinsIndexSlotSize)
+	slot = append(slot, state)
+	slot = append(slot, encodeOutPoint(key.outpoint)...)
+	slot = binary.LittleEndian.AppendUint64(slot, uint64(entry.offset))
+	slot = binary.LittleEndian.AppendUint32(slot, uint32(entry.size))
+	return slot[:coinsIndexSlotSize:coinsIndexSlotSize]
+}
+
+// decodeCoinsSlot parses a slot written by encodeCoinsSlot
+func decodeCoinsSlot(slot []byte) (byte, coinsIndexKey, coinsIndexEntry) {
+	state := slot[0]
+	outpoint, _ := decodeOutPoint(slot[1:37])
+	entry := coinsIndexEntry{
+		offset: int64(binary.LittleEndian.Uint64(slot[37:])),
+		size:   int64(binary.LittleEndian.Uint32(slot[45:])),
+	}
+	return state, coinsIndexKey{kind: state, outpoint: outpoint}, entry
+}
+
+// home returns the first slot probed for key
+func (idx *coinsIndex) home(key coinsIndexKey) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{key.kind})
+	h.Write(encodeOutPoint(key.outpoint))
+	return h.Sum64() & (idx.slots - 1)
+}
+
+// find probes for key. It returns the slot holding key, or the slot an
+// insert of key should use if key is not in the table.
+func (idx *coinsIndex) find(key coinsIndexKey) (uint64, coinsIndexEntry, bool, error) {
+	slot := make([]byte, coinsIndexSlotSize)
+	free, hasFree := uint64(0), false
+	i := idx.home(key)
+	for probes := uint64(0); probes < idx.slots; probes++ {
+		if _, err := idx.file.ReadAt(slot, slotOffset(i)); err != nil {
+			return 0, coinsIndexEntry{}, false, fmt.Errorf("failed to read coins index: %w", err)
+		}
+		state, slotKey, entry := decodeCoinsSlot(slot)
+		switch {
+		case state == coinsSlotEmpty:
+			if !hasFree {
+				free = i
+			}
+			return free, coinsIndexEntry{}, false, nil
+		case state == coinsSlotDeleted:
+			if !hasFree {
+				free, hasFree = i, true
+			}
+		case slotKey == key:
+			return i, entry, true, nil
+		}
+		i = (i + 1) & (idx.slots - 1)
+	}
+	if !hasFree {
+		return 0, coinsIndexEntry{}, false, errors.New("coins index is full")
+	}
+	return free, coinsIndexEntry{}, false, nil
+}
+
+// get returns the log location of key
+func (idx *coinsIndex) get(key coinsIndexKey) (coinsIndexEntry, bool, error) {
+	_, entry, found, err := idx.find(key)
+	return entry, found, err
+}
+
+// put points key at entry, replacing any previous location
+func (idx *coinsIndex) put(key coinsIndexKey, entry coinsIndexEntry) error {
+	i, old, found, err := idx.find(key)
+	if err != nil {
+		return err
+	}
+	var state [1]byte
+	if !found {
+		if _, err := idx.file.ReadAt(state[:], slotOffset(i)); err != nil {
+			return fmt.Errorf("failed to read coins index: %w", err)
+		}
+	}
+	if _, err := idx.file.WriteAt(encodeCoinsSlot(key.kind, key, entry), slotOffset(i)); err != nil {
+		return fmt.Errorf("failed to write coins index: %w", err)
+	}
+
+	if found {
+		idx.liveBytes -= old.size
+	} else {
+		if state[0] == coinsSlotEmpty {
+			idx.used++
+		}
+		idx.entries++
+		if key.kind == coinsSlotCoin {
+			idx.count++
+		}
+	}
+	idx.liveBytes += entry.size
+	return nil
+}
+
+// remove deletes key from the table if present
+func (idx *coinsIndex) remove(key coinsIndexKey) error {
+	i, old, found, err := idx.find(key)
+	if err != nil || !found {
+		return err
+	}
+	if _, err := idx.file.WriteAt(encodeCoinsSlot(coinsSlotDeleted, key, old), slotOffset(i)); err != nil {
+		return fmt.Errorf("failed to write coins index: %w", err)
+	}
+	idx.entries--
+	if key.kind == coinsSlotCoin {
+		idx.count--
+	}
+	idx.liveBytes -= old.size
+	return nil
+}
+
+// forEach calls fn with every entry in the table
+func (idx *coinsIndex) forEach(fn func(coinsIndexKey, coinsIndexEntry) error) error {
+	reader := bufio.NewReaderSize(io.NewSectionReader(idx.file, coinsIndexHeaderSize, int64(idx.slots)*coinsIndexSlotSize), 1<<16)
+	slot := make([]byte, coinsIndexSlotSize)
+	for i := uint64(0); i < idx.slots; i++ {
+		if _, err := io.ReadFull(reader, slot); err != nil {
+			return fmt.Errorf("failed to read coins index: %w", err)
+		}
+		if state, key, entry := decodeCoinsSlot(slot); state == coinsSlotCoin || state == coinsSlotUndo {
+			if err := fn(key, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recount recomputes the header totals from the table. They are only kept
+// up to date by complete batches, so a replayed batch may have skewed them.
+func (idx *coinsIndex) recount() error {
+	idx.used, idx.entries, idx.count, idx.liveBytes = 0, 0, 0, 0
+	reader := bufio.NewReaderSize(io.NewSectionReader(idx.file, coinsIndexHeaderSize, int64(idx.slots)*coinsIndexSlotSize), 1<<16)
+	slot := make([]byte, coinsIndexSlotSize)
+	for i := uint64(0); i < idx.slots; i++ {
+		if _, err := io.ReadFull(reader, slot); err != nil {
+			return fmt.Errorf("failed to read coins index: %w", err)
+		}
+		state, _, entry := decodeCoinsSlot(slot)
+		if state == coinsSlotEmpty {
+			continue
+		}
+		idx.used++
+		if state == coinsSlotDeleted {
+			continue
+		}
+		idx.entries++
+		idx.liveBytes += entry.size
+		if state == coinsSlotCoin {
+			idx.count++
+		}
+	}
+	return nil
+}
+
+// hasRoom reports whether n more entries can be inserted while keeping the
+// table at most three quarters used
+func (idx *coinsIndex) hasRoom(n int) bool {
+	return idx.used+uint64(n) <= idx.slots/4*3
+}
+
+// coinsIndexSlots returns the table size for n entries, leaving half the
+// slots free so inserts do not force another resize soon
+func coinsIndexSlots(n uint64) uint64 {
+	slots := uint64(coinsIndexMinSlots)
+	for slots/2 < n {
+		slots *= 2
+	}
+	return slots
+}
+
+func (idx *coinsIndex) close() error {
+	return idx.file.Close()
+}
@@ -0,0 +1,84 @@
+package bitcoin
+
+import "fmt"
+
+// ErrorCode identifies the consensus rule a block or transaction broke
+type ErrorCode int
+
+// Consensus rule violations reported in a RuleError
+const (
+	ErrNoTransactions ErrorCode = iota
+	ErrFirstTxNotCoinbase
+	ErrMultipleCoinbases
+	ErrBadCoinbaseScriptLen
+	ErrBadTransaction
+	ErrBlockTooBig
+	ErrBadDiffBits
+	ErrTimeTooOld
+	ErrTimewarp
+	ErrBadCoinbaseHeight
+	ErrBlockVersionTooOld
+	ErrOverwriteTx
+	ErrUnfinalizedTx
+	ErrSequenceLockNotMet
+	ErrMissingTxOut
+	ErrDoubleSpend
+	ErrImmatureSpend
+	ErrBadTxOutValue
+	ErrSpendTooHigh
+	ErrBadCoinbaseValue
+	ErrTooManySigOps
+	ErrScriptValidation
+)
+
+// errorCodeStrings names each error code for logging
+var errorCodeStrings = map[ErrorCode]string{
+	ErrNoTransactions:       "ErrNoTransactions",
+	ErrFirstTxNotCoinbase:   "ErrFirstTxNotCoinbase",
+	ErrMultipleCoinbases:    "ErrMultipleCoinbases",
+	ErrBadCoinbaseScriptLen: "ErrBadCoinbaseScriptLen",
+	ErrBadTransaction:       "ErrBadTransaction",
+	ErrBlockTooBig:          "ErrBlockTooBig",
+	ErrBadDiffBits:          "ErrBadDiffBits",
+	ErrTimeTooOld:           "ErrTimeTooOld",
+	ErrTimewarp:             "ErrTimewarp",
+	ErrBadCoinbaseHeight:    "ErrBadCoinbaseHeight",
+	ErrBlockVersionTooOld:   "ErrBlockVersionTooOld",
+	ErrOverwriteTx:          "ErrOverwriteTx",
+	ErrUnfinalizedTx:        "ErrUnfinalizedTx",
+	ErrSequenceLockNotMet:   "ErrSequenceLockNotMet",
+	ErrMissingTxOut:         "ErrMissingTxOut",
+	ErrDoubleSpend:          "ErrDoubleSpend",
+	ErrImmatureSpend:        "ErrImmatureSpend",
+	ErrBadTxOutValue:        "ErrBadTxOutValue",
+	ErrSpendTooHigh:         "ErrSpendTooHigh",
+	ErrBadCoinbaseValue:     "ErrBadCoinbaseValue",
+	ErrTooManySigOps:        "ErrTooManySigOps",
+	ErrScriptValidation:     "ErrScriptValidation",
+}
+
+// String returns the name of the error code
+func (e ErrorCode) String() string {
+	if s, ok := errorCodeStrings[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError reports a block or transaction that breaks a consensus rule.
+// Blocks failing with a RuleError are invalid and are never reconsidered;
+// use errors.As to recover the code from a wrapped error.
+type RuleError struct {
+	Code        ErrorCode
+	Description string
+}
+
+// Error returns the description of the rule violation
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+// ruleError creates a RuleError with a formatted description
+func ruleError(code ErrorCode, format string, args ...interface{}) RuleError {
+	return RuleError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
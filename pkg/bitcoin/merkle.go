@@ -1,53 +1,92 @@
 package bitcoin
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 )
 
 // CalculateMerkleRoot calculates the merkle root for a list of transaction hashes
-// This implements the Bitcoin merkle tree algorithm with proper duplication for odd counts
+// This implements the Bitcoin merkle tree algorithm with proper duplication for odd counts.
+// Hashes are given and returned in display order, like Transaction.Hash and
+// BlockHeader.MerkleRoot; the tree itself is built over the internal byte order.
 func CalculateMerkleRoot(txHashes []Hash256) Hash256 {
+	root, _ := CalculateMerkleRootMutated(txHashes)
+	return root
+}
+
+// CalculateMerkleRootMutated calculates the merkle root like CalculateMerkleRoot and
+// also reports whether the list is a CVE-2012-2459 mutation. Duplicating the last
+// hash of an odd level means that appending copies of trailing transactions can
+// produce the same root, so any level with two identical hashes in a pair is
+// flagged. Such a list never belongs to a valid block, but the header it was
+// paired with may well be valid.
+func CalculateMerkleRootMutated(txHashes []Hash256) (Hash256, bool) {
 	// Handle edge cases
 	if len(txHashes) == 0 {
-		return ZeroHash // Return zero hash for empty input
+		return ZeroHash, false // Return zero hash for empty input
 	}
 
 	// For single transaction, merkle root equals the transaction hash
 	if len(txHashes) == 1 {
-		return txHashes[0]
+		return txHashes[0], false
 	}
 
-	// TDD REFACTOR: Implement proper merkle tree algorithm
-	// Make a copy to avoid modifying the original slice
+	// Make a copy in internal byte order to avoid modifying the original slice
 	hashes := make([]Hash256, len(txHashes))
-	copy(hashes, txHashes)
+	for i, hash := range txHashes {
+		hashes[i] = reverseHash(hash)
+	}
 
 	// Build the merkle tree level by level
+	mutated := false
 	for len(hashes) > 1 {
-		var nextLevel []Hash256
+		nextLevel := make([]Hash256, 0, (len(hashes)+1)/2)
 
 		// Process pairs of hashes
 		for i := 0; i < len(hashes); i += 2 {
-			var left, right Hash256
-			left = hashes[i]
+			left := hashes[i]
 
 			// If odd number of hashes, duplicate the last one (Bitcoin rule)
+			right := left
 			if i+1 < len(hashes) {
 				right = hashes[i+1]
-			} else {
-				right = hashes[i] // Duplicate last hash
+				if left == right {
+					mutated = true
+				}
 			}
 
 			// Combine the pair using double SHA-256
-			combined := doubleSHA256(left, right)
-			nextLevel = append(nextLevel, combined)
+			nextLevel = append(nextLevel, doubleSHA256(left, right))
 		}
 
 		hashes = nextLevel
 	}
 
-	// Return the final root hash
-	return hashes[0]
+	// Return the final root hash in display order
+	return reverseHash(hashes[0]), mutated
+}
+
+// CalculateWitnessMerkleRoot calculates the BIP141 witness merkle root over the
+// wtxids of a block's transactions. The coinbase wtxid is defined as zero.
+func CalculateWitnessMerkleRoot(transactions []Transaction) Hash256 {
+	wtxids := make([]Hash256, len(transactions))
+	for i := 1; i < len(transactions); i++ {
+		wtxids[i] = transactions[i].WitnessHash()
+	}
+	return CalculateMerkleRoot(wtxids)
+}
+
+// reverseHash converts a hash between display and internal byte order
+func reverseHash(h Hash256) Hash256 {
+	var reversed Hash256
+	for i := 0; i < 32; i++ {
+		reversed[i] = h[31-i]
+	}
+	return reversed
 }
 
 // doubleSHA256 performs Bitcoin's double SHA-256 hash on two concatenated hashes
@@ -66,3 +105,343 @@ func doubleSHA256(left, right Hash256) Hash256 {
 	copy(result[:], second[:])
 	return result
 }
+
+// MerkleBranch returns the sibling hashes linking the transaction at index to
+// the merkle root, ordered from the leaves upwards. Hashes are in display order.
+func MerkleBranch(txHashes []Hash256, index int) ([]Hash256, error) {
+	if index < 0 || index >= len(txHashes) {
+		return nil, fmt.Errorf("transaction index %d out of range", index)
+	}
+
+	hashes := make([]Hash256, len(txHashes))
+	for i, hash := range txHashes {
+		hashes[i] = reverseHash(hash)
+	}
+
+	var branch []Hash256
+	for len(hashes) > 1 {
+		// The last hash of an odd level is paired with itself
+		sibling := index ^ 1
+		if sibling >= len(hashes) {
+			sibling = index
+		}
+		branch = append(branch, reverseHash(hashes[sibling]))
+
+		nextLevel := make([]Hash256, 0, (len(hashes)+1)/2)
+		for i := 0; i < len(hashes); i += 2 {
+			right := hashes[i]
+			if i+1 < len(hashes) {
+				right = hashes[i+1]
+			}
+			nextLevel = append(nextLevel, doubleSHA256(hashes[i], right))
+		}
+
+		hashes = nextLevel
+		index >>= 1
+	}
+
+	return branch, nil
+}
+
+// VerifyMerkleBranch checks that a transaction hash at index is committed to by
+// root through the given branch, as produced by MerkleBranch
+func VerifyMerkleBranch(txHash Hash256, branch []Hash256, index int, root Hash256) bool {
+	if index < 0 || (len(branch) < 63 && index>>len(branch) != 0) {
+		return false
+	}
+
+	hash := reverseHash(txHash)
+	for _, sibling := range branch {
+		if index&1 == 1 {
+			hash = doubleSHA256(reverseHash(sibling), hash)
+		} else {
+			hash = doubleSHA256(hash, reverseHash(sibling))
+		}
+		index >>= 1
+	}
+
+	return reverseHash(hash) == root
+}
+
+// minTransactionWeight is the weight of the smallest possible transaction,
+// which bounds the number of transactions a partial merkle tree can claim
+const minTransactionWeight = WitnessScaleFactor * 60
+
+// PartialMerkleTree is the BIP37 compact proof that a subset of a block's
+// transactions is committed to by its merkle root. The tree is walked depth
+// first; each flag bit tells whether a node is an ancestor of a matched
+// transaction, and hashes are only included for the subtrees not descended into.
+type PartialMerkleTree struct {
+	TransactionCount uint32    // Total number of transactions in the block
+	Hashes           []Hash256 // Node hashes in depth-first order (display order)
+	Flags            []bool    // Node flag bits in depth-first order
+}
+
+// NewPartialMerkleTree builds a partial merkle tree for the transactions whose
+// matches entry is true
+func NewPartialMerkleTree(txHashes []Hash256, matches []bool) (*PartialMerkleTree, error) {
+	if len(txHashes) == 0 {
+		return nil, errors.New("cannot build partial merkle tree without transactions")
+	}
+	if len(matches) != len(txHashes) {
+		return nil, fmt.Errorf("match count %d does not equal transaction count %d", len(matches), len(txHashes))
+	}
+
+	leaves := make([]Hash256, len(txHashes))
+	for i, hash := range txHashes {
+		leaves[i] = reverseHash(hash)
+	}
+
+	pmt := &PartialMerkleTree{TransactionCount: uint32(len(txHashes))}
+	pmt.traverseAndBuild(pmt.height(), 0, leaves, matches)
+	return pmt, nil
+}
+
+// ExtractMatches verifies the tree structure and returns the merkle root it
+// commits to along with the matched transaction hashes and their positions
+// in the block. The caller must compare the root against the block header.
+func (pmt *PartialMerkleTree) ExtractMatches() (Hash256, []Hash256, []int, error) {
+	if pmt.TransactionCount == 0 {
+		return ZeroHash, nil, nil, errors.New("partial merkle tree has no transactions")
+	}
+	if pmt.TransactionCount > MaxBlockWeight/minTransactionWeight {
+		return ZeroHash, nil, nil, fmt.Errorf("partial merkle tree claims too many transactions: %d",
+			pmt.TransactionCount)
+	}
+	if len(pmt.Hashes) > int(pmt.TransactionCount) {
+		return ZeroHash, nil, nil, errors.New("partial merkle tree has more hashes than transactions")
+	}
+	if len(pmt.Flags) < len(pmt.Hashes) {
+		return ZeroHash, nil, nil, errors.New("partial merkle tree has fewer flag bits than hashes")
+	}
+
+	e := &merkleExtractor{pmt: pmt}
+	root, err := e.traverseAndExtract(pmt.height(), 0)
+	if err != nil {
+		return ZeroHash, nil, nil, err
+	}
+
+	// Every hash must be used, and every flag bit up to byte padding
+	if (e.bitsUsed+7)/8 != (len(pmt.Flags)+7)/8 {
+		return ZeroHash, nil, nil, errors.New("partial merkle tree has unused flag bits")
+	}
+	if e.hashesUsed != len(pmt.Hashes) {
+		return ZeroHash, nil, nil, errors.New("partial merkle tree has unused hashes")
+	}
+
+	return reverseHash(root), e.matches, e.indexes, nil
+}
+
+// height returns the height of the tree above the leaves
+func (pmt *PartialMerkleTree) height() int {
+	height := 0
+	for pmt.treeWidth(height) > 1 {
+		height++
+	}
+	return height
+}
+
+// treeWidth returns the number of nodes at the given height
+func (pmt *PartialMerkleTree) treeWidth(height int) int {
+	return int((uint64(pmt.TransactionCount) + (1 << height) - 1) >> height)
+}
+
+// calcHash computes the hash of a node from the leaves (internal byte order)
+func (pmt *PartialMerkleTree) calcHash(height, pos int, leaves []Hash256) Hash256 {
+	if height == 0 {
+		return leaves[pos]
+	}
+	left := pmt.calcHash(height-1, pos*2, leaves)
+	right := left
+	if pos*2+1 < pmt.treeWidth(height-1) {
+		right = pmt.calcHash(height-1, pos*2+1, leaves)
+	}
+	return doubleSHA256(left, right)
+}
+
+// traverseAndBuild appends the flag bits and hashes for the subtree at height and pos
+func (pmt *PartialMerkleTree) traverseAndBuild(height, pos int, leaves []Hash256, matches []bool) {
+	parentOfMatch := false
+	for p := pos << height; p < (pos+1)<<height && p < len(leaves); p++ {
+		parentOfMatch = parentOfMatch || matches[p]
+	}
+	pmt.Flags = append(pmt.Flags, parentOfMatch)
+
+	if height == 0 || !parentOfMatch {
+		pmt.Hashes = append(pmt.Hashes, reverseHash(pmt.calcHash(height, pos, leaves)))
+		return
+	}
+
+	pmt.traverseAndBuild(height-1, pos*2, leaves, matches)
+	if pos*2+1 < pmt.treeWidth(height-1) {
+		pmt.traverseAndBuild(height-1, pos*2+1, leaves, matches)
+	}
+}
+
+// merkleExtractor holds the traversal state of PartialMerkleTree.ExtractMatches
+type merkleExtractor struct {
+	pmt        *PartialMerkleTree
+	bitsUsed   int
+	hashesUsed int
+	matches    []Hash256
+	indexes    []int
+}
+
+// traverseAndExtract consumes the flag bits and hashes for the subtree at
+// height and pos and returns its hash (internal byte order)
+func (e *merkleExtractor) traverseAndExtract(height, pos int) (Hash256, error) {
+	if e.bitsUsed >= len(e.pmt.Flags) {
+		return ZeroHash, errors.New("partial merkle tree ran out of flag bits")
+	}
+	parentOfMatch := e.pmt.Flags[e.bitsUsed]
+	e.bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if e.hashesUsed >= len(e.pmt.Hashes) {
+			return ZeroHash, errors.New("partial merkle tree ran out of hashes")
+		}
+		hash := e.pmt.Hashes[e.hashesUsed]
+		e.hashesUsed++
+		if height == 0 && parentOfMatch {
+			e.matches = append(e.matches, hash)
+			e.indexes = append(e.indexes, pos)
+		}
+		return reverseHash(hash), nil
+	}
+
+	left, err := e.traverseAndExtract(height-1, pos*2)
+	if err != nil {
+		return ZeroHash, err
+	}
+	right := left
+	if pos*2+1 < e.pmt.treeWidth(height-1) {
+		if right, err = e.traverseAndExtract(height-1, pos*2+1); err != nil {
+			return ZeroHash, err
+		}
+		// Identical children would allow CVE-2012-2459 style forgeries
+		if right == left {
+			return ZeroHash, errors.New("partial merkle tree has identical sibling hashes")
+		}
+	}
+	return doubleSHA256(left, right), nil
+}
+
+// MerkleBlock is the payload of the BIP37 merkleblock message: a block header
+// and a partial merkle tree proving which transactions the block contains
+type MerkleBlock struct {
+	Header BlockHeader
+	Tree   PartialMerkleTree
+}
+
+// NewMerkleBlock builds a proof that the given transactions are included in
+// the block. Every txid must be in the block.
+func NewMerkleBlock(block *Block, txids []Hash256) (*MerkleBlock, error) {
+	wanted := make(map[Hash256]bool, len(txids))
+	for _, txid := range txids {
+		wanted[txid] = true
+	}
+
+	txHashes := block.transactionHashes()
+	matches := make([]bool, len(txHashes))
+	for i, hash := range txHashes {
+		if wanted[hash] {
+			matches[i] = true
+			delete(wanted, hash)
+		}
+	}
+	if len(wanted) > 0 {
+		return nil, errors.New("not all transactions found in block")
+	}
+
+	tree, err := NewPartialMerkleTree(txHashes, matches)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleBlock{Header: block.Header, Tree: *tree}, nil
+}
+
+// Verify checks the partial merkle tree against the header merkle root and
+// returns the transaction hashes it proves to be in the block
+func (mb *MerkleBlock) Verify() ([]Hash256, error) {
+	root, matches, _, err := mb.Tree.ExtractMatches()
+	if err != nil {
+		return nil, err
+	}
+	if root != mb.Header.MerkleRoot {
+		return nil, errors.New("partial merkle tree does not match header merkle root")
+	}
+	return matches, nil
+}
+
+// Serialize serializes the merkle block to the merkleblock wire format:
+// header, transaction count, hashes and the flag bits packed LSB first
+func (mb *MerkleBlock) Serialize() ([]byte, error) {
+	header, err := mb.Header.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	writeUint32LE(&buf, mb.Tree.TransactionCount)
+
+	buf.Write(EncodeVarInt(uint64(len(mb.Tree.Hashes))))
+	for _, hash := range mb.Tree.Hashes {
+		internal := reverseHash(hash)
+		buf.Write(internal[:])
+	}
+
+	flags := make([]byte, (len(mb.Tree.Flags)+7)/8)
+	for i, bit := range mb.Tree.Flags {
+		if bit {
+			flags[i/8] |= 1 << (i % 8)
+		}
+	}
+	buf.Write(EncodeVarInt(uint64(len(flags))))
+	buf.Write(flags)
+
+	return buf.Bytes(), nil
+}
+
+// DeserializeMerkleBlock reads a merkleblock payload from r. The proof is
+// not checked; call Verify before trusting the matched transactions.
+func DeserializeMerkleBlock(r io.Reader) (*MerkleBlock, error) {
+	header, err := DeserializeBlockHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var count [4]byte
+	if err := readFull(r, count[:], "transaction count"); err != nil {
+		return nil, err
+	}
+	mb := &MerkleBlock{Header: header}
+	mb.Tree.TransactionCount = binary.LittleEndian.Uint32(count[:])
+
+	hashCount, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hash count: %v", err)
+	}
+	if hashCount > MaxPayload/32 {
+		return nil, fmt.Errorf("hash count too large: %d", hashCount)
+	}
+	mb.Tree.Hashes = make([]Hash256, 0, preallocCount(hashCount))
+	for i := uint64(0); i < hashCount; i++ {
+		var hash Hash256
+		if err := readFull(r, hash[:], fmt.Sprintf("hash %d", i)); err != nil {
+			return nil, err
+		}
+		mb.Tree.Hashes = append(mb.Tree.Hashes, reverseHash(hash))
+	}
+
+	flags, err := readVarBytes(r, "flags")
+	if err != nil {
+		return nil, err
+	}
+	mb.Tree.Flags = make([]bool, len(flags)*8)
+	for i := range mb.Tree.Flags {
+		mb.Tree.Flags[i] = flags[i/8]&(1<<(i%8)) != 0
+	}
+
+	return mb, nil
+}
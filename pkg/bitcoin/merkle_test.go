@@ -1,6 +1,9 @@
 package bitcoin
 
 import (
+	"bytes"
+	"encoding/hex"
+	"strings"
 	"testing"
 )
 
@@ -153,3 +156,366 @@ func TestMerkleTree_EmptyInput(t *testing.T) {
 		})
 	}
 }
+
+// TestMerkleTree_MainnetBlocks tests merkle roots of real mainnet blocks
+func TestMerkleTree_MainnetBlocks(t *testing.T) {
+	tests := []struct {
+		name         string
+		txHashes     []string
+		expectedRoot string
+	}{
+		{
+			name: "Block 170",
+			txHashes: []string{
+				"b1fea52486ce0c62bb442b530a3f0132b826c74e473d1f2c220bfa78111c5082",
+				"f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16",
+			},
+			expectedRoot: "7dac2c5666815c17a3b36427de37bb9d2e2c5ccec3f8633eb91a4205cb4c10ff",
+		},
+		{
+			name: "Block 100000",
+			txHashes: []string{
+				"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+				"fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4",
+				"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+				"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+			},
+			expectedRoot: "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var txHashes []Hash256
+			for _, hexHash := range tt.txHashes {
+				hash, err := NewHash256FromString(hexHash)
+				if err != nil {
+					t.Fatalf("Failed to parse tx hash %s: %v", hexHash, err)
+				}
+				txHashes = append(txHashes, hash)
+			}
+
+			merkleRoot := CalculateMerkleRoot(txHashes)
+			if merkleRoot.String() != tt.expectedRoot {
+				t.Errorf("Expected merkle root %s, got %s", tt.expectedRoot, merkleRoot.String())
+			}
+		})
+	}
+}
+
+// TestCalculateMerkleRootMutated tests CVE-2012-2459 mutation detection
+func TestCalculateMerkleRootMutated(t *testing.T) {
+	hashes := make([]Hash256, 6)
+	for i := range hashes {
+		hashes[i] = Hash256{byte(i + 1)}
+	}
+	a, b, c, d, e, f := hashes[0], hashes[1], hashes[2], hashes[3], hashes[4], hashes[5]
+
+	tests := []struct {
+		name            string
+		original        []Hash256
+		mutated         []Hash256
+		expectMutated   bool
+		expectSameRoots bool
+	}{
+		{"duplicated last transaction", []Hash256{a, b, c}, []Hash256{a, b, c, c}, true, true},
+		{"duplicated last pair", []Hash256{a, b, c, d, e, f}, []Hash256{a, b, c, d, e, f, e, f}, true, true},
+		{"duplicate not in a pair", []Hash256{a, b, c}, []Hash256{a, b, b, c}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalRoot, originalMutated := CalculateMerkleRootMutated(tt.original)
+			if originalMutated {
+				t.Error("Expected original list not to be flagged as mutated")
+			}
+
+			mutatedRoot, mutated := CalculateMerkleRootMutated(tt.mutated)
+			if mutated != tt.expectMutated {
+				t.Errorf("Expected mutated %v, got %v", tt.expectMutated, mutated)
+			}
+			if (originalRoot == mutatedRoot) != tt.expectSameRoots {
+				t.Errorf("Expected same roots %v, got %s and %s", tt.expectSameRoots, originalRoot, mutatedRoot)
+			}
+		})
+	}
+}
+
+// testTxHashes returns n distinct transaction hashes
+func testTxHashes(n int) []Hash256 {
+	hashes := make([]Hash256, n)
+	for i := range hashes {
+		hashes[i] = DoubleHashSHA256([]byte{byte(i), byte(i >> 8)})
+	}
+	return hashes
+}
+
+// TestMerkleBranch tests branch generation and verification against block 100000
+func TestMerkleBranch(t *testing.T) {
+	txHashes := []Hash256{
+		mustParseHash("8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87"),
+		mustParseHash("fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4"),
+		mustParseHash("6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4"),
+		mustParseHash("e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"),
+	}
+	root := mustParseHash("f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766")
+
+	for i, txHash := range txHashes {
+		branch, err := MerkleBranch(txHashes, i)
+		if err != nil {
+			t.Fatalf("Unexpected error for index %d: %v", i, err)
+		}
+		if len(branch) != 2 {
+			t.Fatalf("Expected branch length 2, got %d", len(branch))
+		}
+		if branch[0] != txHashes[i^1] {
+			t.Errorf("Expected first sibling %s, got %s", txHashes[i^1], branch[0])
+		}
+		if !VerifyMerkleBranch(txHash, branch, i, root) {
+			t.Errorf("Branch for index %d failed verification", i)
+		}
+		if VerifyMerkleBranch(txHash, branch, i^1, root) {
+			t.Errorf("Branch for index %d verified at the wrong position", i)
+		}
+		if VerifyMerkleBranch(txHash, branch, i+4, root) {
+			t.Errorf("Branch for index %d verified with an out of range position", i)
+		}
+	}
+
+	// Odd sized levels pair the last hash with itself
+	for n := 1; n <= 9; n++ {
+		hashes := testTxHashes(n)
+		root := CalculateMerkleRoot(hashes)
+		for i := range hashes {
+			branch, err := MerkleBranch(hashes, i)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !VerifyMerkleBranch(hashes[i], branch, i, root) {
+				t.Errorf("%d transactions: branch for index %d failed verification", n, i)
+			}
+		}
+	}
+
+	if _, err := MerkleBranch(txHashes, 4); err == nil {
+		t.Error("Expected error for out of range index")
+	}
+	if _, err := MerkleBranch(nil, 0); err == nil {
+		t.Error("Expected error for empty transaction list")
+	}
+}
+
+// TestPartialMerkleTree tests building and extracting partial merkle trees
+func TestPartialMerkleTree(t *testing.T) {
+	patterns := []struct {
+		name  string
+		match func(i int) bool
+	}{
+		{"no matches", func(i int) bool { return false }},
+		{"all matches", func(i int) bool { return true }},
+		{"first only", func(i int) bool { return i == 0 }},
+		{"every third", func(i int) bool { return i%3 == 1 }},
+		{"sparse tail", func(i int) bool { return i == 8 || i == 100 }},
+	}
+
+	for _, n := range []int{1, 2, 3, 4, 7, 9, 17, 56, 101} {
+		hashes := testTxHashes(n)
+		root := CalculateMerkleRoot(hashes)
+
+		for _, pattern := range patterns {
+			matches := make([]bool, n)
+			var expected []int
+			for i := range matches {
+				matches[i] = pattern.match(i)
+				if matches[i] {
+					expected = append(expected, i)
+				}
+			}
+
+			pmt, err := NewPartialMerkleTree(hashes, matches)
+			if err != nil {
+				t.Fatalf("%d/%s: unexpected error: %v", n, pattern.name, err)
+			}
+
+			gotRoot, matched, indexes, err := pmt.ExtractMatches()
+			if err != nil {
+				t.Fatalf("%d/%s: failed to extract matches: %v", n, pattern.name, err)
+			}
+			if gotRoot != root {
+				t.Errorf("%d/%s: expected root %s, got %s", n, pattern.name, root, gotRoot)
+			}
+			if len(indexes) != len(expected) {
+				t.Fatalf("%d/%s: expected %d matches, got %d", n, pattern.name, len(expected), len(indexes))
+			}
+			for j, index := range indexes {
+				if index != expected[j] || matched[j] != hashes[index] {
+					t.Errorf("%d/%s: unexpected match %d at index %d", n, pattern.name, j, index)
+				}
+			}
+		}
+	}
+}
+
+// TestPartialMerkleTree_Invalid tests rejection of malformed partial merkle trees
+func TestPartialMerkleTree_Invalid(t *testing.T) {
+	hashes := testTxHashes(7)
+	matches := []bool{false, true, false, false, false, true, false}
+	valid, err := NewPartialMerkleTree(hashes, matches)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	clone := func() *PartialMerkleTree {
+		return &PartialMerkleTree{
+			TransactionCount: valid.TransactionCount,
+			Hashes:           append([]Hash256{}, valid.Hashes...),
+			Flags:            append([]bool{}, valid.Flags...),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(pmt *PartialMerkleTree)
+		errMsg string
+	}{
+		{"no transactions", func(pmt *PartialMerkleTree) { pmt.TransactionCount = 0 }, "no transactions"},
+		{"too many transactions", func(pmt *PartialMerkleTree) { pmt.TransactionCount = 1 << 20 }, "too many"},
+		{"more hashes than transactions", func(pmt *PartialMerkleTree) {
+			pmt.TransactionCount = 2
+		}, "more hashes than transactions"},
+		{"fewer bits than hashes", func(pmt *PartialMerkleTree) { pmt.Flags = pmt.Flags[:2] }, "fewer flag bits"},
+		{"missing hash", func(pmt *PartialMerkleTree) { pmt.Hashes = pmt.Hashes[:len(pmt.Hashes)-1] }, "ran out of hashes"},
+		{"extra hash", func(pmt *PartialMerkleTree) { pmt.Hashes = append(pmt.Hashes, ZeroHash) }, "unused hashes"},
+		{"extra flag byte", func(pmt *PartialMerkleTree) {
+			pmt.Flags = append(pmt.Flags, make([]bool, 8)...)
+		}, "unused flag bits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pmt := clone()
+			tt.mutate(pmt)
+			_, _, _, err := pmt.ExtractMatches()
+			if err == nil {
+				t.Fatal("Expected error, got none")
+			}
+			if !contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing '%s', got '%s'", tt.errMsg, err.Error())
+			}
+		})
+	}
+
+	// A tampered hash yields a different root
+	pmt := clone()
+	pmt.Hashes[0][0] ^= 0x01
+	root, _, _, err := pmt.ExtractMatches()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if root == CalculateMerkleRoot(hashes) {
+		t.Error("Expected tampered tree to produce a different root")
+	}
+}
+
+// TestPartialMerkleTree_DuplicateSiblings tests that CVE-2012-2459 style trees are rejected
+func TestPartialMerkleTree_DuplicateSiblings(t *testing.T) {
+	hashes := testTxHashes(3)
+	mutated := append(append([]Hash256{}, hashes...), hashes[2])
+
+	pmt, err := NewPartialMerkleTree(mutated, []bool{false, false, true, true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, _, err := pmt.ExtractMatches(); err == nil || !contains(err.Error(), "identical sibling") {
+		t.Errorf("Expected identical sibling error, got %v", err)
+	}
+}
+
+// TestMerkleBlock tests building, serializing and verifying merkleblock payloads
+func TestMerkleBlock(t *testing.T) {
+	block := createWitnessBlock()
+	block.Transactions = append(block.Transactions, Transaction{
+		Version:  1,
+		Inputs:   []TxInput{{PreviousOutput: OutPoint{Hash: Hash256{0x42}}, Sequence: 0xffffffff}},
+		Outputs:  []TxOutput{{Value: 1000, ScriptPubKey: []byte{0x51}}},
+		LockTime: 0,
+	})
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+
+	txid := block.Transactions[2].Hash()
+	mb, err := NewMerkleBlock(block, []Hash256{txid})
+	if err != nil {
+		t.Fatalf("Failed to build merkle block: %v", err)
+	}
+
+	data, err := mb.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize merkle block: %v", err)
+	}
+	parsed, err := DeserializeMerkleBlock(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to deserialize merkle block: %v", err)
+	}
+	reserialized, err := parsed.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to reserialize merkle block: %v", err)
+	}
+	if !bytes.Equal(data, reserialized) {
+		t.Errorf("Round trip mismatch:\n%s\n%s", hex.EncodeToString(data), hex.EncodeToString(reserialized))
+	}
+
+	matched, err := parsed.Verify()
+	if err != nil {
+		t.Fatalf("Failed to verify merkle block: %v", err)
+	}
+	if len(matched) != 1 || matched[0] != txid {
+		t.Errorf("Expected matched txid %s, got %v", txid, matched)
+	}
+
+	// Header commits to a different root
+	parsed.Header.MerkleRoot[0] ^= 0x01
+	if _, err := parsed.Verify(); err == nil {
+		t.Error("Expected error for merkle root mismatch")
+	}
+
+	if _, err := NewMerkleBlock(block, []Hash256{{0x99}}); err == nil {
+		t.Error("Expected error for transaction not in block")
+	}
+
+	// Truncated payloads
+	for _, size := range []int{0, 79, 83, 84, len(data) - 1} {
+		if _, err := DeserializeMerkleBlock(bytes.NewReader(data[:size])); err == nil {
+			t.Errorf("Expected error for %d byte payload", size)
+		}
+	}
+}
+
+// TestMerkleBlock_Mainnet tests parsing a merkleblock proof for mainnet block 100000
+func TestMerkleBlock_Mainnet(t *testing.T) {
+	// gettxoutproof for the second transaction of block 100000
+	proof := strings.Join([]string{
+		"0100000050120119172a610421a6c3011dd330d9df07b63616c2cc1f1cd0020000000000" +
+			"6657a9252aacd5c0b2940996ecff952228c3067cc38d4885efb5a4ac4247e9f3" +
+			"37221b4d4c86041b0f2b5710",
+		"04000000",
+		"03",
+		"876dd0a3ef4a2816ffd1c12ab649825a958b0ff3bb3d6f3e1250f13ddbf0148c",
+		"c40297f730dd7b5a99567eb8d27b78758f607507c52292d02d4031895b52f2ff",
+		"49aef42d78e3e9999c9e6ec9e1dddd6cb880bf3b076a03be1318ca789089308e",
+		"01",
+		"0b",
+	}, "")
+
+	mb, err := DeserializeMerkleBlock(bytes.NewReader(mustDecodeHex(proof)))
+	if err != nil {
+		t.Fatalf("Failed to parse proof: %v", err)
+	}
+	matched, err := mb.Verify()
+	if err != nil {
+		t.Fatalf("Failed to verify proof: %v", err)
+	}
+	expected := "fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4"
+	if len(matched) != 1 || matched[0].String() != expected {
+		t.Errorf("Expected matched txid %s, got %v", expected, matched)
+	}
+}
@@ -0,0 +1,368 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ChainParams holds the consensus rules and network settings of a Bitcoin network
+type ChainParams struct {
+	Name        string
+	Magic       uint32 // P2P message start bytes (little-endian)
+	DefaultPort uint16 // P2P listen port
+	RPCPort     uint16 // JSON-RPC listen port
+
+	GenesisBlock *Block
+	GenesisHash  Hash256
+
+	// Proof of work
+	PowLimit     *big.Int // Highest allowed target
+	PowLimitBits uint32   // PowLimit in compact form
+
+	// Difficulty retargeting
+	TargetTimespan           uint32 // Seconds per retarget period
+	TargetSpacing            uint32 // Seconds per block
+	RetargetAdjustmentFactor uint32 // Max factor the target may change by per period
+	ReduceMinDifficulty      bool   // Allow min-difficulty blocks after MinDiffReductionTime
+	MinDiffReductionTime     uint32 // Seconds without a block before min difficulty applies
+	EnforceBIP94             bool   // Timewarp fix and retarget from the period's first block
+	NoRetargeting            bool   // Keep the genesis target forever
+
+	// Block subsidy
+	SubsidyHalvingInterval int32
+
+	// Heights at which buried soft forks are enforced
+	BIP34Height  int32 // Coinbase must push the block height
+	BIP65Height  int32 // OP_CHECKLOCKTIMEVERIFY
+	BIP66Height  int32 // Strict DER signatures
+	CSVHeight    int32 // OP_CHECKSEQUENCEVERIFY, BIP68 sequence locks and BIP113
+	SegwitHeight int32 // Segregated witness
+
+	// Block at BIP34Height on the chain where BIP34 makes BIP30 checks
+	// redundant, or zero to always check BIP30
+	BIP34Hash Hash256
+
+	// Historical blocks whose coinbase overwrote an unspent coinbase before
+	// BIP30, by height
+	BIP30Exceptions map[int32]Hash256
+
+	// Historical blocks validated with other script flags than their height implies
+	ScriptFlagExceptions map[Hash256]ScriptFlags
+
+	// DNS seeds used to discover peers
+	DNSSeeds []string
+}
+
+// DifficultyAdjustmentInterval returns the number of blocks between retargets
+func (p *ChainParams) DifficultyAdjustmentInterval() int32 {
+	return int32(p.TargetTimespan / p.TargetSpacing)
+}
+
+// IsSegwitActive reports whether segregated witness rules apply at a height
+func (p *ChainParams) IsSegwitActive(height int32) bool {
+	return height >= p.SegwitHeight
+}
+
+// BlockScriptFlags returns the script verification flags for the inputs of
+// the block with the given height and hash. P2SH and segwit rules apply to
+// every block except the listed exceptions; the other buried soft forks apply
+// from their activation height. Taproot is not enforced until BIP341/342 are
+// implemented, so witness v1 outputs are anyone-can-spend as they were before
+// its activation.
+func (p *ChainParams) BlockScriptFlags(height int32, hash Hash256) ScriptFlags {
+	flags := ScriptVerifyP2SH | ScriptVerifyWitness
+	if exception, ok := p.ScriptFlagExceptions[hash]; ok {
+		flags = exception
+	}
+
+	if height >= p.BIP66Height {
+		flags |= ScriptVerifyDERSig
+	}
+	if height >= p.BIP65Height {
+		flags |= ScriptVerifyCheckLockTimeVerify
+	}
+	if height >= p.CSVHeight {
+		flags |= ScriptVerifyCheckSequenceVerify
+	}
+	if p.IsSegwitActive(height) {
+		flags |= ScriptVerifyNullDummy
+	}
+	return flags
+}
+
+// Retarget parameters shared by every network
+const (
+	targetTimespan           = 14 * 24 * 60 * 60 // Two weeks
+	targetSpacing            = 10 * 60           // Ten minutes
+	retargetAdjustmentFactor = 4
+)
+
+// genesisCoinbaseMessage is the headline embedded in the original genesis coinbase
+const genesisCoinbaseMessage = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+
+// testnet4CoinbaseMessage is the block hash committed to by the testnet4 genesis coinbase
+const testnet4CoinbaseMessage = "03/May/2024 000000000000000000001ebd58c244970b3aa9d783bb001011fbe8ea8e98e00e"
+
+// genesisOutputScript pays the genesis reward to Satoshi's public key
+var genesisOutputScript = mustDecodeScriptHex("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb6" +
+	"49f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+
+// testnet4OutputScript pays the testnet4 genesis reward to an unspendable all-zero key
+var testnet4OutputScript = mustDecodeScriptHex("21000000000000000000000000000000000000000000000000000000000000000000ac")
+
+// MainNetParams are the parameters of the Bitcoin main network
+var MainNetParams = ChainParams{
+	Name:        "mainnet",
+	Magic:       MagicMainnet,
+	DefaultPort: 8333,
+	RPCPort:     8332,
+
+	GenesisBlock: newGenesisBlock(genesisCoinbaseMessage, genesisOutputScript, 1231006505, 0x1d00ffff, 2083236893),
+	GenesisHash:  mustParseHashHex("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
+
+	PowLimit:     mustParseBigHex("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	PowLimitBits: 0x1d00ffff,
+
+	TargetTimespan:           targetTimespan,
+	TargetSpacing:            targetSpacing,
+	RetargetAdjustmentFactor: retargetAdjustmentFactor,
+
+	SubsidyHalvingInterval: 210000,
+
+	BIP34Height:  227931,
+	BIP65Height:  388381,
+	BIP66Height:  363725,
+	CSVHeight:    419328,
+	SegwitHeight: 481824,
+
+	BIP34Hash: mustParseHashHex("000000000000024b89b42a942fe0d9fea3bb44ab7bd1b19115dd6a759c0808b8"),
+
+	BIP30Exceptions: map[int32]Hash256{
+		91842: mustParseHashHex("00000000000a4d0a398161ffc163c503763b1f4360639393e0e4c8e300e0caec"),
+		91880: mustParseHashHex("00000000000743f190a18c5577a3c2d2a1f610ae9601ac046a38084ccb7cd721"),
+	},
+
+	ScriptFlagExceptions: map[Hash256]ScriptFlags{
+		// Block 170060 spends a P2SH output invalidly, before BIP16 was enforced
+		mustParseHashHex("00000000000002dc756eebf4f49723ed8d30cc28a5f108eb94b1ba88ac4f9c22"): ScriptFlagsNone,
+		// Block 692261 spends a witness v1 output invalidly, before taproot was enforced
+		mustParseHashHex("0000000000000000000f14c35b2d841e986ab5441de8c585d5ffe55ea1e395ad"): ScriptVerifyP2SH | ScriptVerifyWitness,
+	},
+
+	DNSSeeds: []string{
+		"seed.bitcoin.sipa.be",
+		"dnsseed.bluematt.me",
+		"seed.bitcoinstats.com",
+		"seed.bitcoin.jonasschnelli.ch",
+		"seed.btc.petertodd.net",
+		"seed.bitcoin.sprovoost.nl",
+		"dnsseed.emzy.de",
+		"seed.bitcoin.wiz.biz",
+	},
+}
+
+// TestNet3Params are the parameters of the version 3 test network
+var TestNet3Params = ChainParams{
+	Name:        "testnet3",
+	Magic:       MagicTestnet3,
+	DefaultPort: 18333,
+	RPCPort:     18332,
+
+	GenesisBlock: newGenesisBlock(genesisCoinbaseMessage, genesisOutputScript, 1296688602, 0x1d00ffff, 414098458),
+	GenesisHash:  mustParseHashHex("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"),
+
+	PowLimit:     mustParseBigHex("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	PowLimitBits: 0x1d00ffff,
+
+	TargetTimespan:           targetTimespan,
+	TargetSpacing:            targetSpacing,
+	RetargetAdjustmentFactor: retargetAdjustmentFactor,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     targetSpacing * 2,
+
+	SubsidyHalvingInterval: 210000,
+
+	BIP34Height:  21111,
+	BIP65Height:  581885,
+	BIP66Height:  330776,
+	CSVHeight:    770112,
+	SegwitHeight: 834624,
+
+	BIP34Hash: mustParseHashHex("0000000023b3a96d3484e5abb3755c413e7d41500f8e2a5c3f0dd01299cd8ef8"),
+
+	DNSSeeds: []string{
+		"testnet-seed.bitcoin.jonasschnelli.ch",
+		"seed.tbtc.petertodd.net",
+		"seed.testnet.bitcoin.sprovoost.nl",
+		"testnet-seed.bluematt.me",
+	},
+}
+
+// TestNet4Params are the parameters of the BIP94 version 4 test network
+var TestNet4Params = ChainParams{
+	Name:        "testnet4",
+	Magic:       MagicTestnet4,
+	DefaultPort: 48333,
+	RPCPort:     48332,
+
+	GenesisBlock: newGenesisBlock(testnet4CoinbaseMessage, testnet4OutputScript, 1714777860, 0x1d00ffff, 393743547),
+	GenesisHash:  mustParseHashHex("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043"),
+
+	PowLimit:     mustParseBigHex("00000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	PowLimitBits: 0x1d00ffff,
+
+	TargetTimespan:           targetTimespan,
+	TargetSpacing:            targetSpacing,
+	RetargetAdjustmentFactor: retargetAdjustmentFactor,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     targetSpacing * 2,
+	EnforceBIP94:             true,
+
+	SubsidyHalvingInterval: 210000,
+
+	BIP34Height:  1,
+	BIP65Height:  1,
+	BIP66Height:  1,
+	CSVHeight:    1,
+	SegwitHeight: 1,
+
+	DNSSeeds: []string{
+		"seed.testnet4.bitcoin.sprovoost.nl",
+		"seed.testnet4.wiz.biz",
+	},
+}
+
+// SigNetParams are the parameters of the default BIP325 signet. Signet
+// validation is incomplete: the block solution proving a block was signed
+// by the network's challenge is not checked, so any block meeting the proof
+// of work is accepted.
+var SigNetParams = ChainParams{
+	Name:        "signet",
+	Magic:       MagicSignet,
+	DefaultPort: 38333,
+	RPCPort:     38332,
+
+	GenesisBlock: newGenesisBlock(genesisCoinbaseMessage, genesisOutputScript, 1598918400, 0x1e0377ae, 52613770),
+	GenesisHash:  mustParseHashHex("00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"),
+
+	PowLimit:     mustParseBigHex("00000377ae000000000000000000000000000000000000000000000000000000"),
+	PowLimitBits: 0x1e0377ae,
+
+	TargetTimespan:           targetTimespan,
+	TargetSpacing:            targetSpacing,
+	RetargetAdjustmentFactor: retargetAdjustmentFactor,
+
+	SubsidyHalvingInterval: 210000,
+
+	BIP34Height:  1,
+	BIP65Height:  1,
+	BIP66Height:  1,
+	CSVHeight:    1,
+	SegwitHeight: 1,
+
+	DNSSeeds: []string{
+		"seed.signet.bitcoin.sprovoost.nl",
+	},
+}
+
+// RegTestParams are the parameters of the local regression test network.
+// Blocks can be mined instantly and the subsidy halves every 150 blocks.
+var RegTestParams = ChainParams{
+	Name:        "regtest",
+	Magic:       MagicRegtest,
+	DefaultPort: 18444,
+	RPCPort:     18443,
+
+	GenesisBlock: newGenesisBlock(genesisCoinbaseMessage, genesisOutputScript, 1296688602, 0x207fffff, 2),
+	GenesisHash:  mustParseHashHex("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"),
+
+	PowLimit:     mustParseBigHex("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	PowLimitBits: 0x207fffff,
+
+	TargetTimespan:           targetTimespan,
+	TargetSpacing:            targetSpacing,
+	RetargetAdjustmentFactor: retargetAdjustmentFactor,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     targetSpacing * 2,
+	NoRetargeting:            true,
+
+	SubsidyHalvingInterval: 150,
+
+	BIP34Height:  1,
+	BIP65Height:  1,
+	BIP66Height:  1,
+	CSVHeight:    1,
+	SegwitHeight: 0,
+}
+
+// ParamsForNetwork returns the chain parameters for a network name
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	switch strings.ToLower(name) {
+	case "mainnet", "main":
+		return &MainNetParams, nil
+	case "testnet3", "testnet", "test":
+		return &TestNet3Params, nil
+	case "testnet4":
+		return &TestNet4Params, nil
+	case "signet":
+		return &SigNetParams, nil
+	case "regtest":
+		return &RegTestParams, nil
+	default:
+		return nil, fmt.Errorf("unknown network: %s", name)
+	}
+}
+
+// newGenesisBlock builds a genesis block the way Bitcoin Core does: a single
+// coinbase paying 50 BTC whose scriptSig pushes the compact bits 0x1d00ffff,
+// the number 4 and the message
+func newGenesisBlock(message string, outputScript []byte, timestamp, bits, nonce uint32) *Block {
+	scriptSig := []byte{0x04, 0xff, 0xff, 0x00, 0x1d, 0x01, 0x04}
+	if len(message) > 75 {
+		scriptSig = append(scriptSig, byte(OP_PUSHDATA1))
+	}
+	scriptSig = append(scriptSig, byte(len(message)))
+	scriptSig = append(scriptSig, message...)
+
+	coinbase := NewTransaction(1,
+		[]TxInput{{
+			PreviousOutput: OutPoint{Hash: ZeroHash, Index: 0xffffffff},
+			ScriptSig:      scriptSig,
+			Sequence:       0xffffffff,
+		}},
+		[]TxOutput{{Value: 50 * 100000000, ScriptPubKey: outputScript}},
+		0)
+
+	merkleRoot := CalculateMerkleRoot([]Hash256{coinbase.Hash()})
+	header := NewBlockHeader(1, ZeroHash, merkleRoot, timestamp, bits, nonce)
+	return NewBlock(header, []Transaction{*coinbase})
+}
+
+// mustDecodeScriptHex decodes a hex constant, panicking on malformed input
+func mustDecodeScriptHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// mustParseHashHex parses a display-order hash constant, panicking on malformed input
+func mustParseHashHex(s string) Hash256 {
+	hash, err := NewHash256FromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// mustParseBigHex parses a big-endian hex constant, panicking on malformed input
+func mustParseBigHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex integer: " + s)
+	}
+	return n
+}
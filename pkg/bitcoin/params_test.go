@@ -0,0 +1,134 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestChainParams_Genesis tests that every network's genesis block hashes to the known value
+func TestChainParams_Genesis(t *testing.T) {
+	tests := []struct {
+		params       *ChainParams
+		expectedRoot string
+	}{
+		{&MainNetParams, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"},
+		{&TestNet3Params, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"},
+		{&TestNet4Params, "7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e"},
+		{&SigNetParams, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"},
+		{&RegTestParams, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.params.Name, func(t *testing.T) {
+			genesis := tt.params.GenesisBlock
+			if genesis.Hash() != tt.params.GenesisHash {
+				t.Errorf("Expected genesis hash %s, got %s", tt.params.GenesisHash, genesis.Hash())
+			}
+			if genesis.Header.MerkleRoot.String() != tt.expectedRoot {
+				t.Errorf("Expected merkle root %s, got %s", tt.expectedRoot, genesis.Header.MerkleRoot)
+			}
+			if err := genesis.CheckMerkleRoot(); err != nil {
+				t.Errorf("Genesis merkle root check failed: %v", err)
+			}
+			if genesis.Header.Bits != tt.params.PowLimitBits {
+				t.Errorf("Expected genesis bits 0x%08x, got 0x%08x", tt.params.PowLimitBits, genesis.Header.Bits)
+			}
+			if !ValidateProofOfWork(genesis.Hash(), genesis.Header.Bits) {
+				t.Error("Genesis block does not satisfy its own proof of work")
+			}
+			if BigTargetToCompact(tt.params.PowLimit) != tt.params.PowLimitBits {
+				t.Errorf("Pow limit does not match compact bits 0x%08x", tt.params.PowLimitBits)
+			}
+			if tt.params.DifficultyAdjustmentInterval() != 2016 {
+				t.Errorf("Expected retarget interval 2016, got %d", tt.params.DifficultyAdjustmentInterval())
+			}
+		})
+	}
+
+	// The mainnet genesis block must serialize byte for byte
+	serialized, err := MainNetParams.GenesisBlock.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize genesis block: %v", err)
+	}
+	if !bytes.Equal(serialized, mustDecodeHex(genesisBlockHex)) {
+		t.Errorf("Genesis block serialization mismatch: %s", hex.EncodeToString(serialized))
+	}
+}
+
+// TestParamsForNetwork tests network name lookup
+func TestParamsForNetwork(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected *ChainParams
+	}{
+		{"mainnet", &MainNetParams},
+		{"main", &MainNetParams},
+		{"testnet", &TestNet3Params},
+		{"testnet3", &TestNet3Params},
+		{"testnet4", &TestNet4Params},
+		{"signet", &SigNetParams},
+		{"RegTest", &RegTestParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := ParamsForNetwork(tt.name)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if params != tt.expected {
+				t.Errorf("Expected %s params, got %s", tt.expected.Name, params.Name)
+			}
+		})
+	}
+
+	if _, err := ParamsForNetwork("litecoin"); err == nil {
+		t.Error("Expected error for unknown network")
+	}
+}
+
+// TestBlockChain_WithParams tests that a chain follows its network's rules
+func TestBlockChain_WithParams(t *testing.T) {
+	blockchain := NewBlockChainWithParams(&RegTestParams, RegTestParams.GenesisBlock)
+	if blockchain.Params() != &RegTestParams {
+		t.Error("Expected regtest params")
+	}
+	if blockchain.GetTip().Hash() != RegTestParams.GenesisHash {
+		t.Errorf("Expected regtest genesis tip, got %s", blockchain.GetTip().Hash())
+	}
+
+	if NewBlockChain(nil).Params() != &MainNetParams {
+		t.Error("Expected NewBlockChain to default to mainnet")
+	}
+}
+
+// TestP2PMessage_WithParams tests that messages are framed with the network magic
+func TestP2PMessage_WithParams(t *testing.T) {
+	msg := NewP2PMessageWithParams(&RegTestParams, "ping", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	data := msg.Serialize()
+	if !bytes.Equal(data[:4], []byte{0xfa, 0xbf, 0xb5, 0xda}) {
+		t.Errorf("Expected regtest magic, got %x", data[:4])
+	}
+
+	parsed, err := DeserializeP2PMessageWithParams(&RegTestParams, data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize regtest message: %v", err)
+	}
+	if parsed.Magic() != MagicRegtest || parsed.Command() != "ping" {
+		t.Errorf("Unexpected message: magic 0x%08x, command %s", parsed.Magic(), parsed.Command())
+	}
+
+	// Messages from another network are rejected
+	if _, err := DeserializeP2PMessage(data); err == nil {
+		t.Error("Expected mainnet deserialization of a regtest message to fail")
+	}
+	if _, err := DeserializeP2PMessageWithParams(&TestNet4Params, data); err == nil {
+		t.Error("Expected testnet4 deserialization of a regtest message to fail")
+	}
+
+	mainnet := NewP2PMessage("ping", nil).Serialize()
+	if !bytes.Equal(mainnet[:4], []byte{0xf9, 0xbe, 0xb4, 0xd9}) {
+		t.Errorf("Expected mainnet magic, got %x", mainnet[:4])
+	}
+}
@@ -1,6 +1,8 @@
 package bitcoin
 
 import (
+	"errors"
+	"fmt"
 	"math/big"
 )
 
@@ -18,6 +20,19 @@ func ValidateProofOfWork(blockHash Hash256, targetBits uint32) bool {
 	return hashInt.Cmp(target) <= 0
 }
 
+// CheckProofOfWork checks that a header's target is positive and within the
+// network's pow limit, and that the header hash meets it
+func CheckProofOfWork(header *BlockHeader, params *ChainParams) error {
+	target := CompactToBigTarget(header.Bits)
+	if header.Bits&0x00800000 != 0 || target.Sign() <= 0 || target.Cmp(params.PowLimit) > 0 {
+		return fmt.Errorf("target 0x%08x out of range", header.Bits)
+	}
+	if !ValidateProofOfWork(header.Hash(), header.Bits) {
+		return errors.New("invalid proof of work")
+	}
+	return nil
+}
+
 // CompactToBigTarget converts compact target representation to big.Int
 // TDD REFACTOR: Enhanced implementation with proper Bitcoin format handling
 func CompactToBigTarget(compactBits uint32) *big.Int {
@@ -72,45 +87,95 @@ func BigTargetToHash256(target *big.Int) Hash256 {
 // AdjustDifficulty calculates new difficulty target based on time taken
 // TDD REFACTOR: Complete Bitcoin difficulty adjustment algorithm
 func AdjustDifficulty(currentTargetBits, actualTimeSeconds uint32) uint32 {
-	// Bitcoin difficulty adjustment constants
-	const targetTimespan = 14 * 24 * 60 * 60 // 2 weeks in seconds
-	const maxAdjustment = 4                  // Max 4x adjustment up or down
-
 	// Handle edge case
 	if actualTimeSeconds == 0 {
 		return currentTargetBits
 	}
 
 	// If time is exactly 2 weeks, no adjustment needed
-	if actualTimeSeconds == targetTimespan {
+	if actualTimeSeconds == MainNetParams.TargetTimespan {
 		return currentTargetBits
 	}
 
 	// Convert current target to big.Int for calculation
 	currentTarget := CompactToBigTarget(currentTargetBits)
 
-	// Calculate adjustment ratio: actualTime / targetTime
-	// newTarget = currentTarget * actualTime / targetTime
-	actualTime := big.NewInt(int64(actualTimeSeconds))
-	targetTime := big.NewInt(targetTimespan)
+	// Calculate new target: currentTarget * actualTime / targetTime
+	newTarget := retarget(currentTarget, int64(actualTimeSeconds), &MainNetParams)
+
+	// Convert back to compact representation
+	return BigTargetToCompact(newTarget)
+}
+
+// retarget scales a target by actualTimespan / TargetTimespan, limiting the
+// change to RetargetAdjustmentFactor in either direction
+func retarget(target *big.Int, actualTimespan int64, params *ChainParams) *big.Int {
+	targetTimespan := int64(params.TargetTimespan)
+	maxAdjustment := int64(params.RetargetAdjustmentFactor)
 
 	// Apply adjustment limits (max 4x up or down)
-	maxTime := big.NewInt(targetTimespan * maxAdjustment)
-	minTime := big.NewInt(targetTimespan / maxAdjustment)
+	if actualTimespan < targetTimespan/maxAdjustment {
+		actualTimespan = targetTimespan / maxAdjustment
+	}
+	if actualTimespan > targetTimespan*maxAdjustment {
+		actualTimespan = targetTimespan * maxAdjustment
+	}
+
+	newTarget := new(big.Int).Mul(target, big.NewInt(actualTimespan))
+	return newTarget.Div(newTarget, big.NewInt(targetTimespan))
+}
+
+// maxTimewarp is how many seconds BIP94 lets the first block of a retarget
+// period predate its parent
+const maxTimewarp = 600
+
+// calcNextRequiredDifficulty returns the bits a block with the given timestamp
+// must carry to follow last, as Bitcoin Core's GetNextWorkRequired
+func calcNextRequiredDifficulty(params *ChainParams, last *blockNode, timestamp uint32) uint32 {
+	if last == nil {
+		return params.PowLimitBits
+	}
+
+	interval := params.DifficultyAdjustmentInterval()
+	if (last.height+1)%interval != 0 {
+		if !params.ReduceMinDifficulty {
+			return last.header.Bits
+		}
+
+		// Test networks allow a minimum difficulty block once no block has
+		// been found for twice the target spacing
+		if int64(timestamp) > int64(last.header.Timestamp)+int64(params.MinDiffReductionTime) {
+			return params.PowLimitBits
+		}
 
-	if actualTime.Cmp(maxTime) > 0 {
-		actualTime = maxTime
+		// Otherwise return to the difficulty of the last regular block
+		node := last
+		for node.parent != nil && node.height%interval != 0 && node.header.Bits == params.PowLimitBits {
+			node = node.parent
+		}
+		return node.header.Bits
 	}
-	if actualTime.Cmp(minTime) < 0 {
-		actualTime = minTime
+
+	if params.NoRetargeting {
+		return last.header.Bits
 	}
 
-	// Calculate new target: currentTarget * actualTime / targetTime
-	newTarget := new(big.Int)
-	newTarget.Mul(currentTarget, actualTime)
-	newTarget.Div(newTarget, targetTime)
+	// The window covers 2016 blocks but measures only the 2015 intervals
+	// between them. This off-by-one is part of consensus and must be kept.
+	first := last.ancestor(last.height - (interval - 1))
+	actualTimespan := int64(last.header.Timestamp) - int64(first.header.Timestamp)
 
-	// Convert back to compact representation
+	// BIP94 scales the first block's target, which the min difficulty
+	// exception cannot have lowered, instead of the last one's
+	baseBits := last.header.Bits
+	if params.EnforceBIP94 {
+		baseBits = first.header.Bits
+	}
+
+	newTarget := retarget(CompactToBigTarget(baseBits), actualTimespan, params)
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget = params.PowLimit
+	}
 	return BigTargetToCompact(newTarget)
 }
 
@@ -155,3 +220,21 @@ func BigTargetToCompact(target *big.Int) uint32 {
 
 	return compact
 }
+
+// CalcWork returns the expected number of hashes needed to find a block with
+// the given compact target, 2^256 / (target + 1). Chains are compared by the
+// sum of this value over their blocks rather than by their length.
+func CalcWork(bits uint32) *big.Int {
+	// Negative targets carry no work
+	if bits&0x00800000 != 0 {
+		return big.NewInt(0)
+	}
+
+	target := CompactToBigTarget(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
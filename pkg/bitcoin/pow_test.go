@@ -1,6 +1,7 @@
 package bitcoin
 
 import (
+	"math"
 	"math/big"
 	"testing"
 )
@@ -460,3 +461,161 @@ func TestBigTargetToCompact_EdgeCases(t *testing.T) {
 		})
 	}
 }
+
+// TestCalcWork tests the expected hash count for compact targets
+func TestCalcWork(t *testing.T) {
+	tests := []struct {
+		name     string
+		bits     uint32
+		expected string
+	}{
+		{"mainnet pow limit", 0x1d00ffff, "4295032833"},
+		{"regtest pow limit", 0x207fffff, "2"},
+		{"block 100000", 0x1b04864c, "62209952899966"},
+		{"zero target", 0x00000000, "0"},
+		{"negative target", 0x1d80ffff, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work := CalcWork(tt.bits)
+			if work.String() != tt.expected {
+				t.Errorf("Expected work %s, got %s", tt.expected, work.String())
+			}
+		})
+	}
+}
+
+// TestCheckProofOfWork tests header proof of work against network pow limits
+func TestCheckProofOfWork(t *testing.T) {
+	genesis := MainNetParams.GenesisBlock.Header
+	if err := CheckProofOfWork(&genesis, &MainNetParams); err != nil {
+		t.Errorf("Expected mainnet genesis to pass, got %v", err)
+	}
+
+	regtestGenesis := RegTestParams.GenesisBlock.Header
+	if err := CheckProofOfWork(&regtestGenesis, &RegTestParams); err != nil {
+		t.Errorf("Expected regtest genesis to pass on regtest, got %v", err)
+	}
+	if err := CheckProofOfWork(&regtestGenesis, &MainNetParams); err == nil || !contains(err.Error(), "out of range") {
+		t.Errorf("Expected regtest target to exceed the mainnet pow limit, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		bits   uint32
+		errMsg string
+	}{
+		{"zero target", 0x00000000, "out of range"},
+		{"negative target", 0x1d80ffff, "out of range"},
+		{"overflowing target", 0x2300ffff, "out of range"},
+		{"hash above target", 0x1d00ffff, "invalid proof of work"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := NewBlockHeader(1, ZeroHash, ZeroHash, 1296688602, tt.bits, 3)
+			err := CheckProofOfWork(&header, &RegTestParams)
+			if err == nil || !contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing '%s', got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+// solveBlock searches the nonce space, starting from zero, for a header hash
+// that meets the block's target and reports whether one was found. It is only
+// practical at regtest difficulty, where it is used to mine blocks for tests.
+func solveBlock(block *Block) bool {
+	for nonce := uint32(0); ; nonce++ {
+		block.Header.Nonce = nonce
+		block.Header.hash = nil
+		block.hash = nil
+		if ValidateProofOfWork(block.Hash(), block.Header.Bits) {
+			return true
+		}
+		if nonce == math.MaxUint32 {
+			return false
+		}
+	}
+}
+
+// TestSolveBlock tests mining blocks at regtest difficulty
+func TestSolveBlock(t *testing.T) {
+	for _, bits := range []uint32{0x207fffff, 0x2000ffff} {
+		header := NewBlockHeader(1, RegTestParams.GenesisHash, ZeroHash, 1296689202, bits, 0)
+		block := NewBlock(header, nil)
+		staleHash := block.Hash()
+
+		if !solveBlock(block) {
+			t.Fatalf("Failed to solve block with bits 0x%08x", bits)
+		}
+		if err := CheckProofOfWork(&block.Header, &RegTestParams); err != nil {
+			t.Errorf("Solved block failed proof of work: %v", err)
+		}
+		if block.Header.Nonce != 0 && block.Hash() == staleHash {
+			t.Error("Expected the cached block hash to be refreshed")
+		}
+	}
+}
+
+// buildTestNodes links count index nodes from height 0, taking each header
+// from the callback, and returns the last one
+func buildTestNodes(count int32, header func(height int32) BlockHeader) *blockNode {
+	var last *blockNode
+	for height := int32(0); height < count; height++ {
+		last = &blockNode{header: header(height), parent: last, height: height}
+	}
+	return last
+}
+
+// TestCalcNextRequiredDifficulty tests retargeting and the test network difficulty rules
+func TestCalcNextRequiredDifficulty(t *testing.T) {
+	const start = 1261130161
+
+	// Mainnet blocks 30240 to 32255, retargeted to 0x1d00d86a at 32256. The
+	// timespan is measured from the first to the last block of the period.
+	evenly := func(end uint32, bits uint32) func(int32) BlockHeader {
+		return func(height int32) BlockHeader {
+			timestamp := start + uint32(int64(end-start)*int64(height)/2015)
+			return BlockHeader{Timestamp: timestamp, Bits: bits}
+		}
+	}
+
+	minDifficulty := TestNet3Params.PowLimitBits
+	testnetPeriod := func(height int32) BlockHeader {
+		header := BlockHeader{Timestamp: start + uint32(height)*600, Bits: 0x1c00ffff}
+		if height > 2010 {
+			header.Bits = minDifficulty
+		}
+		return header
+	}
+
+	tests := []struct {
+		name      string
+		params    *ChainParams
+		last      *blockNode
+		timestamp uint32
+		expected  uint32
+	}{
+		{"mainnet block 32256", &MainNetParams, buildTestNodes(2016, evenly(1262152739, 0x1d00ffff)), 1262153464, 0x1d00d86a},
+		{"between retargets", &MainNetParams, buildTestNodes(2000, evenly(1262152739, 0x1c0ffff0)), 1262153464, 0x1c0ffff0},
+		{"fast period clamped", &MainNetParams, buildTestNodes(2016, evenly(start+2015, 0x1c0ffff0)), start + 2016, 0x1c03fffc},
+		{"slow period clamped to pow limit", &MainNetParams, buildTestNodes(2016, evenly(start+2015*6000, 0x1d00ffff)), start + 2016*6000, 0x1d00ffff},
+		{"no retargeting", &RegTestParams, buildTestNodes(2016, evenly(start+2015, 0x207fffff)), start + 2016, 0x207fffff},
+		{"testnet block after 20 minutes", &TestNet3Params, buildTestNodes(2015, testnetPeriod), start + 2014*600 + 1201, minDifficulty},
+		{"testnet block within 20 minutes", &TestNet3Params, buildTestNodes(2015, testnetPeriod), start + 2014*600 + 1200, 0x1c00ffff},
+		{"testnet retarget from min difficulty", &TestNet3Params, buildTestNodes(2016, testnetPeriod), start + 2015*600 + 600, 0x1d00ffde},
+		{"testnet4 retarget from first block", &TestNet4Params, buildTestNodes(2016, testnetPeriod), start + 2015*600 + 600, 0x1c00ffde},
+		{"genesis", &MainNetParams, nil, start, 0x1d00ffff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bits := calcNextRequiredDifficulty(tt.params, tt.last, tt.timestamp)
+			if bits != tt.expected {
+				t.Errorf("Expected bits 0x%08x, got 0x%08x", tt.expected, bits)
+			}
+		})
+	}
+}
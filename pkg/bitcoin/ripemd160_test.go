@@ -0,0 +1,35 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// TestRIPEMD160 tests RIPEMD-160 against the reference test vectors
+func TestRIPEMD160(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"empty", "", "9c1185a5c5e9fc54612808977ee8f548b2258d31"},
+		{"single character", "a", "0bdc9d2d256b3ee9daae347be6f4dc835a467ffe"},
+		{"abc", "abc", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"message digest", "message digest", "5d0689ef49d2fae572b881b123a85ffa21595f36"},
+		{"alphabet", "abcdefghijklmnopqrstuvwxyz", "f71c27109c692c1b56bbdceb5b9d2865b3708dbc"},
+		{"two blocks of padding", "abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq", "12a053384a9c0c88e405a06c27dcf49ada62eb2b"},
+		{"mixed case and digits", "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "b0e20b6e3116640286ed3a87a5713079b21f5189"},
+		{"eight times 1234567890", strings.Repeat("1234567890", 8), "9b752e45573d4b39f4dbd3323cab82bf63326bfb"},
+		{"million a", strings.Repeat("a", 1000000), "52783243c1697bdbe16d37f97f68f08325dc1528"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := RIPEMD160([]byte(tt.input))
+			if got := hex.EncodeToString(digest[:]); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package bitcoin
 
 import (
-	"encoding/hex"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
@@ -75,21 +78,38 @@ const (
 	OP_TUCK         ScriptOpcode = 0x7d
 
 	// String ops
-	OP_SIZE ScriptOpcode = 0x82
+	OP_CAT    ScriptOpcode = 0x7e // Disabled
+	OP_SUBSTR ScriptOpcode = 0x7f // Disabled
+	OP_LEFT   ScriptOpcode = 0x80 // Disabled
+	OP_RIGHT  ScriptOpcode = 0x81 // Disabled
+	OP_SIZE   ScriptOpcode = 0x82
 
 	// Bitwise logic
+	OP_INVERT      ScriptOpcode = 0x83 // Disabled
+	OP_AND         ScriptOpcode = 0x84 // Disabled
+	OP_OR          ScriptOpcode = 0x85 // Disabled
+	OP_XOR         ScriptOpcode = 0x86 // Disabled
 	OP_EQUAL       ScriptOpcode = 0x87
 	OP_EQUALVERIFY ScriptOpcode = 0x88
+	OP_RESERVED1   ScriptOpcode = 0x89
+	OP_RESERVED2   ScriptOpcode = 0x8a
 
 	// Arithmetic
 	OP_1ADD               ScriptOpcode = 0x8b
 	OP_1SUB               ScriptOpcode = 0x8c
+	OP_2MUL               ScriptOpcode = 0x8d // Disabled
+	OP_2DIV               ScriptOpcode = 0x8e // Disabled
 	OP_NEGATE             ScriptOpcode = 0x8f
 	OP_ABS                ScriptOpcode = 0x90
 	OP_NOT                ScriptOpcode = 0x91
 	OP_0NOTEQUAL          ScriptOpcode = 0x92
 	OP_ADD                ScriptOpcode = 0x93
 	OP_SUB                ScriptOpcode = 0x94
+	OP_MUL                ScriptOpcode = 0x95 // Disabled
+	OP_DIV                ScriptOpcode = 0x96 // Disabled
+	OP_MOD                ScriptOpcode = 0x97 // Disabled
+	OP_LSHIFT             ScriptOpcode = 0x98 // Disabled
+	OP_RSHIFT             ScriptOpcode = 0x99 // Disabled
 	OP_BOOLAND            ScriptOpcode = 0x9a
 	OP_BOOLOR             ScriptOpcode = 0x9b
 	OP_NUMEQUAL           ScriptOpcode = 0x9c
@@ -153,6 +173,15 @@ type ScriptEngine struct {
 	script   Script
 	pc       int
 
+	// Start of the script code committed to by signatures (after the last OP_CODESEPARATOR)
+	codeSepPos int
+
+	// One entry per enclosing OP_IF/OP_NOTIF, true if its current branch executes
+	condStack []bool
+
+	// Signature hashing rules for the script being executed
+	sigVersion SigVersion
+
 	// Execution flags
 	flags ScriptFlags
 
@@ -160,6 +189,9 @@ type ScriptEngine struct {
 	tx       *Transaction
 	txIdx    int
 	prevOuts []TxOutput
+
+	// BIP143 midstates of tx, computed on first use unless provided
+	sigHashes *TxSigHashes
 }
 
 // ScriptFlags control script execution behavior
@@ -187,6 +219,14 @@ const (
 	ScriptVerifyTaproot                            ScriptFlags = 1 << 17 // BIP340/341/342
 )
 
+// SigVersion identifies the signature hashing rules a script is executed under
+type SigVersion int
+
+const (
+	SigVersionBase      SigVersion = iota // Legacy and P2SH scripts
+	SigVersionWitnessV0                   // BIP143 segwit version 0 scripts
+)
+
 // NewScriptEngine creates a new script execution engine
 func NewScriptEngine(script Script, tx *Transaction, txIdx int, prevOuts []TxOutput, flags ScriptFlags) *ScriptEngine {
 	return &ScriptEngine{
@@ -201,20 +241,75 @@ func NewScriptEngine(script Script, tx *Transaction, txIdx int, prevOuts []TxOut
 	}
 }
 
+// NewWitnessV0ScriptEngine creates an engine that executes a segwit version 0
+// script (the P2WSH witness script, or the P2PKH equivalent for P2WPKH) with the
+// remaining witness items as its initial stack. Signatures are hashed with BIP143,
+// which commits to the amount of prevOuts[txIdx].
+func NewWitnessV0ScriptEngine(script Script, witnessStack [][]byte, tx *Transaction, txIdx int,
+	prevOuts []TxOutput, flags ScriptFlags) *ScriptEngine {
+	se := NewScriptEngine(script, tx, txIdx, prevOuts, flags)
+	se.sigVersion = SigVersionWitnessV0
+	for _, item := range witnessStack {
+		se.stack = append(se.stack, append([]byte{}, item...))
+	}
+	return se
+}
+
+// ErrUnsupportedOpcode reports a script using an opcode the engine does not
+// implement yet
+var ErrUnsupportedOpcode = errors.New("unimplemented opcode")
+
 // Execute runs the script and returns true if successful
 func (se *ScriptEngine) Execute() (bool, error) {
 	// Handle empty script case
 	if len(se.script) == 0 {
 		return true, nil // Empty scripts succeed
 	}
+	if len(se.script) > MaxScriptSize {
+		return false, fmt.Errorf("script of %d bytes exceeds %d", len(se.script), MaxScriptSize)
+	}
 
+	se.condStack = se.condStack[:0]
+	opCount := 0
 	for se.pc < len(se.script) {
-		opcode := ScriptOpcode(se.script[se.pc])
-		se.pc++
-
-		if err := se.executeOpcode(opcode); err != nil {
+		opcode, data, next, err := parseScriptOp(se.script, se.pc)
+		if err != nil {
 			return false, err
 		}
+		se.pc = next
+
+		// Oversized pushes and disabled opcodes fail even in a branch that is not executed
+		if len(data) > MaxScriptElementSize {
+			return false, fmt.Errorf("push of %d bytes exceeds %d", len(data), MaxScriptElementSize)
+		}
+		if isDisabledOpcode(opcode) {
+			return false, fmt.Errorf("disabled opcode: %02x", opcode)
+		}
+		if opcode > OP_16 {
+			if opCount++; opCount > MaxOpsPerScript {
+				return false, fmt.Errorf("script has more than %d operations", MaxOpsPerScript)
+			}
+		}
+
+		switch {
+		case !se.executing() && (opcode < OP_IF || opcode > OP_ENDIF):
+			// A branch that is not executed is still parsed, but only the flow
+			// control opcodes, which track where it ends, are evaluated
+		case opcode <= OP_PUSHDATA4:
+			se.stack = append(se.stack, data)
+		default:
+			if err := se.executeOpcode(opcode); err != nil {
+				return false, err
+			}
+		}
+
+		if size := len(se.stack) + len(se.altStack); size > MaxStackSize {
+			return false, fmt.Errorf("stack size %d exceeds %d", size, MaxStackSize)
+		}
+	}
+
+	if len(se.condStack) != 0 {
+		return false, errors.New("unbalanced conditional: OP_IF without OP_ENDIF")
 	}
 
 	// Script execution succeeds if it ran without errors
@@ -224,6 +319,17 @@ func (se *ScriptEngine) Execute() (bool, error) {
 	return true, nil
 }
 
+// isDisabledOpcode reports whether an opcode was disabled in 2010. A script
+// containing one fails wherever it appears.
+func isDisabledOpcode(opcode ScriptOpcode) bool {
+	switch opcode {
+	case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR, OP_XOR,
+		OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT:
+		return true
+	}
+	return false
+}
+
 // executeOpcode executes a single opcode
 func (se *ScriptEngine) executeOpcode(opcode ScriptOpcode) error {
 	switch opcode {
@@ -348,6 +454,44 @@ func (se *ScriptEngine) executeOpcode(opcode ScriptOpcode) error {
 			return fmt.Errorf("OP_VERIFY: failed")
 		}
 
+	// Flow control
+	case OP_IF, OP_NOTIF:
+		branch := false
+		if se.executing() {
+			if len(se.stack) < 1 {
+				return fmt.Errorf("OP_IF: insufficient stack items")
+			}
+			condition := se.stack[len(se.stack)-1]
+			se.stack = se.stack[:len(se.stack)-1]
+
+			// MINIMALIF: segwit scripts may only branch on an empty vector or 0x01
+			if se.sigVersion == SigVersionWitnessV0 && se.flags&ScriptVerifyMinimalIf != 0 &&
+				(len(condition) > 1 || (len(condition) == 1 && condition[0] != 1)) {
+				return fmt.Errorf("OP_IF: condition is not minimal")
+			}
+			branch = se.isTrue(condition)
+			if opcode == OP_NOTIF {
+				branch = !branch
+			}
+		}
+		se.condStack = append(se.condStack, branch)
+
+	case OP_ELSE:
+		if len(se.condStack) == 0 {
+			return fmt.Errorf("unbalanced conditional: OP_ELSE without OP_IF")
+		}
+		se.condStack[len(se.condStack)-1] = !se.condStack[len(se.condStack)-1]
+
+	case OP_ENDIF:
+		if len(se.condStack) == 0 {
+			return fmt.Errorf("unbalanced conditional: OP_ENDIF without OP_IF")
+		}
+		se.condStack = se.condStack[:len(se.condStack)-1]
+
+	case OP_VERIF, OP_VERNOTIF:
+		// Unlike other invalid opcodes these fail even in a branch that is not executed
+		return fmt.Errorf("invalid opcode: %02x", opcode)
+
 	// Hash operations
 	case OP_HASH160:
 		if len(se.stack) < 1 {
@@ -358,7 +502,47 @@ func (se *ScriptEngine) executeOpcode(opcode ScriptOpcode) error {
 		hash := hash160(data)
 		se.stack = append(se.stack, hash[:])
 
+	case OP_RIPEMD160:
+		if len(se.stack) < 1 {
+			return fmt.Errorf("OP_RIPEMD160: insufficient stack items")
+		}
+		data := se.stack[len(se.stack)-1]
+		se.stack = se.stack[:len(se.stack)-1]
+		hash := RIPEMD160(data)
+		se.stack = append(se.stack, hash[:])
+
+	case OP_SHA1:
+		if len(se.stack) < 1 {
+			return fmt.Errorf("OP_SHA1: insufficient stack items")
+		}
+		data := se.stack[len(se.stack)-1]
+		se.stack = se.stack[:len(se.stack)-1]
+		hash := sha1.Sum(data)
+		se.stack = append(se.stack, hash[:])
+
+	case OP_SHA256:
+		if len(se.stack) < 1 {
+			return fmt.Errorf("OP_SHA256: insufficient stack items")
+		}
+		data := se.stack[len(se.stack)-1]
+		se.stack = se.stack[:len(se.stack)-1]
+		hash := sha256.Sum256(data)
+		se.stack = append(se.stack, hash[:])
+
+	case OP_HASH256:
+		if len(se.stack) < 1 {
+			return fmt.Errorf("OP_HASH256: insufficient stack items")
+		}
+		data := se.stack[len(se.stack)-1]
+		se.stack = se.stack[:len(se.stack)-1]
+		hash := DoubleHashSHA256(data)
+		se.stack = append(se.stack, hash[:])
+
 	// Signature operations
+	case OP_CODESEPARATOR:
+		// Signatures only commit to the script following the last executed separator
+		se.codeSepPos = se.pc
+
 	case OP_CHECKSIG:
 		if len(se.stack) < 2 {
 			return fmt.Errorf("OP_CHECKSIG: insufficient stack items (need signature and pubkey)")
@@ -370,7 +554,10 @@ func (se *ScriptEngine) executeOpcode(opcode ScriptOpcode) error {
 		se.stack = se.stack[:len(se.stack)-2]
 
 		// Perform signature verification
-		valid := se.verifySignature(signatureBytes, pubKeyBytes)
+		valid, err := se.checkSig(signatureBytes, pubKeyBytes)
+		if err != nil {
+			return fmt.Errorf("OP_CHECKSIG: %w", err)
+		}
 
 		// Push result to stack
 		if valid {
@@ -379,25 +566,34 @@ func (se *ScriptEngine) executeOpcode(opcode ScriptOpcode) error {
 			se.stack = append(se.stack, []byte{0})
 		}
 
+	case OP_RETURN:
+		return errors.New("OP_RETURN executed")
+
+	case OP_VER, OP_RESERVED, OP_RESERVED1, OP_RESERVED2:
+		return fmt.Errorf("reserved opcode executed: %02x", opcode)
+
 	default:
-		// Handle push operations
-		if opcode >= 1 && opcode <= 75 {
-			// Direct push of N bytes
-			n := int(opcode)
-			if se.pc+n > len(se.script) {
-				return fmt.Errorf("push operation exceeds script bounds")
-			}
-			data := se.script[se.pc : se.pc+n]
-			se.pc += n
-			se.stack = append(se.stack, data)
-		} else {
-			return fmt.Errorf("unimplemented opcode: %02x", opcode)
+		// Data pushes are handled by Execute, which parses them
+		if opcode > OP_NOP10 {
+			return fmt.Errorf("invalid opcode: %02x", opcode)
 		}
+		return fmt.Errorf("%w: %02x", ErrUnsupportedOpcode, opcode)
 	}
 
 	return nil
 }
 
+// executing reports whether the current branch of every enclosing
+// conditional is executed
+func (se *ScriptEngine) executing() bool {
+	for _, branch := range se.condStack {
+		if !branch {
+			return false
+		}
+	}
+	return true
+}
+
 // isTrue returns true if the byte slice represents a true value
 func (se *ScriptEngine) isTrue(data []byte) bool {
 	if len(data) == 0 {
@@ -499,6 +695,12 @@ func (s Script) AnalyzeScript() ScriptType {
 	return ScriptTypeUnknown
 }
 
+// IsUnspendable reports whether an output with this script can provably never
+// be spent, so it need not be kept in the UTXO set
+func (s Script) IsUnspendable() bool {
+	return (len(s) > 0 && s[0] == byte(OP_RETURN)) || len(s) > MaxScriptSize
+}
+
 // IsStandard returns true if the script is considered standard
 func (s Script) IsStandard() bool {
 	scriptType := s.AnalyzeScript()
@@ -540,18 +742,12 @@ func (s Script) isStandardMultisig() bool {
 }
 
 // Helper functions
+
+// hash160 returns RIPEMD160(SHA256(data)), the hash of public keys and
+// scripts committed to by P2PKH, P2SH and P2WPKH outputs
 func hash160(data []byte) Hash160 {
-	// TODO: Implement proper HASH160 (RIPEMD160(SHA256(data)))
-	// For now, return a deterministic hash for testing
-	// This is a placeholder - real Bitcoin uses RIPEMD160(SHA256(data))
-	if string(data) == "Hello" {
-		// Return the expected test hash for "Hello"
-		expected, _ := hex.DecodeString("b6a9c8c230722b7c748331a8b450f05566dc7d0f")
-		var result Hash160
-		copy(result[:], expected)
-		return result
-	}
-	return ZeroHash160
+	sum := sha256.Sum256(data)
+	return Hash160(RIPEMD160(sum[:]))
 }
 
 func bytesEqual(a, b []byte) bool {
@@ -566,6 +762,105 @@ func bytesEqual(a, b []byte) bool {
 	return true
 }
 
+// parseScriptOp reads the opcode at pc together with any data it pushes and
+// returns the position of the following opcode
+func parseScriptOp(script []byte, pc int) (opcode ScriptOpcode, data []byte, next int, err error) {
+	if pc >= len(script) {
+		return 0, nil, pc, errors.New("script position out of range")
+	}
+
+	opcode = ScriptOpcode(script[pc])
+	pc++
+
+	var size int
+	switch {
+	case opcode < OP_PUSHDATA1:
+		size = int(opcode)
+	case opcode == OP_PUSHDATA1:
+		if pc+1 > len(script) {
+			return opcode, nil, pc, errors.New("OP_PUSHDATA1: missing length")
+		}
+		size = int(script[pc])
+		pc++
+	case opcode == OP_PUSHDATA2:
+		if pc+2 > len(script) {
+			return opcode, nil, pc, errors.New("OP_PUSHDATA2: missing length")
+		}
+		size = int(binary.LittleEndian.Uint16(script[pc:]))
+		pc += 2
+	case opcode == OP_PUSHDATA4:
+		if pc+4 > len(script) {
+			return opcode, nil, pc, errors.New("OP_PUSHDATA4: missing length")
+		}
+		size64 := uint64(binary.LittleEndian.Uint32(script[pc:]))
+		pc += 4
+		if size64 > uint64(len(script)-pc) {
+			return opcode, nil, pc, errors.New("push operation exceeds script bounds")
+		}
+		size = int(size64)
+	default:
+		return opcode, nil, pc, nil
+	}
+
+	if size > len(script)-pc {
+		return opcode, nil, pc, errors.New("push operation exceeds script bounds")
+	}
+	return opcode, script[pc : pc+size], pc + size, nil
+}
+
+// pushDataScript returns the script that pushes data using the smallest
+// pushdata opcode for its length (CScript() << data in Bitcoin Core)
+func pushDataScript(data []byte) Script {
+	n := len(data)
+	var script Script
+	switch {
+	case n < int(OP_PUSHDATA1):
+		script = append(script, byte(n))
+	case n <= 0xff:
+		script = append(script, byte(OP_PUSHDATA1), byte(n))
+	case n <= 0xffff:
+		script = append(script, byte(OP_PUSHDATA2), byte(n), byte(n>>8))
+	default:
+		script = append(script, byte(OP_PUSHDATA4), byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(script, data...)
+}
+
+// FindAndDelete returns a copy of the script with every occurrence of pattern
+// that starts on an opcode boundary removed. This reproduces the legacy
+// consensus quirk that strips signatures from the script code before hashing.
+func (s Script) FindAndDelete(pattern Script) Script {
+	if len(pattern) == 0 {
+		return s
+	}
+
+	result := make(Script, 0, len(s))
+	found := false
+	pc, copyFrom := 0, 0
+	for {
+		result = append(result, s[copyFrom:pc]...)
+		for len(s)-pc >= len(pattern) && bytesEqual(s[pc:pc+len(pattern)], pattern) {
+			pc += len(pattern)
+			found = true
+		}
+		copyFrom = pc
+
+		if pc >= len(s) {
+			break
+		}
+		_, _, next, err := parseScriptOp(s, pc)
+		if err != nil {
+			break
+		}
+		pc = next
+	}
+
+	if !found {
+		return s
+	}
+	return append(result, s[copyFrom:]...)
+}
+
 // GetStack returns a copy of the current execution stack
 func (se *ScriptEngine) GetStack() [][]byte {
 	// Return a copy to prevent external modification
@@ -581,10 +876,16 @@ func (se *ScriptEngine) GetStack() [][]byte {
 func (se *ScriptEngine) SetScript(script Script) {
 	se.script = script
 	se.pc = 0
+	se.codeSepPos = 0
 }
 
 // bytesToNum converts Bitcoin script number format (little-endian) to int64
 func (se *ScriptEngine) bytesToNum(data []byte) int64 {
+	return decodeScriptNum(data)
+}
+
+// decodeScriptNum converts Bitcoin script number format (little-endian) to int64
+func decodeScriptNum(data []byte) int64 {
 	if len(data) == 0 {
 		return 0
 	}
@@ -616,6 +917,11 @@ func (se *ScriptEngine) bytesToNum(data []byte) int64 {
 
 // numToBytes converts int64 to Bitcoin script number format (little-endian)
 func (se *ScriptEngine) numToBytes(num int64) []byte {
+	return encodeScriptNum(num)
+}
+
+// encodeScriptNum converts int64 to Bitcoin script number format (little-endian)
+func encodeScriptNum(num int64) []byte {
 	if num == 0 {
 		return []byte{}
 	}
@@ -649,80 +955,292 @@ func (se *ScriptEngine) numToBytes(num int64) []byte {
 	return result
 }
 
-// verifySignature verifies an ECDSA signature against a public key
-// This is a basic implementation for TDD - will be enhanced for full Bitcoin compliance
-func (se *ScriptEngine) verifySignature(signatureBytes, pubKeyBytes []byte) bool {
-	// Basic validation
-	if len(signatureBytes) == 0 || len(pubKeyBytes) == 0 {
-		return false
+// checkSig applies the signature and public key encoding rules selected by
+// the script flags and then verifies the signature
+func (se *ScriptEngine) checkSig(signatureBytes, pubKeyBytes []byte) (bool, error) {
+	if err := se.checkSignatureEncoding(signatureBytes); err != nil {
+		return false, err
+	}
+	if err := se.checkPubKeyEncoding(pubKeyBytes); err != nil {
+		return false, err
+	}
+
+	valid := se.verifySignature(signatureBytes, pubKeyBytes)
+	if !valid && se.flags&ScriptVerifyNullFail != 0 && len(signatureBytes) > 0 {
+		return false, errors.New("signature must be empty if verification fails")
+	}
+
+	return valid, nil
+}
+
+// checkSignatureEncoding enforces BIP66 strict DER, low-S and defined
+// sighash types when the corresponding flags are set. An empty signature
+// is always allowed so that scripts can provide a compact invalid signature.
+func (se *ScriptEngine) checkSignatureEncoding(sig []byte) error {
+	if len(sig) == 0 {
+		return nil
+	}
+
+	if se.flags&(ScriptVerifyDERSig|ScriptVerifyLowS|ScriptVerifyStrictEnc) != 0 && !IsValidSignatureEncoding(sig) {
+		return errors.New("non-canonical DER signature")
 	}
 
-	// For now, implement a simple verification stub
-	// TODO: Implement full DER signature parsing and ECDSA verification
-	// This should:
-	// 1. Parse DER-encoded signature
-	// 2. Extract SIGHASH type
-	// 3. Create transaction hash for verification
-	// 4. Parse public key (compressed/uncompressed)
-	// 5. Verify ECDSA signature
+	if se.flags&ScriptVerifyLowS != 0 {
+		parsed, err := ParseDERSignature(sig[:len(sig)-1])
+		if err != nil || !parsed.IsLowS() {
+			return errors.New("signature S value is unnecessarily high")
+		}
+	}
+
+	if se.flags&ScriptVerifyStrictEnc != 0 && !isDefinedHashType(sig) {
+		return errors.New("signature has undefined hash type")
+	}
+
+	return nil
+}
 
-	// Placeholder implementation for TDD GREEN phase
-	// Check if this looks like a valid signature format (DER encoding starts with 0x30)
-	if len(signatureBytes) < 6 || signatureBytes[0] != 0x30 {
+// checkPubKeyEncoding enforces compressed or uncompressed public keys under STRICTENC,
+// and compressed keys only in witness v0 scripts under WITNESS_PUBKEYTYPE
+func (se *ScriptEngine) checkPubKeyEncoding(pubKey []byte) error {
+	if se.flags&ScriptVerifyStrictEnc != 0 && !isCompressedOrUncompressedPubKey(pubKey) {
+		return errors.New("public key has invalid encoding")
+	}
+	if se.flags&ScriptVerifyWitnessPubkeyType != 0 && se.sigVersion == SigVersionWitnessV0 &&
+		(len(pubKey) != 33 || (pubKey[0] != PubKeyCompressedEven && pubKey[0] != PubKeyCompressedOdd)) {
+		return errors.New("witness public key must be compressed")
+	}
+	return nil
+}
+
+// verifySignature verifies an ECDSA signature against a public key.
+// The last byte of the signature is the sighash type; the remainder is
+// parsed with the pre-BIP66 lax DER rules, strictness being a flag concern.
+func (se *ScriptEngine) verifySignature(signatureBytes, pubKeyBytes []byte) bool {
+	if len(signatureBytes) == 0 {
 		return false
 	}
 
-	// Check if this looks like a valid public key
-	if len(pubKeyBytes) != 33 && len(pubKeyBytes) != 65 {
-		return false // Invalid pubkey length
+	pubKey, err := ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return false
 	}
 
-	// Compressed pubkey should start with 0x02 or 0x03
-	// Uncompressed pubkey should start with 0x04
-	if len(pubKeyBytes) == 33 && (pubKeyBytes[0] != 0x02 && pubKeyBytes[0] != 0x03) {
+	hashType := SigHashType(signatureBytes[len(signatureBytes)-1])
+	sig, err := parseDERSignatureLax(signatureBytes[:len(signatureBytes)-1])
+	if err != nil {
 		return false
 	}
-	if len(pubKeyBytes) == 65 && pubKeyBytes[0] != 0x04 {
+
+	sigHash, err := se.signatureHash(signatureBytes, hashType)
+	if err != nil {
 		return false
 	}
 
-	// Enhanced verification logic for TDD REFACTOR phase
-	// Check for obviously invalid signatures
-
-	// DER signature format: 0x30 [total-length] 0x02 [r-length] [r] 0x02 [s-length] [s] [sighash]
-	// Check for all-zero r and s components (invalid signatures)
-	if len(signatureBytes) >= 8 {
-		// Look for pattern: 30440220[32 zeros]0220[32 zeros]01
-		// This is a common invalid signature pattern
-		rStart := 4  // After 30 44 02 20
-		sStart := 38 // After r component and 02 20
-
-		if len(signatureBytes) >= 71 { // Standard DER signature length + SIGHASH
-			// Check if r component is all zeros
-			rAllZero := true
-			for i := rStart; i < rStart+32; i++ {
-				if signatureBytes[i] != 0x00 {
-					rAllZero = false
-					break
-				}
+	return VerifyECDSA(pubKey, sigHash[:], sig)
+}
+
+// signatureHash computes the message a signature with the given hash type commits to.
+// The script code runs from the last OP_CODESEPARATOR to the end of the script. Legacy
+// scripts additionally have every push of the signature itself removed (FindAndDelete);
+// witness v0 scripts commit to it verbatim along with the spent amount.
+func (se *ScriptEngine) signatureHash(signatureBytes []byte, hashType SigHashType) (Hash256, error) {
+	if se.tx == nil {
+		return ZeroHash, errors.New("no transaction context for signature hash")
+	}
+
+	if se.sigVersion == SigVersionWitnessV0 {
+		if se.txIdx < 0 || se.txIdx >= len(se.prevOuts) {
+			return ZeroHash, errors.New("no previous output for witness signature hash")
+		}
+		if se.sigHashes == nil {
+			se.sigHashes = NewTxSigHashes(se.tx)
+		}
+		return se.tx.WitnessV0SigHash(se.sigHashes, se.txIdx, se.script[se.codeSepPos:], se.prevOuts[se.txIdx].Value, hashType)
+	}
+
+	scriptCode := se.script[se.codeSepPos:].FindAndDelete(pushDataScript(signatureBytes))
+	return se.tx.LegacySigHash(se.txIdx, scriptCode, hashType)
+}
+
+// Script execution limits
+const (
+	MaxScriptElementSize = 520   // Largest item a script may push or a witness v0 stack hold
+	MaxScriptSize        = 10000 // Largest script that can be executed
+	MaxOpsPerScript      = 201   // Most non-push opcodes in a script, executed or not
+	MaxStackSize         = 1000  // Most items on the stack and alt stack together
+)
+
+// IsPushOnly reports whether the script consists only of push operations,
+// counting OP_1NEGATE and OP_1 to OP_16 as pushes
+func (s Script) IsPushOnly() bool {
+	for pc := 0; pc < len(s); {
+		opcode, _, next, err := parseScriptOp(s, pc)
+		if err != nil || opcode > OP_16 {
+			return false
+		}
+		pc = next
+	}
+	return true
+}
+
+// WitnessProgram returns the version and program of a segwit output script:
+// a version opcode (OP_0 or OP_1 to OP_16) followed by one push of 2 to 40 bytes
+func (s Script) WitnessProgram() (int, []byte, bool) {
+	if len(s) < 4 || len(s) > 42 || int(s[1])+2 != len(s) {
+		return 0, nil, false
+	}
+	switch {
+	case s[0] == byte(OP_0):
+		return 0, s[2:], true
+	case s[0] >= byte(OP_1) && s[0] <= byte(OP_16):
+		return int(s[0]-byte(OP_1)) + 1, s[2:], true
+	}
+	return 0, nil, false
+}
+
+// VerifyScript checks that input txIdx of tx satisfies the output it spends,
+// prevOuts[txIdx], under the given flags. prevOuts holds the output spent by
+// every input of tx. The scriptSig is executed first and its stack handed to
+// the output script, which must leave a true value on top. With
+// ScriptVerifyP2SH a P2SH output also runs the serialized script pushed last
+// by the scriptSig, and with ScriptVerifyWitness segwit programs are verified
+// against the input's witness. sigHashes are tx's BIP143 midstates, which
+// the caller may compute once with NewTxSigHashes and pass for every input;
+// if nil they are computed when needed.
+func VerifyScript(tx *Transaction, txIdx int, prevOuts []TxOutput, sigHashes *TxSigHashes, flags ScriptFlags) error {
+	if txIdx < 0 || txIdx >= len(tx.Inputs) || len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("no previous output for input %d", txIdx)
+	}
+	scriptSig := Script(tx.Inputs[txIdx].ScriptSig)
+	scriptPubKey := Script(prevOuts[txIdx].ScriptPubKey)
+	witness := tx.Inputs[txIdx].Witness
+
+	if flags&ScriptVerifySigPushOnly != 0 && !scriptSig.IsPushOnly() {
+		return errors.New("scriptSig is not push only")
+	}
+
+	se := NewScriptEngine(scriptSig, tx, txIdx, prevOuts, flags)
+	if _, err := se.Execute(); err != nil {
+		return fmt.Errorf("scriptSig: %w", err)
+	}
+	sigStack := se.GetStack()
+
+	se.SetScript(scriptPubKey)
+	if err := se.executeToTrue(); err != nil {
+		return fmt.Errorf("scriptPubKey: %w", err)
+	}
+	finalStack := len(se.stack)
+
+	hadWitness := false
+	if flags&ScriptVerifyWitness != 0 {
+		if version, program, ok := scriptPubKey.WitnessProgram(); ok {
+			hadWitness = true
+			if len(scriptSig) != 0 {
+				return errors.New("witness program spent with a non-empty scriptSig")
 			}
+			if err := verifyWitnessProgram(tx, txIdx, prevOuts, witness, version, program, sigHashes, flags); err != nil {
+				return err
+			}
+			finalStack = 1
+		}
+	}
+
+	if flags&ScriptVerifyP2SH != 0 && scriptPubKey.AnalyzeScript() == ScriptTypeP2SH {
+		if !scriptSig.IsPushOnly() {
+			return errors.New("P2SH scriptSig is not push only")
+		}
+		// The output script succeeded, so the scriptSig pushed at least the redeem script
+		redeemScript := Script(sigStack[len(sigStack)-1])
+		redeem := NewScriptEngine(redeemScript, tx, txIdx, prevOuts, flags)
+		redeem.stack = sigStack[:len(sigStack)-1]
+		if err := redeem.executeToTrue(); err != nil {
+			return fmt.Errorf("P2SH redeem script: %w", err)
+		}
+		finalStack = len(redeem.stack)
 
-			// Check if s component is all zeros
-			sAllZero := true
-			for i := sStart; i < sStart+32; i++ {
-				if signatureBytes[i] != 0x00 {
-					sAllZero = false
-					break
+		if flags&ScriptVerifyWitness != 0 {
+			if version, program, ok := redeemScript.WitnessProgram(); ok {
+				hadWitness = true
+				if !bytesEqual(scriptSig, pushDataScript(redeemScript)) {
+					return errors.New("P2SH witness program scriptSig must be a single push")
 				}
+				if err := verifyWitnessProgram(tx, txIdx, prevOuts, witness, version, program, sigHashes, flags); err != nil {
+					return err
+				}
+				finalStack = 1
 			}
+		}
+	}
 
-			// If both r and s are zero, signature is invalid
-			if rAllZero && sAllZero {
-				return false
-			}
+	// Clean stack is only meaningful together with the rules that move items off the stack
+	if flags&ScriptVerifyCleanStack != 0 && flags&ScriptVerifyP2SH != 0 && flags&ScriptVerifyWitness != 0 &&
+		finalStack != 1 {
+		return errors.New("stack not clean after execution")
+	}
+
+	if flags&ScriptVerifyWitness != 0 && !hadWitness && len(witness) > 0 {
+		return errors.New("unexpected witness data")
+	}
+	return nil
+}
+
+// executeToTrue runs the script and requires a true value on top of the stack
+func (se *ScriptEngine) executeToTrue() error {
+	if _, err := se.Execute(); err != nil {
+		return err
+	}
+	if len(se.stack) == 0 || !se.isTrue(se.stack[len(se.stack)-1]) {
+		return errors.New("script evaluated to false")
+	}
+	return nil
+}
+
+// verifyWitnessProgram checks a segwit program against the input's witness.
+// Version 0 programs are P2WPKH (20 bytes) or P2WSH (32 bytes); other
+// versions, including taproot's version 1, are left to soft forks and succeed.
+func verifyWitnessProgram(tx *Transaction, txIdx int, prevOuts []TxOutput, witness [][]byte,
+	version int, program []byte, sigHashes *TxSigHashes, flags ScriptFlags) error {
+	var script Script
+	var stack [][]byte
+
+	switch {
+	case version == 0 && len(program) == Hash256Size:
+		if len(witness) == 0 {
+			return errors.New("P2WSH witness is empty")
+		}
+		script = witness[len(witness)-1]
+		if scriptHash := sha256.Sum256(script); !bytesEqual(scriptHash[:], program) {
+			return errors.New("P2WSH witness script does not match program")
+		}
+		stack = witness[:len(witness)-1]
+	case version == 0 && len(program) == Hash160Size:
+		if len(witness) != 2 {
+			return fmt.Errorf("P2WPKH witness has %d items, expected 2", len(witness))
 		}
+		script = WitnessPubKeyHashScriptCode(program)
+		stack = witness
+	case version == 0:
+		return fmt.Errorf("witness v0 program has invalid length %d", len(program))
+	default:
+		if flags&ScriptVerifyDiscourageUpgradableWitnessProgram != 0 {
+			return fmt.Errorf("upgradable witness program version %d", version)
+		}
+		return nil
 	}
 
-	// For TDD: return true for valid-looking signatures that aren't obviously invalid
-	return true
+	for _, item := range stack {
+		if len(item) > MaxScriptElementSize {
+			return fmt.Errorf("witness item of %d bytes exceeds %d", len(item), MaxScriptElementSize)
+		}
+	}
+
+	se := NewWitnessV0ScriptEngine(script, stack, tx, txIdx, prevOuts, flags)
+	se.sigHashes = sigHashes
+	if err := se.executeToTrue(); err != nil {
+		return fmt.Errorf("witness script: %w", err)
+	}
+	if len(se.stack) != 1 {
+		return fmt.Errorf("witness script left %d items on the stack", len(se.stack))
+	}
+	return nil
 }
@@ -2,7 +2,9 @@ package bitcoin
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"strings"
 	"testing"
 )
 
@@ -103,18 +105,60 @@ func TestScriptEngine_Execute(t *testing.T) {
 			name:       "OP_HASH160 of known data",
 			scriptHex:  "0548656c6c6fa9", // PUSH(5) "Hello" OP_HASH160
 			expected:   true,
-			finalStack: []string{"b6a9c8c230722b7c748331a8b450f05566dc7d0f"}, // HASH160("Hello")
+			finalStack: []string{"578635f64c2b8b846f2b659853f6333e4148ebe8"}, // HASH160("Hello")
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_RIPEMD160 of known data",
+			scriptHex:  "0548656c6c6fa6", // PUSH(5) "Hello" OP_RIPEMD160
+			expected:   true,
+			finalStack: []string{"d44426aca8ae0a69cdbc4021c64fa5ad68ca32fe"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_SHA1 of known data",
+			scriptHex:  "0548656c6c6fa7", // PUSH(5) "Hello" OP_SHA1
+			expected:   true,
+			finalStack: []string{"f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_SHA256 of known data",
+			scriptHex:  "0548656c6c6fa8", // PUSH(5) "Hello" OP_SHA256
+			expected:   true,
+			finalStack: []string{"185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_HASH256 of known data",
+			scriptHex:  "0548656c6c6faa", // PUSH(5) "Hello" OP_HASH256
+			expected:   true,
+			finalStack: []string{"70bc18bef5ae66b72d1995f8db90a583a60d77b4066e4653f1cead613025861c"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_SHA256 with empty stack (should fail)",
+			scriptHex:  "a8", // OP_SHA256
+			expected:   false,
+			finalStack: []string{},
 			flags:      ScriptFlagsNone,
 		},
 
 		// Complex scripts
 		{
 			name:       "Simple P2PKH-like pattern (without signature)",
-			scriptHex:  "76a914" + "b6a9c8c230722b7c748331a8b450f05566dc7d0f" + "87", // OP_DUP OP_HASH160 <hash> OP_EQUAL
+			scriptHex:  "76a914" + "578635f64c2b8b846f2b659853f6333e4148ebe8" + "87", // OP_DUP OP_HASH160 <hash> OP_EQUAL
 			expected:   false,                                                        // Should fail without matching data on stack
 			finalStack: []string{},
 			flags:      ScriptFlagsNone,
 		},
+		{
+			name:       "P2SH-like pattern with matching data",
+			scriptHex:  "0548656c6c6f" + "a914" + "578635f64c2b8b846f2b659853f6333e4148ebe8" + "87", // "Hello" OP_HASH160 <hash> OP_EQUAL
+			expected:   true,
+			finalStack: []string{"01"},
+			flags:      ScriptFlagsNone,
+		},
 
 		// Error conditions
 		{
@@ -138,6 +182,213 @@ func TestScriptEngine_Execute(t *testing.T) {
 			finalStack: []string{},
 			flags:      ScriptFlagsNone,
 		},
+		// Flow control
+		{
+			name:       "OP_IF takes true branch",
+			scriptHex:  "516352675368", // OP_1 OP_IF OP_2 OP_ELSE OP_3 OP_ENDIF
+			expected:   true,
+			finalStack: []string{"02"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_IF takes false branch",
+			scriptHex:  "006352675368", // OP_0 OP_IF OP_2 OP_ELSE OP_3 OP_ENDIF
+			expected:   true,
+			finalStack: []string{"03"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_NOTIF inverts condition",
+			scriptHex:  "006452675368", // OP_0 OP_NOTIF OP_2 OP_ELSE OP_3 OP_ENDIF
+			expected:   true,
+			finalStack: []string{"02"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Nested conditionals",
+			scriptHex:  "516300635267546868", // OP_1 OP_IF OP_0 OP_IF OP_2 OP_ELSE OP_4 OP_ENDIF OP_ENDIF
+			expected:   true,
+			finalStack: []string{"04"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Nested conditional in skipped branch",
+			scriptHex:  "006351635268675568", // OP_0 OP_IF OP_1 OP_IF OP_2 OP_ENDIF OP_ELSE OP_5 OP_ENDIF
+			expected:   true,
+			finalStack: []string{"05"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Multiple OP_ELSE alternate branches",
+			scriptHex:  "5163526753675468", // OP_1 OP_IF OP_2 OP_ELSE OP_3 OP_ELSE OP_4 OP_ENDIF
+			expected:   true,
+			finalStack: []string{"02", "04"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_IF without OP_ENDIF (should fail)",
+			scriptHex:  "516352", // OP_1 OP_IF OP_2
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_ELSE without OP_IF (should fail)",
+			scriptHex:  "5167", // OP_1 OP_ELSE
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_ENDIF without OP_IF (should fail)",
+			scriptHex:  "5168", // OP_1 OP_ENDIF
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_IF with empty stack (should fail)",
+			scriptHex:  "6368", // OP_IF OP_ENDIF
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_VERIF in skipped branch (should fail)",
+			scriptHex:  "0063656851", // OP_0 OP_IF OP_VERIF OP_ENDIF OP_1
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Unknown opcode in skipped branch",
+			scriptHex:  "0063ba6851", // OP_0 OP_IF 0xba OP_ENDIF OP_1
+			expected:   true,
+			finalStack: []string{"01"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Push data in skipped branch is not parsed as opcodes",
+			scriptHex:  "00634c026368675168", // OP_0 OP_IF PUSHDATA1(2) 6368 OP_ELSE OP_1 OP_ENDIF
+			expected:   true,
+			finalStack: []string{"01"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Disabled opcode (should fail)",
+			scriptHex:  "51517e", // OP_1 OP_1 OP_CAT
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Disabled opcode in skipped branch (should fail)",
+			scriptHex:  "00637e6851", // OP_0 OP_IF OP_CAT OP_ENDIF OP_1
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Oversized push in skipped branch (should fail)",
+			scriptHex:  "00634d0902" + strings.Repeat("00", MaxScriptElementSize+1) + "6851", // OP_0 OP_IF PUSHDATA2(521) OP_ENDIF OP_1
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		// Script limits
+		{
+			name:       "Script over the size limit (should fail)",
+			scriptHex:  strings.Repeat("51", MaxScriptSize+1),
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Most operations allowed",
+			scriptHex:  "0063" + strings.Repeat("61", MaxOpsPerScript-2) + "68", // OP_0 OP_IF OP_NOP... OP_ENDIF
+			expected:   true,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Unexecuted operations count towards the limit (should fail)",
+			scriptHex:  "0063" + strings.Repeat("61", MaxOpsPerScript-1) + "68",
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Stack over the size limit (should fail)",
+			scriptHex:  strings.Repeat("51", MaxStackSize+1),
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		// Opcodes that always fail when executed
+		{
+			name:       "OP_RETURN (should fail)",
+			scriptHex:  "516a", // OP_1 OP_RETURN
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_RETURN and OP_VER in skipped branch",
+			scriptHex:  "00636a62685a", // OP_0 OP_IF OP_RETURN OP_VER OP_ENDIF OP_10
+			expected:   true,
+			finalStack: []string{"0a"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_VER (should fail)",
+			scriptHex:  "5162", // OP_1 OP_VER
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		// Push data opcodes
+		{
+			name:       "Largest push",
+			scriptHex:  "4d0802" + strings.Repeat("ab", MaxScriptElementSize), // OP_PUSHDATA2 520 bytes
+			expected:   true,
+			finalStack: []string{strings.Repeat("ab", MaxScriptElementSize)},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Oversized push (should fail)",
+			scriptHex:  "4d0902" + strings.Repeat("ab", MaxScriptElementSize+1), // OP_PUSHDATA2 521 bytes
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_PUSHDATA1",
+			scriptHex:  "4c03010203", // OP_PUSHDATA1 3 bytes
+			expected:   true,
+			finalStack: []string{"010203"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_PUSHDATA2",
+			scriptHex:  "4d0200abcd", // OP_PUSHDATA2 2 bytes
+			expected:   true,
+			finalStack: []string{"abcd"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "OP_PUSHDATA4",
+			scriptHex:  "4e01000000ff", // OP_PUSHDATA4 1 byte
+			expected:   true,
+			finalStack: []string{"ff"},
+			flags:      ScriptFlagsNone,
+		},
+		{
+			name:       "Push past end of script (should fail)",
+			scriptHex:  "4c0501", // OP_PUSHDATA1 5 bytes, only 1 present
+			expected:   false,
+			finalStack: []string{},
+			flags:      ScriptFlagsNone,
+		},
 	}
 
 	for _, tt := range tests {
@@ -193,7 +444,7 @@ func TestScriptEngine_P2PKHExecution(t *testing.T) {
 	t.Skip("P2PKH execution requires signature validation - will be implemented in next phase")
 }
 
-// TestScriptEngine_SignatureVerification tests ECDSA signature verification (TDD RED phase)
+// TestScriptEngine_SignatureVerification tests that OP_CHECKSIG rejects signatures that do not verify
 func TestScriptEngine_SignatureVerification(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -205,13 +456,13 @@ func TestScriptEngine_SignatureVerification(t *testing.T) {
 		description  string
 	}{
 		{
-			name:         "Valid ECDSA signature verification",
+			name:         "Well-formed signature without transaction context",
 			scriptHex:    "ac", // OP_CHECKSIG
 			txHash:       "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
 			pubKeyHex:    "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",                                                                             // Sample compressed pubkey
 			signatureHex: "304402200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef02200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef01", // Sample DER signature + SIGHASH_ALL
-			expected:     true,
-			description:  "Valid signature should verify successfully",
+			expected:     false,
+			description:  "A DER-shaped signature cannot verify without a transaction to hash",
 		},
 		{
 			name:         "Invalid signature should fail verification",
@@ -228,8 +479,8 @@ func TestScriptEngine_SignatureVerification(t *testing.T) {
 			txHash:       "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
 			pubKeyHex:    "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
 			signatureHex: "304402200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef02200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef01",
-			expected:     true,
-			description:  "Complete P2PKH script should execute with valid signature",
+			expected:     false,
+			description:  "Complete P2PKH script must not accept a fabricated signature",
 		},
 	}
 
@@ -404,3 +655,145 @@ func TestScriptEngine_Benchmarks(t *testing.T) {
 		}
 	}
 }
+
+// TestScriptEngine_CheckSigEncoding tests signature and public key encoding flags on OP_CHECKSIG
+func TestScriptEngine_CheckSigEncoding(t *testing.T) {
+	const (
+		compressedKey = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+		hybridKey     = "0679be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+			"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+		lowSSig  = "3006020101020101"
+		highSSig = "3026020101" + "0221" + "00fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364140"
+		laxSig   = "3010020101020101"
+	)
+
+	tests := []struct {
+		name        string
+		sigHex      string
+		pubKeyHex   string
+		flags       ScriptFlags
+		expectError bool
+	}{
+		{"lax DER allowed without flags", laxSig + "01", compressedKey, ScriptFlagsNone, false},
+		{"lax DER rejected under DERSIG", laxSig + "01", compressedKey, ScriptVerifyDERSig, true},
+		{"high S allowed without LOW_S", highSSig + "01", compressedKey, ScriptVerifyDERSig, false},
+		{"high S rejected under LOW_S", highSSig + "01", compressedKey, ScriptVerifyLowS, true},
+		{"undefined hash type allowed without STRICTENC", lowSSig + "04", compressedKey, ScriptVerifyDERSig, false},
+		{"undefined hash type rejected under STRICTENC", lowSSig + "04", compressedKey, ScriptVerifyStrictEnc, true},
+		{"ANYONECANPAY hash type accepted under STRICTENC", lowSSig + "81", compressedKey, ScriptVerifyStrictEnc, false},
+		{"hybrid key allowed without STRICTENC", lowSSig + "01", hybridKey, ScriptFlagsNone, false},
+		{"hybrid key rejected under STRICTENC", lowSSig + "01", hybridKey, ScriptVerifyStrictEnc, true},
+		{"failing signature rejected under NULLFAIL", lowSSig + "01", compressedKey, ScriptVerifyNullFail, true},
+		{"empty signature allowed under NULLFAIL", "", compressedKey, ScriptVerifyNullFail | ScriptVerifyStrictEnc, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := mustDecodeHex(tt.sigHex)
+			pubKey := mustDecodeHex(tt.pubKeyHex)
+
+			var script []byte
+			if len(sig) == 0 {
+				script = append(script, byte(OP_0))
+			} else {
+				script = append(script, byte(len(sig)))
+				script = append(script, sig...)
+			}
+			script = append(script, byte(len(pubKey)))
+			script = append(script, pubKey...)
+			script = append(script, byte(OP_CHECKSIG))
+
+			engine := NewScriptEngine(script, nil, 0, nil, tt.flags)
+			result, err := engine.Execute()
+
+			if tt.expectError {
+				if result || err == nil {
+					t.Errorf("Expected script error, got result %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// None of these signatures verify, so OP_CHECKSIG must push false
+			stack := engine.GetStack()
+			if len(stack) != 1 || engine.isTrue(stack[0]) {
+				t.Errorf("Expected a single false stack item, got %x", stack)
+			}
+		})
+	}
+}
+
+// TestVerifyScript tests spending an output with a scriptSig and witness
+func TestVerifyScript(t *testing.T) {
+	witnessScript := []byte{byte(OP_1)}
+	scriptHash := sha256.Sum256(witnessScript)
+	p2wsh := append([]byte{byte(OP_0), 0x20}, scriptHash[:]...)
+	redeemHash := hash160([]byte{byte(OP_1)})
+	p2sh := append(append([]byte{byte(OP_HASH160), 0x14}, redeemHash[:]...), byte(OP_EQUAL))
+	bigRedeem := bytes.Repeat([]byte{byte(OP_1)}, MaxScriptElementSize+1)
+	bigRedeemHash := hash160(bigRedeem)
+	p2shBig := append(append([]byte{byte(OP_HASH160), 0x14}, bigRedeemHash[:]...), byte(OP_EQUAL))
+	falseHash := hash160([]byte{byte(OP_0)})
+	p2shFalse := append(append([]byte{byte(OP_HASH160), 0x14}, falseHash[:]...), byte(OP_EQUAL))
+	ifScript := []byte{byte(OP_IF), byte(OP_1), byte(OP_ELSE), byte(OP_0), byte(OP_ENDIF)}
+	ifHash := sha256.Sum256(ifScript)
+	p2wshIf := append([]byte{byte(OP_0), 0x20}, ifHash[:]...)
+	minimalIf := ScriptVerifyWitness | ScriptVerifyMinimalIf
+	p2tr := append([]byte{byte(OP_1), 0x20}, bytes.Repeat([]byte{0x01}, 32)...)
+
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		witness      [][]byte
+		scriptPubKey []byte
+		flags        ScriptFlags
+		valid        bool
+	}{
+		{"true output", nil, nil, []byte{byte(OP_1)}, ScriptFlagsNone, true},
+		{"false output", nil, nil, []byte{byte(OP_0)}, ScriptFlagsNone, false},
+		{"scriptSig satisfies output", []byte{byte(OP_2)}, nil, []byte{byte(OP_2), byte(OP_EQUAL)}, ScriptFlagsNone, true},
+		{"scriptSig not push only", []byte{byte(OP_1), byte(OP_DUP)}, nil, []byte{byte(OP_EQUAL)}, ScriptVerifySigPushOnly, false},
+		{"P2SH", []byte{0x01, byte(OP_1)}, nil, p2sh, ScriptVerifyP2SH, true},
+		{"P2SH wrong redeem script", []byte{0x01, byte(OP_2)}, nil, p2sh, ScriptVerifyP2SH, false},
+		{"P2SH redeem script fails", []byte{0x01, byte(OP_0)}, nil, p2shFalse, ScriptVerifyP2SH, false},
+		{"P2SH redeem script over the element size", pushDataScript(bigRedeem), nil, p2shBig, ScriptVerifyP2SH, false},
+		{"P2SH redeem script not run before BIP16", []byte{0x01, byte(OP_0)}, nil, p2shFalse, ScriptFlagsNone, true},
+		{"P2WSH", nil, [][]byte{witnessScript}, p2wsh, ScriptVerifyWitness, true},
+		{"P2WSH wrong witness script", nil, [][]byte{{byte(OP_2)}}, p2wsh, ScriptVerifyWitness, false},
+		{"P2WSH with scriptSig", []byte{byte(OP_1)}, [][]byte{witnessScript}, p2wsh, ScriptVerifyWitness, false},
+		{"unexpected witness", nil, [][]byte{{0x01}}, []byte{byte(OP_1)}, ScriptVerifyWitness, false},
+		{"witness ignored before segwit", nil, [][]byte{{0x01}}, []byte{byte(OP_1)}, ScriptFlagsNone, true},
+		{"taproot output not enforced", nil, [][]byte{{0x01}}, p2tr, MainNetParams.BlockScriptFlags(800000, Hash256{0x01}), true},
+		{"P2WSH OP_IF", nil, [][]byte{{0x01}, ifScript}, p2wshIf, minimalIf, true},
+		{"P2WSH OP_IF false branch", nil, [][]byte{{}, ifScript}, p2wshIf, minimalIf, false},
+		{"P2WSH OP_IF non-minimal condition", nil, [][]byte{{0x02}, ifScript}, p2wshIf, minimalIf, false},
+		{"P2WSH OP_IF non-minimal without MINIMALIF", nil, [][]byte{{0x02}, ifScript}, p2wshIf, ScriptVerifyWitness, true},
+		{"legacy OP_IF ignores MINIMALIF", []byte{0x01, 0x02}, nil, ifScript, minimalIf, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{
+				Version: 2,
+				Inputs: []TxInput{{
+					PreviousOutput: OutPoint{Hash: Hash256{0x01}},
+					ScriptSig:      tt.scriptSig,
+					Sequence:       0xffffffff,
+					Witness:        tt.witness,
+				}},
+				Outputs: []TxOutput{{Value: 900, ScriptPubKey: []byte{byte(OP_1)}}},
+			}
+			prevOuts := []TxOutput{{Value: 1000, ScriptPubKey: tt.scriptPubKey}}
+
+			err := VerifyScript(tx, 0, prevOuts, nil, tt.flags)
+			if tt.valid && err != nil {
+				t.Errorf("Expected script to verify, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected script verification to fail")
+			}
+		})
+	}
+}
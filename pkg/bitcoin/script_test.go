@@ -281,6 +281,31 @@ func TestScript_IsStandard(t *testing.T) {
 	}
 }
 
+// TestScript_IsUnspendable tests which output scripts are left out of the UTXO set
+func TestScript_IsUnspendable(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   Script
+		expected bool
+	}{
+		{"empty script", Script{}, false},
+		{"P2PKH", Script{byte(OP_DUP), byte(OP_HASH160), 0x14}, false},
+		{"OP_RETURN", Script{byte(OP_RETURN)}, true},
+		{"OP_RETURN with data", Script{byte(OP_RETURN), 0x01, 0xff}, true},
+		{"OP_RETURN after another opcode", Script{byte(OP_1), byte(OP_RETURN)}, false},
+		{"largest executable script", make(Script, MaxScriptSize), false},
+		{"script over the size limit", make(Script, MaxScriptSize+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.script.IsUnspendable(); result != tt.expected {
+				t.Errorf("Expected unspendable=%v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
 // TestScript_String tests script string representation
 // TODO: Implement String() method in future TDD iteration
 /*
@@ -799,31 +824,30 @@ func TestScript_Hash160_EdgeCases(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    []byte
-		expected bool // Whether it should return ZeroHash160
+		expected string // RIPEMD160(SHA256(input))
 	}{
 		{
 			name:     "Hello input",
 			input:    []byte("Hello"),
-			expected: false, // Should return specific hash
+			expected: "578635f64c2b8b846f2b659853f6333e4148ebe8",
 		},
 		{
 			name:     "empty input",
 			input:    []byte{},
-			expected: true, // Should return ZeroHash160
+			expected: "b472a266d0bd89c13706a4132ccfb16f7c3b9fcb",
 		},
 		{
-			name:     "different input",
-			input:    []byte("World"),
-			expected: true, // Should return ZeroHash160
+			name:     "compressed public key",
+			input:    mustDecodeHex("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+			expected: "751e76e8199196d454941c45d1b3a323f1433bd6",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			result := hash160(tt.input)
-			isZero := result == ZeroHash160
-			if isZero != tt.expected {
-				t.Errorf("Expected zero hash %v, got %v for input %s", tt.expected, isZero, string(tt.input))
+			if result.String() != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
 			}
 		})
 	}
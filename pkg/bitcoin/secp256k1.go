@@ -0,0 +1,298 @@
+package bitcoin
+
+import (
+	"errors"
+	"math/big"
+)
+
+// secp256k1 curve parameters (SEC 2, section 2.4.1)
+// y^2 = x^3 + 7 over the prime field F_p
+var (
+	secp256k1P, _     = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secp256k1N, _     = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secp256k1HalfN    = new(big.Int).Rsh(secp256k1N, 1)
+	secp256k1Gx, _    = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secp256k1Gy, _    = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	secp256k1B        = big.NewInt(7)
+	secp256k1SqrtExp  = new(big.Int).Rsh(new(big.Int).Add(secp256k1P, big.NewInt(1)), 2) // (p+1)/4
+	secp256k1Infinity = jacobianPoint{x: new(big.Int), y: new(big.Int), z: new(big.Int)}
+)
+
+// Public key encoding prefixes
+const (
+	PubKeyCompressedEven = 0x02
+	PubKeyCompressedOdd  = 0x03
+	PubKeyUncompressed   = 0x04
+	PubKeyHybridEven     = 0x06
+	PubKeyHybridOdd      = 0x07
+)
+
+// PublicKey represents a point on the secp256k1 curve
+type PublicKey struct {
+	X *big.Int
+	Y *big.Int
+}
+
+// ParsePubKey parses a compressed, uncompressed or hybrid SEC1 encoded public key
+// and verifies that it lies on the secp256k1 curve
+func ParsePubKey(data []byte) (*PublicKey, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty public key")
+	}
+
+	switch data[0] {
+	case PubKeyCompressedEven, PubKeyCompressedOdd:
+		if len(data) != CompressedPubKeySize {
+			return nil, errors.New("invalid compressed public key length")
+		}
+		x := new(big.Int).SetBytes(data[1:])
+		y, err := decompressY(x, data[0] == PubKeyCompressedOdd)
+		if err != nil {
+			return nil, err
+		}
+		return &PublicKey{X: x, Y: y}, nil
+
+	case PubKeyUncompressed, PubKeyHybridEven, PubKeyHybridOdd:
+		if len(data) != UncompressedPubKeySize {
+			return nil, errors.New("invalid uncompressed public key length")
+		}
+		x := new(big.Int).SetBytes(data[1:33])
+		y := new(big.Int).SetBytes(data[33:])
+		if data[0] != PubKeyUncompressed && (y.Bit(0) == 1) != (data[0] == PubKeyHybridOdd) {
+			return nil, errors.New("hybrid public key parity mismatch")
+		}
+		if !isOnCurve(x, y) {
+			return nil, errors.New("public key is not on the secp256k1 curve")
+		}
+		return &PublicKey{X: x, Y: y}, nil
+
+	default:
+		return nil, errors.New("unknown public key encoding")
+	}
+}
+
+// SerializeCompressed returns the 33-byte compressed encoding of the public key
+func (pk *PublicKey) SerializeCompressed() []byte {
+	out := make([]byte, CompressedPubKeySize)
+	out[0] = PubKeyCompressedEven
+	if pk.Y.Bit(0) == 1 {
+		out[0] = PubKeyCompressedOdd
+	}
+	pk.X.FillBytes(out[1:])
+	return out
+}
+
+// SerializeUncompressed returns the 65-byte uncompressed encoding of the public key
+func (pk *PublicKey) SerializeUncompressed() []byte {
+	out := make([]byte, UncompressedPubKeySize)
+	out[0] = PubKeyUncompressed
+	pk.X.FillBytes(out[1:33])
+	pk.Y.FillBytes(out[33:])
+	return out
+}
+
+// VerifyECDSA verifies an ECDSA signature over a 32-byte message hash.
+// Like Bitcoin Core, both low and high S values are accepted here; low-S
+// is a script flag concern handled by the interpreter.
+func VerifyECDSA(pubKey *PublicKey, hash []byte, sig *Signature) bool {
+	if pubKey == nil || sig == nil || len(hash) != 32 {
+		return false
+	}
+
+	// r and s must be in [1, n-1]
+	if sig.R.Sign() <= 0 || sig.R.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+	if sig.S.Sign() <= 0 || sig.S.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+
+	z := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(sig.S, secp256k1N)
+	u1 := new(big.Int).Mul(z, w)
+	u1.Mod(u1, secp256k1N)
+	u2 := new(big.Int).Mul(sig.R, w)
+	u2.Mod(u2, secp256k1N)
+
+	// R = u1*G + u2*Q
+	g := jacobianPoint{x: secp256k1Gx, y: secp256k1Gy, z: big.NewInt(1)}
+	q := jacobianPoint{x: pubKey.X, y: pubKey.Y, z: big.NewInt(1)}
+	point := doubleScalarMult(u1, g, u2, q)
+	if point.isInfinity() {
+		return false
+	}
+
+	x, _ := point.toAffine()
+	x.Mod(x, secp256k1N)
+	return x.Cmp(sig.R) == 0
+}
+
+// decompressY recovers the y coordinate for x with the requested parity
+func decompressY(x *big.Int, odd bool) (*big.Int, error) {
+	if x.Cmp(secp256k1P) >= 0 {
+		return nil, errors.New("public key x coordinate out of range")
+	}
+
+	// y^2 = x^3 + 7
+	y2 := new(big.Int).Exp(x, big.NewInt(3), secp256k1P)
+	y2.Add(y2, secp256k1B)
+	y2.Mod(y2, secp256k1P)
+
+	// p = 3 mod 4, so sqrt(a) = a^((p+1)/4)
+	y := new(big.Int).Exp(y2, secp256k1SqrtExp, secp256k1P)
+	check := new(big.Int).Mul(y, y)
+	check.Mod(check, secp256k1P)
+	if check.Cmp(y2) != 0 {
+		return nil, errors.New("public key x coordinate is not on the secp256k1 curve")
+	}
+
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(secp256k1P, y)
+	}
+	return y, nil
+}
+
+// isOnCurve reports whether the affine point (x, y) satisfies the curve equation
+func isOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.Cmp(secp256k1P) >= 0 || y.Sign() < 0 || y.Cmp(secp256k1P) >= 0 {
+		return false
+	}
+	lhs := new(big.Int).Mul(y, y)
+	lhs.Mod(lhs, secp256k1P)
+	rhs := new(big.Int).Exp(x, big.NewInt(3), secp256k1P)
+	rhs.Add(rhs, secp256k1B)
+	rhs.Mod(rhs, secp256k1P)
+	return lhs.Cmp(rhs) == 0
+}
+
+// jacobianPoint is a curve point in Jacobian coordinates (X/Z^2, Y/Z^3).
+// The point at infinity is represented by z == 0.
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+func (p jacobianPoint) isInfinity() bool {
+	return p.z.Sign() == 0
+}
+
+// toAffine converts the point back to affine coordinates
+func (p jacobianPoint) toAffine() (x, y *big.Int) {
+	zInv := new(big.Int).ModInverse(p.z, secp256k1P)
+	zInv2 := new(big.Int).Mul(zInv, zInv)
+	zInv2.Mod(zInv2, secp256k1P)
+	zInv3 := new(big.Int).Mul(zInv2, zInv)
+	zInv3.Mod(zInv3, secp256k1P)
+
+	x = new(big.Int).Mul(p.x, zInv2)
+	x.Mod(x, secp256k1P)
+	y = new(big.Int).Mul(p.y, zInv3)
+	y.Mod(y, secp256k1P)
+	return x, y
+}
+
+// fieldMul returns a*b mod p
+func fieldMul(a, b *big.Int) *big.Int {
+	r := new(big.Int).Mul(a, b)
+	return r.Mod(r, secp256k1P)
+}
+
+// fieldSub returns a-b mod p
+func fieldSub(a, b *big.Int) *big.Int {
+	r := new(big.Int).Sub(a, b)
+	return r.Mod(r, secp256k1P)
+}
+
+// pointDouble doubles a point using the a=0 doubling formula (dbl-2009-l)
+func pointDouble(p jacobianPoint) jacobianPoint {
+	if p.isInfinity() || p.y.Sign() == 0 {
+		return secp256k1Infinity
+	}
+
+	a := fieldMul(p.x, p.x)
+	b := fieldMul(p.y, p.y)
+	c := fieldMul(b, b)
+
+	// D = 2*((X+B)^2 - A - C)
+	xb := new(big.Int).Add(p.x, b)
+	d := fieldSub(fieldSub(fieldMul(xb, xb), a), c)
+	d.Lsh(d, 1).Mod(d, secp256k1P)
+
+	e := new(big.Int).Mul(a, big.NewInt(3))
+	e.Mod(e, secp256k1P)
+	f := fieldMul(e, e)
+
+	// X3 = F - 2*D
+	x3 := fieldSub(f, new(big.Int).Lsh(d, 1))
+	// Y3 = E*(D - X3) - 8*C
+	y3 := fieldSub(fieldMul(e, fieldSub(d, x3)), new(big.Int).Lsh(c, 3))
+	// Z3 = 2*Y*Z
+	z3 := fieldMul(p.y, p.z)
+	z3.Lsh(z3, 1).Mod(z3, secp256k1P)
+
+	return jacobianPoint{x: x3, y: y3, z: z3}
+}
+
+// pointAdd adds two points using the add-1998-cmo-2 formula
+func pointAdd(p, q jacobianPoint) jacobianPoint {
+	if p.isInfinity() {
+		return q
+	}
+	if q.isInfinity() {
+		return p
+	}
+
+	z1z1 := fieldMul(p.z, p.z)
+	z2z2 := fieldMul(q.z, q.z)
+	u1 := fieldMul(p.x, z2z2)
+	u2 := fieldMul(q.x, z1z1)
+	s1 := fieldMul(fieldMul(p.y, q.z), z2z2)
+	s2 := fieldMul(fieldMul(q.y, p.z), z1z1)
+
+	h := fieldSub(u2, u1)
+	r := fieldSub(s2, s1)
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return pointDouble(p)
+		}
+		return secp256k1Infinity
+	}
+
+	hh := fieldMul(h, h)
+	hhh := fieldMul(h, hh)
+	v := fieldMul(u1, hh)
+
+	// X3 = r^2 - H^3 - 2*V
+	x3 := fieldSub(fieldSub(fieldMul(r, r), hhh), new(big.Int).Lsh(v, 1))
+	// Y3 = r*(V - X3) - S1*H^3
+	y3 := fieldSub(fieldMul(r, fieldSub(v, x3)), fieldMul(s1, hhh))
+	// Z3 = Z1*Z2*H
+	z3 := fieldMul(fieldMul(p.z, q.z), h)
+
+	return jacobianPoint{x: x3, y: y3, z: z3}
+}
+
+// doubleScalarMult computes k1*P + k2*Q with Shamir's trick
+func doubleScalarMult(k1 *big.Int, p jacobianPoint, k2 *big.Int, q jacobianPoint) jacobianPoint {
+	pq := pointAdd(p, q)
+	result := secp256k1Infinity
+
+	bits := k1.BitLen()
+	if k2.BitLen() > bits {
+		bits = k2.BitLen()
+	}
+
+	for i := bits - 1; i >= 0; i-- {
+		result = pointDouble(result)
+		b1, b2 := k1.Bit(i), k2.Bit(i)
+		switch {
+		case b1 == 1 && b2 == 1:
+			result = pointAdd(result, pq)
+		case b1 == 1:
+			result = pointAdd(result, p)
+		case b2 == 1:
+			result = pointAdd(result, q)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,241 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// TestParsePubKey tests SEC1 public key parsing and point decompression
+func TestParsePubKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		pubKeyHex   string
+		expectError bool
+		expectedY   string
+	}{
+		{
+			name:      "compressed generator point",
+			pubKeyHex: "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+			expectedY: "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+		},
+		{
+			name:      "compressed generator point with odd prefix",
+			pubKeyHex: "0379be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+			expectedY: "b7c52588d95c3b9aa25b0403f1eef75702e84bb7597aabe663b82f6f04ef2777",
+		},
+		{
+			name: "uncompressed generator point",
+			pubKeyHex: "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+				"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+			expectedY: "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+		},
+		{
+			name: "hybrid generator point with matching parity",
+			pubKeyHex: "0679be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+				"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+			expectedY: "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+		},
+		{
+			name: "hybrid generator point with wrong parity",
+			pubKeyHex: "0779be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+				"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+			expectError: true,
+		},
+		{
+			name: "uncompressed point not on curve",
+			pubKeyHex: "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+				"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b9",
+			expectError: true,
+		},
+		{
+			name:        "compressed x with no square root",
+			pubKeyHex:   "020000000000000000000000000000000000000000000000000000000000000005",
+			expectError: true,
+		},
+		{
+			name:        "compressed x above field prime",
+			pubKeyHex:   "03fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc30",
+			expectError: true,
+		},
+		{
+			name:        "wrong length for prefix",
+			pubKeyHex:   "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817",
+			expectError: true,
+		},
+		{
+			name:        "unknown prefix",
+			pubKeyHex:   "0579be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+			expectError: true,
+		},
+		{
+			name:        "empty key",
+			pubKeyHex:   "",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubKey, err := ParsePubKey(mustDecodeHex(tt.pubKeyHex))
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			y := hex.EncodeToString(pubKey.Y.FillBytes(make([]byte, 32)))
+			if y != tt.expectedY {
+				t.Errorf("Expected y %s, got %s", tt.expectedY, y)
+			}
+		})
+	}
+}
+
+// TestPublicKey_Serialize tests round-tripping public key encodings
+func TestPublicKey_Serialize(t *testing.T) {
+	compressed := mustDecodeHex("0379be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	pubKey, err := ParsePubKey(compressed)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+
+	if !bytes.Equal(pubKey.SerializeCompressed(), compressed) {
+		t.Errorf("Compressed round trip mismatch: %x", pubKey.SerializeCompressed())
+	}
+
+	reparsed, err := ParsePubKey(pubKey.SerializeUncompressed())
+	if err != nil {
+		t.Fatalf("Failed to parse uncompressed encoding: %v", err)
+	}
+	if reparsed.X.Cmp(pubKey.X) != 0 || reparsed.Y.Cmp(pubKey.Y) != 0 {
+		t.Error("Uncompressed round trip produced a different point")
+	}
+}
+
+// TestVerifyECDSA tests signature verification against an OpenSSL generated vector
+func TestVerifyECDSA(t *testing.T) {
+	pubKeyBytes := mustDecodeHex("04b069f5eaf08c1979ff69c029ce4f0e61dbc2f099e2c27689e1de62dcd91e81bc" +
+		"6e781b58c2d98091a4f511fb2782881dd6acd6a817d4b6ce31f77a403441e5c6")
+	hash := mustDecodeHex("7521e7fb04c29ccc70016021179840f0a6c381628438aa778f59eaa91a24c3fc")
+	der := mustDecodeHex("3045022100b06fd17879cefbb8891b08ea3837dd206cc2a0b56dd4534549e8e88cc4fe7cdb" +
+		"0220754cd0964dbd7fe2a3f4c7d4d16a3d78f43efa8d58a70871e2a5e452880ed1f1")
+
+	pubKey, err := ParsePubKey(pubKeyBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	sig, err := ParseDERSignature(der)
+	if err != nil {
+		t.Fatalf("Failed to parse signature: %v", err)
+	}
+
+	if !VerifyECDSA(pubKey, hash, sig) {
+		t.Error("Valid signature failed verification")
+	}
+
+	// Compressed encoding of the same key must verify too
+	compressed, err := ParsePubKey(pubKey.SerializeCompressed())
+	if err != nil {
+		t.Fatalf("Failed to parse compressed key: %v", err)
+	}
+	if !VerifyECDSA(compressed, hash, sig) {
+		t.Error("Valid signature failed verification with compressed key")
+	}
+
+	// High-S form of the same signature is still mathematically valid
+	highS := &Signature{R: sig.R, S: new(big.Int).Sub(secp256k1N, sig.S)}
+	if highS.IsLowS() {
+		t.Error("Expected negated S to be high")
+	}
+	if !VerifyECDSA(pubKey, hash, highS) {
+		t.Error("High-S signature failed verification")
+	}
+
+	// Tampered message
+	tampered := append([]byte{}, hash...)
+	tampered[0] ^= 0x01
+	if VerifyECDSA(pubKey, tampered, sig) {
+		t.Error("Signature verified against a different message")
+	}
+
+	// Wrong key
+	generator, _ := ParsePubKey(mustDecodeHex("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"))
+	if VerifyECDSA(generator, hash, sig) {
+		t.Error("Signature verified against the wrong public key")
+	}
+
+	// Out of range components
+	for _, bad := range []*Signature{
+		{R: new(big.Int), S: sig.S},
+		{R: sig.R, S: new(big.Int)},
+		{R: new(big.Int).Set(secp256k1N), S: sig.S},
+		{R: sig.R, S: new(big.Int).Set(secp256k1N)},
+	} {
+		if VerifyECDSA(pubKey, hash, bad) {
+			t.Errorf("Out of range signature verified: r=%x s=%x", bad.R, bad.S)
+		}
+	}
+
+	if VerifyECDSA(pubKey, hash[:31], sig) {
+		t.Error("Signature verified against a short message hash")
+	}
+}
+
+// TestVerifyECDSA_SignRoundTrip tests verification of locally produced signatures
+func TestVerifyECDSA_SignRoundTrip(t *testing.T) {
+	for i := int64(1); i <= 5; i++ {
+		priv := big.NewInt(i * 0x1234567)
+		hash := sha256.Sum256([]byte{byte(i)})
+
+		sig := signTestHash(priv, hash[:])
+		if !sig.IsLowS() {
+			t.Errorf("Key %d: expected low-S signature", i)
+		}
+		if !VerifyECDSA(testPubKey(priv), hash[:], sig) {
+			t.Errorf("Key %d: signature failed verification", i)
+		}
+
+		reparsed, err := ParseDERSignature(sig.Serialize())
+		if err != nil {
+			t.Fatalf("Key %d: failed to parse serialized signature: %v", i, err)
+		}
+		if reparsed.R.Cmp(sig.R) != 0 || reparsed.S.Cmp(sig.S) != 0 {
+			t.Errorf("Key %d: DER round trip mismatch", i)
+		}
+	}
+}
+
+// testPubKey derives the public key for a private key scalar
+func testPubKey(priv *big.Int) *PublicKey {
+	g := jacobianPoint{x: secp256k1Gx, y: secp256k1Gy, z: big.NewInt(1)}
+	x, y := doubleScalarMult(priv, g, new(big.Int), secp256k1Infinity).toAffine()
+	return &PublicKey{X: x, Y: y}
+}
+
+// signTestHash produces a low-S ECDSA signature with a deterministic nonce.
+// It is only suitable for tests.
+func signTestHash(priv *big.Int, hash []byte) *Signature {
+	nonceSeed := sha256.Sum256(append(priv.FillBytes(make([]byte, 32)), hash...))
+	k := new(big.Int).SetBytes(nonceSeed[:])
+	k.Mod(k, secp256k1N)
+
+	r := testPubKey(k).X
+	r.Mod(r, secp256k1N)
+
+	s := new(big.Int).Mul(r, priv)
+	s.Add(s, new(big.Int).SetBytes(hash))
+	s.Mul(s, new(big.Int).ModInverse(k, secp256k1N))
+	s.Mod(s, secp256k1N)
+	if s.Cmp(secp256k1HalfN) > 0 {
+		s.Sub(secp256k1N, s)
+	}
+
+	return &Signature{R: r, S: s}
+}
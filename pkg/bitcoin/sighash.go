@@ -0,0 +1,234 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// sigHashMask selects the base hash type (ALL, NONE, SINGLE) from a hash type
+const sigHashMask = 0x1f
+
+// sigHashOne is the digest returned for SIGHASH_SINGLE when the input has no
+// matching output. Consensus signs this constant instead of failing, so it
+// must be reproduced exactly.
+var sigHashOne = Hash256{0x01}
+
+// LegacySigHash computes the pre-segwit signature hash for an input.
+//
+// The subscript is the script being executed from the last executed
+// OP_CODESEPARATOR onwards, with the signature already removed by FindAndDelete.
+// Remaining OP_CODESEPARATOR opcodes are stripped here, as Bitcoin Core does.
+// The result is in internal byte order, i.e. exactly the 32 bytes that are signed.
+func (tx *Transaction) LegacySigHash(inputIndex int, subscript []byte, hashType SigHashType) (Hash256, error) {
+	if inputIndex < 0 || inputIndex >= len(tx.Inputs) {
+		return ZeroHash, fmt.Errorf("input index %d out of range", inputIndex)
+	}
+
+	baseType := hashType & sigHashMask
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+
+	// SIGHASH_SINGLE without a corresponding output signs the constant one
+	if baseType == SigHashSingle && inputIndex >= len(tx.Outputs) {
+		return sigHashOne, nil
+	}
+
+	var buf bytes.Buffer
+
+	// Version (4 bytes, little-endian)
+	writeUint32LE(&buf, tx.Version)
+
+	// Inputs: only the signed input with ANYONECANPAY, otherwise all of them
+	inputs := tx.Inputs
+	firstIndex := 0
+	if anyoneCanPay {
+		inputs = tx.Inputs[inputIndex : inputIndex+1]
+		firstIndex = inputIndex
+	}
+	buf.Write(EncodeVarInt(uint64(len(inputs))))
+	for i, input := range inputs {
+		idx := firstIndex + i
+		writeOutPoint(&buf, input.PreviousOutput)
+
+		// Only the signed input carries the script code
+		if idx == inputIndex {
+			script := removeCodeSeparators(subscript)
+			buf.Write(EncodeVarInt(uint64(len(script))))
+			buf.Write(script)
+		} else {
+			buf.Write(EncodeVarInt(0))
+		}
+
+		// Other inputs' sequences are zeroed for NONE and SINGLE
+		sequence := input.Sequence
+		if idx != inputIndex && (baseType == SigHashNone || baseType == SigHashSingle) {
+			sequence = 0
+		}
+		writeUint32LE(&buf, sequence)
+	}
+
+	// Outputs
+	switch baseType {
+	case SigHashNone:
+		buf.Write(EncodeVarInt(0))
+	case SigHashSingle:
+		// Outputs before the signed one are blanked to value -1 with an empty script
+		buf.Write(EncodeVarInt(uint64(inputIndex + 1)))
+		for i := 0; i < inputIndex; i++ {
+			writeUint64LE(&buf, 0xffffffffffffffff)
+			buf.Write(EncodeVarInt(0))
+		}
+		writeTxOutput(&buf, tx.Outputs[inputIndex])
+	default:
+		buf.Write(EncodeVarInt(uint64(len(tx.Outputs))))
+		for _, output := range tx.Outputs {
+			writeTxOutput(&buf, output)
+		}
+	}
+
+	// Locktime and the full 4-byte hash type
+	writeUint32LE(&buf, tx.LockTime)
+	writeUint32LE(&buf, uint32(hashType))
+
+	return DoubleHashSHA256(buf.Bytes()), nil
+}
+
+// removeCodeSeparators returns the script with every OP_CODESEPARATOR removed.
+// Parsing stops at the first malformed push; the remainder is kept verbatim.
+func removeCodeSeparators(script []byte) []byte {
+	result := make([]byte, 0, len(script))
+	start := 0
+	for pc := 0; pc < len(script); {
+		opcode, _, next, err := parseScriptOp(script, pc)
+		if err != nil {
+			break
+		}
+		if opcode == OP_CODESEPARATOR {
+			result = append(result, script[start:pc]...)
+			start = next
+		}
+		pc = next
+	}
+	return append(result, script[start:]...)
+}
+
+// writeOutPoint writes an outpoint in wire format (hash reversed)
+func writeOutPoint(buf *bytes.Buffer, op OutPoint) {
+	for i := len(op.Hash) - 1; i >= 0; i-- {
+		buf.WriteByte(op.Hash[i])
+	}
+	writeUint32LE(buf, op.Index)
+}
+
+// writeTxOutput writes an output in wire format
+func writeTxOutput(buf *bytes.Buffer, output TxOutput) {
+	writeUint64LE(buf, output.Value)
+	buf.Write(EncodeVarInt(uint64(len(output.ScriptPubKey))))
+	buf.Write(output.ScriptPubKey)
+}
+
+func writeUint32LE(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64LE(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+// TxSigHashes holds the BIP143 midstates that are identical for every input
+// of a transaction. Computing them once keeps segwit signature hashing linear
+// in the transaction size instead of quadratic.
+type TxSigHashes struct {
+	HashPrevouts Hash256 // Double SHA-256 of all input outpoints
+	HashSequence Hash256 // Double SHA-256 of all input sequences
+	HashOutputs  Hash256 // Double SHA-256 of all outputs
+}
+
+// NewTxSigHashes computes the BIP143 midstates for a transaction. They are
+// only valid while the transaction's inputs and outputs are left unchanged.
+func NewTxSigHashes(tx *Transaction) *TxSigHashes {
+	var prevouts, sequences, outputs bytes.Buffer
+	for _, input := range tx.Inputs {
+		writeOutPoint(&prevouts, input.PreviousOutput)
+		writeUint32LE(&sequences, input.Sequence)
+	}
+	for _, output := range tx.Outputs {
+		writeTxOutput(&outputs, output)
+	}
+
+	return &TxSigHashes{
+		HashPrevouts: DoubleHashSHA256(prevouts.Bytes()),
+		HashSequence: DoubleHashSHA256(sequences.Bytes()),
+		HashOutputs:  DoubleHashSHA256(outputs.Bytes()),
+	}
+}
+
+// WitnessV0SigHash computes the BIP143 signature hash for a segwit version 0 input.
+//
+// Unlike the legacy algorithm the script code is committed to verbatim (no
+// FindAndDelete, no OP_CODESEPARATOR stripping) together with the amount of
+// the output being spent. For P2WPKH the script code is the equivalent P2PKH
+// script, see WitnessPubKeyHashScriptCode; for P2WSH it is the witness script
+// from the last executed OP_CODESEPARATOR onwards. midstates are the
+// transaction's NewTxSigHashes, shared between its inputs; if nil they are
+// computed for this call.
+// The result is in internal byte order, i.e. exactly the 32 bytes that are signed.
+func (tx *Transaction) WitnessV0SigHash(midstates *TxSigHashes, inputIndex int, scriptCode []byte, amount uint64,
+	hashType SigHashType) (Hash256, error) {
+	if inputIndex < 0 || inputIndex >= len(tx.Inputs) {
+		return ZeroHash, fmt.Errorf("input index %d out of range", inputIndex)
+	}
+
+	baseType := hashType & sigHashMask
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+	if midstates == nil {
+		midstates = NewTxSigHashes(tx)
+	}
+
+	var hashPrevouts, hashSequence, hashOutputs Hash256
+	if !anyoneCanPay {
+		hashPrevouts = midstates.HashPrevouts
+	}
+	if !anyoneCanPay && baseType != SigHashSingle && baseType != SigHashNone {
+		hashSequence = midstates.HashSequence
+	}
+	switch {
+	case baseType != SigHashSingle && baseType != SigHashNone:
+		hashOutputs = midstates.HashOutputs
+	case baseType == SigHashSingle && inputIndex < len(tx.Outputs):
+		// Only the output matching this input is committed to
+		var buf bytes.Buffer
+		writeTxOutput(&buf, tx.Outputs[inputIndex])
+		hashOutputs = DoubleHashSHA256(buf.Bytes())
+	}
+
+	input := tx.Inputs[inputIndex]
+	var buf bytes.Buffer
+
+	writeUint32LE(&buf, tx.Version)
+	buf.Write(hashPrevouts[:])
+	buf.Write(hashSequence[:])
+	writeOutPoint(&buf, input.PreviousOutput)
+	buf.Write(EncodeVarInt(uint64(len(scriptCode))))
+	buf.Write(scriptCode)
+	writeUint64LE(&buf, amount)
+	writeUint32LE(&buf, input.Sequence)
+	buf.Write(hashOutputs[:])
+	writeUint32LE(&buf, tx.LockTime)
+	writeUint32LE(&buf, uint32(hashType))
+
+	return DoubleHashSHA256(buf.Bytes()), nil
+}
+
+// WitnessPubKeyHashScriptCode returns the BIP143 script code for a P2WPKH
+// program: OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func WitnessPubKeyHashScriptCode(pubKeyHash []byte) Script {
+	script := make(Script, 0, 25)
+	script = append(script, byte(OP_DUP), byte(OP_HASH160), byte(len(pubKeyHash)))
+	script = append(script, pubKeyHash...)
+	return append(script, byte(OP_EQUALVERIFY), byte(OP_CHECKSIG))
+}
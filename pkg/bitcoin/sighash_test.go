@@ -0,0 +1,476 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// Mainnet transaction f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16
+// (block 170) spending the block 9 coinbase output with a P2PK signature
+const (
+	block170SpendHex = "0100000001c997a5e56e104102fa209c6a852dd90660a20b2d9c352423edce25857fcd3704000000004847" +
+		"304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4" +
+		"acdd12909d831cc56cbbac4622082221a8768d1d0901ffffffff0200ca9a3b00000000434104ae1a62fe09c5f51b13905f" +
+		"07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f" +
+		"7e6cd84cac00286bee0000000043410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2" +
+		"e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac00000000"
+	block9CoinbaseScriptHex = "410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf97444" +
+		"64f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac"
+)
+
+// TestTransaction_LegacySigHash_Mainnet verifies the first person-to-person
+// transaction signature against our legacy sighash
+func TestTransaction_LegacySigHash_Mainnet(t *testing.T) {
+	tx, err := DeserializeTransaction(mustDecodeHex(block170SpendHex))
+	if err != nil {
+		t.Fatalf("Failed to deserialize transaction: %v", err)
+	}
+	prevScript := Script(mustDecodeHex(block9CoinbaseScriptHex))
+
+	sigHash, err := tx.LegacySigHash(0, prevScript, SigHashAll)
+	if err != nil {
+		t.Fatalf("Failed to compute sighash: %v", err)
+	}
+
+	// scriptSig is a single push of the signature
+	sigBytes := tx.Inputs[0].ScriptSig[1:]
+	sig, err := ParseDERSignature(sigBytes[:len(sigBytes)-1])
+	if err != nil {
+		t.Fatalf("Failed to parse signature: %v", err)
+	}
+	pubKey, err := ParsePubKey(prevScript[1:66])
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+
+	if !VerifyECDSA(pubKey, sigHash[:], sig) {
+		t.Errorf("Mainnet signature failed verification against sighash %x", sigHash)
+	}
+
+	// The same spend through the script engine
+	prevOuts := []TxOutput{{Value: 5000000000, ScriptPubKey: prevScript}}
+	flags := ScriptVerifyP2SH | ScriptVerifyDERSig | ScriptVerifyStrictEnc
+	if !runScriptPair(t, tx.Inputs[0].ScriptSig, prevScript, tx, 0, prevOuts, flags) {
+		t.Error("Script engine rejected mainnet P2PK spend")
+	}
+
+	// Any change to the transaction invalidates the signature
+	tx.Outputs[0].Value--
+	tx.hash = nil
+	if runScriptPair(t, tx.Inputs[0].ScriptSig, prevScript, tx, 0, prevOuts, flags) {
+		t.Error("Script engine accepted signature for modified transaction")
+	}
+}
+
+// TestTransaction_LegacySigHash_HashTypes tests which fields each hash type commits to
+func TestTransaction_LegacySigHash_HashTypes(t *testing.T) {
+	subscript := Script(mustDecodeHex(block9CoinbaseScriptHex))
+
+	tests := []struct {
+		name        string
+		hashType    SigHashType
+		mutate      func(tx *Transaction)
+		expectEqual bool
+	}{
+		{"ALL commits to outputs", SigHashAll, func(tx *Transaction) { tx.Outputs[1].Value++ }, false},
+		{"ALL commits to other inputs", SigHashAll, func(tx *Transaction) { tx.Inputs[1].Sequence = 7 }, false},
+		{"NONE ignores outputs", SigHashNone, func(tx *Transaction) { tx.Outputs = tx.Outputs[:1] }, true},
+		{"NONE ignores other sequences", SigHashNone, func(tx *Transaction) { tx.Inputs[1].Sequence = 7 }, true},
+		{"NONE commits to other prevouts", SigHashNone, func(tx *Transaction) { tx.Inputs[1].PreviousOutput.Index = 9 }, false},
+		{"SINGLE ignores other outputs", SigHashSingle, func(tx *Transaction) { tx.Outputs[1].Value++ }, true},
+		{"SINGLE commits to its output", SigHashSingle, func(tx *Transaction) { tx.Outputs[0].Value++ }, false},
+		{"SINGLE ignores other sequences", SigHashSingle, func(tx *Transaction) { tx.Inputs[1].Sequence = 7 }, true},
+		{"ANYONECANPAY ignores other inputs", SigHashAll | SigHashAnyoneCanPay,
+			func(tx *Transaction) { tx.Inputs = tx.Inputs[:1] }, true},
+		{"ANYONECANPAY commits to own sequence", SigHashAll | SigHashAnyoneCanPay,
+			func(tx *Transaction) { tx.Inputs[0].Sequence = 7 }, false},
+		{"hash type is committed", SigHashAll, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := createSigHashTestTransaction()
+			before, err := tx.LegacySigHash(0, subscript, tt.hashType)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			otherType := tt.hashType
+			if tt.mutate != nil {
+				tt.mutate(tx)
+			} else {
+				otherType |= 0x40 // undefined bits are still hashed
+			}
+
+			after, err := tx.LegacySigHash(0, subscript, otherType)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if (before == after) != tt.expectEqual {
+				t.Errorf("Expected equal=%v, got before=%x after=%x", tt.expectEqual, before, after)
+			}
+		})
+	}
+}
+
+// TestTransaction_LegacySigHash_SingleBug tests the SIGHASH_SINGLE "one" quirk
+func TestTransaction_LegacySigHash_SingleBug(t *testing.T) {
+	tx := createSigHashTestTransaction()
+	tx.Outputs = tx.Outputs[:1]
+
+	sigHash, err := tx.LegacySigHash(1, Script{byte(OP_1)}, SigHashSingle)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := Hash256{0x01}
+	if sigHash != expected {
+		t.Errorf("Expected %x, got %x", expected, sigHash)
+	}
+
+	if _, err := tx.LegacySigHash(2, Script{byte(OP_1)}, SigHashAll); err == nil {
+		t.Error("Expected error for out of range input index")
+	}
+}
+
+// TestTransaction_LegacySigHash_CodeSeparator tests that OP_CODESEPARATOR is stripped
+func TestTransaction_LegacySigHash_CodeSeparator(t *testing.T) {
+	tx := createSigHashTestTransaction()
+
+	plain := Script{byte(OP_1), byte(OP_DROP), byte(OP_1)}
+	withSeparators := Script{byte(OP_CODESEPARATOR), byte(OP_1), byte(OP_CODESEPARATOR), byte(OP_DROP), byte(OP_1)}
+
+	h1, _ := tx.LegacySigHash(0, plain, SigHashAll)
+	h2, _ := tx.LegacySigHash(0, withSeparators, SigHashAll)
+	if h1 != h2 {
+		t.Error("OP_CODESEPARATOR should not be part of the hashed script code")
+	}
+
+	// 0xab inside a push is data, not a separator
+	pushed := Script{0x01, byte(OP_CODESEPARATOR)}
+	if got := removeCodeSeparators(pushed); !bytes.Equal(got, pushed) {
+		t.Errorf("Pushed 0xab was removed: %x", got)
+	}
+}
+
+// TestScript_FindAndDelete tests removal of pushes on opcode boundaries
+func TestScript_FindAndDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		pattern  string
+		expected string
+	}{
+		{"single match", "0302ff03", "0302ff03", ""},
+		{"repeated match", "0302ff030302ff03", "0302ff03", ""},
+		{"pattern inside push", "0302ff030302ff03", "02", "0302ff030302ff03"},
+		{"pattern inside push data", "0302ff030302ff03", "ff", "0302ff030302ff03"},
+		{"opcode prefix of push", "0302ff030302ff03", "03", "02ff0302ff03"},
+		{"straddling match", "0302ff030302ff03", "ff03", "0302ff030302ff03"},
+		{"no match", "51525354", "0155", "51525354"},
+		{"empty pattern", "51", "", "51"},
+		{"match before malformed push", "0151" + "4c", "0151", "4c"},
+		{"pushdata1 match", "4c0155" + "51", "4c0155", "51"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Script(mustDecodeHex(tt.script)).FindAndDelete(mustDecodeHex(tt.pattern))
+			if !bytes.Equal(got, mustDecodeHex(tt.expected)) {
+				t.Errorf("Expected %s, got %x", tt.expected, []byte(got))
+			}
+		})
+	}
+}
+
+// TestScriptEngine_CheckSigCodeSeparator tests signing the script after OP_CODESEPARATOR
+func TestScriptEngine_CheckSigCodeSeparator(t *testing.T) {
+	priv := big.NewInt(0xc0ffee)
+	pubKey := testPubKey(priv).SerializeCompressed()
+
+	// <pubkey> OP_CODESEPARATOR OP_CHECKSIG: only OP_CHECKSIG is signed
+	tail := Script{byte(OP_CHECKSIG)}
+	scriptPubKey := append(pushDataScript(pubKey), byte(OP_CODESEPARATOR), byte(OP_CHECKSIG))
+
+	tests := []struct {
+		name       string
+		signedCode Script
+		expected   bool
+	}{
+		{"signature over code after separator", tail, true},
+		{"signature over full script", scriptPubKey, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := createSigHashTestTransaction()
+			scriptSig := signLegacyInput(t, tx, 0, tt.signedCode, SigHashAll, priv)
+			prevOuts := []TxOutput{{Value: 1000, ScriptPubKey: scriptPubKey}}
+
+			if got := runScriptPair(t, scriptSig, scriptPubKey, tx, 0, prevOuts, ScriptVerifyStrictEnc); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// createSigHashTestTransaction builds a two-input, two-output transaction
+func createSigHashTestTransaction() *Transaction {
+	return NewTransaction(1,
+		[]TxInput{
+			{PreviousOutput: OutPoint{Hash: Hash256{0x01}, Index: 0}, Sequence: 0xffffffff},
+			{PreviousOutput: OutPoint{Hash: Hash256{0x02}, Index: 1}, Sequence: 0xfffffffe},
+		},
+		[]TxOutput{
+			{Value: 1000, ScriptPubKey: []byte{byte(OP_1)}},
+			{Value: 2000, ScriptPubKey: []byte{byte(OP_2)}},
+		},
+		0)
+}
+
+// signLegacyInput signs input idx over scriptCode and returns a scriptSig pushing the signature
+func signLegacyInput(t *testing.T, tx *Transaction, idx int, scriptCode Script, hashType SigHashType,
+	priv *big.Int) []byte {
+	t.Helper()
+	sigHash, err := tx.LegacySigHash(idx, scriptCode, hashType)
+	if err != nil {
+		t.Fatalf("Failed to compute sighash: %v", err)
+	}
+	sig := append(signTestHash(priv, sigHash[:]).Serialize(), byte(hashType))
+	return pushDataScript(sig)
+}
+
+// runScriptPair executes scriptSig followed by scriptPubKey and reports
+// whether execution succeeded with a true value on top of the stack
+func runScriptPair(t *testing.T, scriptSig, scriptPubKey []byte, tx *Transaction, idx int,
+	prevOuts []TxOutput, flags ScriptFlags) bool {
+	t.Helper()
+	engine := NewScriptEngine(scriptSig, tx, idx, prevOuts, flags)
+	if ok, err := engine.Execute(); !ok {
+		t.Logf("scriptSig failed: %v", err)
+		return false
+	}
+	engine.SetScript(scriptPubKey)
+	if ok, err := engine.Execute(); !ok {
+		t.Logf("scriptPubKey failed: %v", err)
+		return false
+	}
+	stack := engine.GetStack()
+	return len(stack) > 0 && engine.isTrue(stack[len(stack)-1])
+}
+
+// BIP143 native P2WPKH example: the second input spends 6 BTC from
+// 00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1
+const (
+	bip143UnsignedTxHex = "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000ee" +
+		"ffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb2060000" +
+		"00001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2" +
+		"d50ce2f0167faa815988ac11000000"
+	bip143PubKeyHex = "025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee6357"
+	bip143SigHex    = "304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406" +
+		"f90300e8f3358f51928d43c212a8caed02de67eebee"
+)
+
+// TestTransaction_WitnessV0SigHash_BIP143 tests the digest against the BIP143 P2WPKH example
+func TestTransaction_WitnessV0SigHash_BIP143(t *testing.T) {
+	tx, err := DeserializeTransaction(mustDecodeHex(bip143UnsignedTxHex))
+	if err != nil {
+		t.Fatalf("Failed to deserialize transaction: %v", err)
+	}
+
+	midstates := NewTxSigHashes(tx)
+	expectedMidstates := map[string]struct{ got, expected string }{
+		"hashPrevouts": {hex.EncodeToString(midstates.HashPrevouts[:]),
+			"96b827c8483d4e9b96712b6713a7b68d6e8003a781feba36c31143470b4efd37"},
+		"hashSequence": {hex.EncodeToString(midstates.HashSequence[:]),
+			"52b0a642eea2fb7ae638c36f6252b6750293dbe574a806984b8e4d8548339a3b"},
+		"hashOutputs": {hex.EncodeToString(midstates.HashOutputs[:]),
+			"863ef3e1a92afbfdb97f31ad0fc7683ee943e9abcf2501590ff8f6551f47e5e5"},
+	}
+	for name, m := range expectedMidstates {
+		if m.got != m.expected {
+			t.Errorf("Expected %s %s, got %s", name, m.expected, m.got)
+		}
+	}
+
+	scriptCode := WitnessPubKeyHashScriptCode(mustDecodeHex("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1"))
+	if hex.EncodeToString(scriptCode) != "76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac" {
+		t.Errorf("Unexpected P2WPKH script code %x", scriptCode)
+	}
+
+	sigHash, err := tx.WitnessV0SigHash(nil, 1, scriptCode, 600000000, SigHashAll)
+	if err != nil {
+		t.Fatalf("Failed to compute sighash: %v", err)
+	}
+	expected := "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670"
+	if got := hex.EncodeToString(sigHash[:]); got != expected {
+		t.Errorf("Expected sighash %s, got %s", expected, got)
+	}
+
+	pubKey, err := ParsePubKey(mustDecodeHex(bip143PubKeyHex))
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	sig, err := ParseDERSignature(mustDecodeHex(bip143SigHex))
+	if err != nil {
+		t.Fatalf("Failed to parse signature: %v", err)
+	}
+	if !VerifyECDSA(pubKey, sigHash[:], sig) {
+		t.Error("BIP143 example signature failed verification")
+	}
+
+	// The amount is committed to
+	other, _ := tx.WitnessV0SigHash(nil, 1, scriptCode, 600000001, SigHashAll)
+	if other == sigHash {
+		t.Error("Expected sighash to change with the spent amount")
+	}
+
+	if _, err := tx.WitnessV0SigHash(nil, 2, scriptCode, 0, SigHashAll); err == nil {
+		t.Error("Expected error for out of range input index")
+	}
+}
+
+// TestTransaction_WitnessV0SigHash_HashTypes tests which parts of the
+// transaction each hash type commits to
+func TestTransaction_WitnessV0SigHash_HashTypes(t *testing.T) {
+	scriptCode := Script{byte(OP_TRUE)}
+
+	tests := []struct {
+		name           string
+		hashType       SigHashType
+		mutate         func(tx *Transaction)
+		expectedChange bool
+	}{
+		{"ALL commits to other input sequence", SigHashAll,
+			func(tx *Transaction) { tx.Inputs[1].Sequence = 7 }, true},
+		{"ALL commits to other outputs", SigHashAll,
+			func(tx *Transaction) { tx.Outputs[1].Value++ }, true},
+		{"NONE ignores other input sequence", SigHashNone,
+			func(tx *Transaction) { tx.Inputs[1].Sequence = 7 }, false},
+		{"NONE ignores outputs", SigHashNone,
+			func(tx *Transaction) { tx.Outputs[0].Value++ }, false},
+		{"NONE commits to other outpoints", SigHashNone,
+			func(tx *Transaction) { tx.Inputs[1].PreviousOutput.Index++ }, true},
+		{"SINGLE commits to matching output", SigHashSingle,
+			func(tx *Transaction) { tx.Outputs[0].Value++ }, true},
+		{"SINGLE ignores other outputs", SigHashSingle,
+			func(tx *Transaction) { tx.Outputs[1].Value++ }, false},
+		{"ANYONECANPAY ignores other outpoints", SigHashAll | SigHashAnyoneCanPay,
+			func(tx *Transaction) { tx.Inputs[1].PreviousOutput.Index++ }, false},
+		{"ANYONECANPAY commits to outputs", SigHashAll | SigHashAnyoneCanPay,
+			func(tx *Transaction) { tx.Outputs[1].Value++ }, true},
+		{"own sequence always committed", SigHashNone | SigHashAnyoneCanPay,
+			func(tx *Transaction) { tx.Inputs[0].Sequence = 7 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := createSigHashTestTransaction()
+			before, err := tx.WitnessV0SigHash(nil, 0, scriptCode, 1000, tt.hashType)
+			if err != nil {
+				t.Fatalf("Failed to compute sighash: %v", err)
+			}
+
+			mutated := createSigHashTestTransaction()
+			tt.mutate(mutated)
+			after, err := mutated.WitnessV0SigHash(nil, 0, scriptCode, 1000, tt.hashType)
+			if err != nil {
+				t.Fatalf("Failed to compute sighash: %v", err)
+			}
+
+			if changed := before != after; changed != tt.expectedChange {
+				t.Errorf("Expected change %v, got %v", tt.expectedChange, changed)
+			}
+		})
+	}
+
+	// SIGHASH_SINGLE without a matching output has no legacy bug: it signs a zero hashOutputs
+	tx := createSigHashTestTransaction()
+	tx.Outputs = tx.Outputs[:1]
+	sigHash, err := tx.WitnessV0SigHash(nil, 1, scriptCode, 1000, SigHashSingle)
+	if err != nil {
+		t.Fatalf("Failed to compute sighash: %v", err)
+	}
+	if sigHash == sigHashOne {
+		t.Error("Expected BIP143 SIGHASH_SINGLE to not reproduce the legacy bug")
+	}
+
+	// Nothing is cached on the transaction, so hashing after a change sees it
+	tx = createSigHashTestTransaction()
+	before, _ := tx.WitnessV0SigHash(nil, 0, scriptCode, 1000, SigHashAll)
+	tx.Outputs[1].Value++
+	after, _ := tx.WitnessV0SigHash(nil, 0, scriptCode, 1000, SigHashAll)
+	if before == after {
+		t.Error("Expected the sighash to commit to outputs changed after an earlier hash")
+	}
+}
+
+// TestScriptEngine_WitnessV0CheckSig tests OP_CHECKSIG under witness v0 hashing
+func TestScriptEngine_WitnessV0CheckSig(t *testing.T) {
+	priv := big.NewInt(0xb143)
+	compressed := testPubKey(priv).SerializeCompressed()
+	uncompressed := testPubKey(priv).SerializeUncompressed()
+	prevOuts := []TxOutput{{Value: 50000}, {Value: 60000}}
+
+	witnessScript := func(pubKey []byte) Script {
+		return append(pushDataScript(pubKey), byte(OP_CHECKSIG))
+	}
+	sign := func(tx *Transaction, scriptCode Script, amount uint64) []byte {
+		sigHash, err := tx.WitnessV0SigHash(nil, 1, scriptCode, amount, SigHashAll)
+		if err != nil {
+			t.Fatalf("Failed to compute sighash: %v", err)
+		}
+		return append(signTestHash(priv, sigHash[:]).Serialize(), byte(SigHashAll))
+	}
+
+	tests := []struct {
+		name     string
+		pubKey   []byte
+		amount   uint64
+		flags    ScriptFlags
+		legacy   bool
+		expected bool
+	}{
+		{"valid signature", compressed, 60000, ScriptFlagsNone, false, true},
+		{"signature over wrong amount", compressed, 59999, ScriptFlagsNone, false, false},
+		{"legacy signature in witness script", compressed, 60000, ScriptFlagsNone, true, false},
+		{"uncompressed key without WITNESS_PUBKEYTYPE", uncompressed, 60000, ScriptFlagsNone, false, true},
+		{"uncompressed key with WITNESS_PUBKEYTYPE", uncompressed, 60000, ScriptVerifyWitnessPubkeyType, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := createSigHashTestTransaction()
+			script := witnessScript(tt.pubKey)
+
+			var sig []byte
+			if tt.legacy {
+				sig = signLegacyInput(t, tx, 1, script, SigHashAll, priv)[1:]
+			} else {
+				sig = sign(tx, script, tt.amount)
+			}
+
+			engine := NewWitnessV0ScriptEngine(script, [][]byte{sig}, tx, 1, prevOuts, tt.flags)
+			ok, err := engine.Execute()
+			stack := engine.GetStack()
+			result := ok && len(stack) > 0 && engine.isTrue(stack[len(stack)-1])
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v (err: %v)", tt.expected, result, err)
+			}
+		})
+	}
+
+	// Without the spent output the amount is unknown and nothing verifies
+	tx := createSigHashTestTransaction()
+	script := witnessScript(compressed)
+	engine := NewWitnessV0ScriptEngine(script, [][]byte{sign(tx, script, 60000)}, tx, 1, nil, ScriptFlagsNone)
+	if ok, _ := engine.Execute(); ok {
+		stack := engine.GetStack()
+		if len(stack) > 0 && engine.isTrue(stack[len(stack)-1]) {
+			t.Error("Expected verification to fail without previous outputs")
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SigHashType represents the signature hash type appended to a signature
+type SigHashType uint32
+
+// Signature hash types
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+// Signature encoding limits (BIP66)
+const (
+	MinDERSignatureSize = 8  // 0x30 len 0x02 1 r 0x02 1 s
+	MaxDERSignatureSize = 72 // 0x30 len 0x02 33 r 0x02 33 s
+)
+
+// Signature represents an ECDSA signature
+type Signature struct {
+	R *big.Int
+	S *big.Int
+}
+
+// ParseDERSignature parses a strictly DER encoded signature (without the
+// trailing sighash byte) as required by BIP66
+func ParseDERSignature(der []byte) (*Signature, error) {
+	if err := checkDEREncoding(der); err != nil {
+		return nil, err
+	}
+
+	lenR := int(der[3])
+	r := new(big.Int).SetBytes(der[4 : 4+lenR])
+	s := new(big.Int).SetBytes(der[6+lenR:])
+
+	if r.Sign() == 0 || r.Cmp(secp256k1N) >= 0 {
+		return nil, errors.New("signature R value out of range")
+	}
+	if s.Sign() == 0 || s.Cmp(secp256k1N) >= 0 {
+		return nil, errors.New("signature S value out of range")
+	}
+
+	return &Signature{R: r, S: s}, nil
+}
+
+// IsValidSignatureEncoding reports whether a script signature (DER plus the
+// sighash byte) follows the BIP66 strict DER rules
+func IsValidSignatureEncoding(sig []byte) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	return checkDEREncoding(sig[:len(sig)-1]) == nil
+}
+
+// checkDEREncoding enforces the BIP66 DER rules on a signature without its sighash byte.
+// Format: 0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S]
+func checkDEREncoding(der []byte) error {
+	if len(der) < MinDERSignatureSize {
+		return errors.New("signature too short")
+	}
+	if len(der) > MaxDERSignatureSize {
+		return errors.New("signature too long")
+	}
+	if der[0] != 0x30 {
+		return errors.New("signature is not a DER sequence")
+	}
+	if int(der[1]) != len(der)-2 {
+		return errors.New("signature length does not match sequence length")
+	}
+
+	lenR := int(der[3])
+	if 5+lenR >= len(der) {
+		return errors.New("signature R length out of bounds")
+	}
+	lenS := int(der[5+lenR])
+	if lenR+lenS+6 != len(der) {
+		return errors.New("signature R and S lengths do not match total length")
+	}
+
+	if der[2] != 0x02 {
+		return errors.New("signature R is not an integer")
+	}
+	if lenR == 0 {
+		return errors.New("signature R is empty")
+	}
+	if der[4]&0x80 != 0 {
+		return errors.New("signature R is negative")
+	}
+	if lenR > 1 && der[4] == 0x00 && der[5]&0x80 == 0 {
+		return errors.New("signature R has excessive padding")
+	}
+
+	if der[lenR+4] != 0x02 {
+		return errors.New("signature S is not an integer")
+	}
+	if lenS == 0 {
+		return errors.New("signature S is empty")
+	}
+	if der[lenR+6]&0x80 != 0 {
+		return errors.New("signature S is negative")
+	}
+	if lenS > 1 && der[lenR+6] == 0x00 && der[lenR+7]&0x80 == 0 {
+		return errors.New("signature S has excessive padding")
+	}
+
+	return nil
+}
+
+// parseDERSignatureLax parses a signature the way pre-BIP66 consensus did.
+// It tolerates non-canonical lengths, padding and trailing data. R or S values
+// that overflow 32 bytes yield a zero signature which never verifies.
+func parseDERSignatureLax(der []byte) (*Signature, error) {
+	pos := 0
+
+	// Sequence tag and length
+	if pos == len(der) || der[pos] != 0x30 {
+		return nil, errors.New("signature is not a DER sequence")
+	}
+	pos++
+	if pos == len(der) {
+		return nil, errors.New("signature truncated")
+	}
+	lenByte := int(der[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(der)-pos {
+			return nil, errors.New("signature sequence length out of bounds")
+		}
+		pos += lenByte
+	}
+
+	rPos, rLen, pos, err := parseLaxInteger(der, pos)
+	if err != nil {
+		return nil, fmt.Errorf("signature R: %w", err)
+	}
+	sPos, sLen, _, err := parseLaxInteger(der, pos)
+	if err != nil {
+		return nil, fmt.Errorf("signature S: %w", err)
+	}
+
+	// Ignore leading zeroes
+	for rLen > 0 && der[rPos] == 0 {
+		rLen--
+		rPos++
+	}
+	for sLen > 0 && der[sPos] == 0 {
+		sLen--
+		sPos++
+	}
+
+	if rLen > 32 || sLen > 32 {
+		return &Signature{R: new(big.Int), S: new(big.Int)}, nil
+	}
+
+	r := new(big.Int).SetBytes(der[rPos : rPos+rLen])
+	s := new(big.Int).SetBytes(der[sPos : sPos+sLen])
+	if r.Cmp(secp256k1N) >= 0 || s.Cmp(secp256k1N) >= 0 {
+		return &Signature{R: new(big.Int), S: new(big.Int)}, nil
+	}
+
+	return &Signature{R: r, S: s}, nil
+}
+
+// parseLaxInteger reads a DER integer header at pos and returns the position
+// and length of its value along with the position following it
+func parseLaxInteger(der []byte, pos int) (valuePos, valueLen, next int, err error) {
+	if pos == len(der) || der[pos] != 0x02 {
+		return 0, 0, 0, errors.New("not an integer")
+	}
+	pos++
+	if pos == len(der) {
+		return 0, 0, 0, errors.New("truncated length")
+	}
+
+	lenByte := int(der[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(der)-pos {
+			return 0, 0, 0, errors.New("length out of bounds")
+		}
+		for lenByte > 0 && der[pos] == 0 {
+			pos++
+			lenByte--
+		}
+		if lenByte >= 8 {
+			return 0, 0, 0, errors.New("length too large")
+		}
+		for lenByte > 0 {
+			valueLen = valueLen<<8 + int(der[pos])
+			pos++
+			lenByte--
+		}
+	} else {
+		valueLen = lenByte
+	}
+
+	if valueLen > len(der)-pos {
+		return 0, 0, 0, errors.New("value out of bounds")
+	}
+	return pos, valueLen, pos + valueLen, nil
+}
+
+// IsLowS reports whether S is at most half the curve order (BIP62 rule 5)
+func (sig *Signature) IsLowS() bool {
+	return sig.S.Cmp(secp256k1HalfN) <= 0
+}
+
+// Serialize returns the strict DER encoding of the signature (without sighash byte)
+func (sig *Signature) Serialize() []byte {
+	r := derInteger(sig.R)
+	s := derInteger(sig.S)
+
+	out := make([]byte, 0, 6+len(r)+len(s))
+	out = append(out, 0x30, byte(4+len(r)+len(s)))
+	out = append(out, 0x02, byte(len(r)))
+	out = append(out, r...)
+	out = append(out, 0x02, byte(len(s)))
+	out = append(out, s...)
+	return out
+}
+
+// derInteger encodes a non-negative integer as minimal big-endian DER content
+func derInteger(v *big.Int) []byte {
+	b := v.Bytes()
+	if len(b) == 0 {
+		return []byte{0x00}
+	}
+	if b[0]&0x80 != 0 {
+		return append([]byte{0x00}, b...)
+	}
+	return b
+}
+
+// isDefinedHashType reports whether the signature's sighash byte is one of
+// the defined SIGHASH types (with or without ANYONECANPAY)
+func isDefinedHashType(sig []byte) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	hashType := SigHashType(sig[len(sig)-1]) &^ SigHashAnyoneCanPay
+	return hashType >= SigHashAll && hashType <= SigHashSingle
+}
+
+// isCompressedOrUncompressedPubKey reports whether a public key uses a
+// standard SEC1 encoding (hybrid keys are rejected)
+func isCompressedOrUncompressedPubKey(pubKey []byte) bool {
+	switch {
+	case len(pubKey) == UncompressedPubKeySize && pubKey[0] == PubKeyUncompressed:
+		return true
+	case len(pubKey) == CompressedPubKeySize &&
+		(pubKey[0] == PubKeyCompressedEven || pubKey[0] == PubKeyCompressedOdd):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package bitcoin
+
+import (
+	"testing"
+)
+
+// TestIsValidSignatureEncoding tests the BIP66 strict DER rules
+func TestIsValidSignatureEncoding(t *testing.T) {
+	const validSig = "3044" +
+		"0220" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" +
+		"0220" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" +
+		"01"
+
+	tests := []struct {
+		name     string
+		sigHex   string
+		expected bool
+	}{
+		{"valid signature", validSig, true},
+		{"minimal valid signature", "300602010102010101", true},
+		{"padded R with high bit", "3007020200800201010" + "1", true},
+		{"empty", "", false},
+		{"too short", "3006020101020101", false},
+		{"wrong sequence tag", "310602010102010101", false},
+		{"wrong total length", "300702010102010101", false},
+		{"R length overflows", "300602050102010101", false},
+		{"lengths mismatch", "300602010102020101", false},
+		{"R not an integer", "300603010102010101", false},
+		{"zero length R", "3006020002020101" + "01", false},
+		{"negative R", "300602018102010101", false},
+		{"excessively padded R", "30070202000102010101", false},
+		{"S not an integer", "300602010103010101", false},
+		{"zero length S", "3006020201010200" + "01", false},
+		{"negative S", "300602010102018101", false},
+		{"excessively padded S", "30070201010202000101", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidSignatureEncoding(mustDecodeHex(tt.sigHex)); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestParseDERSignature tests strict parsing including range checks
+func TestParseDERSignature(t *testing.T) {
+	sig, err := ParseDERSignature(mustDecodeHex("3006020101020102"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sig.R.Int64() != 1 || sig.S.Int64() != 2 {
+		t.Errorf("Expected r=1 s=2, got r=%d s=%d", sig.R, sig.S)
+	}
+
+	// R equal to the curve order is out of range
+	overflow := "3026" +
+		"0221" + "00fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141" +
+		"020101"
+	if _, err := ParseDERSignature(mustDecodeHex(overflow)); err == nil {
+		t.Error("Expected error for R >= n")
+	}
+
+	if _, err := ParseDERSignature(mustDecodeHex("3006020100020101")); err == nil {
+		t.Error("Expected error for zero R")
+	}
+}
+
+// TestParseDERSignatureLax tests the pre-BIP66 permissive parser
+func TestParseDERSignatureLax(t *testing.T) {
+	tests := []struct {
+		name        string
+		derHex      string
+		expectError bool
+		expectedR   int64
+		expectedS   int64
+	}{
+		{"strict encoding", "3006020101020102", false, 1, 2},
+		{"wrong sequence length", "3010020101020102", false, 1, 2},
+		{"long-form sequence length", "308106020101020102", false, 1, 2},
+		{"long-form integer length", "30070281010102010" + "2", false, 1, 2},
+		{"excess zero padding", "3008020300000102010" + "2", false, 1, 2},
+		{"trailing garbage", "3006020101020102ffff", false, 1, 2},
+		{"R overflows 32 bytes", "3027" + "0222" + "01" + "0000000000000000000000000000000000000000000000000000000000000000" +
+			"00" + "020102", false, 0, 0},
+		{"not a sequence", "3106020101020102", true, 0, 0},
+		{"truncated", "30", true, 0, 0},
+		{"R not an integer", "3006030101020102", true, 0, 0},
+		{"S truncated", "30060201010201", true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := parseDERSignatureLax(mustDecodeHex(tt.derHex))
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sig.R.Int64() != tt.expectedR || sig.S.Int64() != tt.expectedS {
+				t.Errorf("Expected r=%d s=%d, got r=%d s=%d", tt.expectedR, tt.expectedS, sig.R, sig.S)
+			}
+		})
+	}
+}
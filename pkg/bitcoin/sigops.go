@@ -0,0 +1,126 @@
+package bitcoin
+
+// Signature operation limits
+const (
+	MaxBlockSigOpsCost    = 80000 // Sigop cost allowed per block (BIP141)
+	MaxPubKeysPerMultisig = 20    // Keys counted for a multisig without a known count
+)
+
+// SigOpCount counts the signature operations in a script. Without accurate
+// counting every OP_CHECKMULTISIG counts as MaxPubKeysPerMultisig; with it,
+// a multisig preceded by OP_1 to OP_16 counts as that many keys. Counting
+// stops at the first unparsable opcode, as in Bitcoin Core.
+func (s Script) SigOpCount(accurate bool) int {
+	count := 0
+	lastOpcode := OP_INVALIDOPCODE
+	for pc := 0; pc < len(s); {
+		opcode, _, next, err := parseScriptOp(s, pc)
+		if err != nil {
+			break
+		}
+		pc = next
+
+		switch opcode {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			if accurate && lastOpcode >= OP_1 && lastOpcode <= OP_16 {
+				count += int(lastOpcode-OP_1) + 1
+			} else {
+				count += MaxPubKeysPerMultisig
+			}
+		}
+		lastOpcode = opcode
+	}
+	return count
+}
+
+// LegacySigOpCount counts the signature operations in a transaction's
+// scriptSigs and output scripts, without looking at the outputs it spends
+func (tx *Transaction) LegacySigOpCount() int {
+	count := 0
+	for _, input := range tx.Inputs {
+		count += Script(input.ScriptSig).SigOpCount(false)
+	}
+	for _, output := range tx.Outputs {
+		count += Script(output.ScriptPubKey).SigOpCount(false)
+	}
+	return count
+}
+
+// lastPush returns the data pushed by the last operation of a push-only
+// script, or false if the script is not push only. OP_1NEGATE and OP_1 to
+// OP_16 push no data.
+func (s Script) lastPush() ([]byte, bool) {
+	var data []byte
+	for pc := 0; pc < len(s); {
+		opcode, push, next, err := parseScriptOp(s, pc)
+		if err != nil || opcode > OP_16 {
+			return nil, false
+		}
+		data = push
+		pc = next
+	}
+	return data, true
+}
+
+// P2SHSigOpCount counts the signature operations in the redeem script a
+// scriptSig spending a P2SH output pushes last, counting accurately
+func (s Script) P2SHSigOpCount(scriptSig Script) int {
+	if s.AnalyzeScript() != ScriptTypeP2SH {
+		return s.SigOpCount(true)
+	}
+	redeemScript, ok := scriptSig.lastPush()
+	if !ok {
+		return 0
+	}
+	return Script(redeemScript).SigOpCount(true)
+}
+
+// WitnessSigOpCount counts the signature operations of a segwit spend of
+// scriptPubKey, native or nested in P2SH. A P2WPKH spend counts one, a P2WSH
+// spend those of its witness script; other spends count none.
+func WitnessSigOpCount(scriptSig, scriptPubKey Script, witness [][]byte, flags ScriptFlags) int {
+	if flags&ScriptVerifyWitness == 0 {
+		return 0
+	}
+
+	version, program, ok := scriptPubKey.WitnessProgram()
+	if !ok && flags&ScriptVerifyP2SH != 0 && scriptPubKey.AnalyzeScript() == ScriptTypeP2SH {
+		if redeemScript, pushOnly := scriptSig.lastPush(); pushOnly {
+			version, program, ok = Script(redeemScript).WitnessProgram()
+		}
+	}
+	if !ok || version != 0 {
+		return 0
+	}
+
+	switch {
+	case len(program) == Hash160Size:
+		return 1
+	case len(program) == Hash256Size && len(witness) > 0:
+		return Script(witness[len(witness)-1]).SigOpCount(true)
+	}
+	return 0
+}
+
+// SigOpCost returns the sigop cost of a transaction spending prevOuts, one
+// output per input, under the given flags. Legacy signature operations cost
+// WitnessScaleFactor each, as do those of P2SH redeem scripts when P2SH is
+// enforced; witness signature operations cost one. A coinbase spends nothing,
+// so prevOuts may be nil for it.
+func (tx *Transaction) SigOpCost(prevOuts []TxOutput, flags ScriptFlags) int {
+	cost := tx.LegacySigOpCount() * WitnessScaleFactor
+	if tx.IsCoinbase() {
+		return cost
+	}
+
+	for i, input := range tx.Inputs {
+		scriptPubKey := Script(prevOuts[i].ScriptPubKey)
+		if flags&ScriptVerifyP2SH != 0 && scriptPubKey.AnalyzeScript() == ScriptTypeP2SH {
+			cost += scriptPubKey.P2SHSigOpCount(input.ScriptSig) * WitnessScaleFactor
+		}
+		cost += WitnessSigOpCount(input.ScriptSig, scriptPubKey, input.Witness, flags)
+	}
+	return cost
+}
@@ -0,0 +1,123 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// TestScript_SigOpCount tests legacy and accurate signature operation counting
+func TestScript_SigOpCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   Script
+		legacy   int
+		accurate int
+	}{
+		{"empty", Script{}, 0, 0},
+		{"checksig", Script{byte(OP_DUP), byte(OP_CHECKSIG), byte(OP_CHECKSIGVERIFY)}, 2, 2},
+		{"2-of-3 multisig", Script{byte(OP_2), byte(OP_3), byte(OP_CHECKMULTISIG)}, 20, 3},
+		{"multisig without key count", Script{byte(OP_CHECKMULTISIGVERIFY)}, 20, 20},
+		{"pushed opcodes not counted", Script{0x02, byte(OP_CHECKSIG), byte(OP_CHECKSIG)}, 0, 0},
+		{"counting stops at truncated push", Script{byte(OP_CHECKSIG), 0x05, 0x01}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if count := tt.script.SigOpCount(false); count != tt.legacy {
+				t.Errorf("Expected %d legacy sigops, got %d", tt.legacy, count)
+			}
+			if count := tt.script.SigOpCount(true); count != tt.accurate {
+				t.Errorf("Expected %d accurate sigops, got %d", tt.accurate, count)
+			}
+		})
+	}
+}
+
+// TestTransaction_SigOpCost tests the sigop cost of spends of each output type
+func TestTransaction_SigOpCost(t *testing.T) {
+	multisig := Script{byte(OP_1), byte(OP_2), byte(OP_CHECKMULTISIG)}
+	p2sh := append(Script{byte(OP_HASH160), 0x14}, append(make([]byte, 20), byte(OP_EQUAL))...)
+	p2wpkh := append(Script{byte(OP_0), 0x14}, make([]byte, 20)...)
+	scriptHash := sha256.Sum256(multisig)
+	p2wsh := append(Script{byte(OP_0), 0x20}, scriptHash[:]...)
+	p2pk := append(Script{0x21}, append(make([]byte, 33), byte(OP_CHECKSIG))...)
+	allFlags := ScriptVerifyP2SH | ScriptVerifyWitness
+
+	tests := []struct {
+		name         string
+		scriptSig    Script
+		witness      [][]byte
+		scriptPubKey Script
+		flags        ScriptFlags
+		expected     int
+	}{
+		{"P2PK output spent", nil, nil, p2pk, allFlags, 0},
+		{"P2SH multisig", pushDataScript(multisig), nil, p2sh, allFlags, 2 * WitnessScaleFactor},
+		{"P2SH before BIP16", pushDataScript(multisig), nil, p2sh, ScriptFlagsNone, 0},
+		{"P2SH with non-push scriptSig", append(pushDataScript(multisig), byte(OP_NOP)), nil, p2sh, allFlags, 0},
+		{"P2WPKH", nil, [][]byte{{0x01}, {0x02}}, p2wpkh, allFlags, 1},
+		{"P2WPKH before segwit", nil, [][]byte{{0x01}, {0x02}}, p2wpkh, ScriptVerifyP2SH, 0},
+		{"P2WSH multisig", nil, [][]byte{{}, multisig}, p2wsh, allFlags, 2},
+		{"P2WSH without witness", nil, nil, p2wsh, allFlags, 0},
+		{"P2SH-P2WPKH", pushDataScript(p2wpkh), [][]byte{{0x01}, {0x02}}, p2sh, allFlags, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{
+				Version: 2,
+				Inputs: []TxInput{{
+					PreviousOutput: OutPoint{Hash: Hash256{0x01}},
+					ScriptSig:      tt.scriptSig,
+					Sequence:       0xffffffff,
+					Witness:        tt.witness,
+				}},
+				Outputs: []TxOutput{{Value: 900, ScriptPubKey: []byte{byte(OP_1)}}},
+			}
+			prevOuts := []TxOutput{{Value: 1000, ScriptPubKey: tt.scriptPubKey}}
+
+			// The scriptSig's own legacy sigops always count
+			expected := tt.expected + tx.LegacySigOpCount()*WitnessScaleFactor
+			if cost := tx.SigOpCost(prevOuts, tt.flags); cost != expected {
+				t.Errorf("Expected sigop cost %d, got %d", expected, cost)
+			}
+		})
+	}
+
+	coinbase := createCoinbaseTransaction(5000000000)
+	if cost := coinbase.SigOpCost(nil, allFlags); cost != WitnessScaleFactor {
+		t.Errorf("Expected coinbase paying to a public key to cost %d, got %d", WitnessScaleFactor, cost)
+	}
+}
+
+// TestBlockChain_P2SHSigOps tests that sigops in redeem scripts count towards the block limit
+func TestBlockChain_P2SHSigOps(t *testing.T) {
+	genesis := createGenesisBlock()
+	blockchain := newTestBlockChain()
+	p2sh := append([]byte{byte(OP_HASH160), 0x14}, append(make([]byte, 20), byte(OP_EQUAL))...)
+	blockchain.GetUTXOSet().Add(NewUTXO(Hash256{0x01}, 0, 1000, p2sh))
+
+	// Counted accurately, each OP_CHECKMULTISIG without a key count is 20 sigops
+	redeemScript := bytes.Repeat([]byte{byte(OP_CHECKMULTISIG)}, MaxBlockSigOpsCost/WitnessScaleFactor/MaxPubKeysPerMultisig)
+	spend := spendingTransaction(900, OutPoint{Hash: Hash256{0x01}})
+	spend.Inputs[0].ScriptSig = pushDataScript(redeemScript)
+	if spend.LegacySigOpCount() != 0 {
+		t.Fatal("Expected no legacy sigops in the pushed redeem script")
+	}
+
+	block := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: genesis.Hash(),
+		Timestamp:     genesis.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{*createUniqueCoinbaseTransaction(5000000000, 1), spend})
+
+	err := blockchain.AddBlock(block)
+	if err == nil || !contains(err.Error(), "sigop cost") {
+		t.Fatalf("Expected sigop limit error, got %v", err)
+	}
+	if !blockchain.IsBlockInvalid(block.Hash()) {
+		t.Error("Expected block over the sigop limit to be marked invalid")
+	}
+}
@@ -0,0 +1,98 @@
+package bitcoin
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Network-adjusted time limits, matching Bitcoin Core
+const (
+	MaxTimeSamples     = 200              // Peers remembered for the median offset
+	MinTimeSamples     = 5                // Samples, including our own, before adjusting
+	MaxTimeAdjustment  = 70 * time.Minute // Largest offset applied to the local clock
+	MaxFutureBlockTime = 2 * time.Hour    // How far ahead of adjusted time a block may be
+)
+
+// TimeSource provides the network-adjusted time used to judge block timestamps
+type TimeSource interface {
+	// AdjustedTime returns the local clock corrected by the median peer offset
+	AdjustedTime() time.Time
+
+	// AddTimeSample records the time a peer reported in its version message
+	AddTimeSample(peerID string, timestamp time.Time)
+
+	// Offset returns the correction currently applied to the local clock
+	Offset() time.Duration
+}
+
+// MedianTimeSource adjusts the local clock by the median offset reported by
+// peers. Each peer is counted once and the offset is only updated for an odd
+// number of samples, so a single new peer cannot flip the median back and forth.
+// The peer code does not exchange version messages yet, so nothing records
+// samples and the adjusted time is the local clock.
+type MedianTimeSource struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	offsets []time.Duration // Our own zero offset followed by one per peer
+	seen    map[string]bool
+	offset  time.Duration
+}
+
+// NewMedianTimeSource creates a time source backed by the system clock
+func NewMedianTimeSource() *MedianTimeSource {
+	return NewMedianTimeSourceWithClock(time.Now)
+}
+
+// NewMedianTimeSourceWithClock creates a time source backed by the given
+// clock, allowing tests to control the current time
+func NewMedianTimeSourceWithClock(now func() time.Time) *MedianTimeSource {
+	return &MedianTimeSource{
+		now:     now,
+		offsets: []time.Duration{0},
+		seen:    make(map[string]bool),
+	}
+}
+
+// AdjustedTime returns the local clock corrected by the median peer offset
+func (m *MedianTimeSource) AdjustedTime() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now().Truncate(time.Second).Add(m.offset)
+}
+
+// Offset returns the correction currently applied to the local clock
+func (m *MedianTimeSource) Offset() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.offset
+}
+
+// AddTimeSample records the time a peer reported in its version message
+func (m *MedianTimeSource) AddTimeSample(peerID string, timestamp time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.seen[peerID] || len(m.seen) >= MaxTimeSamples {
+		return
+	}
+	m.seen[peerID] = true
+
+	offset := timestamp.Sub(m.now()).Truncate(time.Second)
+	m.offsets = append(m.offsets, offset)
+
+	if len(m.offsets) < MinTimeSamples || len(m.offsets)%2 == 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, len(m.offsets))
+	copy(sorted, m.offsets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	// Peers far out of line with our clock are more likely wrong than we are
+	if median > MaxTimeAdjustment || median < -MaxTimeAdjustment {
+		median = 0
+	}
+	m.offset = median
+}
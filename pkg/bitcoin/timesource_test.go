@@ -0,0 +1,129 @@
+package bitcoin
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMedianTimeSource tests the median peer offset applied to the local clock
+func TestMedianTimeSource(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		name     string
+		offsets  []time.Duration
+		expected time.Duration
+	}{
+		{"no peers", nil, 0},
+		{"too few samples", []time.Duration{time.Minute, time.Minute, time.Minute}, 0},
+		{"median of five", []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, -5 * time.Second}, 10 * time.Second},
+		{"even count keeps previous", []time.Duration{time.Minute, time.Minute, time.Minute, time.Minute, time.Minute}, time.Minute},
+		{"within limit", []time.Duration{69 * time.Minute, 69 * time.Minute, 69 * time.Minute, 69 * time.Minute}, 69 * time.Minute},
+		{"beyond limit", []time.Duration{71 * time.Minute, 71 * time.Minute, 71 * time.Minute, 71 * time.Minute}, 0},
+		{"beyond negative limit", []time.Duration{-2 * time.Hour, -2 * time.Hour, -2 * time.Hour, -2 * time.Hour}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := NewMedianTimeSourceWithClock(clock)
+			for i, offset := range tt.offsets {
+				source.AddTimeSample(string(rune('a'+i)), now.Add(offset))
+			}
+			if source.Offset() != tt.expected {
+				t.Errorf("Expected offset %v, got %v", tt.expected, source.Offset())
+			}
+			if !source.AdjustedTime().Equal(now.Add(tt.expected)) {
+				t.Errorf("Expected adjusted time %v, got %v", now.Add(tt.expected), source.AdjustedTime())
+			}
+		})
+	}
+}
+
+// TestMedianTimeSource_DuplicatePeer tests that a peer is only sampled once
+func TestMedianTimeSource_DuplicatePeer(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	source := NewMedianTimeSourceWithClock(func() time.Time { return now })
+
+	for i := 0; i < 10; i++ {
+		source.AddTimeSample("peer", now.Add(time.Hour))
+	}
+	if source.Offset() != 0 {
+		t.Errorf("Expected a single peer not to adjust the clock, got %v", source.Offset())
+	}
+}
+
+// TestBlockChain_MedianTimePast tests that timestamps must exceed the median of the last 11 blocks
+func TestBlockChain_MedianTimePast(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+	if blockchain.MedianTimePast() != genesis.Header.Timestamp {
+		t.Errorf("Expected genesis median time past %d, got %d", genesis.Header.Timestamp, blockchain.MedianTimePast())
+	}
+
+	prev := genesis
+	for i := 1; i <= 11; i++ {
+		block := createNextBlock(t, blockchain, prev, 600, i)
+		if err := blockchain.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %d: %v", i, err)
+		}
+		prev = block
+	}
+
+	// Blocks 1 to 11 span 600 to 6600 seconds after genesis, so the median is block 6
+	expected := genesis.Header.Timestamp + 6*600
+	if blockchain.MedianTimePast() != expected {
+		t.Fatalf("Expected median time past %d, got %d", expected, blockchain.MedianTimePast())
+	}
+
+	tests := []struct {
+		name        string
+		timestamp   uint32
+		shouldError bool
+	}{
+		{"equal to median time past", expected, true},
+		{"before median time past", expected - 1, true},
+		{"one second after median time past", expected + 1, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := createNextBlock(t, blockchain, prev, tt.timestamp-prev.Header.Timestamp, 100+i)
+			err := blockchain.AddBlock(block)
+			if tt.shouldError && (err == nil || !contains(err.Error(), "not after median time past")) {
+				t.Errorf("Expected median time past error, got %v", err)
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("Expected block to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+// TestBlockChain_FutureBlock tests the limit on timestamps ahead of network-adjusted time
+func TestBlockChain_FutureBlock(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+
+	now := genesis.Header.Time().Add(time.Hour)
+	blockchain.SetTimeSource(NewMedianTimeSourceWithClock(func() time.Time { return now }))
+
+	// Four hours after genesis is an hour past the two hour limit
+	block := createNextBlock(t, blockchain, genesis, 4*3600, 1)
+	err := blockchain.AddBlock(block)
+	if err == nil || !contains(err.Error(), "too far in future") {
+		t.Fatalf("Expected future block error, got %v", err)
+	}
+	if blockchain.IsBlockInvalid(block.Hash()) {
+		t.Error("Expected future block not to be marked invalid")
+	}
+
+	// Peers running an hour ahead make the block acceptable
+	source := blockchain.TimeSource()
+	for _, peer := range []string{"a", "b", "c", "d"} {
+		source.AddTimeSample(peer, now.Add(time.Hour))
+	}
+	if err := blockchain.AddBlock(block); err != nil {
+		t.Errorf("Expected block to be accepted with adjusted time, got %v", err)
+	}
+}
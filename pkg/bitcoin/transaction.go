@@ -3,7 +3,9 @@ package bitcoin
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 )
 
 // Transaction represents a Bitcoin transaction
@@ -78,6 +80,20 @@ func EncodeVarInt(value uint64) []byte {
 	}
 }
 
+// VarIntSize returns the number of bytes EncodeVarInt uses for a value
+func VarIntSize(value uint64) int {
+	switch {
+	case value < 0xfd:
+		return 1
+	case value <= 0xffff:
+		return 3
+	case value <= 0xffffffff:
+		return 5
+	default:
+		return 9
+	}
+}
+
 // DecodeVarInt decodes a Bitcoin variable-length integer
 func DecodeVarInt(data []byte) (value uint64, bytesRead int, err error) {
 	if len(data) == 0 {
@@ -253,141 +269,342 @@ func (tx *Transaction) serializeForHashing() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// DeserializeTransaction deserializes a transaction from Bitcoin wire format
+// DeserializeTransaction deserializes a transaction from Bitcoin wire format.
+// The data must contain exactly one transaction; trailing bytes are rejected.
 func DeserializeTransaction(data []byte) (*Transaction, error) {
+	tx, bytesRead, err := DecodeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	if bytesRead != len(data) {
+		return nil, fmt.Errorf("unexpected %d trailing bytes after transaction", len(data)-bytesRead)
+	}
+	return tx, nil
+}
+
+// DecodeTransaction decodes the transaction at the start of data and returns
+// the number of bytes consumed, so that transactions embedded in a larger
+// buffer (such as a block) can be decoded one after another.
+func DecodeTransaction(data []byte) (*Transaction, int, error) {
 	if len(data) == 0 {
-		return nil, fmt.Errorf("empty transaction data")
+		return nil, 0, fmt.Errorf("empty transaction data")
 	}
 
+	r := bytes.NewReader(data)
+	tx, err := ReadTransaction(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tx, len(data) - r.Len(), nil
+}
+
+// ReadTransaction reads one transaction in wire format from r.
+//
+// Both the legacy and the BIP144 witness encoding are accepted. A witness
+// encoding is only canonical if at least one input has witness data; a set
+// flag with every witness empty is rejected, as are unknown flag bits.
+func ReadTransaction(r io.Reader) (*Transaction, error) {
 	tx := &Transaction{}
-	offset := 0
 
 	// Version (4 bytes)
-	if len(data[offset:]) < 4 {
-		return nil, fmt.Errorf("insufficient data for version")
+	var buf [8]byte
+	if err := readFull(r, buf[:4], "version"); err != nil {
+		return nil, err
+	}
+	tx.Version = binary.LittleEndian.Uint32(buf[:4])
+
+	// SegWit marker (0x00) and flag. A legacy transaction cannot start with a
+	// zero input count followed by a non-zero byte, so this is unambiguous.
+	if err := readFull(r, buf[:1], "input count"); err != nil {
+		return nil, fmt.Errorf("failed to decode input count: %v", err)
+	}
+	hasWitness := false
+	if buf[0] == 0x00 {
+		if err := readFull(r, buf[:1], "output count"); err != nil {
+			return nil, fmt.Errorf("failed to decode output count: %v", err)
+		}
+		if buf[0] == 0x00 {
+			// No inputs and no outputs
+			return tx, readLockTime(r, tx)
+		}
+		if buf[0] != 0x01 {
+			return nil, fmt.Errorf("unknown transaction flag: 0x%02x", buf[0])
+		}
+		hasWitness = true
+		if err := readFull(r, buf[:1], "input count"); err != nil {
+			return nil, fmt.Errorf("failed to decode input count: %v", err)
+		}
 	}
-	tx.Version = binary.LittleEndian.Uint32(data[offset : offset+4])
-	offset += 4
 
 	// Input count
-	inputCount, bytesRead, err := DecodeVarInt(data[offset:])
+	inputCount, err := readVarIntAfter(r, buf[0])
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode input count: %v", err)
 	}
-	offset += bytesRead
-
-	// Validate input count before conversion
-	if inputCount > 0x7fffffff { // Max int value
+	if inputCount > MaxPayload {
 		return nil, fmt.Errorf("input count too large: %d", inputCount)
 	}
 
 	// Inputs
-	tx.Inputs = make([]TxInput, int(inputCount))
+	tx.Inputs = make([]TxInput, 0, preallocCount(inputCount))
 	for i := uint64(0); i < inputCount; i++ {
-		// Previous output hash (32 bytes, reversed from wire format)
-		if len(data[offset:]) < 32 {
-			return nil, fmt.Errorf("insufficient data for input %d hash", i)
-		}
-		// Reverse the hash bytes from wire format
-		for j := 0; j < 32; j++ {
-			tx.Inputs[i].PreviousOutput.Hash[j] = data[offset+31-j]
+		input, err := readTxInput(r, i)
+		if err != nil {
+			return nil, err
 		}
-		offset += 32
+		tx.Inputs = append(tx.Inputs, input)
+	}
 
-		// Previous output index (4 bytes)
-		if len(data[offset:]) < 4 {
-			return nil, fmt.Errorf("insufficient data for input %d index", i)
-		}
-		tx.Inputs[i].PreviousOutput.Index = binary.LittleEndian.Uint32(data[offset : offset+4])
-		offset += 4
+	// Output count
+	outputCount, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode output count: %v", err)
+	}
+	if outputCount > MaxPayload {
+		return nil, fmt.Errorf("output count too large: %d", outputCount)
+	}
 
-		// Script length
-		var scriptLen uint64
-		var scriptBytesRead int
-		scriptLen, scriptBytesRead, err = DecodeVarInt(data[offset:])
+	// Outputs
+	tx.Outputs = make([]TxOutput, 0, preallocCount(outputCount))
+	for i := uint64(0); i < outputCount; i++ {
+		output, err := readTxOutput(r, i)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode input %d script length: %v", i, err)
+			return nil, err
 		}
-		offset += scriptBytesRead
+		tx.Outputs = append(tx.Outputs, output)
+	}
 
-		// Script
-		// Validate script length before conversion
-		if scriptLen > 0x7fffffff { // Max int value
-			return nil, fmt.Errorf("input %d script length too large: %d", i, scriptLen)
-		}
-		scriptLenInt := int(scriptLen)
-		if len(data[offset:]) < scriptLenInt {
-			return nil, fmt.Errorf("insufficient data for input %d script", i)
+	// Witness data, one stack per input
+	if hasWitness {
+		for i := range tx.Inputs {
+			if tx.Inputs[i].Witness, err = readWitness(r, i); err != nil {
+				return nil, err
+			}
 		}
-		tx.Inputs[i].ScriptSig = make([]byte, scriptLen)
-		copy(tx.Inputs[i].ScriptSig, data[offset:offset+scriptLenInt])
-		offset += scriptLenInt
 
-		// Sequence (4 bytes)
-		if len(data[offset:]) < 4 {
-			return nil, fmt.Errorf("insufficient data for input %d sequence", i)
+		if !tx.HasWitness() {
+			return nil, fmt.Errorf("superfluous witness flag with empty witness data")
 		}
-		tx.Inputs[i].Sequence = binary.LittleEndian.Uint32(data[offset : offset+4])
-		offset += 4
 	}
 
-	// Output count
-	var outputCount uint64
-	var outputBytesRead int
-	outputCount, outputBytesRead, err = DecodeVarInt(data[offset:])
+	return tx, readLockTime(r, tx)
+}
+
+// readTxInput reads one transaction input (without witness)
+func readTxInput(r io.Reader, i uint64) (TxInput, error) {
+	var input TxInput
+
+	// Previous output hash (32 bytes, reversed from wire format)
+	var hash Hash256
+	if err := readFull(r, hash[:], fmt.Sprintf("input %d hash", i)); err != nil {
+		return input, err
+	}
+	input.PreviousOutput.Hash = reverseHash(hash)
+
+	// Previous output index (4 bytes)
+	var buf [4]byte
+	if err := readFull(r, buf[:], fmt.Sprintf("input %d index", i)); err != nil {
+		return input, err
+	}
+	input.PreviousOutput.Index = binary.LittleEndian.Uint32(buf[:])
+
+	// Script
+	script, err := readVarBytes(r, fmt.Sprintf("input %d script", i))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode output count: %v", err)
+		return input, err
 	}
-	offset += outputBytesRead
+	input.ScriptSig = script
 
-	// Validate output count before conversion
-	if outputCount > 0x7fffffff { // Max int value
-		return nil, fmt.Errorf("output count too large: %d", outputCount)
+	// Sequence (4 bytes)
+	if err := readFull(r, buf[:], fmt.Sprintf("input %d sequence", i)); err != nil {
+		return input, err
 	}
+	input.Sequence = binary.LittleEndian.Uint32(buf[:])
 
-	// Outputs
-	tx.Outputs = make([]TxOutput, int(outputCount))
-	for i := uint64(0); i < outputCount; i++ {
-		// Value (8 bytes)
-		if len(data[offset:]) < 8 {
-			return nil, fmt.Errorf("insufficient data for output %d value", i)
-		}
-		tx.Outputs[i].Value = binary.LittleEndian.Uint64(data[offset : offset+8])
-		offset += 8
+	return input, nil
+}
+
+// readTxOutput reads one transaction output
+func readTxOutput(r io.Reader, i uint64) (TxOutput, error) {
+	var output TxOutput
 
-		// Script length
-		var scriptLen uint64
-		var scriptBytesRead int
-		scriptLen, scriptBytesRead, err = DecodeVarInt(data[offset:])
+	// Value (8 bytes)
+	var buf [8]byte
+	if err := readFull(r, buf[:], fmt.Sprintf("output %d value", i)); err != nil {
+		return output, err
+	}
+	output.Value = binary.LittleEndian.Uint64(buf[:])
+
+	// Script
+	script, err := readVarBytes(r, fmt.Sprintf("output %d script", i))
+	if err != nil {
+		return output, err
+	}
+	output.ScriptPubKey = script
+
+	return output, nil
+}
+
+// readWitness reads the witness stack of one input. An empty stack is returned as nil.
+func readWitness(r io.Reader, i int) ([][]byte, error) {
+	itemCount, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode input %d witness count: %v", i, err)
+	}
+	if itemCount > MaxPayload {
+		return nil, fmt.Errorf("input %d witness count too large: %d", i, itemCount)
+	}
+	if itemCount == 0 {
+		return nil, nil
+	}
+
+	witness := make([][]byte, 0, preallocCount(itemCount))
+	for j := uint64(0); j < itemCount; j++ {
+		item, err := readVarBytes(r, fmt.Sprintf("input %d witness item %d", i, j))
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode output %d script length: %v", i, err)
+			return nil, err
 		}
-		offset += scriptBytesRead
+		witness = append(witness, item)
+	}
+	return witness, nil
+}
 
-		// Script
-		// Validate script length before conversion
-		if scriptLen > 0x7fffffff { // Max int value
-			return nil, fmt.Errorf("output %d script length too large: %d", i, scriptLen)
+// readLockTime reads the trailing locktime field
+func readLockTime(r io.Reader, tx *Transaction) error {
+	var buf [4]byte
+	if err := readFull(r, buf[:], "locktime"); err != nil {
+		return err
+	}
+	tx.LockTime = binary.LittleEndian.Uint32(buf[:])
+	return nil
+}
+
+// readVarBytes reads a varint length followed by that many bytes
+func readVarBytes(r io.Reader, what string) ([]byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s length: %v", what, err)
+	}
+	if length > MaxPayload {
+		return nil, fmt.Errorf("%s length too large: %d", what, length)
+	}
+
+	data := make([]byte, int(length))
+	if err := readFull(r, data, what); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readVarInt reads a variable length integer from r
+func readVarInt(r io.Reader) (uint64, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("empty data")
 		}
-		scriptLenInt := int(scriptLen)
-		if len(data[offset:]) < scriptLenInt {
-			return nil, fmt.Errorf("insufficient data for output %d script", i)
+		return 0, err
+	}
+	return readVarIntAfter(r, first[0])
+}
+
+// readVarIntAfter reads the remainder of a variable length integer whose
+// first byte has already been consumed
+func readVarIntAfter(r io.Reader, first byte) (uint64, error) {
+	var size int
+	switch first {
+	case 0xfd:
+		size = 2
+	case 0xfe:
+		size = 4
+	case 0xff:
+		size = 8
+	default:
+		return uint64(first), nil
+	}
+
+	var buf [8]byte
+	if err := readFull(r, buf[:size], fmt.Sprintf("%x varint", first)); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// readFull fills buf from r, reporting a short read as insufficient data for what
+func readFull(r io.Reader, buf []byte, what string) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("insufficient data for %s", what)
 		}
-		tx.Outputs[i].ScriptPubKey = make([]byte, scriptLen)
-		copy(tx.Outputs[i].ScriptPubKey, data[offset:offset+scriptLenInt])
-		offset += scriptLenInt
+		return fmt.Errorf("failed to read %s: %w", what, err)
 	}
+	return nil
+}
 
-	// Locktime (4 bytes)
-	if len(data[offset:]) < 4 {
-		return nil, fmt.Errorf("insufficient data for locktime")
+// preallocCount bounds slice preallocation for counts read from untrusted input.
+// Larger counts still decode, the slice just grows as elements are read.
+func preallocCount(count uint64) int {
+	const maxPrealloc = 1024
+	if count > maxPrealloc {
+		return maxPrealloc
 	}
-	tx.LockTime = binary.LittleEndian.Uint32(data[offset : offset+4])
+	return int(count)
+}
 
-	// TODO: Handle witness data for SegWit transactions
-	// This would require detecting the witness flag and parsing witness data
+// Size returns the serialized size of the transaction in bytes, including
+// the marker, flag and witness data when present
+func (tx *Transaction) Size() int {
+	size := tx.StrippedSize()
+	if !tx.HasWitness() {
+		return size
+	}
 
-	return tx, nil
+	size += 2 // marker and flag
+	for _, input := range tx.Inputs {
+		size += witnessStackSize(input.Witness)
+	}
+	for _, witness := range tx.Witnesses {
+		size += witnessStackSize(witness.Stack)
+	}
+	return size
+}
+
+// StrippedSize returns the serialized size of the transaction in bytes without witness data
+func (tx *Transaction) StrippedSize() int {
+	size := 4 + VarIntSize(uint64(len(tx.Inputs))) // version and input count
+	for _, input := range tx.Inputs {
+		size += 32 + 4 // previous output hash and index
+		size += VarIntSize(uint64(len(input.ScriptSig))) + len(input.ScriptSig)
+		size += 4 // sequence
+	}
+
+	size += VarIntSize(uint64(len(tx.Outputs)))
+	for _, output := range tx.Outputs {
+		size += 8 // value
+		size += VarIntSize(uint64(len(output.ScriptPubKey))) + len(output.ScriptPubKey)
+	}
+
+	return size + 4 // locktime
+}
+
+// Weight returns the transaction weight as defined by BIP141
+func (tx *Transaction) Weight() int {
+	return tx.StrippedSize()*(WitnessScaleFactor-1) + tx.Size()
+}
+
+// VirtualSize returns the transaction weight divided by four, rounded up.
+// Fee rates are expressed per virtual byte.
+func (tx *Transaction) VirtualSize() int {
+	return (tx.Weight() + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
+
+// witnessStackSize returns the serialized size of one witness stack
+func witnessStackSize(stack [][]byte) int {
+	size := VarIntSize(uint64(len(stack)))
+	for _, item := range stack {
+		size += VarIntSize(uint64(len(item))) + len(item)
+	}
+	return size
 }
 
 // Hash returns the transaction ID (excludes witness data)
@@ -415,13 +632,20 @@ func (tx *Transaction) Hash() Hash256 {
 	return *tx.hash
 }
 
-// WitnessHash returns the witness transaction ID (includes witness data)
+// WitnessHash returns the witness transaction ID (includes witness data).
+// For transactions without witness data it equals Hash.
 func (tx *Transaction) WitnessHash() Hash256 {
 	if tx.wthash == nil {
-		// TODO: Implement witness transaction serialization and hashing
-		// For now, return zero hash
-		hash := ZeroHash
-		tx.wthash = &hash
+		serialized, err := tx.Serialize()
+		if err != nil {
+			// In case of serialization error, return zero hash
+			hash := ZeroHash
+			tx.wthash = &hash
+		} else {
+			// Displayed in reverse byte order like the txid
+			hash := reverseHash(DoubleHashSHA256(serialized))
+			tx.wthash = &hash
+		}
 	}
 	return *tx.wthash
 }
@@ -379,6 +379,155 @@ func TestVarInt(t *testing.T) {
 	}
 }
 
+// bip143SignedWitnessHex returns the BIP143 P2WPKH example transaction in
+// witness encoding, with only the segwit input signed
+func bip143SignedWitnessHex() string {
+	unsigned := bip143UnsignedTxHex
+	body := unsigned[8 : len(unsigned)-8] // inputs and outputs
+	// Input 0 has no witness, input 1 has <sig> <pubkey>
+	witness := "00" + "02" + "47" + bip143SigHex + "01" + "21" + bip143PubKeyHex
+	return unsigned[:8] + "0001" + body + witness + unsigned[len(unsigned)-8:]
+}
+
+// TestDecodeTransaction_Witness tests decoding of the BIP144 witness encoding
+func TestDecodeTransaction_Witness(t *testing.T) {
+	raw := mustDecodeHex(bip143SignedWitnessHex())
+
+	tx, bytesRead, err := DecodeTransaction(append(append([]byte{}, raw...), 0xde, 0xad))
+	if err != nil {
+		t.Fatalf("Failed to decode witness transaction: %v", err)
+	}
+	if bytesRead != len(raw) {
+		t.Errorf("Expected %d bytes read, got %d", len(raw), bytesRead)
+	}
+
+	if !tx.HasWitness() {
+		t.Fatal("Expected decoded transaction to have witness data")
+	}
+	if len(tx.Inputs[0].Witness) != 0 {
+		t.Errorf("Expected empty witness for input 0, got %d items", len(tx.Inputs[0].Witness))
+	}
+	if len(tx.Inputs[1].Witness) != 2 {
+		t.Fatalf("Expected 2 witness items for input 1, got %d", len(tx.Inputs[1].Witness))
+	}
+	if !bytes.Equal(tx.Inputs[1].Witness[1], mustDecodeHex(bip143PubKeyHex)) {
+		t.Errorf("Witness public key mismatch: %x", tx.Inputs[1].Witness[1])
+	}
+
+	// The txid excludes the witness and therefore matches the unsigned transaction
+	unsigned, err := DeserializeTransaction(mustDecodeHex(bip143UnsignedTxHex))
+	if err != nil {
+		t.Fatalf("Failed to deserialize unsigned transaction: %v", err)
+	}
+	if tx.Hash() != unsigned.Hash() {
+		t.Errorf("Expected txid %x, got %x", unsigned.Hash(), tx.Hash())
+	}
+
+	serialized, err := tx.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize transaction: %v", err)
+	}
+	if !bytes.Equal(serialized, raw) {
+		t.Errorf("Round-trip failed")
+		t.Errorf("Original:   %s", hex.EncodeToString(raw))
+		t.Errorf("Round-trip: %s", hex.EncodeToString(serialized))
+	}
+}
+
+// TestDeserializeTransaction_NonCanonical tests rejection of malformed and non-canonical encodings
+func TestDeserializeTransaction_NonCanonical(t *testing.T) {
+	signed := bip143SignedWitnessHex()
+	unsigned := bip143UnsignedTxHex
+	body := unsigned[8 : len(unsigned)-8]
+	version, lockTime := unsigned[:8], unsigned[len(unsigned)-8:]
+
+	tests := []struct {
+		name     string
+		dataHex  string
+		errorMsg string
+	}{
+		{"trailing bytes after legacy transaction", unsigned + "00", "trailing bytes"},
+		{"trailing bytes after witness transaction", signed + "0000", "trailing bytes"},
+		{"witness flag with all witnesses empty", version + "0001" + body + "0000" + lockTime,
+			"superfluous witness flag"},
+		{"unknown flag", version + "0002" + body + lockTime, "unknown transaction flag"},
+		{"truncated witness item", version + "0001" + body + "00" + "0147" + bip143SigHex[:20],
+			"insufficient data for input 1 witness item 0"},
+		{"missing witness stacks", version + "0001" + body, "witness count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DeserializeTransaction(mustDecodeHex(tt.dataHex))
+			if err == nil {
+				t.Fatalf("Expected error containing '%s', got none", tt.errorMsg)
+			}
+			if !contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Expected error containing '%s', got '%s'", tt.errorMsg, err.Error())
+			}
+		})
+	}
+}
+
+// TestTransaction_SizeAndWeight tests exact sizes and BIP141 weight
+func TestTransaction_SizeAndWeight(t *testing.T) {
+	tests := []struct {
+		name             string
+		txHex            string
+		expectedSize     int
+		expectedStripped int
+		expectedWeight   int
+		expectedVSize    int
+	}{
+		{
+			name:             "Genesis coinbase transaction",
+			txHex:            "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000",
+			expectedSize:     204,
+			expectedStripped: 204,
+			expectedWeight:   816,
+			expectedVSize:    204,
+		},
+		{
+			name:             "Witness transaction",
+			txHex:            bip143SignedWitnessHex(),
+			expectedSize:     270,
+			expectedStripped: 160,
+			expectedWeight:   750,
+			expectedVSize:    188,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := mustDecodeHex(tt.txHex)
+			tx, err := DeserializeTransaction(raw)
+			if err != nil {
+				t.Fatalf("Failed to deserialize transaction: %v", err)
+			}
+
+			if tx.Size() != len(raw) || tx.Size() != tt.expectedSize {
+				t.Errorf("Expected size %d (%d serialized), got %d", tt.expectedSize, len(raw), tx.Size())
+			}
+
+			stripped, err := tx.serializeForHashing()
+			if err != nil {
+				t.Fatalf("Failed to serialize stripped transaction: %v", err)
+			}
+			if tx.StrippedSize() != len(stripped) || tx.StrippedSize() != tt.expectedStripped {
+				t.Errorf("Expected stripped size %d (%d serialized), got %d",
+					tt.expectedStripped, len(stripped), tx.StrippedSize())
+			}
+
+			if tx.Weight() != tt.expectedWeight {
+				t.Errorf("Expected weight %d, got %d", tt.expectedWeight, tx.Weight())
+			}
+			if tx.VirtualSize() != tt.expectedVSize {
+				t.Errorf("Expected vsize %d, got %d", tt.expectedVSize, tx.VirtualSize())
+			}
+		})
+	}
+}
+
 // Helper functions for test data
 func mustDecodeHex(hexStr string) []byte {
 	data, err := hex.DecodeString(hexStr)
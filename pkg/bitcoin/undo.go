@@ -0,0 +1,105 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// TxUndo holds the outputs a transaction spent, in input order, so they can
+// be restored when its block is disconnected
+type TxUndo struct {
+	SpentOutputs []*UTXO // Each keeps the height and coinbase flag it was created with
+}
+
+// BlockUndo holds the undo data for every non-coinbase transaction of a block,
+// in block order. Disconnecting a block with it costs as much as connecting it,
+// so a reorganization only touches the blocks it switches.
+type BlockUndo struct {
+	Transactions []TxUndo
+}
+
+// Serialize encodes the undo data for storage in the coins database
+func (u *BlockUndo) Serialize() []byte {
+	var buf bytes.Buffer
+	buf.Write(EncodeVarInt(uint64(len(u.Transactions))))
+	for _, txUndo := range u.Transactions {
+		buf.Write(EncodeVarInt(uint64(len(txUndo.SpentOutputs))))
+		for _, utxo := range txUndo.SpentOutputs {
+			coin := encodeCoin(utxo)
+			buf.Write(EncodeVarInt(uint64(len(coin))))
+			buf.Write(coin)
+		}
+	}
+	return buf.Bytes()
+}
+
+// DeserializeBlockUndo decodes undo data written by Serialize
+func DeserializeBlockUndo(r io.Reader) (*BlockUndo, error) {
+	txCount, err := readVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode undo transaction count: %w", err)
+	}
+
+	undo := &BlockUndo{Transactions: make([]TxUndo, 0, preallocCount(txCount))}
+	for i := uint64(0); i < txCount; i++ {
+		spentCount, err := readVarInt(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode spent output count: %w", err)
+		}
+
+		txUndo := TxUndo{SpentOutputs: make([]*UTXO, 0, preallocCount(spentCount))}
+		for j := uint64(0); j < spentCount; j++ {
+			coin, err := readVarBytes(r, "spent output")
+			if err != nil {
+				return nil, err
+			}
+			utxo, err := decodeCoin(coin)
+			if err != nil {
+				return nil, err
+			}
+			txUndo.SpentOutputs = append(txUndo.SpentOutputs, utxo)
+		}
+		undo.Transactions = append(undo.Transactions, txUndo)
+	}
+	return undo, nil
+}
+
+// encodeCoin serializes an unspent output with its height and coinbase flag
+func encodeCoin(utxo *UTXO) []byte {
+	buf := make([]byte, 0, 49+VarIntSize(uint64(len(utxo.scriptPubKey)))+len(utxo.scriptPubKey))
+	buf = append(buf, utxo.txHash[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, utxo.outputIndex)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(utxo.height))
+	if utxo.coinbase {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, utxo.amount)
+	buf = append(buf, EncodeVarInt(uint64(len(utxo.scriptPubKey)))...)
+	return append(buf, utxo.scriptPubKey...)
+}
+
+// decodeCoin parses an unspent output written by encodeCoin
+func decodeCoin(data []byte) (*UTXO, error) {
+	if len(data) < 49 {
+		return nil, fmt.Errorf("coin too short: %d bytes", len(data))
+	}
+
+	var txHash Hash256
+	copy(txHash[:], data[:32])
+	scriptLen, n, err := DecodeVarInt(data[49:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode script length: %w", err)
+	}
+	if uint64(len(data)-49-n) != scriptLen {
+		return nil, fmt.Errorf("coin script length %d does not match %d remaining bytes", scriptLen, len(data)-49-n)
+	}
+
+	utxo := NewUTXO(txHash, binary.LittleEndian.Uint32(data[32:36]), binary.LittleEndian.Uint64(data[41:49]), data[49+n:])
+	utxo.height = int32(binary.LittleEndian.Uint32(data[36:40]))
+	utxo.coinbase = data[40] == 1
+	return utxo, nil
+}
@@ -1,6 +1,7 @@
 package bitcoin
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -11,6 +12,8 @@ type UTXO struct {
 	outputIndex  uint32
 	amount       uint64
 	scriptPubKey []byte
+	height       int32 // Height of the block that created the output
+	coinbase     bool  // Created by a coinbase transaction
 }
 
 // NewUTXO creates a new UTXO
@@ -25,6 +28,15 @@ func NewUTXO(txHash Hash256, outputIndex uint32, amount uint64, scriptPubKey []b
 	}
 }
 
+// NewUTXOWithHeight creates a UTXO created at the given height, by a
+// coinbase transaction if coinbase is set
+func NewUTXOWithHeight(txHash Hash256, outputIndex uint32, amount uint64, scriptPubKey []byte, height int32, coinbase bool) *UTXO {
+	utxo := NewUTXO(txHash, outputIndex, amount, scriptPubKey)
+	utxo.height = height
+	utxo.coinbase = coinbase
+	return utxo
+}
+
 // TxHash returns the transaction hash
 func (u *UTXO) TxHash() Hash256 {
 	return u.txHash
@@ -45,54 +57,166 @@ func (u *UTXO) ScriptPubKey() []byte {
 	return u.scriptPubKey
 }
 
-// UTXOSet represents a set of unspent transaction outputs
-// TDD GREEN: Basic implementation using map for fast lookups
+// Height returns the height of the block that created the output
+func (u *UTXO) Height() int32 {
+	return u.height
+}
+
+// IsCoinbase reports whether the output was created by a coinbase transaction
+func (u *UTXO) IsCoinbase() bool {
+	return u.coinbase
+}
+
+// IsMature reports whether the output may be spent in a block at spendHeight.
+// Coinbase outputs need CoinbaseMaturity confirmations; others are always mature.
+func (u *UTXO) IsMature(spendHeight int32) bool {
+	return !u.coinbase || spendHeight-u.height >= CoinbaseMaturity
+}
+
+// ErrCoinsDatabase reports that coins could not be read from disk
+var ErrCoinsDatabase = errors.New("coins database read failed")
+
+// DefaultDBCache is the default memory budget of the coins cache, in bytes
+const DefaultDBCache = 450 << 20
+
+// utxoCacheEntryOverhead approximates the memory used by a cached coin
+// besides its script: map slot, outpoint key, entry and UTXO structs
+const utxoCacheEntryOverhead = 160
+
+// utxoCacheEntry is a coin held in memory. A nil utxo marks a spent coin
+// that still has to be deleted from the database.
+type utxoCacheEntry struct {
+	utxo  *UTXO
+	dirty bool // Differs from the database
+	fresh bool // Not in the database, so spending it needs no delete
+}
+
+// UTXOSet represents a set of unspent transaction outputs.
+// Without a database every coin lives in memory. With one, memory acts as a
+// write-back cache: changes are kept until Flush writes them in one atomic
+// batch together with the best block, and coins are read back on demand.
 type UTXOSet struct {
-	utxos map[string]*UTXO // key: txHash:outputIndex
+	cache map[OutPoint]*utxoCacheEntry
+	count int // Unspent outputs, in memory and on disk
+
+	db            *CoinsDB
+	dbErr         error // First database read error, reported by Flush
+	cacheBytes    int64
+	maxCacheBytes int64
+
+	// Undo data of connected blocks not yet flushed, and of disconnected
+	// blocks still to be deleted from the database
+	undo        map[Hash256]*BlockUndo
+	undoDeleted map[Hash256]bool
 }
 
-// NewUTXOSet creates a new UTXO set
+// NewUTXOSet creates a new in-memory UTXO set
 func NewUTXOSet() *UTXOSet {
 	return &UTXOSet{
-		utxos: make(map[string]*UTXO),
+		cache:       make(map[OutPoint]*utxoCacheEntry),
+		undo:        make(map[Hash256]*BlockUndo),
+		undoDeleted: make(map[Hash256]bool),
+	}
+}
+
+// NewUTXOSetWithDB creates a UTXO set stored in db, caching up to
+// cacheBytes of changes and lookups in memory
+func NewUTXOSetWithDB(db *CoinsDB, cacheBytes int64) *UTXOSet {
+	s := NewUTXOSet()
+	s.db = db
+	s.count = db.Count()
+	s.maxCacheBytes = cacheBytes
+	return s
+}
+
+// fetch returns the cache entry for an outpoint, reading it from the database if needed
+func (s *UTXOSet) fetch(outpoint OutPoint) *utxoCacheEntry {
+	if entry, exists := s.cache[outpoint]; exists {
+		return entry
+	}
+	if s.db == nil {
+		return nil
+	}
+
+	utxo, found, err := s.db.GetCoin(outpoint)
+	if err != nil {
+		if s.dbErr == nil {
+			s.dbErr = err
+		}
+		return nil
+	}
+	if !found {
+		return nil
 	}
+	entry := &utxoCacheEntry{utxo: utxo}
+	s.cache[outpoint] = entry
+	s.cacheBytes += cacheEntrySize(utxo)
+	return entry
 }
 
-// makeKey creates a unique key for UTXO indexing
-func (s *UTXOSet) makeKey(txHash Hash256, outputIndex uint32) string {
-	return fmt.Sprintf("%s:%d", txHash.String(), outputIndex)
+// cacheEntrySize estimates the memory used by a cached coin
+func cacheEntrySize(utxo *UTXO) int64 {
+	return utxoCacheEntryOverhead + int64(len(utxo.scriptPubKey))
 }
 
 // Add adds a UTXO to the set
 func (s *UTXOSet) Add(utxo *UTXO) {
-	key := s.makeKey(utxo.txHash, utxo.outputIndex)
-	s.utxos[key] = utxo
+	outpoint := OutPoint{Hash: utxo.txHash, Index: utxo.outputIndex}
+	entry, exists := s.cache[outpoint]
+	if !exists {
+		// Outputs are created once, so a coin missing from the cache is not on disk
+		s.cache[outpoint] = &utxoCacheEntry{utxo: utxo, dirty: true, fresh: true}
+		s.cacheBytes += cacheEntrySize(utxo)
+		s.count++
+		return
+	}
+
+	if entry.utxo == nil {
+		s.count++
+	} else {
+		s.cacheBytes -= cacheEntrySize(entry.utxo)
+	}
+	entry.utxo = utxo
+	entry.dirty = true
+	s.cacheBytes += cacheEntrySize(utxo)
 }
 
 // Remove removes a UTXO from the set
 func (s *UTXOSet) Remove(txHash Hash256, outputIndex uint32) bool {
-	key := s.makeKey(txHash, outputIndex)
-	if _, exists := s.utxos[key]; exists {
-		delete(s.utxos, key)
+	outpoint := OutPoint{Hash: txHash, Index: outputIndex}
+	entry := s.fetch(outpoint)
+	if entry == nil || entry.utxo == nil {
+		return false
+	}
+
+	s.count--
+	if entry.fresh || s.db == nil {
+		s.cacheBytes -= cacheEntrySize(entry.utxo)
+		delete(s.cache, outpoint)
 		return true
 	}
-	return false
+	entry.utxo = nil
+	entry.dirty = true
+	return true
 }
 
 // Find finds a UTXO in the set
 func (s *UTXOSet) Find(txHash Hash256, outputIndex uint32) (*UTXO, bool) {
-	key := s.makeKey(txHash, outputIndex)
-	utxo, exists := s.utxos[key]
-	return utxo, exists
+	entry := s.fetch(OutPoint{Hash: txHash, Index: outputIndex})
+	if entry == nil || entry.utxo == nil {
+		return nil, false
+	}
+	return entry.utxo, true
 }
 
 // Size returns the number of UTXOs in the set
 func (s *UTXOSet) Size() int {
-	return len(s.utxos)
+	return s.count
 }
 
 // ValidateSpend validates if a UTXO can be spent
-// TDD GREEN: Basic validation to check if UTXO exists and has sufficient amount
+// TDD GREEN: Basic validation to check if UTXO exists and has sufficient amount.
+// Use CheckTxInputs to validate every input of a transaction.
 func (s *UTXOSet) ValidateSpend(txHash Hash256, outputIndex uint32, amount uint64) bool {
 	utxo, exists := s.Find(txHash, outputIndex)
 	if !exists {
@@ -104,25 +228,276 @@ func (s *UTXOSet) ValidateSpend(txHash Hash256, outputIndex uint32, amount uint6
 	return utxo.amount >= amount
 }
 
+// forEach calls fn with every UTXO in the set, cached or on disk
+func (s *UTXOSet) forEach(fn func(*UTXO)) {
+	for _, entry := range s.cache {
+		if entry.utxo != nil {
+			fn(entry.utxo)
+		}
+	}
+	if s.db == nil {
+		return
+	}
+	err := s.db.ForEachCoin(func(utxo *UTXO) {
+		if _, cached := s.cache[OutPoint{Hash: utxo.txHash, Index: utxo.outputIndex}]; !cached {
+			fn(utxo)
+		}
+	})
+	if err != nil && s.dbErr == nil {
+		s.dbErr = err
+	}
+}
+
 // TotalValue calculates the total value of all UTXOs in the set
 func (s *UTXOSet) TotalValue() uint64 {
 	total := uint64(0)
-	for _, utxo := range s.utxos {
+	s.forEach(func(utxo *UTXO) {
 		total += utxo.amount
-	}
+	})
 	return total
 }
 
 // GetAllUTXOs returns all UTXOs in the set
 func (s *UTXOSet) GetAllUTXOs() []*UTXO {
-	utxos := make([]*UTXO, 0, len(s.utxos))
-	for _, utxo := range s.utxos {
+	utxos := make([]*UTXO, 0, s.count)
+	s.forEach(func(utxo *UTXO) {
 		utxos = append(utxos, utxo)
-	}
+	})
 	return utxos
 }
 
 // Clear removes all UTXOs from the set
 func (s *UTXOSet) Clear() {
-	s.utxos = make(map[string]*UTXO)
+	for _, utxo := range s.GetAllUTXOs() {
+		s.Remove(utxo.txHash, utxo.outputIndex)
+	}
+}
+
+// CacheSize returns the estimated memory used by cached coins, in bytes
+func (s *UTXOSet) CacheSize() int64 {
+	return s.cacheBytes
+}
+
+// NeedsFlush reports whether the cache has outgrown its memory budget
+func (s *UTXOSet) NeedsFlush() bool {
+	return s.db != nil && s.cacheBytes > s.maxCacheBytes
+}
+
+// BestBlock returns the block the coins on disk are the result of
+// connecting, or ZeroHash for an in-memory set or an empty database
+func (s *UTXOSet) BestBlock() Hash256 {
+	if s.db == nil {
+		return ZeroHash
+	}
+	return s.db.BestBlock()
+}
+
+// Flush writes every change since the last flush to the database in one
+// batch, recording bestBlock as the block the set now reflects, and empties
+// the cache. It does nothing for an in-memory set.
+func (s *UTXOSet) Flush(bestBlock Hash256) error {
+	if s.db == nil {
+		return nil
+	}
+	if err := s.readError(); err != nil {
+		return err
+	}
+
+	batch := &coinsBatch{undo: s.undo}
+	for outpoint, entry := range s.cache {
+		switch {
+		case !entry.dirty:
+		case entry.utxo != nil:
+			batch.coins = append(batch.coins, entry.utxo)
+		case !entry.fresh:
+			batch.spent = append(batch.spent, outpoint)
+		}
+	}
+	for hash := range s.undoDeleted {
+		batch.undoDeleted = append(batch.undoDeleted, hash)
+	}
+
+	if err := s.db.write(batch, bestBlock); err != nil {
+		return err
+	}
+
+	s.cache = make(map[OutPoint]*utxoCacheEntry)
+	s.cacheBytes = 0
+	s.undo = make(map[Hash256]*BlockUndo)
+	s.undoDeleted = make(map[Hash256]bool)
+	return nil
+}
+
+// BlockUndo returns the undo data recorded when a block was connected
+func (s *UTXOSet) BlockUndo(hash Hash256) (*BlockUndo, error) {
+	if undo, exists := s.undo[hash]; exists {
+		return undo, nil
+	}
+	if s.db == nil || s.undoDeleted[hash] {
+		return nil, fmt.Errorf("no undo data for block %s", hash)
+	}
+	undo, found, err := s.db.GetUndo(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no undo data for block %s", hash)
+	}
+	return undo, nil
+}
+
+// ConnectBlock applies a block's transactions at the given height through a
+// UTXOView, removing the outputs they spend and adding the outputs they
+// create. The set is only changed if every transaction is valid against it.
+// The spent outputs are returned as undo data so DisconnectBlock can reverse
+// the change, and kept until then for BlockUndo.
+func (s *UTXOSet) ConnectBlock(block *Block, height int32) (*BlockUndo, error) {
+	view := NewUTXOView(s)
+	undo, err := view.ConnectBlock(block, height)
+	if readErr := s.readError(); readErr != nil {
+		return nil, readErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.commitBlock(block.Hash(), view, undo)
+	return undo, nil
+}
+
+// readError returns the first database read failure wrapped in
+// ErrCoinsDatabase. A failed read looks like a missing output, so it takes
+// precedence over any error it caused.
+func (s *UTXOSet) readError() error {
+	if s.dbErr != nil {
+		return fmt.Errorf("%w: %v", ErrCoinsDatabase, s.dbErr)
+	}
+	return nil
+}
+
+// commitBlock commits a view on the set holding a connected block and keeps
+// the block's undo data
+func (s *UTXOSet) commitBlock(hash Hash256, view *UTXOView, undo *BlockUndo) {
+	view.Commit()
+	s.undo[hash] = undo
+	delete(s.undoDeleted, hash)
+}
+
+// DisconnectBlock reverses ConnectBlock, removing the outputs the block
+// created and restoring the outputs it spent from its undo data. If an output
+// the block created is missing the set is inconsistent, and it is left
+// unchanged with an error.
+func (s *UTXOSet) DisconnectBlock(block *Block, undo *BlockUndo) error {
+	hash := block.Hash()
+	if undo == nil || len(undo.Transactions) != len(block.Transactions)-1 {
+		return fmt.Errorf("undo data does not match block %s", hash)
+	}
+
+	// Outputs spent later in the block itself are the only ones not in the set
+	spentInBlock := make(map[OutPoint]bool)
+	for i := 1; i < len(block.Transactions); i++ {
+		for _, input := range block.Transactions[i].Inputs {
+			spentInBlock[input.PreviousOutput] = true
+		}
+	}
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txHash := tx.Hash()
+		for j, output := range tx.Outputs {
+			outpoint := OutPoint{Hash: txHash, Index: uint32(j)}
+			if Script(output.ScriptPubKey).IsUnspendable() {
+				continue
+			}
+			if _, found := s.Find(txHash, uint32(j)); !found && !spentInBlock[outpoint] {
+				return fmt.Errorf("output %s created by block %s missing from the UTXO set", outpoint, hash)
+			}
+		}
+	}
+
+	delete(s.undo, hash)
+	if s.db != nil {
+		s.undoDeleted[hash] = true
+	}
+
+	// Walk backwards so outputs created and spent within the block are
+	// removed after they have been restored
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := &block.Transactions[i]
+		txHash := tx.Hash()
+		for j, output := range tx.Outputs {
+			if Script(output.ScriptPubKey).IsUnspendable() {
+				continue
+			}
+			if !s.Remove(txHash, uint32(j)) {
+				// Unreachable: the outputs were checked above
+				return fmt.Errorf("output %s:%d created by block %s missing from the UTXO set", txHash, j, hash)
+			}
+		}
+
+		if i == 0 {
+			break
+		}
+		spent := undo.Transactions[i-1].SpentOutputs
+		for j := len(spent) - 1; j >= 0; j-- {
+			s.Add(spent[j])
+		}
+	}
+	return nil
+}
+
+// CoinbaseMaturity is the number of blocks a coinbase output must wait
+// before it can be spent
+const CoinbaseMaturity = 100
+
+// UTXOLookup finds unspent outputs. It is implemented by UTXOSet and by views
+// layered on top of one.
+type UTXOLookup interface {
+	Find(txHash Hash256, outputIndex uint32) (*UTXO, bool)
+}
+
+// CheckTxInputs checks a transaction's inputs against the unspent outputs in
+// coins, for inclusion in a block at spendHeight. Every input must spend an
+// existing output, coinbase outputs must be mature, input and output values
+// must lie within MaxMoney, and the inputs must cover the outputs. The fee is
+// returned on success. Scripts are not checked. Rule violations are
+// reported as a RuleError.
+func CheckTxInputs(tx *Transaction, coins UTXOLookup, spendHeight int32) (uint64, error) {
+	if tx.IsCoinbase() {
+		return 0, errors.New("coinbase transaction has no inputs to check")
+	}
+
+	var valueIn uint64
+	for i, input := range tx.Inputs {
+		prev := input.PreviousOutput
+		utxo, found := coins.Find(prev.Hash, prev.Index)
+		if !found {
+			return 0, ruleError(ErrMissingTxOut, "input %d spends missing or spent output %s", i, prev)
+		}
+		if !utxo.IsMature(spendHeight) {
+			return 0, ruleError(ErrImmatureSpend, "input %d spends coinbase output %s at depth %d, needs %d",
+				i, prev, spendHeight-utxo.height, CoinbaseMaturity)
+		}
+		if utxo.amount > MaxMoney {
+			return 0, ruleError(ErrBadTxOutValue, "input %d value %d out of range", i, utxo.amount)
+		}
+		valueIn += utxo.amount
+		if valueIn > MaxMoney {
+			return 0, ruleError(ErrBadTxOutValue, "total input value out of range")
+		}
+	}
+
+	var valueOut uint64
+	for i, output := range tx.Outputs {
+		if output.Value > MaxMoney {
+			return 0, ruleError(ErrBadTxOutValue, "output %d value %d out of range", i, output.Value)
+		}
+		valueOut += output.Value
+		if valueOut > MaxMoney {
+			return 0, ruleError(ErrBadTxOutValue, "total output value out of range")
+		}
+	}
+
+	if valueIn < valueOut {
+		return 0, ruleError(ErrSpendTooHigh, "input value %d less than output value %d", valueIn, valueOut)
+	}
+	return valueIn - valueOut, nil
 }
@@ -438,3 +438,178 @@ func TestUTXO_ScriptPubKey(t *testing.T) {
 		}
 	}
 }
+
+// TestUTXOSet_ConnectDisconnectBlock tests that disconnecting a block with its undo data restores the set
+func TestUTXOSet_ConnectDisconnectBlock(t *testing.T) {
+	utxoSet := NewUTXOSet()
+	funding := NewUTXO(Hash256{0x01}, 0, 5000000000, []byte{0x51})
+	funding.height = 7
+	funding.coinbase = true
+	utxoSet.Add(funding)
+	utxoSet.Add(NewUTXO(Hash256{0x02}, 1, 1000, []byte{0x52}))
+
+	// The second transaction spends the funding output, the third spends the
+	// second. Each also creates an output that can never be spent.
+	spend := Transaction{
+		Version: 1,
+		Inputs:  []TxInput{{PreviousOutput: OutPoint{Hash: Hash256{0x01}, Index: 0}, Sequence: 0xffffffff}},
+		Outputs: []TxOutput{
+			{Value: 4000000000, ScriptPubKey: []byte{0x53}},
+			{Value: 0, ScriptPubKey: make([]byte, MaxScriptSize+1)},
+		},
+	}
+	chained := Transaction{
+		Version: 1,
+		Inputs:  []TxInput{{PreviousOutput: OutPoint{Hash: spend.Hash(), Index: 0}, Sequence: 0xffffffff}},
+		Outputs: []TxOutput{
+			{Value: 3000000000, ScriptPubKey: []byte{0x54}},
+			{Value: 0, ScriptPubKey: []byte{byte(OP_RETURN), 0x01, 0xff}},
+		},
+	}
+	block := newTestBlock(BlockHeader{Version: 1}, []Transaction{*createUniqueCoinbaseTransaction(5000000000, 1), spend, chained})
+
+	before := utxoSet.GetAllUTXOs()
+	undo, err := utxoSet.ConnectBlock(block, 200)
+	if err != nil {
+		t.Fatalf("Failed to connect block: %v", err)
+	}
+
+	if _, found := utxoSet.Find(Hash256{0x01}, 0); found {
+		t.Error("Expected funding output to be spent")
+	}
+	if _, found := utxoSet.Find(spend.Hash(), 0); found {
+		t.Error("Expected output spent within the block to be removed")
+	}
+	created, found := utxoSet.Find(chained.Hash(), 0)
+	if !found || created.height != 200 || created.coinbase {
+		t.Errorf("Expected output created at height 200, got %+v", created)
+	}
+	coinbase, found := utxoSet.Find(block.Transactions[0].Hash(), 0)
+	if !found || !coinbase.coinbase {
+		t.Error("Expected coinbase output to be flagged")
+	}
+	if utxoSet.Size() != len(before)+1 {
+		t.Errorf("Expected unspendable outputs to be left out, got %d UTXOs", utxoSet.Size())
+	}
+	if len(undo.Transactions) != 2 || undo.Transactions[0].SpentOutputs[0] != funding {
+		t.Fatal("Expected undo data to record the spent funding output")
+	}
+
+	if err := utxoSet.DisconnectBlock(block, undo); err != nil {
+		t.Fatalf("Failed to disconnect block: %v", err)
+	}
+	if utxoSet.Size() != len(before) {
+		t.Fatalf("Expected %d UTXOs after disconnect, got %d", len(before), utxoSet.Size())
+	}
+	for _, utxo := range before {
+		restored, found := utxoSet.Find(utxo.TxHash(), utxo.OutputIndex())
+		if !found || restored.height != utxo.height || restored.coinbase != utxo.coinbase {
+			t.Errorf("Expected %s:%d to be restored unchanged", utxo.TxHash(), utxo.OutputIndex())
+		}
+	}
+
+	if err := utxoSet.DisconnectBlock(block, &BlockUndo{}); err == nil {
+		t.Error("Expected mismatched undo data to be rejected")
+	}
+
+	// An output of the block missing from the set makes the disconnect fail untouched
+	undo, err = utxoSet.ConnectBlock(block, 200)
+	if err != nil {
+		t.Fatalf("Failed to reconnect block: %v", err)
+	}
+	utxoSet.Remove(chained.Hash(), 0)
+	size := utxoSet.Size()
+	err = utxoSet.DisconnectBlock(block, undo)
+	if err == nil || !contains(err.Error(), "missing from the UTXO set") {
+		t.Errorf("Expected missing output error, got %v", err)
+	}
+	if utxoSet.Size() != size {
+		t.Error("Expected UTXO set unchanged by the failed disconnect")
+	}
+}
+
+// TestUTXO_IsMature tests the coinbase maturity rule
+func TestUTXO_IsMature(t *testing.T) {
+	tests := []struct {
+		name        string
+		coinbase    bool
+		spendHeight int32
+		expected    bool
+	}{
+		{"regular output in next block", false, 11, true},
+		{"coinbase at 99 confirmations", true, 109, false},
+		{"coinbase at 100 confirmations", true, 110, true},
+		{"coinbase in next block", true, 11, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utxo := NewUTXOWithHeight(Hash256{0x01}, 0, 1000, nil, 10, tt.coinbase)
+			if utxo.Height() != 10 || utxo.IsCoinbase() != tt.coinbase {
+				t.Errorf("Expected height 10 and coinbase %t, got %d and %t", tt.coinbase, utxo.Height(), utxo.IsCoinbase())
+			}
+			if utxo.IsMature(tt.spendHeight) != tt.expected {
+				t.Errorf("Expected mature %t at height %d, got %t", tt.expected, tt.spendHeight, !tt.expected)
+			}
+		})
+	}
+}
+
+// TestCheckTxInputs tests checking a transaction's inputs against a UTXO set
+func TestCheckTxInputs(t *testing.T) {
+	utxoSet := NewUTXOSet()
+	utxoSet.Add(NewUTXOWithHeight(Hash256{0x01}, 0, 5000, nil, 1, false))
+	utxoSet.Add(NewUTXOWithHeight(Hash256{0x02}, 0, 3000, nil, 1, false))
+	utxoSet.Add(NewUTXOWithHeight(Hash256{0x03}, 0, 5000000000, nil, 50, true))
+	utxoSet.Add(NewUTXOWithHeight(Hash256{0x04}, 0, MaxMoney, nil, 1, false))
+	utxoSet.Add(NewUTXOWithHeight(Hash256{0x05}, 0, MaxMoney+1, nil, 1, false))
+
+	spending := func(values []uint64, prevs ...Hash256) *Transaction {
+		tx := &Transaction{Version: 1}
+		for _, prev := range prevs {
+			tx.Inputs = append(tx.Inputs, TxInput{PreviousOutput: OutPoint{Hash: prev, Index: 0}, Sequence: 0xffffffff})
+		}
+		for _, value := range values {
+			tx.Outputs = append(tx.Outputs, TxOutput{Value: value, ScriptPubKey: []byte{0x51}})
+		}
+		return tx
+	}
+
+	tests := []struct {
+		name          string
+		tx            *Transaction
+		spendHeight   int32
+		expectedFee   uint64
+		errorContains string
+	}{
+		{"fee from two inputs", spending([]uint64{7000}, Hash256{0x01}, Hash256{0x02}), 10, 1000, ""},
+		{"no fee", spending([]uint64{2000, 3000}, Hash256{0x01}), 10, 0, ""},
+		{"missing input", spending([]uint64{1000}, Hash256{0x01}, Hash256{0x09}), 10, 0, "missing or spent"},
+		{"outputs exceed inputs", spending([]uint64{5001}, Hash256{0x01}), 10, 0, "less than output value"},
+		{"immature coinbase", spending([]uint64{1000}, Hash256{0x03}), 149, 0, "spends coinbase output"},
+		{"mature coinbase", spending([]uint64{1000}, Hash256{0x03}), 150, 4999999000, ""},
+		{"input value out of range", spending([]uint64{1000}, Hash256{0x05}), 10, 0, "input 0 value"},
+		{"total input out of range", spending([]uint64{1000}, Hash256{0x04}, Hash256{0x01}), 10, 0, "total input value"},
+		{"output value out of range", spending([]uint64{MaxMoney + 1}, Hash256{0x04}), 10, 0, "output 0 value"},
+		{"total output out of range", spending([]uint64{MaxMoney, 1}, Hash256{0x04}), 10, 0, "total output value"},
+		{"coinbase", createUniqueCoinbaseTransaction(5000000000, 1), 10, 0, "coinbase"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee, err := CheckTxInputs(tt.tx, utxoSet, tt.spendHeight)
+			if tt.errorContains != "" {
+				if err == nil || !contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected inputs to be valid, got %v", err)
+			}
+			if fee != tt.expectedFee {
+				t.Errorf("Expected fee %d, got %d", tt.expectedFee, fee)
+			}
+		})
+	}
+}
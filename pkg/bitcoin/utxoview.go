@@ -0,0 +1,166 @@
+package bitcoin
+
+import "fmt"
+
+// UTXOStore is a set of unspent outputs that a UTXOView can be layered on
+// and committed to. It is implemented by UTXOSet and UTXOView.
+type UTXOStore interface {
+	UTXOLookup
+	Add(utxo *UTXO)
+	Remove(txHash Hash256, outputIndex uint32) bool
+}
+
+// utxoViewEntry is an output created or spent in a view. A nil utxo marks an
+// output of the base that the view has spent.
+type utxoViewEntry struct {
+	utxo  *UTXO
+	fresh bool // Created in the view, so spending it leaves nothing to commit
+}
+
+// UTXOView records changes to an underlying UTXOStore without applying them.
+// A block is connected against a view, and the view is only committed to its
+// base once every transaction in the block has been accepted, so a block
+// that turns out to be invalid leaves the base untouched.
+type UTXOView struct {
+	base    UTXOStore
+	entries map[OutPoint]*utxoViewEntry
+}
+
+// NewUTXOView creates an empty view on top of base
+func NewUTXOView(base UTXOStore) *UTXOView {
+	return &UTXOView{
+		base:    base,
+		entries: make(map[OutPoint]*utxoViewEntry),
+	}
+}
+
+// Find finds an unspent output, looking in the base if the view has not changed it
+func (v *UTXOView) Find(txHash Hash256, outputIndex uint32) (*UTXO, bool) {
+	if entry, exists := v.entries[OutPoint{Hash: txHash, Index: outputIndex}]; exists {
+		return entry.utxo, entry.utxo != nil
+	}
+	return v.base.Find(txHash, outputIndex)
+}
+
+// Add adds an output to the view
+func (v *UTXOView) Add(utxo *UTXO) {
+	outpoint := OutPoint{Hash: utxo.txHash, Index: utxo.outputIndex}
+	if entry, exists := v.entries[outpoint]; exists {
+		entry.utxo = utxo
+		return
+	}
+	// Outputs are created once, so an output new to the view is new to the base
+	v.entries[outpoint] = &utxoViewEntry{utxo: utxo, fresh: true}
+}
+
+// Remove spends an output, reporting whether it was unspent
+func (v *UTXOView) Remove(txHash Hash256, outputIndex uint32) bool {
+	_, err := v.Spend(OutPoint{Hash: txHash, Index: outputIndex})
+	return err == nil
+}
+
+// Spend marks an output as spent and returns it. Spending an output that is
+// missing, or already spent in the view, is an error.
+func (v *UTXOView) Spend(outpoint OutPoint) (*UTXO, error) {
+	entry, exists := v.entries[outpoint]
+	if exists {
+		if entry.utxo == nil {
+			return nil, ruleError(ErrDoubleSpend, "output %s already spent", outpoint)
+		}
+		utxo := entry.utxo
+		if entry.fresh {
+			delete(v.entries, outpoint)
+		} else {
+			entry.utxo = nil
+		}
+		return utxo, nil
+	}
+
+	utxo, found := v.base.Find(outpoint.Hash, outpoint.Index)
+	if !found {
+		return nil, ruleError(ErrMissingTxOut, "output %s missing or spent", outpoint)
+	}
+	v.entries[outpoint] = &utxoViewEntry{}
+	return utxo, nil
+}
+
+// ConnectTransaction checks a transaction's inputs against the view with
+// CheckTxInputs, then spends them and adds its spendable outputs at the given height.
+// The spent outputs are returned as undo data along with the fee; coinbase
+// transactions only add outputs. On error the view is unchanged.
+func (v *UTXOView) ConnectTransaction(tx *Transaction, height int32) (TxUndo, uint64, error) {
+	coinbase := tx.IsCoinbase()
+	var undo TxUndo
+	var fee uint64
+
+	if !coinbase {
+		// Report double spends as such rather than as missing outputs
+		seen := make(map[OutPoint]bool, len(tx.Inputs))
+		for i, input := range tx.Inputs {
+			prev := input.PreviousOutput
+			if seen[prev] {
+				return TxUndo{}, 0, ruleError(ErrDoubleSpend, "input %d spends output %s twice in one transaction", i, prev)
+			}
+			seen[prev] = true
+			if entry, exists := v.entries[prev]; exists && entry.utxo == nil {
+				return TxUndo{}, 0, ruleError(ErrDoubleSpend, "input %d spends output %s already spent in this block", i, prev)
+			}
+		}
+
+		var err error
+		if fee, err = CheckTxInputs(tx, v, height); err != nil {
+			return TxUndo{}, 0, err
+		}
+		for _, input := range tx.Inputs {
+			spent, err := v.Spend(input.PreviousOutput)
+			if err != nil {
+				return TxUndo{}, 0, err // Unreachable: the inputs were checked above
+			}
+			undo.SpentOutputs = append(undo.SpentOutputs, spent)
+		}
+	}
+
+	txHash := tx.Hash()
+	for i, output := range tx.Outputs {
+		if Script(output.ScriptPubKey).IsUnspendable() {
+			continue
+		}
+		v.Add(NewUTXOWithHeight(txHash, uint32(i), output.Value, output.ScriptPubKey, height, coinbase))
+	}
+	return undo, fee, nil
+}
+
+// ConnectBlock connects every transaction of a block to the view in order,
+// so a transaction may spend outputs created earlier in the same block. It
+// returns the block's undo data, or the first transaction that fails.
+func (v *UTXOView) ConnectBlock(block *Block, height int32) (*BlockUndo, error) {
+	undo := &BlockUndo{}
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txUndo, _, err := v.ConnectTransaction(tx, height)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d (%s): %w", i, tx.Hash(), err)
+		}
+		if !tx.IsCoinbase() {
+			undo.Transactions = append(undo.Transactions, txUndo)
+		}
+	}
+	return undo, nil
+}
+
+// Commit applies the view's changes to its base and empties the view
+func (v *UTXOView) Commit() {
+	// Spends first, so an output spent and created again ends up unspent. An
+	// output of the base that the view replaced is spent and then added back.
+	for outpoint, entry := range v.entries {
+		if !entry.fresh {
+			v.base.Remove(outpoint.Hash, outpoint.Index)
+		}
+	}
+	for _, entry := range v.entries {
+		if entry.utxo != nil {
+			v.base.Add(entry.utxo)
+		}
+	}
+	v.entries = make(map[OutPoint]*utxoViewEntry)
+}
@@ -0,0 +1,187 @@
+package bitcoin
+
+import (
+	"testing"
+)
+
+// spendingTransaction creates a transaction spending the given outpoints into one output
+func spendingTransaction(value uint64, prevs ...OutPoint) Transaction {
+	tx := Transaction{
+		Version: 1,
+		Outputs: []TxOutput{{Value: value, ScriptPubKey: []byte{0x51}}},
+	}
+	for _, prev := range prevs {
+		tx.Inputs = append(tx.Inputs, TxInput{PreviousOutput: prev, Sequence: 0xffffffff})
+	}
+	return tx
+}
+
+// TestUTXOView_Commit tests that a view only changes its base when committed
+func TestUTXOView_Commit(t *testing.T) {
+	base := NewUTXOSet()
+	base.Add(NewUTXO(Hash256{0x01}, 0, 1000, nil))
+	base.Add(NewUTXO(Hash256{0x02}, 0, 2000, nil))
+
+	view := NewUTXOView(base)
+	view.Add(NewUTXO(Hash256{0x03}, 0, 3000, nil))
+	if _, err := view.Spend(OutPoint{Hash: Hash256{0x01}}); err != nil {
+		t.Fatalf("Failed to spend base output: %v", err)
+	}
+
+	// An output created and spent in the view never reaches the base
+	view.Add(NewUTXO(Hash256{0x04}, 0, 4000, nil))
+	if !view.Remove(Hash256{0x04}, 0) {
+		t.Fatal("Expected output created in the view to be spendable")
+	}
+
+	if _, found := view.Find(Hash256{0x01}, 0); found {
+		t.Error("Expected spent output to be hidden by the view")
+	}
+	if _, found := view.Find(Hash256{0x02}, 0); !found {
+		t.Error("Expected untouched output to be found through the view")
+	}
+	if _, err := view.Spend(OutPoint{Hash: Hash256{0x01}}); err == nil {
+		t.Error("Expected spending an output twice to fail")
+	}
+	if base.Size() != 2 {
+		t.Fatalf("Expected base unchanged before commit, got %d UTXOs", base.Size())
+	}
+
+	// Views stack: an inner view commits into the outer one
+	inner := NewUTXOView(view)
+	inner.Remove(Hash256{0x02}, 0)
+	inner.Commit()
+	if _, found := view.Find(Hash256{0x02}, 0); found {
+		t.Error("Expected inner view spend to reach the outer view")
+	}
+	if _, found := base.Find(Hash256{0x02}, 0); !found {
+		t.Error("Expected base unchanged by the inner commit")
+	}
+
+	view.Commit()
+	if base.Size() != 1 || base.TotalValue() != 3000 {
+		t.Errorf("Expected only the created output in the base, got %d worth %d", base.Size(), base.TotalValue())
+	}
+}
+
+// TestUTXOView_ConnectBlock tests that a block is only applied if every transaction connects
+func TestUTXOView_ConnectBlock(t *testing.T) {
+	funding := []OutPoint{{Hash: Hash256{0x01}, Index: 0}, {Hash: Hash256{0x01}, Index: 1}}
+	coinbase := *createUniqueCoinbaseTransaction(5000000000, 1)
+	first := spendingTransaction(900, funding[0])
+
+	tests := []struct {
+		name          string
+		transactions  []Transaction
+		errorContains string
+	}{
+		{"independent spends", []Transaction{coinbase, first, spendingTransaction(900, funding[1])}, ""},
+		{"chained spend", []Transaction{coinbase, first, spendingTransaction(800, OutPoint{Hash: first.Hash()})}, ""},
+		{"double spend in one transaction", []Transaction{coinbase, spendingTransaction(900, funding[0], funding[0])}, "twice in one transaction"},
+		{"double spend across transactions", []Transaction{coinbase, first, spendingTransaction(800, funding[1], funding[0])}, "already spent in this block"},
+		{"missing output", []Transaction{coinbase, first, spendingTransaction(900, OutPoint{Hash: Hash256{0x09}})}, "missing or spent"},
+		{"spend before creation", []Transaction{coinbase, spendingTransaction(800, OutPoint{Hash: first.Hash()}), first}, "missing or spent"},
+		{"outputs exceed inputs", []Transaction{coinbase, first, spendingTransaction(1001, funding[1])}, "less than output value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utxoSet := NewUTXOSet()
+			for _, outpoint := range funding {
+				utxoSet.Add(NewUTXO(outpoint.Hash, outpoint.Index, 1000, []byte{0x51}))
+			}
+			block := newTestBlock(BlockHeader{Version: 1}, tt.transactions)
+
+			undo, err := utxoSet.ConnectBlock(block, 1)
+			if tt.errorContains != "" {
+				if err == nil || !contains(err.Error(), tt.errorContains) {
+					t.Fatalf("Expected error containing %q, got %v", tt.errorContains, err)
+				}
+				if utxoSet.Size() != len(funding) || utxoSet.TotalValue() != 2000 {
+					t.Errorf("Expected UTXO set untouched by a failed block, got %d worth %d", utxoSet.Size(), utxoSet.TotalValue())
+				}
+				if _, err := utxoSet.BlockUndo(block.Hash()); err == nil {
+					t.Error("Expected no undo data for a failed block")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected block to connect, got %v", err)
+			}
+			if len(undo.Transactions) != len(tt.transactions)-1 {
+				t.Errorf("Expected undo data for %d transactions, got %d", len(tt.transactions)-1, len(undo.Transactions))
+			}
+		})
+	}
+}
+
+// TestBlockChain_InvalidSpend tests that a block spending unavailable outputs leaves the chain unchanged
+func TestBlockChain_InvalidSpend(t *testing.T) {
+	blockchain := newTestBlockChain()
+	genesis := blockchain.GetTip()
+	funding := NewUTXO(Hash256{0x01}, 0, 5000000000, []byte{0x51})
+	blockchain.GetUTXOSet().Add(funding)
+	fundingOutpoint := OutPoint{Hash: funding.TxHash()}
+
+	a1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 1)
+	if err := blockchain.AddBlock(a1); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+	utxoCount := blockchain.GetUTXOSet().Size()
+
+	// A block extending the tip whose second spend of the funding output fails
+	doubleSpend := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: a1.Hash(),
+		Timestamp:     a1.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{
+		*createUniqueCoinbaseTransaction(5000000000, 2),
+		spendingTransaction(4000000000, fundingOutpoint),
+		spendingTransaction(3000000000, fundingOutpoint),
+	})
+	if err := blockchain.AddBlock(doubleSpend); err == nil || !contains(err.Error(), "already spent") {
+		t.Fatalf("Expected double spend error, got %v", err)
+	}
+	if blockchain.GetTip() != a1 || !blockchain.IsBlockInvalid(doubleSpend.Hash()) {
+		t.Error("Expected double spending block to be rejected and marked invalid")
+	}
+	if _, found := blockchain.GetUTXOSet().Find(funding.TxHash(), 0); !found || blockchain.GetUTXOSet().Size() != utxoCount {
+		t.Error("Expected UTXO set untouched by the rejected block")
+	}
+
+	// A longer branch whose second block is invalid: the original chain is restored
+	// once the reorganization reaches it
+	b1 := createBlockOn(genesis, RegTestParams.PowLimitBits, 11)
+	b2 := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: b1.Hash(),
+		Timestamp:     b1.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{
+		*createTaggedCoinbaseTransaction(5000000000, 2, 12),
+		spendingTransaction(1000, OutPoint{Hash: Hash256{0x09}}),
+	})
+	b3 := createBlockOn(b2, RegTestParams.PowLimitBits, 13)
+	if err := blockchain.AddBlock(b1); err != nil {
+		t.Fatalf("Failed to add fork block: %v", err)
+	}
+	if err := blockchain.AddBlock(b2); err == nil || !contains(err.Error(), "reorganization failed") {
+		t.Fatalf("Expected reorganization onto an invalid block to fail, got %v", err)
+	}
+	if err := blockchain.AddBlock(b3); err == nil || !contains(err.Error(), "descends from an invalid block") {
+		t.Fatalf("Expected child of the invalid block to be rejected, got %v", err)
+	}
+	if blockchain.GetTip() != a1 || blockchain.Height() != 1 {
+		t.Errorf("Expected original tip restored, got %s at height %d", blockchain.GetTip().Hash(), blockchain.Height())
+	}
+	if !blockchain.IsBlockInvalid(b2.Hash()) || !blockchain.IsBlockInvalid(b3.Hash()) {
+		t.Error("Expected invalid block and its descendant to be marked invalid")
+	}
+	if blockchain.IsBlockInvalid(b1.Hash()) {
+		t.Error("Expected valid fork block not to be marked invalid")
+	}
+	if _, found := blockchain.GetUTXOSet().Find(a1.Transactions[0].Hash(), 0); !found || blockchain.GetUTXOSet().Size() != utxoCount {
+		t.Error("Expected UTXO set of the original chain to be restored")
+	}
+}
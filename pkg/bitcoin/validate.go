@@ -0,0 +1,361 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// InitialBlockSubsidy is the coinbase reward before the first halving, in satoshis
+const InitialBlockSubsidy = 50 * 100000000
+
+// Coinbase scriptSig length limits
+const (
+	MinCoinbaseScriptLen = 2
+	MaxCoinbaseScriptLen = 100
+)
+
+// Transaction finality and BIP68 relative lock-time encoding
+const (
+	LockTimeThreshold = 500000000 // Lock times below are heights, above are timestamps
+
+	SequenceFinal               = 0xffffffff
+	SequenceLockTimeDisableFlag = 1 << 31 // Input has no relative lock-time
+	SequenceLockTimeTypeFlag    = 1 << 22 // Relative lock-time is in units of time
+	SequenceLockTimeMask        = 0x0000ffff
+	SequenceLockTimeGranularity = 9 // Time-based relative locks count 512 second units
+)
+
+// BlockSubsidy returns the new coins a coinbase may claim at the given height,
+// halving every SubsidyHalvingInterval blocks until it reaches zero
+func BlockSubsidy(height int32, params *ChainParams) uint64 {
+	halvings := height / params.SubsidyHalvingInterval
+	// Shifting by the width of the value or more is undefined in Bitcoin Core,
+	// which returns zero explicitly; do the same rather than rely on Go's shifts
+	if halvings >= 64 {
+		return 0
+	}
+	return InitialBlockSubsidy >> uint(halvings)
+}
+
+// TotalIssuance returns the coins created by the subsidies of every block up
+// to and including height: the most the UTXO set of a chain that tall can hold.
+// The genesis coinbase is not spendable, so its subsidy is not counted.
+func TotalIssuance(height int32, params *ChainParams) uint64 {
+	total := uint64(0)
+	interval := params.SubsidyHalvingInterval
+	for start := int32(0); start <= height; start += interval {
+		subsidy := BlockSubsidy(start, params)
+		if subsidy == 0 {
+			break
+		}
+		blocks := interval
+		if height-start < interval {
+			blocks = height - start + 1
+		}
+		total += uint64(blocks) * subsidy
+	}
+	if height >= 0 {
+		total -= BlockSubsidy(0, params)
+	}
+	return total
+}
+
+// SupplyAudit compares the value of the UTXO set with the coins issued up to
+// the tip. Fees left unclaimed by miners make the set worth less than the
+// issuance; it may never be worth more.
+type SupplyAudit struct {
+	Height      int32
+	UTXOCount   int
+	UTXOValue   uint64 // Sum of every unspent output
+	MaxIssuance uint64 // Sum of every subsidy up to Height
+}
+
+// Unclaimed returns the issued coins missing from the UTXO set
+func (a SupplyAudit) Unclaimed() uint64 {
+	if a.UTXOValue > a.MaxIssuance {
+		return 0
+	}
+	return a.MaxIssuance - a.UTXOValue
+}
+
+// IsFinalTx reports whether a transaction may be included in a block at the
+// given height, whose lock-time cutoff is blockTime. A lock time below
+// LockTimeThreshold is a height, anything else a timestamp; it is ignored if
+// every input has a final sequence number.
+func IsFinalTx(tx *Transaction, height int32, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	cutoff := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		cutoff = blockTime
+	}
+	if int64(tx.LockTime) < cutoff {
+		return true
+	}
+
+	for _, input := range tx.Inputs {
+		if input.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// sequenceLocksMet reports whether the BIP68 relative lock-times of a
+// transaction's inputs allow it in the block at node, given the outputs its
+// inputs spend. Time-based locks are measured from the median time past of
+// the block before the one that created the output.
+func (bc *BlockChain) sequenceLocksMet(tx *Transaction, spent []*UTXO, node *blockNode) bool {
+	prev := node.parent
+	minHeight, minTime := int32(-1), int64(-1)
+
+	for i, input := range tx.Inputs {
+		sequence := input.Sequence
+		if sequence&SequenceLockTimeDisableFlag != 0 {
+			continue
+		}
+
+		coinHeight := spent[i].height
+		value := int64(sequence & SequenceLockTimeMask)
+		if sequence&SequenceLockTimeTypeFlag != 0 {
+			coinTime := int64(bc.chainAncestor(prev, max(coinHeight-1, 0)).medianTimePast())
+			minTime = max(minTime, coinTime+value<<SequenceLockTimeGranularity-1)
+		} else {
+			minHeight = max(minHeight, coinHeight+int32(value)-1)
+		}
+	}
+
+	return minHeight < node.height && minTime < int64(prev.medianTimePast())
+}
+
+// chainAncestor returns node's ancestor at the given height, indexing the
+// active chain directly when node is on it, as the parent of a block being
+// connected always is
+func (bc *BlockChain) chainAncestor(node *blockNode, height int32) *blockNode {
+	if height >= 0 && height <= node.height && node.height < int32(len(bc.chain)) && bc.chain[node.height] == node {
+		return bc.chain[height]
+	}
+	return node.ancestor(height)
+}
+
+// checkBlockSanity checks the rules a block must follow regardless of its
+// position in the chain: a leading coinbase and no other, a coinbase
+// scriptSig of 2 to 100 bytes, well-formed transactions, the size and weight
+// limits and the sigop limit for legacy signature operations. The merkle root
+// must already have been checked, so that a block failing here is invalid
+// rather than a mutated copy of a valid one.
+func checkBlockSanity(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block must have at least one transaction")
+	}
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "first transaction must be coinbase")
+	}
+
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, "transaction %d is coinbase (only first can be)", i)
+		}
+		if i == 0 {
+			if n := len(tx.Inputs[0].ScriptSig); n < MinCoinbaseScriptLen || n > MaxCoinbaseScriptLen {
+				return ruleError(ErrBadCoinbaseScriptLen, "coinbase scriptSig length %d outside %d to %d",
+					n, MinCoinbaseScriptLen, MaxCoinbaseScriptLen)
+			}
+		}
+		if err := tx.Validate(); err != nil {
+			return ruleError(ErrBadTransaction, "transaction %d validation failed: %v", i, err)
+		}
+	}
+
+	// Legacy signature operations can be counted without the spent outputs
+	legacyCost := 0
+	for i := range block.Transactions {
+		legacyCost += block.Transactions[i].LegacySigOpCount() * WitnessScaleFactor
+	}
+	if legacyCost > MaxBlockSigOpsCost {
+		return ruleError(ErrTooManySigOps, "block legacy sigop cost %d exceeds maximum %d", legacyCost, MaxBlockSigOpsCost)
+	}
+
+	// Witness data does not count towards the legacy size limit, only the weight
+	if size := block.StrippedSize(); size > MaxBlockSize {
+		return ruleError(ErrBlockTooBig, "block size %d exceeds maximum %d", size, MaxBlockSize)
+	}
+	if weight := block.Weight(); weight > MaxBlockWeight {
+		return ruleError(ErrBlockTooBig, "block weight %d exceeds maximum %d", weight, MaxBlockWeight)
+	}
+	return nil
+}
+
+// CoinbaseHeightScript returns the script a coinbase scriptSig must start
+// with under BIP34: the block height pushed as a minimal script number, which
+// is OP_1 to OP_16 for heights up to 16 (CScript() << height in Bitcoin Core)
+func CoinbaseHeightScript(height int32) Script {
+	switch {
+	case height == 0:
+		return Script{byte(OP_0)}
+	case height >= 1 && height <= 16:
+		return Script{byte(OP_1) + byte(height-1)}
+	}
+	return pushDataScript(encodeScriptNum(int64(height)))
+}
+
+// checkCoinbaseHeight checks that a coinbase scriptSig starts by pushing the
+// height of its block, as BIP34 requires. Only the exact minimal encoding is
+// accepted.
+func checkCoinbaseHeight(coinbase *Transaction, height int32) error {
+	if !bytes.HasPrefix(coinbase.Inputs[0].ScriptSig, CoinbaseHeightScript(height)) {
+		return ruleError(ErrBadCoinbaseHeight, "coinbase does not start with the block height %d", height)
+	}
+	return nil
+}
+
+// checkBlockVersion rejects headers with a version from before a buried soft
+// fork once it is active: BIP34 requires version 2, BIP66 version 3 and BIP65
+// version 4. Like Bitcoin Core the version is compared as a signed number.
+func checkBlockVersion(header *BlockHeader, height int32, params *ChainParams) error {
+	version := int32(header.Version)
+	if (version < 2 && height >= params.BIP34Height) ||
+		(version < 3 && height >= params.BIP66Height) ||
+		(version < 4 && height >= params.BIP65Height) {
+		return ruleError(ErrBlockVersionTooOld, "block version %d rejected at height %d", version, height)
+	}
+	return nil
+}
+
+// bip34ImpliesBIP30Limit is the first height some coinbase from before BIP34
+// could be repeated at: early coinbases start with pushes that happen to be
+// valid BIP34 heights, the lowest being 1,983,702
+const bip34ImpliesBIP30Limit = 1983702
+
+// enforceBIP30 reports whether the block at node must be checked for
+// transactions overwriting unspent ones. Once BIP34 is active, coinbases and
+// so every transaction are unique, making the check redundant until
+// bip34ImpliesBIP30Limit. This only holds on the chain whose BIP34 activation
+// block is BIP34Hash.
+func (bc *BlockChain) enforceBIP30(node *blockNode) bool {
+	params := bc.params
+	if params.BIP34Hash == ZeroHash || node.height >= bip34ImpliesBIP30Limit {
+		return true
+	}
+	activation := node.ancestor(params.BIP34Height)
+	return activation == nil || activation.hash != params.BIP34Hash
+}
+
+// connectBlock validates a block's transactions against the outputs they spend
+// and connects them to view, returning the block's undo data. The block must
+// already have passed the context-free and header checks. Every rule that
+// needs the UTXO set is checked here, along with the minimum block version:
+// BIP30, BIP34, lock times, BIP68 sequence locks, the sigop limit, input
+// values, scripts and the coinbase reward. The
+// view is left partially connected on error and must then be discarded.
+func (bc *BlockChain) connectBlock(node *blockNode, block *Block, view *UTXOView) (*BlockUndo, error) {
+	height := node.height
+	params := bc.params
+	prev := node.parent
+
+	// The genesis coinbase is not spendable: its outputs never enter the UTXO set
+	if prev == nil {
+		return &BlockUndo{}, nil
+	}
+
+	if height > 0 && height >= params.BIP34Height {
+		if err := checkCoinbaseHeight(&block.Transactions[0], height); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkBlockVersion(&block.Header, height, params); err != nil {
+		return nil, err
+	}
+
+	// BIP113: once CSV is active lock times are compared to the median time past
+	csvActive := prev != nil && height >= params.CSVHeight
+	lockTimeCutoff := int64(block.Header.Timestamp)
+	if csvActive {
+		lockTimeCutoff = int64(prev.medianTimePast())
+	}
+	flags := params.BlockScriptFlags(height, node.hash)
+	bip30Exception := params.BIP30Exceptions[height] == node.hash
+	checkBIP30 := bip30Exception || bc.enforceBIP30(node)
+
+	undo := &BlockUndo{}
+	var fees uint64
+	sigOpCost := 0
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		txHash := tx.Hash()
+
+		if !IsFinalTx(tx, height, lockTimeCutoff) {
+			return nil, ruleError(ErrUnfinalizedTx, "transaction %d (%s) is not final", i, txHash)
+		}
+
+		// BIP30: a transaction may not overwrite outputs that are still unspent
+		if checkBIP30 {
+			for j := range tx.Outputs {
+				outpoint := OutPoint{Hash: txHash, Index: uint32(j)}
+				if _, found := view.Find(outpoint.Hash, outpoint.Index); !found {
+					continue
+				}
+				if !bip30Exception {
+					return nil, ruleError(ErrOverwriteTx, "transaction %d (%s) overwrites unspent output %d", i, txHash, j)
+				}
+				// The overwritten output is lost, as it was in the original chain
+				if _, err := view.Spend(outpoint); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		txUndo, fee, err := view.ConnectTransaction(tx, height)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d (%s): %w", i, txHash, err)
+		}
+		var prevOuts []TxOutput
+		for _, spent := range txUndo.SpentOutputs {
+			prevOuts = append(prevOuts, TxOutput{Value: spent.amount, ScriptPubKey: spent.scriptPubKey})
+		}
+
+		sigOpCost += tx.SigOpCost(prevOuts, flags)
+		if sigOpCost > MaxBlockSigOpsCost {
+			return nil, ruleError(ErrTooManySigOps, "block sigop cost %d exceeds maximum %d", sigOpCost, MaxBlockSigOpsCost)
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		fees += fee
+		if fees > MaxMoney {
+			return nil, ruleError(ErrBadTxOutValue, "total fees %d out of range", fees)
+		}
+
+		if csvActive && tx.Version >= 2 && !bc.sequenceLocksMet(tx, txUndo.SpentOutputs, node) {
+			return nil, ruleError(ErrSequenceLockNotMet, "transaction %d (%s) sequence locks not met", i, txHash)
+		}
+
+		// The BIP143 midstates are shared by every input of the transaction
+		var sigHashes *TxSigHashes
+		if tx.HasWitness() {
+			sigHashes = NewTxSigHashes(tx)
+		}
+		for j := range tx.Inputs {
+			err := VerifyScript(tx, j, prevOuts, sigHashes, flags)
+			if errors.Is(err, ErrUnsupportedOpcode) {
+				// Not a rule violation: the engine cannot tell whether the script is valid
+				return nil, fmt.Errorf("transaction %d (%s) input %d script not verified: %w", i, txHash, j, err)
+			}
+			if err != nil {
+				return nil, ruleError(ErrScriptValidation, "transaction %d (%s) input %d script failed: %v", i, txHash, j, err)
+			}
+		}
+
+		undo.Transactions = append(undo.Transactions, txUndo)
+	}
+
+	reward := BlockSubsidy(height, params) + fees
+	if paid := block.Transactions[0].TotalOutput(); paid > reward {
+		return nil, ruleError(ErrBadCoinbaseValue, "coinbase pays %d, more than subsidy plus fees %d", paid, reward)
+	}
+	return undo, nil
+}
@@ -0,0 +1,376 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestBlockSubsidy tests that the block subsidy halves every interval
+func TestBlockSubsidy(t *testing.T) {
+	everyBlock := RegTestParams
+	everyBlock.SubsidyHalvingInterval = 1
+
+	tests := []struct {
+		name     string
+		params   *ChainParams
+		height   int32
+		expected uint64
+	}{
+		{"mainnet genesis", &MainNetParams, 0, 5000000000},
+		{"mainnet before first halving", &MainNetParams, 209999, 5000000000},
+		{"mainnet first halving", &MainNetParams, 210000, 2500000000},
+		{"mainnet fourth halving", &MainNetParams, 840000, 312500000},
+		{"regtest first halving", &RegTestParams, 150, 2500000000},
+		{"last satoshi", &MainNetParams, 32*210000 + 1, 1},
+		{"subsidy exhausted", &MainNetParams, 33 * 210000, 0},
+		{"63 halvings", &everyBlock, 63, 0},
+		{"64 halvings", &everyBlock, 64, 0},
+		{"far beyond 64 halvings", &everyBlock, 1 << 30, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if subsidy := BlockSubsidy(tt.height, tt.params); subsidy != tt.expected {
+				t.Errorf("Expected subsidy %d, got %d", tt.expected, subsidy)
+			}
+		})
+	}
+}
+
+// TestTotalIssuance tests the theoretical supply at a height
+func TestTotalIssuance(t *testing.T) {
+	tests := []struct {
+		name     string
+		height   int32
+		expected uint64
+	}{
+		{"genesis", 0, 0},
+		{"first era", 209999, 209999 * 5000000000},
+		{"first block of the second era", 210000, 209999*5000000000 + 2500000000},
+		{"all eras", 7000000, 2099994997690000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if issuance := TotalIssuance(tt.height, &MainNetParams); issuance != tt.expected {
+				t.Errorf("Expected issuance %d, got %d", tt.expected, issuance)
+			}
+		})
+	}
+
+	if TotalIssuance(1<<31-1, &MainNetParams) > MaxMoney {
+		t.Error("Expected total issuance never to exceed MaxMoney")
+	}
+}
+
+// TestBlockChain_AuditSupply tests the UTXO set audit against the issuance
+func TestBlockChain_AuditSupply(t *testing.T) {
+	blockchain := setupBlockChain(5)
+
+	audit, err := blockchain.AuditSupply()
+	if err != nil {
+		t.Fatalf("Expected audit to pass, got %v", err)
+	}
+	if audit.Height != 4 || audit.UTXOCount != 4 {
+		t.Errorf("Expected 4 UTXOs at height 4, got %d at height %d", audit.UTXOCount, audit.Height)
+	}
+	if audit.UTXOValue != audit.MaxIssuance || audit.Unclaimed() != 0 {
+		t.Errorf("Expected every issued coin in the UTXO set, got %d of %d", audit.UTXOValue, audit.MaxIssuance)
+	}
+
+	// Coins that were never issued make the audit fail
+	blockchain.GetUTXOSet().Add(NewUTXO(Hash256{0x01}, 0, 1, nil))
+	if _, err := blockchain.AuditSupply(); err == nil || !contains(err.Error(), "more than") {
+		t.Errorf("Expected audit to fail on excess coins, got %v", err)
+	}
+}
+
+// TestIsFinalTx tests lock time finality against block height and time
+func TestIsFinalTx(t *testing.T) {
+	const blockTime = 1600000000
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		expected bool
+	}{
+		{"no lock time", 0, 0, true},
+		{"height lock passed", 99, 0, true},
+		{"height lock at block height", 100, 0, false},
+		{"time lock passed", blockTime - 1, 0, true},
+		{"time lock at block time", blockTime, 0, false},
+		{"lock ignored with final sequence", 200, SequenceFinal, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := Transaction{
+				Version:  1,
+				Inputs:   []TxInput{{PreviousOutput: OutPoint{Hash: Hash256{0x01}}, Sequence: tt.sequence}},
+				LockTime: tt.lockTime,
+			}
+			if final := IsFinalTx(&tx, 100, blockTime); final != tt.expected {
+				t.Errorf("Expected final %v, got %v", tt.expected, final)
+			}
+		})
+	}
+}
+
+// TestBlockChain_ConnectBlockRules tests that blocks breaking a rule checked
+// against the UTXO set fail with the matching RuleError and are marked invalid
+func TestBlockChain_ConnectBlockRules(t *testing.T) {
+	genesis := createGenesisBlock()
+	funding := OutPoint{Hash: Hash256{0x01}}
+	unspendable := OutPoint{Hash: Hash256{0x02}}
+	disabled := OutPoint{Hash: Hash256{0x03}}
+	opReturn := OutPoint{Hash: Hash256{0x04}}
+	coinbase := func(amount uint64) Transaction {
+		return *createUniqueCoinbaseTransaction(amount, 1)
+	}
+	spend := func(prev OutPoint, mutate func(tx *Transaction)) Transaction {
+		tx := spendingTransaction(900, prev)
+		if mutate != nil {
+			mutate(&tx)
+		}
+		return tx
+	}
+	withScriptSig := func(scriptSig []byte) Transaction {
+		tx := coinbase(5000000000)
+		tx.Inputs[0].ScriptSig = scriptSig
+		return tx
+	}
+	manySigOps := coinbase(5000000000)
+	manySigOps.Outputs = append(manySigOps.Outputs, TxOutput{ScriptPubKey: bytes.Repeat([]byte{byte(OP_CHECKSIG)}, MaxBlockSigOpsCost/WitnessScaleFactor)})
+
+	tests := []struct {
+		name         string
+		transactions []Transaction
+		overwrite    bool // Fund an output with the coinbase's hash
+		expectedCode ErrorCode
+		valid        bool
+	}{
+		{"valid spend claiming the fee", []Transaction{coinbase(5000000100), spend(funding, nil)}, false, 0, true},
+		{"coinbase claims more than the fee", []Transaction{coinbase(5000000101), spend(funding, nil)}, false, ErrBadCoinbaseValue, false},
+		{"wrong coinbase height", []Transaction{*createUniqueCoinbaseTransaction(5000000000, 2)}, false, ErrBadCoinbaseHeight, false},
+		{"non-minimal coinbase height", []Transaction{withScriptSig([]byte{0x01, 0x01})}, false, ErrBadCoinbaseHeight, false},
+		{"coinbase scriptSig too short", []Transaction{withScriptSig([]byte{byte(OP_1)})}, false, ErrBadCoinbaseScriptLen, false},
+		{"coinbase scriptSig too long", []Transaction{withScriptSig(append([]byte{byte(OP_1)}, make([]byte, 100)...))}, false, ErrBadCoinbaseScriptLen, false},
+		{"overwritten coinbase", []Transaction{coinbase(5000000000)}, true, ErrOverwriteTx, false},
+		{"missing output", []Transaction{coinbase(5000000000), spend(OutPoint{Hash: Hash256{0x09}}, nil)}, false, ErrMissingTxOut, false},
+		{"non-final transaction", []Transaction{coinbase(5000000000), spend(funding, func(tx *Transaction) {
+			tx.LockTime = 5
+			tx.Inputs[0].Sequence = 0
+		})}, false, ErrUnfinalizedTx, false},
+		{"sequence lock not met", []Transaction{coinbase(5000000000), spend(funding, func(tx *Transaction) {
+			tx.Version = 2
+			tx.Inputs[0].Sequence = 10
+		})}, false, ErrSequenceLockNotMet, false},
+		{"time-based sequence lock not met", []Transaction{coinbase(5000000000), spend(funding, func(tx *Transaction) {
+			tx.Version = 2
+			tx.Inputs[0].Sequence = SequenceLockTimeTypeFlag | 1
+		})}, false, ErrSequenceLockNotMet, false},
+		{"sequence lock disabled", []Transaction{coinbase(5000000000), spend(funding, func(tx *Transaction) {
+			tx.Version = 2
+			tx.Inputs[0].Sequence = SequenceLockTimeDisableFlag | 10
+		})}, false, 0, true},
+		{"failing script", []Transaction{coinbase(5000000000), spend(unspendable, nil)}, false, ErrScriptValidation, false},
+		{"OP_RETURN output spent", []Transaction{coinbase(5000000000), spend(opReturn, nil)}, false, ErrScriptValidation, false},
+		{"disabled opcode in unexecuted branch", []Transaction{coinbase(5000000000), spend(disabled, nil)}, false, ErrScriptValidation, false},
+		{"too many sigops", []Transaction{manySigOps}, false, ErrTooManySigOps, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blockchain := NewBlockChainWithParams(&RegTestParams, genesis)
+			blockchain.GetUTXOSet().Add(NewUTXO(funding.Hash, 0, 1000, []byte{byte(OP_1)}))
+			blockchain.GetUTXOSet().Add(NewUTXO(unspendable.Hash, 0, 1000, []byte{byte(OP_0)}))
+			blockchain.GetUTXOSet().Add(NewUTXO(disabled.Hash, 0, 1000,
+				[]byte{byte(OP_0), byte(OP_IF), byte(OP_CAT), byte(OP_ENDIF), byte(OP_1)}))
+			blockchain.GetUTXOSet().Add(NewUTXO(opReturn.Hash, 0, 1000, []byte{byte(OP_1), byte(OP_RETURN)}))
+			if tt.overwrite {
+				blockchain.GetUTXOSet().Add(NewUTXO(tt.transactions[0].Hash(), 0, 1000, nil))
+			}
+			utxoCount := blockchain.GetUTXOSet().Size()
+
+			block := mineTestBlock(BlockHeader{
+				Version:       4,
+				PrevBlockHash: genesis.Hash(),
+				Timestamp:     genesis.Header.Timestamp + 600,
+				Bits:          RegTestParams.PowLimitBits,
+			}, tt.transactions)
+			err := blockchain.AddBlock(block)
+
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Expected block to connect, got %v", err)
+				}
+				return
+			}
+			var ruleErr RuleError
+			if !errors.As(err, &ruleErr) {
+				t.Fatalf("Expected a RuleError, got %v", err)
+			}
+			if ruleErr.Code != tt.expectedCode {
+				t.Errorf("Expected %s, got %s: %v", tt.expectedCode, ruleErr.Code, err)
+			}
+			if !blockchain.IsBlockInvalid(block.Hash()) || blockchain.Height() != 0 {
+				t.Error("Expected block to be marked invalid and the tip unchanged")
+			}
+			if blockchain.GetUTXOSet().Size() != utxoCount {
+				t.Error("Expected UTXO set untouched by the rejected block")
+			}
+		})
+	}
+}
+
+// TestBlockChain_UnsupportedScript tests that a block the script engine cannot
+// verify is rejected without being marked invalid
+func TestBlockChain_UnsupportedScript(t *testing.T) {
+	genesis := createGenesisBlock()
+	blockchain := NewBlockChainWithParams(&RegTestParams, genesis)
+	multisig := []byte{byte(OP_0), byte(OP_0), byte(OP_CHECKMULTISIG)}
+	blockchain.GetUTXOSet().Add(NewUTXO(Hash256{0x01}, 0, 1000, multisig))
+
+	block := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: genesis.Hash(),
+		Timestamp:     genesis.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{*createUniqueCoinbaseTransaction(5000000000, 1), spendingTransaction(900, OutPoint{Hash: Hash256{0x01}})})
+
+	err := blockchain.AddBlock(block)
+	if !errors.Is(err, ErrUnsupportedOpcode) {
+		t.Fatalf("Expected unsupported opcode error, got %v", err)
+	}
+	var ruleErr RuleError
+	if errors.As(err, &ruleErr) {
+		t.Errorf("Expected no RuleError for an unsupported script, got %s", ruleErr.Code)
+	}
+	if blockchain.IsBlockInvalid(block.Hash()) || blockchain.Height() != 0 {
+		t.Error("Expected block left unconnected but not marked invalid")
+	}
+}
+
+// TestCheckBlockVersion tests the minimum block versions of buried soft forks
+func TestCheckBlockVersion(t *testing.T) {
+	params := &MainNetParams
+	tests := []struct {
+		name    string
+		version uint32
+		height  int32
+		valid   bool
+	}{
+		{"version 1 before BIP34", 1, params.BIP34Height - 1, true},
+		{"version 1 at BIP34", 1, params.BIP34Height, false},
+		{"version 2 at BIP34", 2, params.BIP34Height, true},
+		{"version 2 at BIP66", 2, params.BIP66Height, false},
+		{"version 3 at BIP66", 3, params.BIP66Height, true},
+		{"version 3 at BIP65", 3, params.BIP65Height, false},
+		{"version 4 at BIP65", 4, params.BIP65Height, true},
+		{"version bits", 0x20000000, params.BIP65Height, true},
+		{"negative version", 0x80000004, params.BIP65Height, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBlockVersion(&BlockHeader{Version: tt.version}, tt.height, params)
+			if tt.valid && err != nil {
+				t.Errorf("Expected version accepted, got %v", err)
+			}
+			var ruleErr RuleError
+			if !tt.valid && (!errors.As(err, &ruleErr) || ruleErr.Code != ErrBlockVersionTooOld) {
+				t.Errorf("Expected ErrBlockVersionTooOld, got %v", err)
+			}
+		})
+	}
+}
+
+// TestCoinbaseHeightScript tests the BIP34 encoding of block heights
+func TestCoinbaseHeightScript(t *testing.T) {
+	tests := []struct {
+		height   int32
+		expected string
+	}{
+		{0, "00"},
+		{1, "51"},
+		{16, "60"},
+		{17, "0111"},
+		{127, "017f"},
+		{128, "028000"},
+		{255, "02ff00"},
+		{256, "020001"},
+		{227931, "035b7a03"},
+		{8388608, "0400008000"},
+	}
+
+	for _, tt := range tests {
+		if script := hex.EncodeToString(CoinbaseHeightScript(tt.height)); script != tt.expected {
+			t.Errorf("Height %d: expected %s, got %s", tt.height, tt.expected, script)
+		}
+	}
+}
+
+// TestBlockChain_BIP30 tests that only the listed exception blocks may overwrite
+// unspent outputs, and that BIP34 makes the check unnecessary
+func TestBlockChain_BIP30(t *testing.T) {
+	genesis := createGenesisBlock()
+	coinbase := createUniqueCoinbaseTransaction(5000000000, 1)
+	block := mineTestBlock(BlockHeader{
+		Version:       4,
+		PrevBlockHash: genesis.Hash(),
+		Timestamp:     genesis.Header.Timestamp + 600,
+		Bits:          RegTestParams.PowLimitBits,
+	}, []Transaction{*coinbase})
+
+	params := RegTestParams
+	params.BIP30Exceptions = map[int32]Hash256{1: block.Hash()}
+	blockchain := NewBlockChainWithParams(&params, genesis)
+	blockchain.GetUTXOSet().Add(NewUTXO(coinbase.Hash(), 0, 1000, nil))
+
+	if err := blockchain.AddBlock(block); err != nil {
+		t.Fatalf("Expected exception block to overwrite the output, got %v", err)
+	}
+	utxo, found := blockchain.GetUTXOSet().Find(coinbase.Hash(), 0)
+	if !found || utxo.Amount() != 5000000000 || blockchain.GetUTXOSet().Size() != 1 {
+		t.Error("Expected the overwritten output to be replaced by the coinbase output")
+	}
+
+	if !blockchain.enforceBIP30(blockchain.tipNode) {
+		t.Error("Expected BIP30 enforced without a BIP34 activation block")
+	}
+	params.BIP34Hash = block.Hash()
+	if blockchain.enforceBIP30(blockchain.tipNode) {
+		t.Error("Expected BIP30 implied by BIP34 on the chain of its activation block")
+	}
+	params.BIP34Hash = Hash256{0x01}
+	if !blockchain.enforceBIP30(blockchain.tipNode) {
+		t.Error("Expected BIP30 enforced on a chain without the BIP34 activation block")
+	}
+}
+
+// TestChainParams_BlockScriptFlags tests script flags by height and for exception blocks
+func TestChainParams_BlockScriptFlags(t *testing.T) {
+	flags := MainNetParams.BlockScriptFlags(1, Hash256{0x01})
+	if flags&ScriptVerifyP2SH == 0 || flags&ScriptVerifyWitness == 0 {
+		t.Error("Expected P2SH and segwit rules for every block")
+	}
+	if flags&ScriptVerifyTaproot != 0 {
+		t.Error("Expected taproot rules not to be enforced")
+	}
+	if flags&(ScriptVerifyDERSig|ScriptVerifyCheckLockTimeVerify|ScriptVerifyCheckSequenceVerify|ScriptVerifyNullDummy) != 0 {
+		t.Error("Expected buried soft forks inactive at height 1")
+	}
+
+	flags = MainNetParams.BlockScriptFlags(MainNetParams.SegwitHeight, Hash256{0x01})
+	expected := ScriptVerifyDERSig | ScriptVerifyCheckLockTimeVerify | ScriptVerifyCheckSequenceVerify | ScriptVerifyNullDummy
+	if flags&expected != expected {
+		t.Errorf("Expected buried soft forks active at the segwit height, got %#x", flags)
+	}
+
+	bip16Exception := mustParseHash("00000000000002dc756eebf4f49723ed8d30cc28a5f108eb94b1ba88ac4f9c22")
+	if flags := MainNetParams.BlockScriptFlags(170060, bip16Exception); flags&ScriptVerifyP2SH != 0 {
+		t.Error("Expected the BIP16 exception block to be validated without P2SH")
+	}
+}
@@ -457,6 +457,7 @@ func TestBlock_Validate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			header := bitcoin.NewBlockHeader(1, bitcoin.ZeroHash, bitcoin.ZeroHash, 1640995200, 0x1d00ffff, 0)
 			block := bitcoin.NewBlock(header, tt.transactions)
+			block.Header.MerkleRoot = block.CalculateMerkleRoot()
 
 			err := block.Validate()
 
@@ -586,7 +587,7 @@ func TestBlock_Weight(t *testing.T) {
 	weight := block.Weight()
 	size := block.Size()
 
-	// Currently weight = size * 4 (placeholder implementation)
+	// Without witness data every byte weighs four units
 	expectedWeight := size * 4
 	if weight != expectedWeight {
 		t.Errorf("expected weight %d, got %d", expectedWeight, weight)
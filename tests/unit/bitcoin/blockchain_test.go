@@ -2,6 +2,7 @@ package bitcoin_test
 
 import (
 	"bitcoinecho.org/node/pkg/bitcoin"
+	"math"
 	"testing"
 )
 
@@ -204,19 +205,19 @@ func TestBlockChain_UTXO_Integration(t *testing.T) {
 		{
 			name:          "Genesis block UTXO creation",
 			transactions:  []string{"coinbase"},
-			expectedUTXOs: 1,
-			description:   "Genesis coinbase should create initial UTXO",
+			expectedUTXOs: 0,
+			description:   "Genesis coinbase should not create a UTXO",
 		},
 		{
 			name:          "Simple spend and create",
 			transactions:  []string{"coinbase", "spend_and_create"},
-			expectedUTXOs: 2, // Genesis coinbase + new block coinbase
+			expectedUTXOs: 1, // New block coinbase; the genesis coinbase is unspendable
 			description:   "Transaction should update UTXO set correctly",
 		},
 		{
 			name:          "Multiple transactions",
 			transactions:  []string{"coinbase", "spend_create", "spend_create", "spend_only"},
-			expectedUTXOs: 4, // Genesis + 3 new blocks (each adds coinbase)
+			expectedUTXOs: 3, // 3 new blocks (each adds coinbase)
 			description:   "Multiple transactions should maintain correct UTXO count",
 		},
 	}
@@ -226,7 +227,7 @@ func TestBlockChain_UTXO_Integration(t *testing.T) {
 			t.Logf("TDD RED: %s - %s", tt.name, tt.description)
 
 			// This should fail since we haven't implemented UTXO integration yet
-			blockchain := bitcoin.NewBlockChain(createGenesisBlock())
+			blockchain := newTestBlockChain()
 
 			// Process transactions (skip coinbase since Genesis already has it)
 			for i, txType := range tt.transactions {
@@ -342,13 +343,13 @@ func TestBlockChain_Reorganization(t *testing.T) {
 
 // Helper functions for test setup
 func createGenesisBlock() *bitcoin.Block {
-	// Create Genesis block with known parameters
+	// Create Genesis block with known parameters at regtest difficulty
 	genesisHeader := bitcoin.BlockHeader{
-		Version:       1,
+		Version:       4,
 		PrevBlockHash: bitcoin.ZeroHash,
 		MerkleRoot:    mustParseHash("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"),
 		Timestamp:     1231006505, // Genesis timestamp
-		Bits:          0x1d00ffff,
+		Bits:          bitcoin.RegTestParams.PowLimitBits,
 		Nonce:         2083236893,
 	}
 
@@ -361,59 +362,52 @@ func createGenesisBlock() *bitcoin.Block {
 func createValidNextBlock() *bitcoin.Block {
 	// Create a valid block that builds on Genesis
 	header := bitcoin.BlockHeader{
-		Version:       1,
-		PrevBlockHash: mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
+		Version:       4,
+		PrevBlockHash: createGenesisBlock().Hash(),
 		Timestamp:     1231006505 + 600, // 10 minutes later
-		Bits:          0x1d00ffff,
-		Nonce:         12345,
+		Bits:          bitcoin.RegTestParams.PowLimitBits,
 	}
 
-	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return bitcoin.NewBlock(header, []bitcoin.Transaction{*coinbaseTx})
+	coinbaseTx := createUniqueCoinbaseTransaction(5000000000, 1)
+	return mineTestBlock(header, []bitcoin.Transaction{*coinbaseTx})
 }
 
 func createBlockWithInvalidPrevHash() *bitcoin.Block {
 	header := bitcoin.BlockHeader{
-		Version:       1,
+		Version:       4,
 		PrevBlockHash: mustParseHash("1111111111111111111111111111111111111111111111111111111111111111"),
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
 		Timestamp:     1231006505 + 600,
-		Bits:          0x1d00ffff,
-		Nonce:         12345,
+		Bits:          bitcoin.RegTestParams.PowLimitBits,
 	}
 
 	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return bitcoin.NewBlock(header, []bitcoin.Transaction{*coinbaseTx})
+	return mineTestBlock(header, []bitcoin.Transaction{*coinbaseTx})
 }
 
 func createBlockWithInvalidPoW() *bitcoin.Block {
 	header := bitcoin.BlockHeader{
-		Version:       1,
-		PrevBlockHash: mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
+		Version:       4,
+		PrevBlockHash: createGenesisBlock().Hash(),
 		Timestamp:     1231006505 + 600,
 		Bits:          0x1d00ffff,
 		Nonce:         999999, // Invalid nonce that won't meet difficulty
 	}
 
 	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return bitcoin.NewBlock(header, []bitcoin.Transaction{*coinbaseTx})
+	return newTestBlock(header, []bitcoin.Transaction{*coinbaseTx})
 }
 
 func createValidBlock(height int) *bitcoin.Block {
 	// Create a valid block for given height
 	header := bitcoin.BlockHeader{
-		Version:       1,
-		PrevBlockHash: mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"), // Simplified
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
+		Version:       4,
+		PrevBlockHash: createGenesisBlock().Hash(), // Simplified
 		Timestamp:     uint32(1231006505 + height*600),
-		Bits:          0x1d00ffff,
-		Nonce:         uint32(12345 + height),
+		Bits:          bitcoin.RegTestParams.PowLimitBits,
 	}
 
 	coinbaseTx := createCoinbaseTransaction(5000000000)
-	return bitcoin.NewBlock(header, []bitcoin.Transaction{*coinbaseTx})
+	return mineTestBlock(header, []bitcoin.Transaction{*coinbaseTx})
 }
 
 func createCoinbaseTransaction(amount uint64) *bitcoin.Transaction {
@@ -451,16 +445,23 @@ func createCoinbaseTransaction(amount uint64) *bitcoin.Transaction {
 }
 
 func createUniqueCoinbaseTransaction(amount uint64, height int) *bitcoin.Transaction {
-	// Create a unique coinbase transaction by including height in scriptSig
-	heightBytes := []byte{byte(height & 0xff), byte((height >> 8) & 0xff), byte((height >> 16) & 0xff), byte((height >> 24) & 0xff)}
-	scriptSig := append([]byte{0x04, 0xff, 0xff, 0x00, 0x1d}, heightBytes...)
+	return createTaggedCoinbaseTransaction(amount, height, height)
+}
+
+// createTaggedCoinbaseTransaction creates a coinbase for the block at height
+// whose scriptSig starts with the BIP34 height push and ends with the tag, so
+// blocks at the same height on different branches get different coinbases
+func createTaggedCoinbaseTransaction(amount uint64, height, tag int) *bitcoin.Transaction {
+	tagBytes := []byte{byte(tag & 0xff), byte((tag >> 8) & 0xff), byte((tag >> 16) & 0xff), byte((tag >> 24) & 0xff)}
+	scriptSig := append(coinbaseHeightPush(height), 0x04, 0xff, 0xff, 0x00, 0x1d)
+	scriptSig = append(scriptSig, tagBytes...)
 
 	input := bitcoin.TxInput{
 		PreviousOutput: bitcoin.OutPoint{
 			Hash:  bitcoin.ZeroHash,
 			Index: 0xffffffff,
 		},
-		ScriptSig: scriptSig, // Unique script with height
+		ScriptSig: scriptSig, // Unique script with height and tag
 		Sequence:  0xffffffff,
 	}
 
@@ -487,8 +488,30 @@ func createUniqueCoinbaseTransaction(amount uint64, height int) *bitcoin.Transac
 	)
 }
 
+// coinbaseHeightPush returns the minimal push of a block height that BIP34
+// requires at the start of a coinbase scriptSig
+func coinbaseHeightPush(height int) []byte {
+	if height >= 1 && height <= 16 {
+		return []byte{byte(0x50 + height)} // OP_1 to OP_16
+	}
+	var num []byte
+	for h := height; h > 0; h >>= 8 {
+		num = append(num, byte(h))
+	}
+	if len(num) > 0 && num[len(num)-1]&0x80 != 0 {
+		num = append(num, 0x00) // Keep the number positive
+	}
+	return append([]byte{byte(len(num))}, num...)
+}
+
+// newTestBlockChain creates a regtest chain on the test genesis block, so
+// helper blocks can be mined at minimum difficulty
+func newTestBlockChain() *bitcoin.BlockChain {
+	return bitcoin.NewBlockChainWithParams(&bitcoin.RegTestParams, createGenesisBlock())
+}
+
 func setupBlockChain(numBlocks int) *bitcoin.BlockChain {
-	blockchain := bitcoin.NewBlockChain(createGenesisBlock())
+	blockchain := newTestBlockChain()
 
 	for i := 1; i < numBlocks; i++ {
 		// Create block that properly links to previous block
@@ -506,17 +529,37 @@ func setupBlockChain(numBlocks int) *bitcoin.BlockChain {
 func createValidBlockAfter(prevBlock *bitcoin.Block, height int) *bitcoin.Block {
 	// Create a valid block that builds on the previous block
 	header := bitcoin.BlockHeader{
-		Version:       1,
+		Version:       4,
 		PrevBlockHash: prevBlock.Hash(), // Correct previous hash
-		MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000001"),
 		Timestamp:     uint32(1231006505 + height*600),
-		Bits:          0x1d00ffff,
-		Nonce:         uint32(12345 + height),
+		Bits:          bitcoin.RegTestParams.PowLimitBits,
 	}
 
 	// Create unique coinbase transaction for each block
 	coinbaseTx := createUniqueCoinbaseTransaction(5000000000, height)
-	return bitcoin.NewBlock(header, []bitcoin.Transaction{*coinbaseTx})
+	return mineTestBlock(header, []bitcoin.Transaction{*coinbaseTx})
+}
+
+// newTestBlock creates a block whose header commits to its transactions
+func newTestBlock(header bitcoin.BlockHeader, transactions []bitcoin.Transaction) *bitcoin.Block {
+	block := bitcoin.NewBlock(header, transactions)
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	return block
+}
+
+// mineTestBlock creates a block committing to its transactions and mines it
+// at the header's difficulty, which must be regtest's
+func mineTestBlock(header bitcoin.BlockHeader, transactions []bitcoin.Transaction) *bitcoin.Block {
+	merkleRoot := bitcoin.NewBlock(header, transactions).CalculateMerkleRoot()
+	for nonce := uint32(0); nonce < math.MaxUint32; nonce++ {
+		// A new header for every nonce, so no cached hash is reused
+		candidate := bitcoin.NewBlockHeader(header.Version, header.PrevBlockHash, merkleRoot,
+			header.Timestamp, header.Bits, nonce)
+		if bitcoin.ValidateProofOfWork(candidate.Hash(), candidate.Bits) {
+			return bitcoin.NewBlock(candidate, transactions)
+		}
+	}
+	panic("failed to mine test block")
 }
 
 func applyChainCorruption(blockchain *bitcoin.BlockChain, corruption string) {
@@ -528,8 +571,8 @@ func applyChainCorruption(blockchain *bitcoin.BlockChain, corruption string) {
 			block := blockchain.GetBlock(1)
 			if block != nil {
 				// Create a corrupted version by changing the nonce
-				corruptedHeader := block.Header
-				corruptedHeader.Nonce = 999999 // Invalid nonce
+				corruptedHeader := bitcoin.NewBlockHeader(block.Header.Version, block.Header.PrevBlockHash,
+					block.Header.MerkleRoot, block.Header.Timestamp, block.Header.Bits, 999999)
 				corruptedBlock := bitcoin.NewBlock(corruptedHeader, block.Transactions)
 				// Force replace the block in the blockchain (for testing purposes)
 				// Note: This is a test hack - real blockchain wouldn't allow this
@@ -566,7 +609,7 @@ func createForkChain(length int) []*bitcoin.Block {
 	}
 	blocks := make([]*bitcoin.Block, forkBlocks)
 
-	genesisHash := mustParseHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	genesisHash := createGenesisBlock().Hash()
 
 	for i := 0; i < forkBlocks; i++ {
 		var prevHash bitcoin.Hash256
@@ -579,17 +622,15 @@ func createForkChain(length int) []*bitcoin.Block {
 		}
 
 		header := bitcoin.BlockHeader{
-			Version:       1,
+			Version:       4,
 			PrevBlockHash: prevHash,
-			MerkleRoot:    mustParseHash("0000000000000000000000000000000000000000000000000000000000000002"),
 			Timestamp:     uint32(1231006505 + (i+100)*600), // Different timestamps
-			Bits:          0x1d00ffff,
-			Nonce:         uint32(50000 + i), // Different nonces for fork
+			Bits:          bitcoin.RegTestParams.PowLimitBits,
 		}
 
 		// Create unique coinbase for fork blocks
-		coinbaseTx := createUniqueCoinbaseTransaction(5000000000, i+100)
-		blocks[i] = bitcoin.NewBlock(header, []bitcoin.Transaction{*coinbaseTx})
+		coinbaseTx := createTaggedCoinbaseTransaction(5000000000, i+1, i+100)
+		blocks[i] = mineTestBlock(header, []bitcoin.Transaction{*coinbaseTx})
 	}
 	return blocks
 }
@@ -104,14 +104,14 @@ func TestScriptEngine_Execute(t *testing.T) {
 			name:       "OP_HASH160 of known data",
 			scriptHex:  "0548656c6c6fa9", // PUSH(5) "Hello" OP_HASH160
 			expected:   true,
-			finalStack: []string{"b6a9c8c230722b7c748331a8b450f05566dc7d0f"}, // HASH160("Hello")
+			finalStack: []string{"578635f64c2b8b846f2b659853f6333e4148ebe8"}, // HASH160("Hello")
 			flags:      bitcoin.ScriptFlagsNone,
 		},
 
 		// Complex scripts
 		{
 			name:       "Simple P2PKH-like pattern (without signature)",
-			scriptHex:  "76a914" + "b6a9c8c230722b7c748331a8b450f05566dc7d0f" + "87", // bitcoin.OP_DUP OP_HASH160 <hash> OP_EQUAL
+			scriptHex:  "76a914" + "578635f64c2b8b846f2b659853f6333e4148ebe8" + "87", // bitcoin.OP_DUP OP_HASH160 <hash> OP_EQUAL
 			expected:   false,                                                        // Should fail without matching data on stack
 			finalStack: []string{},
 			flags:      bitcoin.ScriptFlagsNone,
@@ -194,7 +194,7 @@ func TestScriptEngine_P2PKHExecution(t *testing.T) {
 	t.Skip("P2PKH execution requires signature validation - will be implemented in next phase")
 }
 
-// TestScriptEngine_SignatureVerification tests ECDSA signature verification (TDD RED phase)
+// TestScriptEngine_SignatureVerification tests that OP_CHECKSIG rejects signatures that do not verify
 func TestScriptEngine_SignatureVerification(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -206,13 +206,13 @@ func TestScriptEngine_SignatureVerification(t *testing.T) {
 		description  string
 	}{
 		{
-			name:         "Valid ECDSA signature verification",
+			name:         "Well-formed signature without transaction context",
 			scriptHex:    "ac", // OP_CHECKSIG
 			txHash:       "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
 			pubKeyHex:    "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",                                                                             // Sample compressed pubkey
 			signatureHex: "304402200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef02200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef01", // Sample DER signature + SIGHASH_ALL
-			expected:     true,
-			description:  "Valid signature should verify successfully",
+			expected:     false,
+			description:  "A DER-shaped signature cannot verify without a transaction to hash",
 		},
 		{
 			name:         "Invalid signature should fail verification",
@@ -229,8 +229,8 @@ func TestScriptEngine_SignatureVerification(t *testing.T) {
 			txHash:       "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
 			pubKeyHex:    "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
 			signatureHex: "304402200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef02200123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef01",
-			expected:     true,
-			description:  "Complete P2PKH script should execute with valid signature",
+			expected:     false,
+			description:  "Complete P2PKH script must not accept a fabricated signature",
 		},
 	}
 
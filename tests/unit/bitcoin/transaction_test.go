@@ -417,7 +417,7 @@ func TestTransaction_Hash(t *testing.T) {
 	}
 }
 
-// TestTransaction_WitnessHash tests witness transaction hashing (currently returns zero)
+// TestTransaction_WitnessHash tests witness transaction hashing
 func TestTransaction_WitnessHash(t *testing.T) {
 	tx := &bitcoin.Transaction{
 		Version: 2,
@@ -444,9 +444,15 @@ func TestTransaction_WitnessHash(t *testing.T) {
 		t.Errorf("witness hash not consistent: %s != %s", wHash1.String(), wHash2.String())
 	}
 
-	// Currently returns zero hash (TODO: implement actual hashing)
-	if !wHash1.IsZero() {
-		t.Logf("Witness hash implementation complete: %s", wHash1.String())
+	// Witness data is committed to by the wtxid but not the txid
+	if wHash1.IsZero() || wHash1 == tx.Hash() {
+		t.Errorf("expected witness hash distinct from txid, got %s", wHash1.String())
+	}
+
+	// Without witness data the wtxid equals the txid
+	legacy := &bitcoin.Transaction{Version: tx.Version, Inputs: tx.Inputs, Outputs: tx.Outputs}
+	if legacy.WitnessHash() != legacy.Hash() {
+		t.Errorf("expected witness hash %s to equal txid %s", legacy.WitnessHash().String(), legacy.Hash().String())
 	}
 }
 